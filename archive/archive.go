@@ -0,0 +1,309 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+/*
+	Archives let a directory with many small files be stored as a single object. They are tar
+	archives whose bytes only depend on the names, contents, permission bits and symlink targets of
+	the files in the directory: entries are sorted by name and timestamps and owners are zeroed. Packing
+	the same tree twice therefore produces the same checksum, which is how cavorite tells whether an
+	extracted directory still matches its cfile.
+*/
+
+const (
+	Format = "tar"
+
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+)
+
+var (
+	ErrUnknownCompression = errors.New("unknown archive compression")
+	ErrUnsupportedEntry   = errors.New("unsupported archive entry")
+	ErrUnsafePath         = errors.New("archive entry escapes the destination directory")
+)
+
+// epoch is the modification time of every entry
+var epoch = time.Unix(0, 0).UTC()
+
+// ValidCompression returns an error wrapping ErrUnknownCompression if compression is not supported
+func ValidCompression(compression string) error {
+	switch compression {
+	case CompressionNone, CompressionGzip:
+		return nil
+	}
+	return fmt.Errorf("%w %q, expected %q", ErrUnknownCompression, compression, CompressionGzip)
+}
+
+// Write writes the contents of dir in fsys to w as an archive and returns the number of entries
+func Write(w io.Writer, fsys afero.Fs, dir, compression string) (int, error) {
+	if err := ValidCompression(compression); err != nil {
+		return 0, err
+	}
+	var gz *gzip.Writer
+	if compression == CompressionGzip {
+		// the gzip header is left empty so that it does not record a name or time
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	dir = filepath.Clean(dir)
+	entries := 0
+	// afero.Walk visits the entries of each directory in lexical order
+	err := afero.Walk(fsys, dir, func(p string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, fsys, p, filepath.ToSlash(rel), info); err != nil {
+			return err
+		}
+		entries++
+		return nil
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("archiving %s: %w", dir, err)
+	}
+	return entries, nil
+}
+
+func writeEntry(tw *tar.Writer, fsys afero.Fs, p, name string, info fs.FileInfo) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: epoch,
+	}
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case mode.IsRegular():
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = info.Size()
+	case mode&fs.ModeSymlink != 0:
+		lr, ok := fsys.(afero.LinkReader)
+		if !ok {
+			return fmt.Errorf("%w: %s is a symlink but the filesystem cannot read links", ErrUnsupportedEntry, p)
+		}
+		target, err := lr.ReadlinkIfPossible(p)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = filepath.ToSlash(target)
+	default:
+		return fmt.Errorf("%w: %s has mode %s", ErrUnsupportedEntry, p, mode)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	f, err := fsys.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Extract extracts the archive read from r into dir in fsys, which should not exist yet, and returns
+// the number of entries. Entries that would be written outside of dir, symlinks that point outside
+// of dir and entries other than files, directories and symlinks are rejected.
+func Extract(fsys afero.Fs, r io.Reader, dir, compression string) (int, error) {
+	if err := ValidCompression(compression); err != nil {
+		return 0, err
+	}
+	if compression == CompressionGzip {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err := fsys.MkdirAll(dir, os.ModePerm); err != nil {
+		return 0, err
+	}
+	// symlinks are created last so that no entry is ever written through one, and directory
+	// permissions are applied last so that read-only directories can still be filled
+	symlinks := make(map[string]string)
+	var symlinkNames []string
+	dirModes := make(map[string]fs.FileMode)
+	var dirs []string
+	tr := tar.NewReader(r)
+	entries := 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		name, err := entryName(hdr.Name)
+		if err != nil {
+			return 0, err
+		}
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		mode := fs.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := fsys.MkdirAll(dst, os.ModePerm); err != nil {
+				return 0, err
+			}
+			if _, ok := dirModes[dst]; !ok {
+				dirs = append(dirs, dst)
+			}
+			dirModes[dst] = mode
+		case tar.TypeReg:
+			if err := extractFile(fsys, tr, dst, mode); err != nil {
+				return 0, err
+			}
+		case tar.TypeSymlink:
+			if _, ok := symlinks[name]; !ok {
+				symlinkNames = append(symlinkNames, name)
+			}
+			symlinks[name] = hdr.Linkname
+		default:
+			return 0, fmt.Errorf("%w: %s has type %q", ErrUnsupportedEntry, hdr.Name, hdr.Typeflag)
+		}
+		entries++
+	}
+	if len(symlinks) > 0 {
+		linker, ok := fsys.(afero.Linker)
+		if !ok {
+			return 0, fmt.Errorf("%w: the archive contains symlinks but the filesystem cannot create them", ErrUnsupportedEntry)
+		}
+		for _, name := range symlinkNames {
+			if err := checkSymlink(name, symlinks); err != nil {
+				return 0, err
+			}
+			dst := filepath.Join(dir, filepath.FromSlash(name))
+			if err := fsys.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+				return 0, err
+			}
+			if err := linker.SymlinkIfPossible(filepath.FromSlash(symlinks[name]), dst); err != nil {
+				return 0, err
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := fsys.Chmod(dirs[i], dirModes[dirs[i]]); err != nil {
+			return 0, err
+		}
+	}
+	return entries, nil
+}
+
+func extractFile(fsys afero.Fs, r io.Reader, dst string, mode fs.FileMode) error {
+	if err := fsys.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := fsys.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// the mode passed to OpenFile is subject to the umask
+	return fsys.Chmod(dst, mode)
+}
+
+// entryName returns the cleaned name of an entry, or an error if it is not a relative path inside
+// the destination directory
+func entryName(name string) (string, error) {
+	clean := path.Clean(strings.TrimSuffix(name, "/"))
+	if name == "" || path.IsAbs(name) || clean == "." || escapes(clean) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return clean, nil
+}
+
+func escapes(p string) bool {
+	return p == ".." || strings.HasPrefix(p, "../")
+}
+
+// maxSymlinkDepth limits how many symlinks are followed when resolving a target, like the kernel does
+const maxSymlinkDepth = 40
+
+// checkSymlink returns an error if the target of the symlink name resolves outside of the destination
+// directory once the symlinks in the archive are followed
+func checkSymlink(name string, symlinks map[string]string) error {
+	target := symlinks[name]
+	var dir []string
+	if d := path.Dir(name); d != "." {
+		dir = strings.Split(d, "/")
+	}
+	// creating name would otherwise follow a symlink in its own path
+	for i := range dir {
+		if _, ok := symlinks[strings.Join(dir[:i+1], "/")]; ok {
+			return fmt.Errorf("%w: %s is below another symlink", ErrUnsafePath, name)
+		}
+	}
+	if _, ok := resolve(dir, target, symlinks, 0); !ok {
+		return fmt.Errorf("%w: %s links to %q", ErrUnsafePath, name, target)
+	}
+	return nil
+}
+
+// resolve resolves target relative to dir, the components of a directory without symlinks, one
+// component at a time. It reports false if target leaves the destination directory.
+func resolve(dir []string, target string, symlinks map[string]string, depth int) ([]string, bool) {
+	if depth > maxSymlinkDepth || target == "" || path.IsAbs(target) {
+		return nil, false
+	}
+	resolved := append([]string(nil), dir...)
+	for _, c := range strings.Split(target, "/") {
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return nil, false
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+		resolved = append(resolved, c)
+		if t, ok := symlinks[strings.Join(resolved, "/")]; ok {
+			if resolved, ok = resolve(resolved[:len(resolved)-1], t, symlinks, depth+1); !ok {
+				return nil, false
+			}
+		}
+	}
+	return resolved, true
+}
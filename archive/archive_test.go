@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// osFs returns an OsFs and a temporary directory in it. afero.BasePathFs is not used because it
+// rewrites symlink targets.
+func osFs(t *testing.T) (afero.Fs, string) {
+	t.Helper()
+	return afero.NewOsFs(), t.TempDir()
+}
+
+// stuffSDK writes a small tree that resembles a macOS framework into dir
+func stuffSDK(t *testing.T, fsys afero.Fs, dir string) {
+	t.Helper()
+	require.NoError(t, fsys.MkdirAll(filepath.Join(dir, "Lib.framework/Versions/A/Headers"), 0755))
+	require.NoError(t, afero.WriteFile(fsys, filepath.Join(dir, "Lib.framework/Versions/A/Headers/lib.h"), []byte("int lib(void);\n"), 0644))
+	require.NoError(t, afero.WriteFile(fsys, filepath.Join(dir, "Lib.framework/Versions/A/Lib"), []byte("\x7fELF"), 0755))
+	require.NoError(t, afero.WriteFile(fsys, filepath.Join(dir, "README"), []byte("read me\n"), 0644))
+	linker := fsys.(afero.Linker)
+	require.NoError(t, linker.SymlinkIfPossible("A", filepath.Join(dir, "Lib.framework/Versions/Current")))
+	require.NoError(t, linker.SymlinkIfPossible("Versions/Current/Headers", filepath.Join(dir, "Lib.framework/Headers")))
+}
+
+func TestWriteIsDeterministic(t *testing.T) {
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		t.Run(compression, func(t *testing.T) {
+			fsys, root := osFs(t)
+			stuffSDK(t, fsys, filepath.Join(root, "a"))
+			stuffSDK(t, fsys, filepath.Join(root, "b"))
+			later := time.Now().Add(time.Hour)
+			require.NoError(t, fsys.Chtimes(filepath.Join(root, "b/README"), later, later))
+
+			var a, b bytes.Buffer
+			n, err := Write(&a, fsys, filepath.Join(root, "a"), compression)
+			require.NoError(t, err)
+			assert.Equal(t, 9, n)
+			_, err = Write(&b, fsys, filepath.Join(root, "b")+"/", compression)
+			require.NoError(t, err)
+			assert.Equal(t, a.Bytes(), b.Bytes())
+		})
+	}
+}
+
+func TestWriteNormalizesHeaders(t *testing.T) {
+	fsys, root := osFs(t)
+	require.NoError(t, fsys.Mkdir(filepath.Join(root, "sdk"), 0755))
+	require.NoError(t, afero.WriteFile(fsys, filepath.Join(root, "sdk/README"), []byte("read me\n"), 0644))
+	var buf bytes.Buffer
+	n, err := Write(&buf, fsys, filepath.Join(root, "sdk"), CompressionNone)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "README", hdr.Name)
+	assert.Equal(t, time.Unix(0, 0), hdr.ModTime)
+	assert.Equal(t, 0, hdr.Uid)
+	assert.Empty(t, hdr.Uname)
+}
+
+func TestWriteUnknownCompression(t *testing.T) {
+	_, err := Write(&bytes.Buffer{}, afero.NewMemMapFs(), "sdk", "zstd")
+	assert.ErrorIs(t, err, ErrUnknownCompression)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, compression := range []string{CompressionNone, CompressionGzip} {
+		t.Run(compression, func(t *testing.T) {
+			fsys, root := osFs(t)
+			stuffSDK(t, fsys, filepath.Join(root, "sdk"))
+			var packed bytes.Buffer
+			_, err := Write(&packed, fsys, filepath.Join(root, "sdk"), compression)
+			require.NoError(t, err)
+
+			out := filepath.Join(root, "out")
+			n, err := Extract(fsys, bytes.NewReader(packed.Bytes()), out, compression)
+			require.NoError(t, err)
+			assert.Equal(t, 9, n)
+
+			b, err := afero.ReadFile(fsys, filepath.Join(out, "Lib.framework/Headers/lib.h"))
+			require.NoError(t, err)
+			assert.Equal(t, "int lib(void);\n", string(b))
+			info, err := fsys.Stat(filepath.Join(out, "Lib.framework/Versions/A/Lib"))
+			require.NoError(t, err)
+			assert.Equal(t, fs.FileMode(0755), info.Mode().Perm())
+			target, err := fsys.(afero.LinkReader).ReadlinkIfPossible(filepath.Join(out, "Lib.framework/Versions/Current"))
+			require.NoError(t, err)
+			assert.Equal(t, "A", target)
+
+			var repacked bytes.Buffer
+			_, err = Write(&repacked, fsys, out, compression)
+			require.NoError(t, err)
+			assert.Equal(t, packed.Bytes(), repacked.Bytes())
+		})
+	}
+}
+
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+}
+
+func tarOf(t *testing.T, entries ...entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = 1
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		if e.typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte("x"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestExtractRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		wantErr error
+	}{
+		{
+			name:    "parent directory",
+			entries: []entry{{name: "../evil", typeflag: tar.TypeReg}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "parent directory after cleaning",
+			entries: []entry{{name: "a/../../evil", typeflag: tar.TypeReg}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "absolute path",
+			entries: []entry{{name: "/tmp/evil", typeflag: tar.TypeReg}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "absolute symlink",
+			entries: []entry{{name: "passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "symlink to parent directory",
+			entries: []entry{{name: "a/up", typeflag: tar.TypeSymlink, linkname: "../.."}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name: "symlink that escapes through another symlink",
+			entries: []entry{
+				{name: "up", typeflag: tar.TypeSymlink, linkname: "here/.."},
+				{name: "here", typeflag: tar.TypeSymlink, linkname: "."},
+			},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name: "symlink below a symlink",
+			entries: []entry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "b"},
+				{name: "b/", typeflag: tar.TypeDir},
+				{name: "a/c", typeflag: tar.TypeSymlink, linkname: "."},
+			},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "symlink loop",
+			entries: []entry{{name: "a", typeflag: tar.TypeSymlink, linkname: "a/x"}},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "hard link",
+			entries: []entry{{name: "a", typeflag: tar.TypeLink, linkname: "b"}},
+			wantErr: ErrUnsupportedEntry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, root := osFs(t)
+			_, err := Extract(fsys, bytes.NewReader(tarOf(t, tt.entries...)), filepath.Join(root, "out"), CompressionNone)
+			assert.ErrorIs(t, err, tt.wantErr)
+			_, err = os.Lstat(filepath.Join(root, "evil"))
+			assert.ErrorIs(t, err, fs.ErrNotExist)
+		})
+	}
+}
@@ -1,23 +1,219 @@
+// Package bindetector tells binary files apart from text files by looking at their first bytes.
 package bindetector
 
 import (
-	"regexp"
-
-	"github.com/google/logger"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
-func IsBinary(filepath string) bool {
-	// for now, we must shell out to /usr/bin/file or respective windows exe called file.exe provided by git
-	// someday, binary determination could be implemented in-house without the need for external tools but until then
-	// shelling out is a necessary evil
-	return isBinary(execFile(filepath))
+// windowSize is how much of the start of a file is examined, the same amount git looks at
+const windowSize = 8000
+
+// maxControlPercent is the share of control characters above which content is taken for binary
+const maxControlPercent = 10
+
+// Result describes what Detect found out about a file
+type Result struct {
+	// Binary is true unless the file looks like text
+	Binary bool
+	// Type is the detected format of binary files, such as "zip" or "elf", or the encoding of text
+	// files, such as "ascii" or "utf-16le"
+	Type string
+	// Reason explains why the file was found to be binary or text
+	Reason string
+}
+
+func (r Result) String() string {
+	kind := "text"
+	if r.Binary {
+		kind = "binary"
+	}
+	return fmt.Sprintf("%s (%s): %s", kind, r.Type, r.Reason)
 }
 
-func isBinary(bytes []byte) bool {
-	matched, err := regexp.Match(`binary`, bytes)
+// Detector detects binary files
+type Detector struct {
+	// FileFallback asks file(1), or file.exe on Windows, about content the heuristics cannot
+	// decide on: content that is neither UTF-8 nor UTF-16 and has no NUL bytes
+	FileFallback bool
+}
+
+// IsBinary reports whether the file at path looks binary. Files that cannot be read are not binary.
+func IsBinary(path string) bool {
+	r, err := Detector{}.DetectFile(path)
 	if err != nil {
-		logger.Errorf("isBinary regex matching error: %v", err)
+		slog.Error("detecting whether file is binary", "path", path, "error", err)
+		return false
+	}
+	return r.Binary
+}
+
+// DetectFile detects whether the file at path is binary
+func (d Detector) DetectFile(path string) (Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+	return d.Detect(f)
+}
+
+// Detect detects whether the content read from r is binary. Only the start of the content is read.
+func (d Detector) Detect(r io.Reader) (Result, error) {
+	window := make([]byte, windowSize)
+	n, err := io.ReadFull(r, window)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return Result{}, err
+	}
+	window = window[:n]
+	// the window may end in the middle of a character
+	truncated := n == windowSize
+
+	if n == 0 {
+		return Result{Type: "empty", Reason: "file is empty"}, nil
+	}
+	if format := matchFormat(window, truncated); format != "" {
+		return Result{Binary: true, Type: format, Reason: fmt.Sprintf("starts with the magic number of %s", format)}, nil
+	}
+	if res, ok := detectBOM(window); ok {
+		return res, nil
+	}
+	if nul := bytes.IndexByte(window, 0); nul >= 0 {
+		if encoding := guessUTF16(window, truncated); encoding != "" {
+			return Result{Type: encoding, Reason: "decodes as UTF-16 text without byte order mark"}, nil
+		}
+		return Result{Binary: true, Type: "data", Reason: fmt.Sprintf("has a NUL byte at offset %d", nul)}, nil
+	}
+	if validUTF8(window, truncated) {
+		if percent := controlPercent(window); percent > maxControlPercent {
+			return Result{Binary: true, Type: "data", Reason: fmt.Sprintf("%d%% of the bytes are control characters", percent)}, nil
+		}
+		for _, c := range window {
+			if c >= utf8.RuneSelf {
+				return Result{Type: "utf-8", Reason: "valid UTF-8 text"}, nil
+			}
+		}
+		return Result{Type: "ascii", Reason: "valid ASCII text"}, nil
 	}
+	if d.FileFallback {
+		res, err := execFile(window)
+		if err == nil {
+			return res, nil
+		}
+		slog.Warn("falling back to heuristics, file(1) failed", "error", err)
+	}
+	if percent := controlPercent(window); percent > maxControlPercent {
+		return Result{Binary: true, Type: "data", Reason: fmt.Sprintf("not UTF-8 and %d%% of the bytes are control characters", percent)}, nil
+	}
+	// most likely text in a legacy encoding such as ISO-8859-1 or Windows-1252
+	return Result{Type: "8bit", Reason: "not UTF-8 but has neither NUL bytes nor many control characters"}, nil
+}
+
+// detectBOM detects text starting with a Unicode byte order mark
+func detectBOM(b []byte) (Result, bool) {
+	// the UTF-32 little endian mark starts with the UTF-16 one
+	for _, bom := range []struct {
+		mark     string
+		encoding string
+	}{
+		{"\xff\xfe\x00\x00", "utf-32le"},
+		{"\x00\x00\xfe\xff", "utf-32be"},
+		{"\xef\xbb\xbf", "utf-8"},
+		{"\xff\xfe", "utf-16le"},
+		{"\xfe\xff", "utf-16be"},
+	} {
+		if bytes.HasPrefix(b, []byte(bom.mark)) {
+			return Result{Type: bom.encoding, Reason: fmt.Sprintf("starts with a %s byte order mark", bom.encoding)}, true
+		}
+	}
+	return Result{}, false
+}
 
-	return matched
+// guessUTF16 returns "utf-16le" or "utf-16be" if b decodes as UTF-16 text in that byte order, or ""
+func guessUTF16(b []byte, truncated bool) string {
+	if len(b)%2 != 0 {
+		if !truncated {
+			return ""
+		}
+		b = b[:len(b)-1]
+	}
+	// NUL bytes are the high bytes of Latin characters, so prefer the byte order with the most of them
+	guess, best := "", -1
+	for _, encoding := range []string{"utf-16le", "utf-16be"} {
+		units := make([]uint16, len(b)/2)
+		latin := 0
+		for i := range units {
+			if encoding == "utf-16le" {
+				units[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+			} else {
+				units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+			}
+			if units[i] < 0x100 {
+				latin++
+			}
+		}
+		if truncated && len(units) > 0 && utf16.IsSurrogate(rune(units[len(units)-1])) {
+			units = units[:len(units)-1]
+		}
+		if latin > best && isUTF16Text(units) {
+			guess, best = encoding, latin
+		}
+	}
+	return guess
+}
+
+// isUTF16Text reports whether units are valid UTF-16 without NUL or control characters other than
+// whitespace
+func isUTF16Text(units []uint16) bool {
+	if len(units) == 0 {
+		return false
+	}
+	for _, r := range utf16.Decode(units) {
+		if r == utf8.RuneError || r == 0 || (r < 0x20 && !isTextControl(byte(r))) || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// validUTF8 reports whether b is valid UTF-8, ignoring a character cut off at the end of a truncated
+// window
+func validUTF8(b []byte, truncated bool) bool {
+	if truncated {
+		for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+			if utf8.RuneStart(b[len(b)-i]) {
+				if !utf8.FullRune(b[len(b)-i:]) {
+					b = b[:len(b)-i]
+				}
+				break
+			}
+		}
+	}
+	return utf8.Valid(b)
+}
+
+// isTextControl reports whether the control character c commonly appears in text
+func isTextControl(c byte) bool {
+	switch c {
+	case '\t', '\n', '\v', '\f', '\r', '\b', 0x1b:
+		return true
+	}
+	return false
+}
+
+// controlPercent returns the percentage of bytes in b that are control characters not found in text
+func controlPercent(b []byte) int {
+	var controls int
+	for _, c := range b {
+		if (c < 0x20 && !isTextControl(c)) || c == 0x7f {
+			controls++
+		}
+	}
+	return controls * 100 / len(b)
 }
@@ -0,0 +1,113 @@
+package bindetector
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsBinary(t *testing.T) {
+	require.True(t, isBinary([]byte(`binary`)))
+	require.False(t, isBinary([]byte(`blah`)))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.bin"), []byte("\x7fELF\x02\x01\x01"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("plain"), 0644))
+	assert.True(t, IsBinary(filepath.Join(dir, "a.bin")))
+	assert.False(t, IsBinary(filepath.Join(dir, "README")))
+	assert.False(t, IsBinary(filepath.Join(dir, "missing")))
+}
+
+func TestDetect(t *testing.T) {
+	// e_lfanew at 0x3c points right behind the DOS header
+	pe := "MZ" + strings.Repeat("\x90", 0x3a) + "\x40\x00\x00\x00" + "PE\x00\x00\x4c\x01"
+	tar := strings.Repeat("\x00", 257) + "ustar\x00" + "00"
+	// é cut off after its first byte at the end of the window
+	truncated := strings.Repeat("a", windowSize-1) + "é"
+	tests := []struct {
+		name    string
+		content string
+		binary  bool
+		typ     string
+	}{
+		{"empty", "", false, "empty"},
+		{"ascii", "hello\nworld\n", false, "ascii"},
+		{"utf-8", "héllo wörld", false, "utf-8"},
+		{"utf-8 bom", "\xef\xbb\xbfhello", false, "utf-8"},
+		{"utf-8 cut off by window", truncated, false, "utf-8"},
+		{"utf-16le bom", "\xff\xfeh\x00i\x00", false, "utf-16le"},
+		{"utf-16be bom", "\xfe\xff\x00h\x00i", false, "utf-16be"},
+		{"utf-32le bom", "\xff\xfe\x00\x00h\x00\x00\x00", false, "utf-32le"},
+		{"utf-16le", "h\x00e\x00l\x00l\x00o\x00\n\x00", false, "utf-16le"},
+		{"utf-16be", "\x00h\x00e\x00l\x00l\x00o", false, "utf-16be"},
+		{"latin-1", "caf\xe9 cr\xe8me", false, "8bit"},
+		{"nul", "abc\x00def", true, "data"},
+		{"control characters", "\x01\x02\x03\x04abc", true, "data"},
+		{"zip", "PK\x03\x04\x14\x00", true, "zip"},
+		{"gzip", "\x1f\x8b\x08\x00", true, "gzip"},
+		{"tar", tar, true, "tar"},
+		{"png", "\x89PNG\r\n\x1a\n\x00\x00", true, "png"},
+		{"jpeg", "\xff\xd8\xff\xe0", true, "jpeg"},
+		{"gif", "GIF89a\x01\x00", true, "gif"},
+		{"elf", "\x7fELF\x02\x01\x01", true, "elf"},
+		{"mach-o", "\xcf\xfa\xed\xfe\x07\x00", true, "mach-o"},
+		{"pe", pe, true, "pe"},
+		{"text starting with MZ", "MZ is short", false, "ascii"},
+		{"long text starting with MZ", "MZ " + strings.Repeat("is not an executable ", 5), false, "ascii"},
+		{"mz without pe header", "MZ" + strings.Repeat("\x90", 0x3a) + "\x40\x00\x00\x00" + "NE\x00\x00", true, "data"},
+		{"pdf", "%PDF-1.7\n%\xe2\xe3\xcf\xd3\n", true, "pdf"},
+		{"ascii pdf", "%PDF-1.7\n1 0 obj\n", false, "ascii"},
+		{"mp3", "ID3\x04\x00\x00\x00\x00\x00\x23", true, "mp3"},
+		{"text starting with ID3", "ID3 tags are read by the player\n", false, "ascii"},
+		{"ogg", "OggS\x00\x02\x00\x00", true, "ogg"},
+		{"text starting with OggS", "OggS is the capture pattern of Ogg pages\n", false, "ascii"},
+		{"flac", "fLaC\x00\x00\x00\x22", true, "flac"},
+		{"text starting with fLaC", "fLaC starts FLAC streams\n", false, "ascii"},
+		{"text starting with 070701", "070701 is the cpio newc magic\n", false, "ascii"},
+		{"text starting with icns", "icns hold macOS icons\n", false, "ascii"},
+		{"text starting with 8BPS", "8BPS starts Photoshop files\n", false, "ascii"},
+		{"text starting with xar!", "xar! starts xar archives\n", false, "ascii"},
+		{"webp", "RIFF\x00\x00\x00\x00WEBPVP8 ", true, "webp"},
+		{"wav", "RIFF\x00\x00\x00\x00WAVEfmt ", true, "wav"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Detector{}.Detect(strings.NewReader(tt.content))
+			require.NoError(t, err)
+			assert.Equal(t, tt.binary, r.Binary, r.String())
+			assert.Equal(t, tt.typ, r.Type, r.String())
+			assert.NotEmpty(t, r.Reason)
+		})
+	}
+}
+
+func TestDetectFileFallback(t *testing.T) {
+	// the heuristics decide when file cannot be run
+	t.Setenv("PATH", "")
+	r, err := Detector{FileFallback: true}.Detect(strings.NewReader("caf\xe9"))
+	require.NoError(t, err)
+	assert.Equal(t, "8bit", r.Type)
+}
+
+func TestDetectWithFile(t *testing.T) {
+	if _, err := exec.LookPath("file"); err != nil {
+		t.Skip("file is not installed")
+	}
+	path := filepath.Join(t.TempDir(), "latin-1.txt")
+	require.NoError(t, os.WriteFile(path, []byte("caf\xe9 cr\xe8me\n"), 0644))
+	r, err := Detector{FileFallback: true}.DetectFile(path)
+	require.NoError(t, err)
+	assert.False(t, r.Binary)
+	assert.Contains(t, r.Reason, "file(1)")
+
+	// content the heuristics decide on never reaches file
+	require.NoError(t, os.WriteFile(path, []byte("plain"), 0644))
+	r, err = Detector{FileFallback: true}.DetectFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "ascii", r.Type)
+}
@@ -0,0 +1,39 @@
+package bindetector
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+)
+
+// execFile asks file(1) for the encoding of content. On Windows, git provides file.exe.
+func execFile(content []byte) (Result, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd := exec.Command(
+		"file",
+		"--mime-encoding",
+		"-b",
+		"-",
+	)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+
+	if err := cmd.Run(); err != nil {
+		return Result{}, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderrBuf.Bytes()))
+	}
+	encoding := string(bytes.TrimSpace(stdoutBuf.Bytes()))
+	return Result{
+		Binary: isBinary(stdoutBuf.Bytes()),
+		Type:   encoding,
+		Reason: fmt.Sprintf("file(1) reports %s encoding", encoding),
+	}, nil
+}
+
+// isBinary reports whether the output of file --mime-encoding is for binary content
+func isBinary(out []byte) bool {
+	return binaryEncoding.Match(out)
+}
+
+var binaryEncoding = regexp.MustCompile(`binary`)
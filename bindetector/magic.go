@@ -0,0 +1,127 @@
+package bindetector
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// signature identifies a file format by the bytes at offset
+type signature struct {
+	offset int
+	magic  []byte
+}
+
+// format is a binary file format recognized by its signatures
+type format struct {
+	name       string
+	signatures []signature
+	// also, if set, must match as well as one of signatures
+	also *signature
+	// check, if set, must accept content matching one of signatures
+	check func(b []byte) bool
+}
+
+func at(offset int, magic string) signature {
+	return signature{offset: offset, magic: []byte(magic)}
+}
+
+// formats are the archive, image, executable and other binary formats recognized by their magic
+// numbers. Signatures shorter than four bytes only appear where they are unlikely to start text, and
+// content matching signatures made of text characters must not look like text itself.
+var formats = []format{
+	// archives and compressed files
+	{name: "zip", signatures: []signature{at(0, "PK\x03\x04"), at(0, "PK\x05\x06"), at(0, "PK\x07\x08")}},
+	{name: "gzip", signatures: []signature{at(0, "\x1f\x8b\x08")}},
+	{name: "bzip2", signatures: []signature{at(0, "BZh")}, also: &signature{offset: 4, magic: []byte("1AY&SY")}},
+	{name: "xz", signatures: []signature{at(0, "\xfd7zXZ\x00")}},
+	{name: "zstd", signatures: []signature{at(0, "\x28\xb5\x2f\xfd")}},
+	{name: "7z", signatures: []signature{at(0, "7z\xbc\xaf\x27\x1c")}},
+	{name: "rar", signatures: []signature{at(0, "Rar!\x1a\x07")}},
+	{name: "tar", signatures: []signature{at(257, "ustar\x00"), at(257, "ustar  \x00")}},
+	{name: "ar", signatures: []signature{at(0, "!<arch>\n")}},
+	{name: "xar", signatures: []signature{at(0, "xar!")}},
+	{name: "cpio", signatures: []signature{at(0, "070701"), at(0, "070702"), at(0, "070707")}},
+	// images
+	{name: "png", signatures: []signature{at(0, "\x89PNG\r\n\x1a\n")}},
+	{name: "jpeg", signatures: []signature{at(0, "\xff\xd8\xff")}},
+	{name: "gif", signatures: []signature{at(0, "GIF87a"), at(0, "GIF89a")}},
+	{name: "webp", signatures: []signature{at(0, "RIFF")}, also: &signature{offset: 8, magic: []byte("WEBP")}},
+	{name: "tiff", signatures: []signature{at(0, "II*\x00"), at(0, "MM\x00*")}},
+	{name: "ico", signatures: []signature{at(0, "\x00\x00\x01\x00")}},
+	{name: "icns", signatures: []signature{at(0, "icns")}},
+	{name: "psd", signatures: []signature{at(0, "8BPS")}},
+	// executables
+	{name: "elf", signatures: []signature{at(0, "\x7fELF")}},
+	{name: "mach-o", signatures: []signature{at(0, "\xfe\xed\xfa\xce"), at(0, "\xfe\xed\xfa\xcf"), at(0, "\xce\xfa\xed\xfe"), at(0, "\xcf\xfa\xed\xfe")}},
+	// fat Mach-O binaries and Java classes share their magic number
+	{name: "mach-o universal or java class", signatures: []signature{at(0, "\xca\xfe\xba\xbe")}},
+	{name: "pe", signatures: []signature{at(0, "MZ")}, check: hasPEHeader},
+	{name: "wasm", signatures: []signature{at(0, "\x00asm")}},
+	// other binary formats
+	{name: "pdf", signatures: []signature{at(0, "%PDF-")}},
+	{name: "sqlite", signatures: []signature{at(0, "SQLite format 3\x00")}},
+	{name: "mp4", signatures: []signature{at(4, "ftyp")}},
+	{name: "ogg", signatures: []signature{at(0, "OggS")}},
+	{name: "flac", signatures: []signature{at(0, "fLaC")}},
+	{name: "wav", signatures: []signature{at(0, "RIFF")}, also: &signature{offset: 8, magic: []byte("WAVE")}},
+	{name: "mp3", signatures: []signature{at(0, "ID3")}},
+}
+
+func (s signature) match(b []byte) bool {
+	return len(b) >= s.offset+len(s.magic) && bytes.Equal(b[s.offset:s.offset+len(s.magic)], s.magic)
+}
+
+// textual reports whether every byte of the signature may appear in text
+func (s signature) textual() bool {
+	for _, c := range s.magic {
+		if (c < 0x20 && !isTextControl(c)) || c >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// matchFormat returns the name of the format of b, or "" if it has no known magic number. The
+// window b was cut off at windowSize if truncated is true.
+func matchFormat(b []byte, truncated bool) string {
+	for _, f := range formats {
+		if f.also != nil && !f.also.match(b) {
+			continue
+		}
+		if f.check != nil && !f.check(b) {
+			continue
+		}
+		for _, s := range f.signatures {
+			if !s.match(b) {
+				continue
+			}
+			// text such as "ID3 tags" or "OggS" must not be taken for a file of that format
+			if s.textual() && (f.also == nil || f.also.textual()) && !hasBinaryBytes(b, truncated) {
+				break
+			}
+			return f.name
+		}
+	}
+	return ""
+}
+
+// hasBinaryBytes reports whether b has NUL bytes, control characters not found in text or is not
+// valid UTF-8
+func hasBinaryBytes(b []byte, truncated bool) bool {
+	for _, c := range b {
+		if (c < 0x20 && !isTextControl(c)) || c == 0x7f {
+			return true
+		}
+	}
+	return !validUTF8(b, truncated)
+}
+
+// hasPEHeader reports whether the DOS header at the start of b points to a PE header, whose offset
+// e_lfanew is stored at 0x3c
+func hasPEHeader(b []byte) bool {
+	if len(b) < 0x40 {
+		return false
+	}
+	offset := int64(binary.LittleEndian.Uint32(b[0x3c:]))
+	return offset+4 <= int64(len(b)) && bytes.Equal(b[offset:offset+4], []byte("PE\x00\x00"))
+}
@@ -0,0 +1,161 @@
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+/*
+	Chunker implements content-defined chunking using a gear rolling hash with normalized chunk sizes
+	(see "FastCDC: a Fast and Efficient Content-Defined Chunking Approach for Data Deduplication").
+	Cut points depend only on the bytes around them, so inserting or removing data in a large file only
+	changes the chunks near the edit and every other chunk can be deduplicated.
+
+	The gear table and cut point rules are part of the storage format: changing them changes every chunk
+	boundary and defeats deduplication against existing objects.
+*/
+
+const (
+	DefaultMinSize = 256 * 1024
+	DefaultAvgSize = 1024 * 1024
+	DefaultMaxSize = 4 * 1024 * 1024
+)
+
+var (
+	ErrInvalidOptions = errors.New("chunk sizes must satisfy 64 <= min_size < avg_size < max_size and avg_size must be a power of two")
+)
+
+// Options configure chunk sizes in bytes. Zero values are replaced by the defaults.
+type Options struct {
+	MinSize int `json:"min_size,omitempty" mapstructure:"min_size"`
+	AvgSize int `json:"avg_size,omitempty" mapstructure:"avg_size"`
+	MaxSize int `json:"max_size,omitempty" mapstructure:"max_size"`
+}
+
+// WithDefaults returns a copy of o with zero values replaced by the defaults
+func (o Options) WithDefaults() Options {
+	if o.MinSize == 0 {
+		o.MinSize = DefaultMinSize
+	}
+	if o.AvgSize == 0 {
+		o.AvgSize = DefaultAvgSize
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultMaxSize
+	}
+	return o
+}
+
+func (o Options) Validate() error {
+	if o.MinSize < 64 || o.MinSize >= o.AvgSize || o.AvgSize >= o.MaxSize || bits.OnesCount(uint(o.AvgSize)) != 1 {
+		return fmt.Errorf("%w: got %+v", ErrInvalidOptions, o)
+	}
+	return nil
+}
+
+var gear = func() (table [256]uint64) {
+	// splitmix64 with a fixed seed so that the table is identical everywhere
+	seed := uint64(0x6361766f72697465)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks
+type Chunker struct {
+	r     io.Reader
+	opts  Options
+	maskS uint64
+	maskL uint64
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+func New(r io.Reader, opts Options) (*Chunker, error) {
+	opts = opts.WithDefaults()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	avgBits := bits.Len(uint(opts.AvgSize)) - 1
+	return &Chunker{
+		r:    r,
+		opts: opts,
+		// Before the average size a cut point is harder to find than after it,
+		// which keeps chunk sizes close to the average.
+		maskS: ^uint64(0) << (64 - (avgBits + 1)),
+		maskL: ^uint64(0) << (64 - (avgBits - 1)),
+		buf:   make([]byte, 2*opts.MaxSize),
+	}, nil
+}
+
+// Next returns the next chunk or io.EOF once the stream is exhausted.
+// The returned slice is only valid until the next call to Next.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.opts.MaxSize && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	cut := c.cutPoint(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+cut]
+	c.start += cut
+	return chunk, nil
+}
+
+func (c *Chunker) fill() error {
+	n := copy(c.buf, c.buf[c.start:c.end])
+	c.start, c.end = 0, n
+	for c.end < len(c.buf) {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Chunker) cutPoint(data []byte) int {
+	n := len(data)
+	if n <= c.opts.MinSize {
+		return n
+	}
+	if n > c.opts.MaxSize {
+		n = c.opts.MaxSize
+	}
+	normal := c.opts.AvgSize
+	if n < normal {
+		normal = n
+	}
+	var h uint64
+	i := c.opts.MinSize
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gear[data[i]]
+		if h&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
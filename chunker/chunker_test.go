@@ -0,0 +1,121 @@
+package chunker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOptions = Options{MinSize: 1024, AvgSize: 4096, MaxSize: 16384}
+
+func randomBytes(t *testing.T, seed int64, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	_, err := rand.New(rand.NewSource(seed)).Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func chunks(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+	c, err := New(bytes.NewReader(b), testOptions)
+	require.NoError(t, err)
+	var out [][]byte
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			return out
+		}
+		require.NoError(t, err)
+		out = append(out, bytes.Clone(chunk))
+	}
+}
+
+func TestChunkerSizesAndContent(t *testing.T) {
+	data := randomBytes(t, 1, 1<<20)
+	got := chunks(t, data)
+	require.NotEmpty(t, got)
+	assert.Equal(t, data, bytes.Join(got, nil))
+	for i, c := range got {
+		assert.LessOrEqual(t, len(c), testOptions.MaxSize)
+		if i < len(got)-1 {
+			assert.GreaterOrEqual(t, len(c), testOptions.MinSize)
+		}
+	}
+	// chunk boundaries must be stable across runs
+	assert.Equal(t, got, chunks(t, data))
+	assert.Empty(t, chunks(t, nil))
+}
+
+func TestChunkerEditLocality(t *testing.T) {
+	original := randomBytes(t, 2, 1<<20)
+	edited := append(bytes.Clone(original[:500000]), append([]byte("a few inserted bytes"), original[500000:]...)...)
+
+	before := make(map[string]bool)
+	for _, c := range chunks(t, original) {
+		before[string(c)] = true
+	}
+	after := chunks(t, edited)
+	changed := 0
+	for _, c := range after {
+		if !before[string(c)] {
+			changed++
+		}
+	}
+	assert.LessOrEqual(t, changed, 3, "an insertion should only change the chunks around it")
+}
+
+func TestOptionsValidate(t *testing.T) {
+	assert.NoError(t, Options{}.WithDefaults().Validate())
+	assert.ErrorIs(t, Options{MinSize: 1024, AvgSize: 3000, MaxSize: 8192}.Validate(), ErrInvalidOptions)
+	assert.ErrorIs(t, Options{MinSize: 4096, AvgSize: 4096, MaxSize: 8192}.Validate(), ErrInvalidOptions)
+	_, err := New(bytes.NewReader(nil), Options{MinSize: 10, AvgSize: 16, MaxSize: 32})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestBuildAndParseManifest(t *testing.T) {
+	data := randomBytes(t, 3, 100000)
+	var offsets []int64
+	m, err := Build(bytes.NewReader(data), testOptions, func(c Chunk, offset int64, b []byte) error {
+		offsets = append(offsets, offset)
+		assert.Equal(t, c.Size, int64(len(b)))
+		assert.Equal(t, data[offset:offset+c.Size], b)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), m.Size)
+	assert.Len(t, offsets, len(m.Chunks))
+	assert.Equal(t, "chunks/ab/abcdef", Key("abcdef"))
+	assert.Equal(t, "chunks/a/a", Key("a"))
+
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(m))
+	parsed, err := ParseManifest(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, m, parsed)
+
+	_, err = ParseManifest(bytes.NewBufferString(`{"version": 99}`))
+	assert.ErrorIs(t, err, ErrManifestVersion)
+
+	for _, checksum := range []string{"", "a", "../../../../etc/passwd", strings.Repeat("A", 64), strings.Repeat("a", 63)} {
+		bad := *m
+		bad.Chunks = append([]Chunk{{Checksum: checksum, Size: 1}}, m.Chunks...)
+		buf.Reset()
+		require.NoError(t, json.NewEncoder(&buf).Encode(bad))
+		_, err = ParseManifest(&buf)
+		assert.ErrorIs(t, err, ErrManifestChecksum, checksum)
+	}
+	bad := *m
+	bad.Checksum = "a"
+	buf.Reset()
+	require.NoError(t, json.NewEncoder(&buf).Encode(bad))
+	_, err = ParseManifest(&buf)
+	assert.ErrorIs(t, err, ErrManifestChecksum)
+}
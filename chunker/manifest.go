@@ -0,0 +1,118 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+)
+
+const ManifestVersion = 1
+
+var (
+	ErrManifestVersion  = errors.New("unsupported chunk manifest version")
+	ErrManifestChecksum = errors.New("chunk manifest has a checksum that is not a lowercase hex SHA-256 digest")
+)
+
+// Chunk is a single content-defined chunk of an object
+type Chunk struct {
+	Checksum string `json:"checksum"`
+	Size     int64  `json:"size"`
+}
+
+// Manifest lists the chunks that make up an object, in order
+type Manifest struct {
+	Version int `json:"version"`
+	// Options are the chunk sizes used to split the object. Retrieve uses them to split
+	// a previous local version the same way so that unchanged chunks can be reused.
+	Options  Options `json:"chunker"`
+	Size     int64   `json:"size"`
+	Checksum string  `json:"checksum"`
+	Chunks   []Chunk `json:"chunks"`
+}
+
+// Key returns the object key a chunk is stored under, relative to any key prefix. Chunks are spread
+// over directories named after the first two characters of their checksum.
+func Key(checksum string) string {
+	dir := checksum
+	if len(dir) > 2 {
+		dir = dir[:2]
+	}
+	return path.Join("chunks", dir, checksum)
+}
+
+// validChecksum reports whether checksum is a SHA-256 digest as Build writes it, so that Key keeps
+// the chunk under chunks/
+func validChecksum(checksum string) bool {
+	if len(checksum) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range checksum {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Build splits r into chunks and returns its manifest. fn, if not nil, is called for every chunk with
+// the offset of the chunk in r. data is only valid for the duration of the call.
+func Build(r io.Reader, opts Options, fn func(c Chunk, offset int64, data []byte) error) (*Manifest, error) {
+	opts = opts.WithDefaults()
+	c, err := New(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Version: ManifestVersion,
+		Options: opts,
+		Chunks:  []Chunk{},
+	}
+	whole := sha256.New()
+	for {
+		data, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		whole.Write(data)
+		sum := sha256.Sum256(data)
+		chunk := Chunk{
+			Checksum: hex.EncodeToString(sum[:]),
+			Size:     int64(len(data)),
+		}
+		if fn != nil {
+			if err := fn(chunk, m.Size, data); err != nil {
+				return nil, err
+			}
+		}
+		m.Chunks = append(m.Chunks, chunk)
+		m.Size += chunk.Size
+	}
+	m.Checksum = hex.EncodeToString(whole.Sum(nil))
+	return m, nil
+}
+
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decoding chunk manifest: %w", err)
+	}
+	if m.Version != ManifestVersion {
+		return nil, fmt.Errorf("%w: %d", ErrManifestVersion, m.Version)
+	}
+	if !validChecksum(m.Checksum) {
+		return nil, fmt.Errorf("%w: %q", ErrManifestChecksum, m.Checksum)
+	}
+	for i, c := range m.Chunks {
+		if !validChecksum(c.Checksum) {
+			return nil, fmt.Errorf("%w: chunk %d: %q", ErrManifestChecksum, i, c.Checksum)
+		}
+	}
+	return &m, nil
+}
@@ -5,18 +5,36 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 
+	"github.com/discentem/cavorite/chunker"
+	"github.com/discentem/cavorite/encryption"
+	"github.com/discentem/cavorite/signing"
 	"github.com/discentem/cavorite/stores"
-	"github.com/google/logger"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/afero"
 )
 
 type Config struct {
-	StoreType stores.StoreType             `json:"store_type" mapstructure:"store_type"`
-	Options   stores.Options               `json:"options" mapstructure:"options"`
+	StoreType stores.StoreType `json:"store_type" mapstructure:"store_type"`
+	Options   stores.Options   `json:"options" mapstructure:"options"`
+	// Encryption, if set, encrypts objects client-side before they are uploaded
+	Encryption *encryption.Options `json:"encryption,omitempty" mapstructure:"encryption"`
+	// Chunking, if set, stores objects as deduplicated content-defined chunks
+	Chunking *chunker.Options `json:"chunking,omitempty" mapstructure:"chunking"`
+	// Signing, if set, signs cfiles on upload and verifies their signatures on retrieve
+	Signing *signing.Options `json:"signing,omitempty" mapstructure:"signing"`
+	// Manifest, if set, is the path of a manifest holding the metadata of every object, relative to
+	// the root of the repo. Otherwise metadata is kept in a cfile next to each object.
+	Manifest string `json:"manifest,omitempty" mapstructure:"manifest"`
+	// Track holds patterns, in the syntax of .cavoriteignore files and relative to the root of the
+	// repo, of the files that must be stored with cavorite instead of being committed
+	Track []string `json:"track,omitempty" mapstructure:"track"`
+	// Gitignore, if set, makes upload add the objects it uploads to a block of the .gitignore in the
+	// root of the repo, so that they are not committed next to their cfile
+	Gitignore bool                         `json:"gitignore,omitempty" mapstructure:"gitignore"`
 	Validate  func() error                 `json:"-"`
 	Expander  func(string) (string, error) `json:"-"`
 	Marshal   func(v any) ([]byte, error)  `json:"-"`
@@ -47,14 +65,20 @@ func InitalizeStoreTypeOf(
 	return Config{
 		StoreType: storeType,
 		Options:   opts,
-		Validate: func() error {
-			return nil
-		},
-		Marshal: func(v any) ([]byte, error) {
-			return json.MarshalIndent(v, "", "  ")
-		},
-		Expander: homedir.Expand,
+	}.WithDefaultFuncs()
+}
+
+// WithDefaultFuncs returns c with Validate, Marshal and Expander set to their defaults.
+// They are not part of the config file, so a loaded Config needs them before it can be written.
+func (c Config) WithDefaultFuncs() Config {
+	c.Validate = func() error {
+		return nil
+	}
+	c.Marshal = func(v any) ([]byte, error) {
+		return json.MarshalIndent(v, "", "  ")
 	}
+	c.Expander = homedir.Expand
+	return c
 }
 
 func (c *Config) Write(fsys afero.Fs, sourceRepo string) error {
@@ -93,6 +117,6 @@ func (c *Config) Write(fsys afero.Fs, sourceRepo string) error {
 	if err := fsys.MkdirAll(filepath.Dir(cfile), os.ModePerm); err != nil {
 		return err
 	}
-	logger.Infof("initializing cavorite config at %s", cfile)
+	slog.Info("initializing cavorite config", "path", cfile)
 	return afero.WriteFile(fsys, cfile, b, os.ModePerm)
 }
@@ -44,6 +44,27 @@ func TestLoadConfig(t *testing.T) {
 				return e == nil
 			},
 		},
+		{
+			name:      "track patterns are parsed",
+			parseInto: &Config{},
+			fsys: testutils.FsysWithJsonCavoriteConfig(t, []byte(`{
+					"store_type": "s3",
+					"options": {
+					 "backend_address": "s3://blahaddress/bucket"
+					},
+					"track": ["*.dmg", "assets/**"]
+				   }`)),
+			expected: &Config{
+				StoreType: stores.StoreType("s3"),
+				Options: stores.Options{
+					BackendAddress: "s3://blahaddress/bucket",
+				},
+				Track: []string{"*.dmg", "assets/**"},
+			},
+			expectedLoadError: func(e error) bool {
+				return e == nil
+			},
+		},
 		{
 			name:      "invalid config parsing returns error",
 			parseInto: nil,
@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gonuts/go-shellquote"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/afero"
+)
+
+// Scheme identifies the envelope format written by Encrypt. It is recorded in cfiles
+// so that future formats can be introduced without breaking existing objects.
+const Scheme = "aes-256-gcm-envelope-v1"
+
+// KeySize is the size in bytes of a key encryption key
+const KeySize = 32
+
+var (
+	ErrNoKeySource  = errors.New("one of key_file, key_env or key_command must be set")
+	ErrKeyEnvEmpty  = errors.New("encryption key environment variable is empty")
+	ErrKeyMaterial  = fmt.Errorf("encryption key must be %d raw bytes, hex or base64 encoded", KeySize)
+	ErrKeyCommand   = errors.New("encryption key command failed")
+	ErrUnknownKeyID = errors.New("object was encrypted with a different key")
+)
+
+// Options describe where cavorite finds the key encryption key. Exactly one source is used, checked in the
+// order KeyFile, KeyEnv, KeyCommand.
+type Options struct {
+	// KeyFile is a path to a file containing the key
+	KeyFile string `json:"key_file,omitempty" mapstructure:"key_file"`
+	// KeyEnv is the name of an environment variable containing the key
+	KeyEnv string `json:"key_env,omitempty" mapstructure:"key_env"`
+	// KeyCommand is executed and its stdout is used as the key, e.g. `vault kv get -field=key secret/cavorite`
+	KeyCommand string `json:"key_command,omitempty" mapstructure:"key_command"`
+}
+
+// Enabled reports whether o configures a key source
+func (o *Options) Enabled() bool {
+	if o == nil {
+		return false
+	}
+	return o.KeyFile != "" || o.KeyEnv != "" || o.KeyCommand != ""
+}
+
+// Key is a key encryption key used to wrap the per-object data keys
+type Key struct {
+	// ID is derived from the key material so that cfiles can record which key was used
+	// without revealing anything about the key itself
+	ID       string
+	material []byte
+}
+
+// NewKey returns a Key for raw key material
+func NewKey(material []byte) (*Key, error) {
+	if len(material) != KeySize {
+		return nil, ErrKeyMaterial
+	}
+	sum := sha256.Sum256(material)
+	return &Key{
+		ID:       hex.EncodeToString(sum[:8]),
+		material: material,
+	}, nil
+}
+
+// LoadKey reads the key described by opts
+func LoadKey(ctx context.Context, fsys afero.Fs, opts *Options) (*Key, error) {
+	if !opts.Enabled() {
+		return nil, ErrNoKeySource
+	}
+	var raw []byte
+	switch {
+	case opts.KeyFile != "":
+		p, err := homedir.Expand(opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		raw, err = afero.ReadFile(fsys, p)
+		if err != nil {
+			return nil, fmt.Errorf("reading key_file: %w", err)
+		}
+	case opts.KeyEnv != "":
+		v := os.Getenv(opts.KeyEnv)
+		if v == "" {
+			return nil, fmt.Errorf("%w: %s", ErrKeyEnvEmpty, opts.KeyEnv)
+		}
+		raw = []byte(v)
+	case opts.KeyCommand != "":
+		args, err := shellquote.Split(opts.KeyCommand)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKeyCommand, err)
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%w: empty command", ErrKeyCommand)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = &stderr
+		raw, err = cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v: %s", ErrKeyCommand, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+	material, err := decodeKeyMaterial(raw)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(material)
+}
+
+// decodeKeyMaterial accepts a key as raw bytes, hex or standard base64. Surrounding whitespace is ignored
+// for the encoded forms so that keys can be stored in files that end with a newline.
+func decodeKeyMaterial(raw []byte) ([]byte, error) {
+	if len(raw) == KeySize {
+		return raw, nil
+	}
+	s := strings.TrimSpace(string(raw))
+	if b, err := hex.DecodeString(s); err == nil && len(b) == KeySize {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == KeySize {
+		return b, nil
+	}
+	return nil, ErrKeyMaterial
+}
@@ -0,0 +1,102 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T) *Key {
+	t.Helper()
+	material := make([]byte, KeySize)
+	_, err := rand.Read(material)
+	require.NoError(t, err)
+	k, err := NewKey(material)
+	require.NoError(t, err)
+	return k
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	key := testKey(t)
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 17} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		var ciphertext bytes.Buffer
+		require.NoError(t, Encrypt(&ciphertext, bytes.NewReader(plaintext), key))
+
+		var out bytes.Buffer
+		require.NoError(t, Decrypt(&out, &ciphertext, key))
+		assert.True(t, bytes.Equal(plaintext, out.Bytes()), "size %d", size)
+	}
+}
+
+func TestDecryptWrongKey(t *testing.T) {
+	var ciphertext bytes.Buffer
+	require.NoError(t, Encrypt(&ciphertext, bytes.NewReader([]byte("firmware")), testKey(t)))
+	err := Decrypt(&bytes.Buffer{}, &ciphertext, testKey(t))
+	assert.ErrorIs(t, err, ErrWrongKey)
+}
+
+func TestDecryptTampered(t *testing.T) {
+	key := testKey(t)
+	plaintext := bytes.Repeat([]byte("a"), 2*segmentSize+5)
+	var ciphertext bytes.Buffer
+	require.NoError(t, Encrypt(&ciphertext, bytes.NewReader(plaintext), key))
+	b := ciphertext.Bytes()
+
+	flipped := bytes.Clone(b)
+	flipped[len(flipped)-1] ^= 1
+	assert.ErrorIs(t, Decrypt(&bytes.Buffer{}, bytes.NewReader(flipped), key), ErrDecrypt)
+
+	// dropping the final segment must not go unnoticed
+	headerSize := len(b) - (2*(segmentSize+16) + 5 + 16)
+	truncated := b[:headerSize+2*(segmentSize+16)]
+	assert.ErrorIs(t, Decrypt(&bytes.Buffer{}, bytes.NewReader(truncated), key), ErrDecrypt)
+
+	assert.ErrorIs(t, Decrypt(&bytes.Buffer{}, bytes.NewReader([]byte("plaintext")), key), ErrNotEncrypted)
+}
+
+func TestLoadKey(t *testing.T) {
+	material := bytes.Repeat([]byte{7}, KeySize)
+	expected, err := NewKey(material)
+	require.NoError(t, err)
+
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, "/keys/hex", []byte(hex.EncodeToString(material)+"\n"), 0600))
+	require.NoError(t, afero.WriteFile(fsys, "/keys/short", []byte("tooshort"), 0600))
+	t.Setenv("CAVORITE_TEST_KEY", base64.StdEncoding.EncodeToString(material))
+
+	tests := []struct {
+		name        string
+		opts        *Options
+		expectedErr error
+	}{
+		{name: "nil options", opts: nil, expectedErr: ErrNoKeySource},
+		{name: "hex key file", opts: &Options{KeyFile: "/keys/hex"}},
+		{name: "bad key material", opts: &Options{KeyFile: "/keys/short"}, expectedErr: ErrKeyMaterial},
+		{name: "base64 env var", opts: &Options{KeyEnv: "CAVORITE_TEST_KEY"}},
+		{name: "empty env var", opts: &Options{KeyEnv: "CAVORITE_TEST_KEY_UNSET"}, expectedErr: ErrKeyEnvEmpty},
+		{name: "key command", opts: &Options{KeyCommand: "echo " + hex.EncodeToString(material)}},
+		{name: "failing key command", opts: &Options{KeyCommand: "false"}, expectedErr: ErrKeyCommand},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := LoadKey(context.Background(), fsys, test.opts)
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, expected.ID, k.ID)
+		})
+	}
+}
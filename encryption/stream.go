@@ -0,0 +1,164 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/*
+	Encrypted objects are laid out as
+
+		magic (5 bytes) | wrap nonce (12) | wrapped data key (48) | nonce prefix (7) | segment...
+
+	Each object gets a random data key which is sealed with the key encryption key (envelope encryption).
+	The plaintext is split into segments of segmentSize bytes which are sealed individually with the data key
+	so that arbitrarily large objects can be streamed. A segment nonce is the nonce prefix followed by a
+	big-endian segment counter and a final-segment flag, which prevents segments from being reordered,
+	dropped or truncated without detection.
+*/
+
+const (
+	segmentSize     = 64 * 1024
+	noncePrefixSize = 7
+)
+
+var (
+	magic = []byte("CAVE\x01")
+
+	ErrNotEncrypted = errors.New("object is not a cavorite encrypted object")
+	ErrWrongKey     = errors.New("unable to unwrap data key: wrong encryption key")
+	ErrDecrypt      = errors.New("decryption failed: object is corrupt or was tampered with")
+)
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// readSegment fills buf from r and reports whether it was the last segment in the stream
+func readSegment(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return n, true, nil
+	case err != nil:
+		return n, false, err
+	}
+	if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+// Encrypt reads plaintext from src and writes an encrypted object to dst
+func Encrypt(dst io.Writer, src io.Reader, key *Key) error {
+	kek, err := newGCM(key.material)
+	if err != nil {
+		return err
+	}
+	dataKey := make([]byte, KeySize)
+	wrapNonce := make([]byte, kek.NonceSize())
+	prefix := make([]byte, noncePrefixSize)
+	for _, b := range [][]byte{dataKey, wrapNonce, prefix} {
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+	}
+	header := bytes.NewBuffer(nil)
+	header.Write(magic)
+	header.Write(wrapNonce)
+	header.Write(kek.Seal(nil, wrapNonce, dataKey, magic))
+	header.Write(prefix)
+	if _, err := dst.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(src)
+	buf := make([]byte, segmentSize)
+	out := make([]byte, 0, segmentSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, last, err := readSegment(r, buf)
+		if err != nil {
+			return err
+		}
+		out = aead.Seal(out[:0], segmentNonce(prefix, counter, last), buf[:n], nil)
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// Decrypt reads an encrypted object from src and writes the plaintext to dst. Plaintext is written as
+// segments are authenticated, so callers must discard dst if an error is returned.
+func Decrypt(dst io.Writer, src io.Reader, key *Key) error {
+	kek, err := newGCM(key.material)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(src)
+	header := make([]byte, len(magic)+kek.NonceSize()+KeySize+kek.Overhead()+noncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ErrNotEncrypted
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return ErrNotEncrypted
+	}
+	rest := header[len(magic):]
+	wrapNonce, rest := rest[:kek.NonceSize()], rest[kek.NonceSize():]
+	wrapped, prefix := rest[:KeySize+kek.Overhead()], rest[KeySize+kek.Overhead():]
+	dataKey, err := kek.Open(nil, wrapNonce, wrapped, magic)
+	if err != nil {
+		return ErrWrongKey
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, segmentSize+aead.Overhead())
+	out := make([]byte, 0, segmentSize)
+	for counter := uint32(0); ; counter++ {
+		n, last, err := readSegment(r, buf)
+		if err != nil {
+			return err
+		}
+		out, err = aead.Open(out[:0], segmentNonce(prefix, counter, last), buf[:n], nil)
+		if err != nil {
+			return ErrDecrypt
+		}
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
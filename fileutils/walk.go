@@ -0,0 +1,40 @@
+package fileutils
+
+import (
+	"io/fs"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// SkippedDirs are never searched by Walk
+var SkippedDirs = map[string]bool{
+	".git":      true,
+	".cavorite": true,
+}
+
+// Walk returns every file below each root for which match returns true, in lexical order
+func Walk(fsys afero.Fs, match func(path string) bool, roots ...string) ([]string, error) {
+	var files []string
+	for _, root := range roots {
+		err := afero.Walk(fsys, root, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if path != root && SkippedDirs[info.Name()] {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if match(path) {
+				files = append(files, filepath.Clean(path))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
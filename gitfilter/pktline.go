@@ -0,0 +1,150 @@
+// Package gitfilter implements git's long-running filter process protocol
+// (https://git-scm.com/docs/gitattributes#_long_running_filter_process), which lets a single process
+// clean and smudge every file git checks in or out.
+package gitfilter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxPacketData is the largest payload of a single pkt-line
+	maxPacketData = 65516
+	flushPacket   = "0000"
+)
+
+var ErrProtocol = errors.New("git filter protocol error")
+
+// pktReader reads pkt-lines, each a four digit hex length followed by the payload
+type pktReader struct {
+	r *bufio.Reader
+}
+
+func newPktReader(r io.Reader) *pktReader {
+	return &pktReader{r: bufio.NewReader(r)}
+}
+
+// readPacket returns the payload of the next packet and false, or nil and true for a flush packet
+func (pr *pktReader) readPacket() ([]byte, bool, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(pr.r, header[:]); err != nil {
+		return nil, false, err
+	}
+	n, err := strconv.ParseUint(string(header[:]), 16, 16)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: invalid packet length %q", ErrProtocol, header)
+	}
+	switch {
+	case n == 0:
+		return nil, true, nil
+	case n <= 4 || n-4 > maxPacketData:
+		return nil, false, fmt.Errorf("%w: invalid packet length %d", ErrProtocol, n)
+	}
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(pr.r, payload); err != nil {
+		return nil, false, err
+	}
+	return payload, false, nil
+}
+
+// readText reads text packets up to the next flush packet, without their trailing newlines
+func (pr *pktReader) readText() ([]string, error) {
+	var lines []string
+	for {
+		payload, flush, err := pr.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return lines, nil
+		}
+		lines = append(lines, strings.TrimSuffix(string(payload), "\n"))
+	}
+}
+
+// contentReader returns the payloads of packets up to the next flush packet as a stream
+func (pr *pktReader) contentReader() *pktContentReader {
+	return &pktContentReader{pr: pr}
+}
+
+type pktContentReader struct {
+	pr   *pktReader
+	buf  []byte
+	done bool
+}
+
+func (cr *pktContentReader) Read(p []byte) (int, error) {
+	for len(cr.buf) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+		payload, flush, err := cr.pr.readPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		cr.buf, cr.done = payload, flush
+	}
+	n := copy(p, cr.buf)
+	cr.buf = cr.buf[n:]
+	return n, nil
+}
+
+// pktWriter writes pkt-lines
+type pktWriter struct {
+	w *bufio.Writer
+}
+
+func newPktWriter(w io.Writer) *pktWriter {
+	return &pktWriter{w: bufio.NewWriter(w)}
+}
+
+func (pw *pktWriter) writePacket(payload []byte) error {
+	if len(payload) == 0 || len(payload) > maxPacketData {
+		return fmt.Errorf("%w: cannot write a packet of %d bytes", ErrProtocol, len(payload))
+	}
+	if _, err := fmt.Fprintf(pw.w, "%04x", len(payload)+4); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(payload)
+	return err
+}
+
+// writeFlush writes a flush packet and sends everything buffered so far to git
+func (pw *pktWriter) writeFlush() error {
+	if _, err := pw.w.WriteString(flushPacket); err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+// writeText writes each line as a text packet followed by a flush packet
+func (pw *pktWriter) writeText(lines ...string) error {
+	for _, line := range lines {
+		if err := pw.writePacket([]byte(line + "\n")); err != nil {
+			return err
+		}
+	}
+	return pw.writeFlush()
+}
+
+// Write splits p into as many packets as needed
+func (pw *pktWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxPacketData)
+		if err := pw.writePacket(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,42 @@
+package gitfilter
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPktLineRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	pw := newPktWriter(&buf)
+	require.NoError(t, pw.writeText("command=smudge", "pathname=a.bin"))
+	big := strings.Repeat("x", maxPacketData+10)
+	_, err := pw.Write([]byte(big))
+	require.NoError(t, err)
+	require.NoError(t, pw.writeFlush())
+	assert.True(t, strings.HasPrefix(buf.String(), "0013command=smudge\n0013pathname=a.bin\n0000fff0"))
+
+	pr := newPktReader(&buf)
+	lines, err := pr.readText()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"command=smudge", "pathname=a.bin"}, lines)
+	content, err := io.ReadAll(pr.contentReader())
+	require.NoError(t, err)
+	assert.Equal(t, big, string(content))
+	_, _, err = pr.readPacket()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPktLineInvalid(t *testing.T) {
+	for _, input := range []string{"zzzz", "0003", "fff1"} {
+		_, _, err := newPktReader(strings.NewReader(input)).readPacket()
+		assert.ErrorIs(t, err, ErrProtocol, input)
+	}
+	_, err := io.ReadAll(newPktReader(strings.NewReader("0006ab")).contentReader())
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.ErrorIs(t, newPktWriter(io.Discard).writePacket(nil), ErrProtocol)
+}
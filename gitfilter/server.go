@@ -0,0 +1,324 @@
+package gitfilter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	capabilityClean  = "capability=clean"
+	capabilitySmudge = "capability=smudge"
+	capabilityDelay  = "capability=delay"
+
+	// maxSpoolMemory is the size above which the content of a request is spooled to a temporary file
+	maxSpoolMemory = 1 << 20
+)
+
+// Filter converts files between their contents in the working tree and what git stores. Paths are
+// relative to the root of the working tree.
+type Filter interface {
+	// Clean reads the contents of path in the working tree from r and writes what git should store to w
+	Clean(ctx context.Context, path string, r io.Reader, w io.Writer) error
+	// Smudge reads what git stores for path from r and writes the contents of path to w
+	Smudge(ctx context.Context, path string, r io.Reader, w io.Writer) error
+}
+
+// Server speaks the filter process protocol on behalf of a Filter. Calls to the Filter are never
+// concurrent. The content of each request is read in full before the Filter is called, so Filters
+// are free to write before they have read everything.
+type Server struct {
+	Filter Filter
+	// Delay, if set, lets git go on with other files while smudged files are produced in the
+	// background. Delayed files are written to temporary files until git asks for them.
+	Delay bool
+
+	mu sync.Mutex
+	// the state of delayed smudges, only touched by Serve
+	pending int
+	ready   map[string]*delayedSmudge
+	listed  map[string]*delayedSmudge
+	done    chan *delayedSmudge
+}
+
+type delayedSmudge struct {
+	path   string
+	output *os.File
+	err    error
+}
+
+// Serve answers the requests git writes to r on w until r is closed
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	pr, pw := newPktReader(r), newPktWriter(w)
+	caps, err := s.handshake(pr, pw)
+	if err != nil {
+		return err
+	}
+	delay := s.Delay && slices.Contains(caps, capabilityDelay)
+	s.ready = make(map[string]*delayedSmudge)
+	s.listed = make(map[string]*delayedSmudge)
+	s.done = make(chan *delayedSmudge)
+	defer s.discardDelayed()
+	for {
+		lines, err := pr.readText()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		keys := parseKeys(lines)
+		switch command := keys["command"]; command {
+		case "clean", "smudge":
+			err = s.filter(ctx, pr, pw, command, keys["pathname"], delay && keys["can-delay"] == "1")
+		case "list_available_blobs":
+			err = s.listAvailable(pw)
+		default:
+			err = pw.writeText("status=error")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handshake negotiates the protocol version and returns the capabilities both sides support
+func (s *Server) handshake(pr *pktReader, pw *pktWriter) ([]string, error) {
+	welcome, err := pr.readText()
+	if err != nil {
+		return nil, err
+	}
+	if len(welcome) == 0 || welcome[0] != "git-filter-client" || !slices.Contains(welcome[1:], "version=2") {
+		return nil, fmt.Errorf("%w: unexpected welcome %q", ErrProtocol, welcome)
+	}
+	if err := pw.writeText("git-filter-server", "version=2"); err != nil {
+		return nil, err
+	}
+	offered, err := pr.readText()
+	if err != nil {
+		return nil, err
+	}
+	supported := []string{capabilityClean, capabilitySmudge}
+	if s.Delay {
+		supported = append(supported, capabilityDelay)
+	}
+	var caps []string
+	for _, c := range offered {
+		if slices.Contains(supported, c) {
+			caps = append(caps, c)
+		}
+	}
+	return caps, pw.writeText(caps...)
+}
+
+func parseKeys(lines []string) map[string]string {
+	keys := make(map[string]string)
+	for _, line := range lines {
+		k, v, _ := strings.Cut(line, "=")
+		keys[k] = v
+	}
+	return keys
+}
+
+// filter answers a single clean or smudge request
+func (s *Server) filter(ctx context.Context, pr *pktReader, pw *pktWriter, command, path string, canDelay bool) error {
+	// git writes the whole request before it reads the response
+	input, err := spool(pr.contentReader())
+	if err != nil {
+		return err
+	}
+	if d, ok := s.listed[path]; ok && command == "smudge" {
+		// git asks again for a delayed file it was told is available, without content
+		input.Close()
+		delete(s.listed, path)
+		return s.respond(pw, func(w io.Writer) error {
+			if d.err != nil {
+				return d.err
+			}
+			_, err := io.Copy(w, d.output)
+			return err
+		}, d.close)
+	}
+	if canDelay && command == "smudge" {
+		return s.delay(ctx, pw, path, input)
+	}
+	defer input.Close()
+	return s.respond(pw, func(w io.Writer) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if command == "clean" {
+			return s.Filter.Clean(ctx, path, input, w)
+		}
+		return s.Filter.Smudge(ctx, path, input, w)
+	})
+}
+
+// spool reads r into memory, or a temporary file if it is large
+func spool(r io.Reader) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, r, maxSpoolMemory+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if n <= maxSpoolMemory {
+		return io.NopCloser(&buf), nil
+	}
+	f, err := os.CreateTemp("", "cavorite-filter-")
+	if err != nil {
+		return nil, err
+	}
+	// the file lives on until it is closed
+	_ = os.Remove(f.Name())
+	if _, err := io.Copy(f, io.MultiReader(&buf, r)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// respond writes the output of write to git, followed by the status of the request. cleanups are
+// called once the response is written.
+func (s *Server) respond(pw *pktWriter, write func(w io.Writer) error, cleanups ...func()) error {
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+	rw := &responseWriter{pw: pw}
+	err := write(rw)
+	if rw.err != nil {
+		// git is gone
+		return rw.err
+	}
+	return rw.finish(err)
+}
+
+// delay smudges input in the background
+func (s *Server) delay(ctx context.Context, pw *pktWriter, path string, input io.ReadCloser) error {
+	output, err := os.CreateTemp("", "cavorite-filter-")
+	if err != nil {
+		input.Close()
+		return err
+	}
+	_ = os.Remove(output.Name())
+	s.pending++
+	go func() {
+		defer input.Close()
+		d := &delayedSmudge{path: path, output: output}
+		s.mu.Lock()
+		d.err = s.Filter.Smudge(ctx, path, input, output)
+		s.mu.Unlock()
+		if d.err == nil {
+			_, d.err = output.Seek(0, io.SeekStart)
+		}
+		s.done <- d
+	}()
+	return pw.writeText("status=delayed")
+}
+
+// listAvailable tells git which delayed files are ready, waiting for at least one if none are
+func (s *Server) listAvailable(pw *pktWriter) error {
+	if len(s.ready) == 0 && s.pending > 0 {
+		s.collect(<-s.done)
+	}
+	for collecting := true; collecting; {
+		select {
+		case d := <-s.done:
+			s.collect(d)
+		default:
+			collecting = false
+		}
+	}
+	paths := make([]string, 0, len(s.ready))
+	for path, d := range s.ready {
+		paths = append(paths, "pathname="+path)
+		s.listed[path] = d
+		delete(s.ready, path)
+	}
+	slices.Sort(paths)
+	if err := pw.writeText(paths...); err != nil {
+		return err
+	}
+	return pw.writeText("status=success")
+}
+
+func (s *Server) collect(d *delayedSmudge) {
+	s.pending--
+	s.ready[d.path] = d
+}
+
+// discardDelayed removes the output of delayed smudges git never asked for
+func (s *Server) discardDelayed() {
+	for _, d := range s.ready {
+		d.close()
+	}
+	for _, d := range s.listed {
+		d.close()
+	}
+	go func(pending int) {
+		for ; pending > 0; pending-- {
+			(<-s.done).close()
+		}
+	}(s.pending)
+}
+
+func (d *delayedSmudge) close() {
+	d.output.Close()
+}
+
+// responseWriter sends status=success before the first byte of content and splits content into packets
+type responseWriter struct {
+	pw      *pktWriter
+	started bool
+	// err is the first error writing to git
+	err error
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if !rw.started {
+		rw.started = true
+		if rw.err = rw.pw.writeText("status=success"); rw.err != nil {
+			return 0, rw.err
+		}
+	}
+	n, err := rw.pw.Write(p)
+	rw.err = err
+	return n, err
+}
+
+// finish ends the content and reports err, the result of the request, to git. If no content was
+// written yet git discards the file, otherwise it is told to discard what it received.
+func (rw *responseWriter) finish(err error) error {
+	if err != nil && !rw.started {
+		return rw.pw.writeText("status=error")
+	}
+	if !rw.started {
+		if writeErr := rw.pw.writeText("status=success"); writeErr != nil {
+			return writeErr
+		}
+	}
+	if writeErr := rw.pw.writeFlush(); writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return rw.pw.writeText("status=error")
+	}
+	// an empty list keeps status=success
+	return rw.pw.writeFlush()
+}
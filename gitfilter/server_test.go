@@ -0,0 +1,263 @@
+package gitfilter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gonuts/go-shellquote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dirFilter stores cleaned contents in a directory, named by their sha256
+type dirFilter string
+
+func (f dirFilter) Clean(ctx context.Context, path string, r io.Reader, w io.Writer) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	checksum := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(filepath.Join(string(f), checksum), b, 0644); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "stored:%s\n", checksum)
+	return err
+}
+
+func (f dirFilter) Smudge(ctx context.Context, path string, r io.Reader, w io.Writer) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	checksum, ok := strings.CutPrefix(strings.TrimSpace(string(b)), "stored:")
+	if !ok {
+		_, err := w.Write(b)
+		return err
+	}
+	stored, err := os.ReadFile(filepath.Join(string(f), checksum))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(stored)
+	return err
+}
+
+// TestMain serves dirFilter when git runs the test binary as a filter process
+func TestMain(m *testing.M) {
+	if dir := os.Getenv("GITFILTER_TEST_STORE"); dir != "" {
+		s := &Server{Filter: dirFilter(dir), Delay: os.Getenv("GITFILTER_TEST_DELAY") == "1"}
+		if err := s.Serve(context.Background(), os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeGit speaks the git side of the protocol to a Server
+type fakeGit struct {
+	pr *pktReader
+	pw *pktWriter
+}
+
+func newFakeGit(t *testing.T, s *Server, caps ...string) *fakeGit {
+	t.Helper()
+	toServer, fromGit := io.Pipe()
+	fromServer, toGit := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(context.Background(), toServer, toGit)
+		toGit.Close()
+	}()
+	t.Cleanup(func() {
+		fromGit.Close()
+		assert.NoError(t, <-served)
+	})
+	g := &fakeGit{pr: newPktReader(fromServer), pw: newPktWriter(fromGit)}
+	require.NoError(t, g.pw.writeText("git-filter-client", "version=2"))
+	lines, err := g.pr.readText()
+	require.NoError(t, err)
+	require.Equal(t, []string{"git-filter-server", "version=2"}, lines)
+	require.NoError(t, g.pw.writeText(caps...))
+	return g
+}
+
+// request sends a request and returns the status, the content and the final status of the response
+func (g *fakeGit) request(t *testing.T, content string, keys ...string) (string, string, string) {
+	t.Helper()
+	require.NoError(t, g.pw.writeText(keys...))
+	if content != "" {
+		_, err := g.pw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, g.pw.writeFlush())
+	status, err := g.pr.readText()
+	require.NoError(t, err)
+	if status[0] != "status=success" {
+		return status[0], "", status[0]
+	}
+	b, err := io.ReadAll(g.pr.contentReader())
+	require.NoError(t, err)
+	final, err := g.pr.readText()
+	require.NoError(t, err)
+	if len(final) == 0 {
+		return status[0], string(b), status[0]
+	}
+	return status[0], string(b), final[0]
+}
+
+func TestServer(t *testing.T) {
+	store := t.TempDir()
+	g := newFakeGit(t, &Server{Filter: dirFilter(store)}, capabilityClean, capabilitySmudge, capabilityDelay)
+	caps, err := g.pr.readText()
+	require.NoError(t, err)
+	assert.Equal(t, []string{capabilityClean, capabilitySmudge}, caps)
+
+	_, cleaned, final := g.request(t, "binary", "command=clean", "pathname=a.bin")
+	assert.Equal(t, "status=success", final)
+	assert.True(t, strings.HasPrefix(cleaned, "stored:"))
+
+	// can-delay is ignored unless the server delays
+	_, smudged, final := g.request(t, cleaned, "command=smudge", "pathname=a.bin", "can-delay=1")
+	assert.Equal(t, "status=success", final)
+	assert.Equal(t, "binary", smudged)
+
+	_, smudged, _ = g.request(t, "", "command=smudge", "pathname=empty.bin")
+	assert.Equal(t, "", smudged)
+
+	status, _, _ := g.request(t, "stored:missing\n", "command=smudge", "pathname=b.bin")
+	assert.Equal(t, "status=error", status)
+
+	// unknown commands have no content
+	require.NoError(t, g.pw.writeText("command=unknown"))
+	lines, err := g.pr.readText()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"status=error"}, lines)
+}
+
+// failingFilter fails after writing part of a smudged file
+type failingFilter struct{ dirFilter }
+
+func (f failingFilter) Smudge(ctx context.Context, path string, r io.Reader, w io.Writer) error {
+	if _, err := w.Write([]byte("partial")); err != nil {
+		return err
+	}
+	return errors.New("connection reset")
+}
+
+func TestServerErrorAfterContent(t *testing.T) {
+	g := newFakeGit(t, &Server{Filter: failingFilter{dirFilter(t.TempDir())}}, capabilitySmudge)
+	_, err := g.pr.readText()
+	require.NoError(t, err)
+	status, content, final := g.request(t, "stored:x\n", "command=smudge", "pathname=a.bin")
+	assert.Equal(t, "status=success", status)
+	assert.Equal(t, "partial", content)
+	assert.Equal(t, "status=error", final)
+}
+
+func TestServerDelay(t *testing.T) {
+	store := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(store, "a"), []byte("first"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(store, "b"), []byte("second"), 0644))
+	g := newFakeGit(t, &Server{Filter: dirFilter(store), Delay: true}, capabilitySmudge, capabilityDelay)
+	caps, err := g.pr.readText()
+	require.NoError(t, err)
+	assert.Equal(t, []string{capabilitySmudge, capabilityDelay}, caps)
+
+	for _, path := range []string{"a", "b", "missing"} {
+		status, _, _ := g.request(t, "stored:"+path, "command=smudge", "pathname="+path+".bin", "can-delay=1")
+		assert.Equal(t, "status=delayed", status)
+	}
+
+	var available []string
+	for len(available) < 3 {
+		require.NoError(t, g.pw.writeText("command=list_available_blobs"))
+		paths, err := g.pr.readText()
+		require.NoError(t, err)
+		require.NotEmpty(t, paths)
+		status, err := g.pr.readText()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"status=success"}, status)
+		available = append(available, paths...)
+	}
+	assert.ElementsMatch(t, []string{"pathname=a.bin", "pathname=b.bin", "pathname=missing.bin"}, available)
+
+	_, smudged, _ := g.request(t, "", "command=smudge", "pathname=a.bin")
+	assert.Equal(t, "first", smudged)
+	_, smudged, _ = g.request(t, "", "command=smudge", "pathname=b.bin")
+	assert.Equal(t, "second", smudged)
+	status, _, _ := g.request(t, "", "command=smudge", "pathname=missing.bin")
+	assert.Equal(t, "status=error", status)
+
+	// nothing is pending, so the list is empty
+	require.NoError(t, g.pw.writeText("command=list_available_blobs"))
+	paths, err := g.pr.readText()
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+	_, err = g.pr.readText()
+	require.NoError(t, err)
+}
+
+func git(t *testing.T, dir string, env []string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "git %s: %s", strings.Join(args, " "), out)
+	return string(out)
+}
+
+func TestServerWithGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	self, err := os.Executable()
+	require.NoError(t, err)
+	for _, delay := range []string{"0", "1"} {
+		t.Run("delay="+delay, func(t *testing.T) {
+			repo, store := t.TempDir(), t.TempDir()
+			env := []string{"GITFILTER_TEST_STORE=" + store, "GITFILTER_TEST_DELAY=" + delay}
+			git(t, repo, env, "init", "-q")
+			git(t, repo, env, "config", "user.name", "test")
+			git(t, repo, env, "config", "user.email", "test@example.com")
+			git(t, repo, env, "config", "filter.test.process", shellquote.Join(self))
+			git(t, repo, env, "config", "filter.test.required", "true")
+			require.NoError(t, os.WriteFile(filepath.Join(repo, ".gitattributes"), []byte("*.bin filter=test -text\n"), 0644))
+			files := map[string]string{"a.bin": "\x00first", "dir/b.bin": "\x00second", "c.txt": "plain"}
+			for path, content := range files {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(repo, path)), 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(repo, path), []byte(content), 0644))
+			}
+			git(t, repo, env, "add", ".")
+			git(t, repo, env, "commit", "-q", "-m", "add files")
+
+			stored := git(t, repo, env, "cat-file", "-p", "HEAD:a.bin")
+			assert.True(t, strings.HasPrefix(stored, "stored:"), stored)
+			assert.Equal(t, "plain", git(t, repo, env, "cat-file", "-p", "HEAD:c.txt"))
+			assert.Empty(t, git(t, repo, env, "status", "--porcelain"))
+
+			for path := range files {
+				require.NoError(t, os.Remove(filepath.Join(repo, path)))
+			}
+			git(t, repo, env, "checkout", "--", ".")
+			for path, content := range files {
+				b, err := os.ReadFile(filepath.Join(repo, path))
+				require.NoError(t, err)
+				assert.Equal(t, content, string(b), path)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+// Package ignore implements .cavoriteignore files, which use the syntax of gitignore files
+// (https://git-scm.com/docs/gitignore) to exclude paths from being uploaded.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// FileName is the name of ignore files. Each applies to the directory it is in and everything below it.
+const FileName = ".cavoriteignore"
+
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// parsePattern parses one line of an ignore file. It returns nil for blank lines and comments.
+func parsePattern(line string) (*pattern, error) {
+	line = trimTrailingSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	p := &pattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	// a pattern containing a slash is relative to the directory of the ignore file,
+	// otherwise it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return nil, nil
+	}
+	expr := globToRegexp(line)
+	if !anchored && !strings.HasPrefix(expr, "(?:.*/)?") {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, err
+	}
+	p.re = re
+	return p, nil
+}
+
+// trimTrailingSpace removes trailing spaces unless they are escaped with a backslash
+func trimTrailingSpace(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// globToRegexp translates a gitignore glob into a regular expression matching slash separated paths
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/") && (i == 0 || glob[i-1] == '/'):
+			// leading or inner "**/" matches zero or more directories
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case glob[i:] == "**" && i > 0 && glob[i-1] == '/':
+			// trailing "/**" matches everything inside
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// rules are the patterns of one ignore file
+type rules struct {
+	// dir is the directory the ignore file is in, "." for the root
+	dir      string
+	patterns []*pattern
+}
+
+// match reports whether rel, relative to the directory of the rules, is matched and if so
+// whether it is ignored. The last matching pattern wins.
+func (r *rules) match(rel string, isDir bool) (matched, ignored bool) {
+	for _, p := range r.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			matched, ignored = true, !p.negate
+		}
+	}
+	return matched, ignored
+}
+
+// Matcher reports whether paths are ignored by the ignore files of a repo. Paths are relative to
+// the root of the repo. Ignore files are read the first time a path below them is checked.
+type Matcher struct {
+	fsys  afero.Fs
+	root  string
+	rules map[string]*rules
+}
+
+// NewMatcher returns a Matcher for the repo at root in fsys
+func NewMatcher(fsys afero.Fs, root string) *Matcher {
+	return &Matcher{fsys: fsys, root: root, rules: make(map[string]*rules)}
+}
+
+// load returns the rules of the ignore file in dir, which are empty if there is none
+func (m *Matcher) load(dir string) (*rules, error) {
+	if r, ok := m.rules[dir]; ok {
+		return r, nil
+	}
+	r := &rules{dir: dir}
+	b, err := afero.ReadFile(m.fsys, filepath.Join(m.root, filepath.FromSlash(dir), FileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		p, err := parsePattern(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filepath.Join(dir, FileName), line, err)
+		}
+		if p != nil {
+			r.patterns = append(r.patterns, p)
+		}
+	}
+	m.rules[dir] = r
+	return r, nil
+}
+
+// Ignored reports whether p, a file or a directory if isDir is true, is ignored. A path is also
+// ignored if any directory it is in is ignored.
+func (m *Matcher) Ignored(p string, isDir bool) (bool, error) {
+	p = filepath.ToSlash(filepath.Clean(p))
+	if p == "." {
+		return false, nil
+	}
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		prefix := path.Join(parts[:i+1]...)
+		ignored, err := m.ignoredItself(prefix, i < len(parts)-1 || isDir)
+		if err != nil || ignored {
+			return ignored, err
+		}
+	}
+	return false, nil
+}
+
+// ignoredItself applies the ignore files of every directory above p to p, deeper ones taking precedence
+func (m *Matcher) ignoredItself(p string, isDir bool) (bool, error) {
+	var dirs []string
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == "." {
+			break
+		}
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		r, err := m.load(dir)
+		if err != nil {
+			return false, err
+		}
+		rel := p
+		if dir != "." {
+			rel = strings.TrimPrefix(p, dir+"/")
+		}
+		if matched, ign := r.match(rel, isDir); matched {
+			ignored = ign
+		}
+	}
+	return ignored, nil
+}
+
+// Patterns are patterns in the syntax of ignore files given by other means, such as on the command
+// line. They are matched against paths relative to the root of the repo.
+type Patterns struct {
+	r rules
+}
+
+// ParsePatterns parses each of lines as a line of an ignore file
+func ParsePatterns(lines ...string) (*Patterns, error) {
+	ps := &Patterns{r: rules{dir: "."}}
+	for _, line := range lines {
+		p, err := parsePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", line, err)
+		}
+		if p != nil {
+			ps.r.patterns = append(ps.r.patterns, p)
+		}
+	}
+	return ps, nil
+}
+
+// Len returns the number of patterns, which is zero if every line was blank or a comment
+func (ps *Patterns) Len() int {
+	return len(ps.r.patterns)
+}
+
+// Match reports whether p, a file or a directory if isDir is true, is matched. Like with ignore
+// files, a path is also matched if any directory it is in is matched.
+func (ps *Patterns) Match(p string, isDir bool) bool {
+	p = filepath.ToSlash(filepath.Clean(p))
+	if p == "." {
+		return false
+	}
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		if matched, ign := ps.r.match(path.Join(parts[:i+1]...), i < len(parts)-1 || isDir); matched && ign {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package ignore
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{pattern: ".DS_Store", path: ".DS_Store", want: true},
+		{pattern: ".DS_Store", path: "assets/deep/.DS_Store", want: true},
+		{pattern: "*.psd", path: "assets/logo.psd", want: true},
+		{pattern: "*.psd", path: "assets/logo.png", want: false},
+		{pattern: "/build", path: "build", isDir: true, want: true},
+		{pattern: "/build", path: "assets/build", isDir: true, want: false},
+		{pattern: "doc/*.txt", path: "doc/notes.txt", want: true},
+		{pattern: "doc/*.txt", path: "doc/server/notes.txt", want: false},
+		{pattern: "**/tmp", path: "a/b/tmp", isDir: true, want: true},
+		{pattern: "**/tmp", path: "tmp", isDir: true, want: true},
+		{pattern: "a/**/b", path: "a/b", want: true},
+		{pattern: "a/**/b", path: "a/x/y/b", want: true},
+		{pattern: "logs/**", path: "logs/2024/today.log", want: true},
+		{pattern: "cache/", path: "cache", isDir: true, want: true},
+		{pattern: "cache/", path: "cache", isDir: false, want: false},
+		{pattern: "file?.bin", path: "file1.bin", want: true},
+		{pattern: "file?.bin", path: "file10.bin", want: false},
+		{pattern: "v[0-9].bin", path: "v3.bin", want: true},
+		{pattern: "v[!0-9].bin", path: "v3.bin", want: false},
+		{pattern: `\#notacomment`, path: "#notacomment", want: true},
+		{pattern: "trailing   ", path: "trailing", want: true},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.path, func(t *testing.T) {
+			p, err := parsePattern(test.pattern)
+			require.NoError(t, err)
+			require.NotNil(t, p)
+			r := rules{patterns: []*pattern{p}}
+			matched, ignored := r.match(test.path, test.isDir)
+			assert.Equal(t, test.want, matched && ignored)
+		})
+	}
+}
+
+func TestParsePatternSkipsComments(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		p, err := parsePattern(line)
+		require.NoError(t, err)
+		assert.Nil(t, p, line)
+	}
+}
+
+func TestMatcher(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, "repo/"+FileName, []byte(`
+# macOS litter
+.DS_Store
+*.tmp
+/scratch/
+!keep.tmp
+`), 0644))
+	require.NoError(t, afero.WriteFile(fsys, "repo/assets/"+FileName, []byte(`
+*.psd
+!important.tmp
+`), 0644))
+	m := NewMatcher(fsys, "repo")
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "assets/logo.png"},
+		{path: "assets/.DS_Store", want: true},
+		{path: "assets/logo.psd", want: true},
+		{path: "logo.psd"},
+		{path: "a.tmp", want: true},
+		{path: "keep.tmp"},
+		// deeper ignore files take precedence
+		{path: "assets/important.tmp"},
+		{path: "scratch", isDir: true, want: true},
+		// files in an ignored directory cannot be re-included
+		{path: "scratch/keep.tmp", want: true},
+		{path: "assets/scratch/x"},
+		{path: "."},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			ignored, err := m.Ignored(test.path, test.isDir)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, ignored)
+		})
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	ps, err := ParsePatterns("*.dmg", "assets/", "!keep.dmg", "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, ps.Len())
+	assert.True(t, ps.Match("installers/chrome.dmg", false))
+	assert.False(t, ps.Match("installers/keep.dmg", false))
+	assert.True(t, ps.Match("assets/logo.png", false))
+	assert.True(t, ps.Match("assets", true))
+	assert.False(t, ps.Match("assets", false))
+	assert.False(t, ps.Match(".", true))
+
+	_, err = ParsePatterns("[z-a]")
+	assert.Error(t, err)
+}
@@ -0,0 +1,303 @@
+package cli
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/archive"
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	cavoriteObjLib "github.com/discentem/cavorite/objects"
+	"github.com/discentem/cavorite/stores"
+)
+
+var (
+	ErrNotADirectory     = errors.New("only directories can be uploaded as archives")
+	ErrArchived          = errors.New("object is an archived directory")
+	ErrStagingWithPlugin = errors.New("plugin stores cannot read from or write to a staging location")
+)
+
+// archiver uploads directories as single archives and extracts them again when they are retrieved.
+// Archives are written to and read from a staging filesystem so that they never touch the source repo.
+type archiver struct {
+	fsys afero.Fs
+	src  metadata.Source
+	// newStore returns the Store to use with fsys, a filesystem on which archives are read from and
+	// written to staging while everything else, like keys, is read from the source repo
+	newStore func(fsys afero.Fs) (stores.Store, error)
+}
+
+// splitArchives separates paths, which may name objects or their cfiles, into the paths of objects and
+// directories that are stored as archives
+func splitArchives(src metadata.Source, ext string, paths ...string) (objects []string, dirs []string) {
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		obj := strings.TrimSuffix(filepath.Clean(path), "."+ext)
+		if m, err := src.Get(obj); err == nil && m.Archive != nil {
+			if !seen[obj] {
+				seen[obj] = true
+				dirs = append(dirs, obj)
+			}
+			continue
+		}
+		objects = append(objects, path)
+	}
+	return objects, dirs
+}
+
+// stagedStoreFunc returns a function that initializes the Store described by cfg on a filesystem
+// returned by newStagingOverlay
+func stagedStoreFunc(ctx context.Context, cfg config.Config) func(afero.Fs) (stores.Store, error) {
+	return func(fsys afero.Fs) (stores.Store, error) {
+		if cfg.StoreType == stores.StoreTypeGoPlugin {
+			// plugins read and write the source repo directly
+			return nil, ErrStagingWithPlugin
+		}
+		return initStoreFromConfig(ctx, cfg, fsys)
+	}
+}
+
+// newStagingOverlay returns a new staging filesystem, a filesystem that reads and writes the files in
+// staging and everything else in fsys, and a function that removes staging. The layer of a
+// CopyOnWriteFs takes precedence, so once a file is staged under the path of an object, Stores
+// constructed on the overlay read and write the staged file instead of the object.
+func newStagingOverlay(fsys afero.Fs) (afero.Fs, afero.Fs, func() error, error) {
+	staging, cleanup, err := stores.NewStagingFs()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return afero.NewCopyOnWriteFs(fsys, staging), staging, cleanup, nil
+}
+
+// stagePlaceholder creates an empty file in staging under the path of obj, so that a Store on the
+// overlay retrieves obj into staging instead of copying obj, which may be a directory, into it
+func stagePlaceholder(staging afero.Fs, obj string) error {
+	if err := staging.MkdirAll(filepath.Dir(obj), os.ModePerm); err != nil {
+		return err
+	}
+	return afero.WriteFile(staging, obj, nil, 0644)
+}
+
+// upload uploads each directory in dirs as an archive and records its metadata in a.src
+func (a *archiver) upload(ctx context.Context, compression string, dirs ...string) (err error) {
+	if err := archive.ValidCompression(compression); err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		info, err := a.fsys.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%w: %s", ErrNotADirectory, dir)
+		}
+	}
+	overlay, staging, cleanup, err := newStagingOverlay(a.fsys)
+	if err != nil {
+		return err
+	}
+	defer func() { err = multierr.Append(err, cleanup()).ErrorOrNil() }()
+	s, err := a.newStore(overlay)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	opts, err := s.GetOptions()
+	if err != nil {
+		return err
+	}
+	prefixOp := cavoriteObjLib.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}
+	archived := &archivedStore{Store: s, archives: make(map[string]metadata.ArchiveMetadata)}
+	for _, dir := range dirs {
+		entries, err := a.pack(staging, dir, compression)
+		if err != nil {
+			return err
+		}
+		slog.Info("archived directory", "path", dir, "entries", entries)
+		archived.archives[prefixOp.Modify(dir)] = metadata.ArchiveMetadata{
+			Format:      archive.Format,
+			Compression: compression,
+			Entries:     entries,
+		}
+	}
+	return upload(ctx, overlay, a.src, archived, dirs...)
+}
+
+// pack writes the archive of dir to staging under the path of dir
+func (a *archiver) pack(staging afero.Fs, dir, compression string) (int, error) {
+	if err := staging.MkdirAll(filepath.Dir(dir), os.ModePerm); err != nil {
+		return 0, err
+	}
+	f, err := staging.Create(dir)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := archive.Write(f, a.fsys, dir, compression)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return entries, err
+}
+
+// retrieve retrieves the archive of each directory in dirs that does not match its metadata in a.src
+// and replaces the directory with its contents
+func (a *archiver) retrieve(ctx context.Context, ext string, dirs ...string) (err error) {
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	var result *multierr.Error
+	r := reportFrom(ctx)
+	start := time.Now()
+	mmap := make(metadata.CfileMetadataMap)
+	// stores derive the path of the object from the cfile it would have in sidecar mode
+	var cfiles, wanted []string
+	for _, dir := range dirs {
+		m, err := a.src.Get(dir)
+		if err == nil && m.Archive == nil {
+			err = fmt.Errorf("%s is not an archived directory", dir)
+		}
+		if err != nil {
+			r.add(newObjectResult(dir, outcomeFailed, nil, start, err))
+			result = multierr.Append(result, err)
+			continue
+		}
+		doRetrieve, err := shouldRetrieveArchive(a.fsys, m, dir)
+		if err != nil {
+			r.add(newObjectResult(dir, outcomeFailed, m, start, err))
+			result = multierr.Append(result, err)
+			continue
+		}
+		if !doRetrieve {
+			slog.Info("retrieval not needed, directory matches its archive", "path", dir)
+			r.add(newObjectResult(dir, outcomeSkipped, m, start, nil))
+			continue
+		}
+		cfile := fmt.Sprintf("%s.%s", dir, ext)
+		mmap[cfile] = *m
+		cfiles = append(cfiles, cfile)
+		wanted = append(wanted, dir)
+	}
+	if len(cfiles) == 0 {
+		return result.ErrorOrNil()
+	}
+
+	overlay, staging, cleanup, err := newStagingOverlay(a.fsys)
+	if err != nil {
+		return err
+	}
+	defer func() { err = multierr.Append(err, cleanup()).ErrorOrNil() }()
+	for _, dir := range wanted {
+		if err := stagePlaceholder(staging, dir); err != nil {
+			return err
+		}
+	}
+	s, err := a.newStore(overlay)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Retrieve(ctx, mmap, cfiles...); err != nil {
+		result = multierr.Append(result, err)
+	}
+	// archives are retrieved together, failures show when they are extracted
+	for i, dir := range wanted {
+		m := mmap[cfiles[i]]
+		err := a.extract(staging, m, dir)
+		r.add(newObjectResult(dir, outcomeRetrieved, &m, start, err))
+		result = multierr.Append(result, err)
+	}
+	return result.ErrorOrNil()
+}
+
+// shouldRetrieveArchive reports whether dir is missing or does not match the archive described by m.
+// Archives are deterministic, so dir matches if packing it again produces the same checksum.
+func shouldRetrieveArchive(fsys afero.Fs, m *metadata.ObjectMetaData, dir string) (bool, error) {
+	if _, err := fsys.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	h, err := metadata.NewHasher(m.HashAlgorithm())
+	if err != nil {
+		return false, err
+	}
+	if _, err := archive.Write(h, fsys, dir, m.Archive.Compression); err != nil {
+		slog.Debug("directory could not be archived, retrieving it", "path", dir, "error", err)
+		return true, nil
+	}
+	return hex.EncodeToString(h.Sum(nil)) != m.Checksum, nil
+}
+
+// extract verifies the archive of dir in staging against m and replaces dir with its contents
+func (a *archiver) extract(staging afero.Fs, m metadata.ObjectMetaData, dir string) error {
+	f, err := staging.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	actual, err := metadata.HashFromReader(m.HashAlgorithm(), f)
+	if err != nil {
+		return err
+	}
+	if actual != m.Checksum {
+		slog.Debug("hash mismatch", "path", dir, "got", actual, "expected", m.Checksum)
+		return fmt.Errorf("%s: %w", dir, metadata.ErrRetrieveFailureHashMismatch)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// extract next to dir first so that dir is left alone if the archive is rejected
+	tmp := dir + ".cavorite-tmp"
+	if err := a.fsys.RemoveAll(tmp); err != nil {
+		return err
+	}
+	entries, err := archive.Extract(a.fsys, f, tmp, m.Archive.Compression)
+	if err != nil {
+		_ = a.fsys.RemoveAll(tmp)
+		return fmt.Errorf("%s: %w", dir, err)
+	}
+	if err := a.fsys.RemoveAll(dir); err != nil {
+		return err
+	}
+	slog.Info("extracted archive", "path", dir, "entries", entries)
+	return a.fsys.Rename(tmp, dir)
+}
+
+// archivedStore records the archive metadata of the directories it uploads before the wrapped Store,
+// which may sign the metadata, annotates it
+type archivedStore struct {
+	stores.Store
+	// archives holds the metadata of each archive by object key
+	archives map[string]metadata.ArchiveMetadata
+}
+
+func (s *archivedStore) Annotate(key string, m *metadata.ObjectMetaData) error {
+	am, ok := s.archives[key]
+	if !ok {
+		return fmt.Errorf("%q was not archived by this store", key)
+	}
+	m.Archive = &am
+	m.SchemaVersion = m.RequiredSchemaVersion()
+	// the mode of the staged archive says nothing about the directory
+	m.Mode = 0
+	if annotator, ok := s.Store.(stores.MetadataAnnotator); ok {
+		return annotator.Annotate(key, m)
+	}
+	return nil
+}
+
+func (s *archivedStore) PrepareUpload() error {
+	return prepareUpload(s.Store)
+}
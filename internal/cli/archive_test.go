@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/archive"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/testutils"
+)
+
+// bucketStore uploads objects from fsys into bucket and retrieves them like simpleStoreForRetrieve
+type bucketStore struct {
+	simpleStoreForRetrieve
+	retrieved *int
+}
+
+func (s bucketStore) Upload(ctx context.Context, objects ...string) error {
+	for _, obj := range objects {
+		b, err := afero.ReadFile(s.sourceFsys, obj)
+		if err != nil {
+			return err
+		}
+		if err := afero.WriteFile(s.bucketFsys, obj, b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s bucketStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap, cfiles ...string) error {
+	*s.retrieved += len(cfiles)
+	return s.simpleStoreForRetrieve.Retrieve(ctx, mmap, cfiles...)
+}
+
+func newTestArchiver(t *testing.T) (*archiver, afero.Fs, afero.Fs, *int) {
+	t.Helper()
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"sdk/include/sdk.h": {Content: []byte("int sdk(void);\n")},
+		"sdk/lib/libsdk.a":  {Content: []byte("!<arch>\n"), Mode: 0755},
+	})
+	require.NoError(t, err)
+	bucket := afero.NewMemMapFs()
+	retrieved := new(int)
+	a := &archiver{
+		fsys: *fsys,
+		src:  metadata.NewSidecarSource(*fsys, "cfile"),
+		newStore: func(overlay afero.Fs) (stores.Store, error) {
+			return bucketStore{
+				simpleStoreForRetrieve: simpleStoreForRetrieve{
+					sourceFsys: overlay,
+					bucketFsys: bucket,
+					options:    stores.Options{MetadataFileExtension: "cfile"},
+				},
+				retrieved: retrieved,
+			}, nil
+		},
+	}
+	return a, *fsys, bucket, retrieved
+}
+
+func TestArchiverRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	a, fsys, _, retrieved := newTestArchiver(t)
+	require.NoError(t, a.upload(ctx, archive.CompressionGzip, "sdk"))
+
+	m, err := a.src.Get("sdk")
+	require.NoError(t, err)
+	assert.Equal(t, "sdk", m.Name)
+	assert.Equal(t, &metadata.ArchiveMetadata{Format: "tar", Compression: "gzip", Entries: 4}, m.Archive)
+	assert.Zero(t, m.Mode)
+	// cavorite versions that do not know about archives refuse the cfile
+	assert.Equal(t, 3, m.SchemaVersion)
+
+	// unchanged directories are not retrieved
+	require.NoError(t, a.retrieve(ctx, "cfile", "sdk"))
+	assert.Equal(t, 0, *retrieved)
+
+	require.NoError(t, afero.WriteFile(fsys, "sdk/include/sdk.h", []byte("changed"), 0644))
+	require.NoError(t, afero.WriteFile(fsys, "sdk/stale", []byte("stale"), 0644))
+	require.NoError(t, a.retrieve(ctx, "cfile", "sdk"))
+	assert.Equal(t, 1, *retrieved)
+	b, err := afero.ReadFile(fsys, "sdk/include/sdk.h")
+	require.NoError(t, err)
+	assert.Equal(t, "int sdk(void);\n", string(b))
+	info, err := fsys.Stat("sdk/lib/libsdk.a")
+	require.NoError(t, err)
+	assert.Equal(t, "-rwxr-xr-x", info.Mode().Perm().String())
+	_, err = fsys.Stat("sdk/stale")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+	_, err = fsys.Stat("sdk.cavorite-tmp")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+
+	require.NoError(t, fsys.RemoveAll("sdk"))
+	require.NoError(t, a.retrieve(ctx, "cfile", "sdk"))
+	_, err = fsys.Stat("sdk/lib/libsdk.a")
+	assert.NoError(t, err)
+}
+
+func TestArchiverRetrieveCorruptArchive(t *testing.T) {
+	ctx := context.Background()
+	a, fsys, bucket, _ := newTestArchiver(t)
+	require.NoError(t, a.upload(ctx, archive.CompressionNone, "sdk"))
+	require.NoError(t, afero.WriteFile(bucket, "sdk", []byte("corrupt"), 0644))
+	require.NoError(t, afero.WriteFile(fsys, "sdk/include/sdk.h", []byte("changed"), 0644))
+
+	err := a.retrieve(ctx, "cfile", "sdk")
+	assert.ErrorIs(t, err, metadata.ErrRetrieveFailureHashMismatch)
+	b, err := afero.ReadFile(fsys, "sdk/include/sdk.h")
+	require.NoError(t, err)
+	assert.Equal(t, "changed", string(b))
+}
+
+func TestArchiverUploadRejectsFiles(t *testing.T) {
+	a, _, _, _ := newTestArchiver(t)
+	err := a.upload(context.Background(), archive.CompressionNone, "sdk/include/sdk.h")
+	assert.ErrorIs(t, err, ErrNotADirectory)
+}
+
+func TestRetrieveRejectsArchives(t *testing.T) {
+	ctx := context.Background()
+	a, fsys, bucket, _ := newTestArchiver(t)
+	require.NoError(t, a.upload(ctx, archive.CompressionNone, "sdk"))
+
+	s := simpleStoreForRetrieve{sourceFsys: fsys, bucketFsys: bucket, options: stores.Options{MetadataFileExtension: "cfile"}}
+	err := Retrieve(ctx, fsys, a.src, s, "sdk.cfile")
+	assert.ErrorIs(t, err, ErrArchived)
+
+	objects, dirs := splitArchives(a.src, "cfile", "sdk.cfile", "sdk/include/sdk.h", "sdk/")
+	assert.Equal(t, []string{"sdk/include/sdk.h"}, objects)
+	assert.Equal(t, []string{"sdk"}, dirs)
+}
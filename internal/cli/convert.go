@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+)
+
+const (
+	metadataModeCfiles   = "cfiles"
+	metadataModeManifest = "manifest"
+)
+
+var (
+	ErrUnknownMetadataMode = fmt.Errorf("--to must be %q or %q", metadataModeCfiles, metadataModeManifest)
+	ErrAlreadyConverted    = errors.New("metadata is already kept that way")
+)
+
+// convertMetadata copies the metadata of every object from one Source to the other. Once it is
+// written, commit is called to make the new Source the configured one and only then the old
+// metadata is deleted.
+func convertMetadata(ctx context.Context, from, to metadata.Source, commit func() error, w io.Writer) error {
+	objects, err := from.List(".")
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	mmap := make(map[string]*metadata.ObjectMetaData, len(objects))
+	for _, obj := range objects {
+		m, err := from.Get(obj)
+		if err != nil {
+			return err
+		}
+		if err := to.Put(obj, m); err != nil {
+			return err
+		}
+		mmap[obj] = m
+	}
+	if err := to.Close(); err != nil {
+		return err
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+	r := reportFrom(ctx)
+	var result *multierr.Error
+	for _, obj := range objects {
+		err := from.Delete(obj)
+		r.add(newObjectResult(obj, outcomeMoved, mmap[obj], start, err))
+		if err != nil {
+			result = multierr.Append(result, err)
+		}
+	}
+	if err := from.Close(); err != nil {
+		result = multierr.Append(result, err)
+	}
+	if objects != nil {
+		fmt.Fprintf(w, "moved the metadata of %d objects from %s to %s\n", len(objects), from.Location("*"), to.Location("*"))
+	}
+	return result.ErrorOrNil()
+}
+
+func convertCmd() *cobra.Command {
+	convertCmd := &cobra.Command{
+		Use:   "convert",
+		Short: "Move the metadata of every object between cfiles and a manifest",
+		Long: `Move the metadata of every object between cfiles next to each object and a single manifest, and update .cavorite/config accordingly.
+Objects are not touched.`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: convertFn,
+	}
+	convertCmd.Flags().String("to", "", fmt.Sprintf("Where to keep metadata, %q or %q", metadataModeCfiles, metadataModeManifest))
+	convertCmd.Flags().String("manifest", metadata.DefaultManifest, "Path of the manifest, relative to the root of the repo")
+	return convertCmd
+}
+
+func convertFn(cmd *cobra.Command, _ []string) error {
+	to, err := cmd.Flags().GetString("to")
+	if err != nil {
+		return err
+	}
+	manifest, err := cmd.Flags().GetString("manifest")
+	if err != nil {
+		return err
+	}
+	cfg := config.Cfg.WithDefaultFuncs()
+	switch to {
+	case metadataModeManifest:
+		if cfg.Manifest != "" {
+			return fmt.Errorf("%w: metadata is kept in %s", ErrAlreadyConverted, cfg.Manifest)
+		}
+		cfg.Manifest = manifest
+	case metadataModeCfiles:
+		if cfg.Manifest == "" {
+			return fmt.Errorf("%w: metadata is kept in cfiles", ErrAlreadyConverted)
+		}
+		cfg.Manifest = ""
+	default:
+		return ErrUnknownMetadataMode
+	}
+	fsys := afero.NewOsFs()
+	return convertMetadata(
+		cmd.Context(),
+		newMetadataSource(config.Cfg, fsys),
+		newMetadataSource(cfg, fsys),
+		func() error {
+			return cfg.Write(fsys, ".")
+		},
+		cmd.OutOrStdout(),
+	)
+}
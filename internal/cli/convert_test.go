@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/testutils"
+)
+
+func TestConvertMetadata(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"tools/a.cfile":   {Content: []byte(fmt.Sprintf(stuffCfile, "tools/a", "sha256", stuffSHA256))},
+		"tools/b/c.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/b/c", "sha256", stuffSHA256))},
+	})
+	require.NoError(t, err)
+	cfiles := metadata.NewSidecarSource(*fsys, "cfile")
+	manifest := metadata.NewManifestSource(*fsys, metadata.DefaultManifest)
+
+	// nothing is deleted if the config cannot be switched
+	errCommit := errors.New("commit failed")
+	err = convertMetadata(context.Background(), cfiles, manifest, func() error { return errCommit }, &bytes.Buffer{})
+	assert.ErrorIs(t, err, errCommit)
+	objects, err := cfiles.List(".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tools/a", "tools/b/c"}, objects)
+
+	var out bytes.Buffer
+	committed := false
+	require.NoError(t, convertMetadata(context.Background(), cfiles, manifest, func() error {
+		committed = true
+		return nil
+	}, &out))
+	assert.True(t, committed)
+	assert.Equal(t, "moved the metadata of 2 objects from *.cfile to .cavorite/manifest.json:*\n", out.String())
+	objects, err = cfiles.List(".")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
+	objects, err = metadata.NewManifestSource(*fsys, metadata.DefaultManifest).List(".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tools/a", "tools/b/c"}, objects)
+
+	// and back again
+	manifest = metadata.NewManifestSource(*fsys, metadata.DefaultManifest)
+	require.NoError(t, convertMetadata(context.Background(), manifest, cfiles, func() error { return nil }, &bytes.Buffer{}))
+	b, err := afero.ReadFile(*fsys, "tools/b/c.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf(stuffCfile, "tools/b/c", "sha256", stuffSHA256), string(b))
+	exists, err := afero.Exists(*fsys, metadata.DefaultManifest)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+)
+
+func exportCmd() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Convert cfiles into metadata for other tools",
+		Long:  "Convert cfiles into metadata for other tools",
+		Args:  cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Help()
+		},
+	}
+	exportCmd.AddCommand(
+		exportLFSCmd(),
+	)
+	return exportCmd
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/lfs"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/testutils"
+)
+
+// contentStore is a simpleStore that retrieves objects from content
+type contentStore struct {
+	simpleStore
+	fsys    afero.Fs
+	content map[string]string
+}
+
+func (s contentStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap, cfiles ...string) error {
+	for cfile, m := range mmap {
+		content, ok := s.content[m.Name]
+		if !ok {
+			return fmt.Errorf("%s not found", m.Name)
+		}
+		if err := afero.WriteFile(s.fsys, strings.TrimSuffix(cfile, ".cfile"), []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const stuffCfile = `{
+ "schema_version": 2,
+ "name": "%s",
+ "algorithm": "%s",
+ "checksum": "%s",
+ "size": 5,
+ "date_modified": "2014-11-12T11:45:26.371Z"
+}`
+
+func TestExportLFS(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		// present
+		"tools/local":       {Content: []byte("stuff"), Mode: 0755},
+		"tools/local.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/local", "sha256", stuffSHA256))},
+		// retrieved from the store
+		"tools/remote.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/remote", "sha256", stuffSHA256))},
+		// not sha256
+		"tools/blake3.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/blake3", "blake3", "4a5d7d3d6e8d6b58c40b0dbb5fe6cfa7ed4e81d1c89a6e5f4fcbe0b9a8d3f7ab"))},
+	})
+	require.NoError(t, err)
+	storage := lfs.LocalStorage{Fsys: *fsys, Dir: lfs.DefaultObjectsDir}
+	s := contentStore{fsys: *fsys, content: map[string]string{"tools/remote": "stuff"}}
+
+	le := &lfsExporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile"), storage: storage}
+	var out bytes.Buffer
+	err = exportLFS(context.Background(), le, s, &out, "tools")
+	assert.ErrorIs(t, err, ErrLFSAlgorithm)
+	assert.Equal(t, "exported tools/local\nexported tools/remote\n", out.String())
+
+	for _, obj := range []string{"tools/local", "tools/remote"} {
+		b, err := afero.ReadFile(*fsys, obj)
+		require.NoError(t, err)
+		assert.Equal(t, stuffLFSPointer.String(), string(b))
+		_, err = (*fsys).Stat(obj + ".cfile")
+		assert.ErrorIs(t, err, afero.ErrFileNotFound)
+	}
+	info, err := (*fsys).Stat("tools/local")
+	require.NoError(t, err)
+	assert.Equal(t, "-rwxr-xr-x", info.Mode().Perm().String())
+	b, err := afero.ReadFile(*fsys, storage.Path(stuffLFSPointer))
+	require.NoError(t, err)
+	assert.Equal(t, "stuff", string(b))
+	_, err = (*fsys).Stat("tools/blake3.cfile")
+	assert.NoError(t, err)
+}
+
+func TestImportExportLFSRoundTrip(t *testing.T) {
+	storage := lfs.LocalStorage{Dir: lfs.DefaultObjectsDir}
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		storage.Path(stuffLFSPointer): {Content: []byte("stuff")},
+		"pointer":                     {Content: []byte(stuffLFSPointer.String())},
+	})
+	require.NoError(t, err)
+	storage.Fsys = *fsys
+
+	li := &lfsImporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile"), sources: []lfs.Fetcher{storage}}
+	s := simpleStore{options: stores.Options{MetadataFileExtension: "cfile"}}
+	require.NoError(t, importLFS(context.Background(), li, s, &bytes.Buffer{}, "."))
+	le := &lfsExporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile"), storage: storage}
+	require.NoError(t, exportLFS(context.Background(), le, s, &bytes.Buffer{}, "."))
+
+	b, err := afero.ReadFile(*fsys, "pointer")
+	require.NoError(t, err)
+	assert.Equal(t, stuffLFSPointer.String(), string(b))
+}
@@ -0,0 +1,320 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/gitfilter"
+	"github.com/discentem/cavorite/metadata"
+	cavoriteObjLib "github.com/discentem/cavorite/objects"
+	"github.com/discentem/cavorite/program"
+	"github.com/discentem/cavorite/stores"
+)
+
+// maxCfileSize is the size above which content is never taken for a cfile
+const maxCfileSize = 64 << 10
+
+// gitFilter cleans objects into cfiles and smudges cfiles into objects for git. Objects are staged
+// so that the Store reads and writes them without touching the working tree.
+type gitFilter struct {
+	staging afero.Fs
+	s       stores.Store
+	cleanup func() error
+	// cache, if not nil, holds the cfile last cleaned or smudged for each path, so that objects that
+	// did not change are neither uploaded again nor given new metadata
+	cache afero.Fs
+}
+
+var _ = gitfilter.Filter(&gitFilter{})
+
+// newGitFilter returns a gitFilter using the Store returned by newStore, see archiver.newStore
+func newGitFilter(fsys afero.Fs, newStore func(fsys afero.Fs) (stores.Store, error), cache afero.Fs) (*gitFilter, error) {
+	overlay, staging, cleanup, err := newStagingOverlay(fsys)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newStore(overlay)
+	if err != nil {
+		_ = cleanup()
+		return nil, err
+	}
+	return &gitFilter{staging: staging, s: s, cleanup: cleanup, cache: cache}, nil
+}
+
+// decodeCfile returns the metadata in b, or nil if b is not a cfile
+func decodeCfile(b []byte) *metadata.ObjectMetaData {
+	if len(b) > maxCfileSize {
+		return nil
+	}
+	m, err := metadata.Decode(bytes.NewReader(b))
+	if err != nil || m.Name == "" || m.Checksum == "" {
+		return nil
+	}
+	return m
+}
+
+// Clean uploads the contents of path and writes its cfile to w. Metadata does not record the
+// modification time or mode of the object, git keeps track of those.
+func (f *gitFilter) Clean(ctx context.Context, path string, r io.Reader, w io.Writer) error {
+	opts, err := f.s.GetOptions()
+	if err != nil {
+		return err
+	}
+	// stores read objects from the path of their key
+	key := cavoriteObjLib.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}.Modify(filepath.Clean(path))
+	if err := f.staging.MkdirAll(filepath.Dir(key), os.ModePerm); err != nil {
+		return err
+	}
+	staged, err := f.staging.Create(key)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.staging.Remove(key) }()
+	defer staged.Close()
+	var head bytes.Buffer
+	if _, err := io.Copy(staged, io.TeeReader(io.LimitReader(r, maxCfileSize+1), &head)); err != nil {
+		return err
+	}
+	if decodeCfile(head.Bytes()) != nil {
+		slog.Debug("already a cfile", "path", path)
+		_, err := w.Write(head.Bytes())
+		return err
+	}
+	if _, err := io.Copy(staged, r); err != nil {
+		return err
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	m, err := metadata.GenerateFromReaderWithAlgorithm(key, time.Time{}, opts.HashAlgorithm, staged)
+	if err != nil {
+		return err
+	}
+	if cached := f.cached(path, m); cached != nil {
+		slog.Debug("object did not change, not uploading it again", "path", path)
+		_, err := w.Write(cached)
+		return err
+	}
+	if err := f.s.Upload(ctx, key); err != nil {
+		slog.Error("uploading object", "path", path, "error", err)
+		return fmt.Errorf("%w for %s", ErrUpload, path)
+	}
+	if err := annotate(f.s, m); err != nil {
+		return err
+	}
+	b, err := metadata.Marshal(m)
+	if err != nil {
+		return err
+	}
+	f.remember(path, b)
+	_, err = w.Write(b)
+	return err
+}
+
+// Smudge retrieves the object described by the cfile read from r and writes it to w once its checksum
+// is verified. Content that is not a cfile is written as it is.
+func (f *gitFilter) Smudge(ctx context.Context, path string, r io.Reader, w io.Writer) error {
+	head, err := io.ReadAll(io.LimitReader(r, maxCfileSize+1))
+	if err != nil {
+		return err
+	}
+	m := decodeCfile(head)
+	if m == nil {
+		// committed before the filter was installed
+		slog.Debug("not a cfile, leaving it as it is", "path", path)
+		if _, err := w.Write(head); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, r)
+		return err
+	}
+	if m.Archive != nil {
+		return fmt.Errorf("%w: %s", ErrArchived, path)
+	}
+	opts, err := f.s.GetOptions()
+	if err != nil {
+		return err
+	}
+	ext := opts.MetadataFileExtension
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	// stores retrieve objects into the path of their key
+	key := m.Name
+	if err := stagePlaceholder(f.staging, key); err != nil {
+		return err
+	}
+	defer func() { _ = f.staging.Remove(key) }()
+	// stores derive the path of the object from the cfile it would have in sidecar mode
+	cfile := fmt.Sprintf("%s.%s", key, ext)
+	// signatures are checked against the path git smudges, not the staged key
+	ctx = stores.WithObjectPath(ctx, cfile, filepath.Clean(path))
+	if err := f.s.Retrieve(ctx, metadata.CfileMetadataMap{cfile: *m}, cfile); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	retrieved, err := f.staging.Open(key)
+	if err != nil {
+		return err
+	}
+	defer retrieved.Close()
+	actual, err := metadata.HashFromReader(m.HashAlgorithm(), retrieved)
+	if err != nil {
+		return err
+	}
+	if actual != m.Checksum {
+		slog.Debug("hash mismatch", "path", path, "got", actual, "expected", m.Checksum)
+		return fmt.Errorf("%s: %w", path, metadata.ErrRetrieveFailureHashMismatch)
+	}
+	if _, err := retrieved.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, retrieved); err != nil {
+		return err
+	}
+	f.remember(path, head)
+	return nil
+}
+
+// cacheKey returns the name of the cache entry of path
+func cacheKey(path string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(filepath.Clean(path))))
+	return hex.EncodeToString(sum[:])
+}
+
+// cached returns the cfile remembered for path if it describes the same object as m
+func (f *gitFilter) cached(path string, m *metadata.ObjectMetaData) []byte {
+	if f.cache == nil {
+		return nil
+	}
+	b, err := afero.ReadFile(f.cache, cacheKey(path))
+	if err != nil {
+		return nil
+	}
+	cached := decodeCfile(b)
+	if cached == nil || cached.Name != m.Name || cached.HashAlgorithm() != m.HashAlgorithm() || cached.Checksum != m.Checksum {
+		return nil
+	}
+	return b
+}
+
+// remember records cfile as the cfile of path. The cache is only an optimization, so failures are
+// logged and otherwise ignored.
+func (f *gitFilter) remember(path string, cfile []byte) {
+	if f.cache == nil {
+		return
+	}
+	if err := afero.WriteFile(f.cache, cacheKey(path), cfile, 0644); err != nil {
+		slog.Warn("could not cache cfile", "path", path, "error", err)
+	}
+}
+
+func (f *gitFilter) Close() error {
+	return multierr.Append(f.s.Close(), f.cleanup()).ErrorOrNil()
+}
+
+// loggingFilter logs the errors of a gitfilter.Filter, git only learns that a file failed
+type loggingFilter struct {
+	gitfilter.Filter
+}
+
+func (f loggingFilter) Clean(ctx context.Context, path string, r io.Reader, w io.Writer) error {
+	err := f.Filter.Clean(ctx, path, r, w)
+	if err != nil {
+		slog.Error("cleaning", "path", path, "error", err)
+	}
+	return err
+}
+
+func (f loggingFilter) Smudge(ctx context.Context, path string, r io.Reader, w io.Writer) error {
+	err := f.Filter.Smudge(ctx, path, r, w)
+	if err != nil {
+		slog.Error("smudging", "path", path, "error", err)
+	}
+	return err
+}
+
+// gitFilterCache returns the directory in the git directory of the repo in the working directory
+// that holds the cache of the filter, creating it if necessary
+func gitFilterCache(fsys afero.Fs) (afero.Fs, error) {
+	out, err := gitOutput("rev-parse", "--git-dir")
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(strings.TrimSpace(string(out)), program.Name, "filter")
+	if err := fsys.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return afero.NewBasePathFs(fsys, dir), nil
+}
+
+func filterProcessCmd() *cobra.Command {
+	filterProcessCmd := &cobra.Command{
+		Use:   "filter-process",
+		Short: "Convert objects to and from cfiles for git, run by git itself",
+		Long: fmt.Sprintf(`Speak git's long-running filter process protocol on stdin and stdout. Files are uploaded
+and replaced by their cfile when git adds them and retrieved when git checks them out. Git runs this
+command for paths with the %s filter attribute once "%s install" configured it.`, program.Name, program.Name),
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: filterProcessFn,
+	}
+	filterProcessCmd.Flags().Bool("delay", true, "Let git check out other files while objects are retrieved")
+	return filterProcessCmd
+}
+
+func filterProcessFn(cmd *cobra.Command, _ []string) (err error) {
+	delay, err := cmd.Flags().GetBool("delay")
+	if err != nil {
+		return err
+	}
+	fsys := afero.NewOsFs()
+	cache, err := gitFilterCache(fsys)
+	if err != nil {
+		// every object is uploaded again whenever git cleans it
+		slog.Warn("not caching cfiles", "error", err)
+		cache = nil
+	}
+	f, err := newGitFilter(fsys, stagedStoreFunc(cmd.Context(), config.Cfg), cache)
+	if err != nil {
+		return err
+	}
+	defer func() { err = multierr.Append(err, f.Close()).ErrorOrNil() }()
+	return serveFilter(cmd.Context(), f, delay, cmd.InOrStdin(), cmd.OutOrStdout())
+}
+
+// serveFilter serves f to git until in is closed
+func serveFilter(ctx context.Context, f gitfilter.Filter, delay bool, in io.Reader, out io.Writer) error {
+	if out == io.Writer(os.Stdout) {
+		stdout, err := protocolStdout()
+		if err != nil {
+			return err
+		}
+		defer stdout.Close()
+		out = stdout
+	}
+	s := &gitfilter.Server{Filter: loggingFilter{f}, Delay: delay}
+	if err := s.Serve(ctx, in, out); err != nil {
+		if errors.Is(err, gitfilter.ErrProtocol) {
+			return fmt.Errorf("%w, %s filter-process is meant to be run by git", err, program.Name)
+		}
+		return err
+	}
+	return nil
+}
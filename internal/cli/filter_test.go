@@ -0,0 +1,217 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gonuts/go-shellquote"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+)
+
+func newTestGitFilter(t *testing.T, bucket, cache afero.Fs) *gitFilter {
+	t.Helper()
+	f, err := newGitFilter(afero.NewMemMapFs(), func(overlay afero.Fs) (stores.Store, error) {
+		return bucketStore{
+			simpleStoreForRetrieve: simpleStoreForRetrieve{
+				sourceFsys: overlay,
+				bucketFsys: bucket,
+				options:    stores.Options{MetadataFileExtension: "cfile"},
+			},
+			retrieved: new(int),
+		}, nil
+	}, cache)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, f.Close()) })
+	return f
+}
+
+func TestGitFilter(t *testing.T) {
+	ctx := context.Background()
+	bucket, cache := afero.NewMemMapFs(), afero.NewMemMapFs()
+	f := newTestGitFilter(t, bucket, cache)
+
+	var cleaned bytes.Buffer
+	require.NoError(t, f.Clean(ctx, "tools/tool", strings.NewReader("stuff"), &cleaned))
+	m, err := metadata.Decode(bytes.NewReader(cleaned.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, "tools/tool", m.Name)
+	assert.Equal(t, stuffSHA256, m.Checksum)
+	assert.True(t, m.DateModified.IsZero())
+	assert.Zero(t, m.Mode)
+	b, err := afero.ReadFile(bucket, "tools/tool")
+	require.NoError(t, err)
+	assert.Equal(t, "stuff", string(b))
+
+	// unchanged objects are not uploaded again
+	require.NoError(t, bucket.Remove("tools/tool"))
+	var again bytes.Buffer
+	require.NoError(t, f.Clean(ctx, "tools/tool", strings.NewReader("stuff"), &again))
+	assert.Equal(t, cleaned.String(), again.String())
+	_, err = bucket.Stat("tools/tool")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+	require.NoError(t, afero.WriteFile(bucket, "tools/tool", []byte("stuff"), 0644))
+
+	var smudged bytes.Buffer
+	require.NoError(t, f.Smudge(ctx, "tools/tool", bytes.NewReader(cleaned.Bytes()), &smudged))
+	assert.Equal(t, "stuff", smudged.String())
+
+	// cfiles and files committed before the filter was installed are left alone
+	var out bytes.Buffer
+	require.NoError(t, f.Clean(ctx, "tools/tool", bytes.NewReader(cleaned.Bytes()), &out))
+	assert.Equal(t, cleaned.String(), out.String())
+	out.Reset()
+	require.NoError(t, f.Smudge(ctx, "legacy.bin", strings.NewReader("\x00legacy"), &out))
+	assert.Equal(t, "\x00legacy", out.String())
+
+	require.NoError(t, afero.WriteFile(bucket, "tools/tool", []byte("STUFF"), 0644))
+	out.Reset()
+	err = f.Smudge(ctx, "tools/tool", bytes.NewReader(cleaned.Bytes()), &out)
+	assert.ErrorIs(t, err, metadata.ErrRetrieveFailureHashMismatch)
+	assert.Empty(t, out.String())
+}
+
+func gitCmd(dir string, env []string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+func git(t *testing.T, dir string, env []string, args ...string) string {
+	t.Helper()
+	out, err := gitCmd(dir, env, args...).CombinedOutput()
+	require.NoError(t, err, "git %s: %s", strings.Join(args, " "), out)
+	return string(out)
+}
+
+// gitIn returns a function running git in dir like gitOutputFrom
+func gitIn(dir string) func(stdin io.Reader, args ...string) ([]byte, error) {
+	return func(stdin io.Reader, args ...string) ([]byte, error) {
+		cmd := gitCmd(dir, nil, args...)
+		cmd.Stdin = stdin
+		return cmd.Output()
+	}
+}
+
+func newTestGitRepo(t *testing.T, env []string) (string, *filterInstaller) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	git(t, repo, env, "init", "-q")
+	git(t, repo, env, "config", "user.name", "test")
+	git(t, repo, env, "config", "user.email", "test@example.com")
+	return repo, &filterInstaller{
+		fsys: afero.NewBasePathFs(afero.NewOsFs(), repo),
+		git: func(args ...string) error {
+			git(t, repo, env, args...)
+			return nil
+		},
+	}
+}
+
+func TestInstall(t *testing.T) {
+	repo, fi := newTestGitRepo(t, nil)
+	require.NoError(t, os.WriteFile(filepath.Join(repo, ".gitattributes"), []byte("*.png filter=cavorite -text"), 0644))
+
+	added, err := fi.install("cavorite filter-process", "*.png", "*.bin", "*.bin", "disk image.iso")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.bin", "disk[[:space:]]image.iso"}, added)
+	b, err := os.ReadFile(filepath.Join(repo, ".gitattributes"))
+	require.NoError(t, err)
+	assert.Equal(t, `*.png filter=cavorite -text
+*.bin filter=cavorite -text
+disk[[:space:]]image.iso filter=cavorite -text
+`, string(b))
+	assert.Equal(t, "cavorite filter-process\n", git(t, repo, nil, "config", "--get", "filter.cavorite.process"))
+	assert.Equal(t, "true\n", git(t, repo, nil, "config", "--get", "filter.cavorite.required"))
+	assert.Equal(t, "filter: cavorite\n", git(t, repo, nil, "check-attr", "filter", "--", "disk image.iso")[len("disk image.iso: "):])
+
+	added, err = fi.install("cavorite filter-process", "*.bin")
+	require.NoError(t, err)
+	assert.Empty(t, added)
+}
+
+// TestHelperFilterProcess is run by git as the filter process of TestFilterWithGit
+func TestHelperFilterProcess(t *testing.T) {
+	bucketDir := os.Getenv("CAVORITE_TEST_FILTER_BUCKET")
+	if bucketDir == "" {
+		t.Skip("only run by git")
+	}
+	fsys := afero.NewOsFs()
+	cache, err := gitFilterCache(fsys)
+	require.NoError(t, err)
+	f, err := newGitFilter(fsys, func(overlay afero.Fs) (stores.Store, error) {
+		return bucketStore{
+			simpleStoreForRetrieve: simpleStoreForRetrieve{
+				sourceFsys: overlay,
+				bucketFsys: afero.NewBasePathFs(fsys, bucketDir),
+				options:    stores.Options{MetadataFileExtension: "cfile"},
+			},
+			retrieved: new(int),
+		}, nil
+	}, cache)
+	require.NoError(t, err)
+	err = serveFilter(context.Background(), f, true, os.Stdin, os.Stdout)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestFilterWithGit(t *testing.T) {
+	bucket := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(bucket, "tools"), 0755))
+	env := []string{"CAVORITE_TEST_FILTER_BUCKET=" + bucket}
+	repo, fi := newTestGitRepo(t, env)
+	self, err := os.Executable()
+	require.NoError(t, err)
+	_, err = fi.install(shellquote.Join(self, "-test.run=^TestHelperFilterProcess$"), "*.bin")
+	require.NoError(t, err)
+
+	files := map[string]string{"a.bin": "\x00first", "tools/tool.bin": "\x00second", "README": "plain"}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(repo, path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(repo, path), []byte(content), 0644))
+	}
+	git(t, repo, env, "add", ".")
+	git(t, repo, env, "commit", "-q", "-m", "add files")
+
+	m, err := metadata.Decode(strings.NewReader(git(t, repo, env, "cat-file", "-p", "HEAD:tools/tool.bin")))
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte("\x00second"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), m.Checksum)
+	b, err := os.ReadFile(filepath.Join(bucket, "tools/tool.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "\x00second", string(b))
+	assert.Equal(t, "plain", git(t, repo, env, "cat-file", "-p", "HEAD:README"))
+	assert.Empty(t, git(t, repo, env, "status", "--porcelain"))
+
+	for path := range files {
+		require.NoError(t, os.Remove(filepath.Join(repo, path)))
+	}
+	git(t, repo, env, "checkout", "--", ".")
+	for path, content := range files {
+		b, err := os.ReadFile(filepath.Join(repo, path))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(b), path)
+	}
+	assert.Empty(t, git(t, repo, env, "status", "--porcelain"))
+}
@@ -0,0 +1,237 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+)
+
+var (
+	ErrFsckFailed         = errors.New("some objects failed the integrity check")
+	ErrStatNotSupported   = errors.New("the store cannot check remote objects")
+	ErrLocalMismatch      = errors.New("local object does not match its metadata")
+	ErrRemoteSizeMismatch = errors.New("remote object has an unexpected size")
+)
+
+// fsckChecker verifies local objects against their metadata and that the store still holds them
+type fsckChecker struct {
+	fsys afero.Fs
+	src  metadata.Source
+	stat stores.StatStore
+	// newStore, if not nil, returns the Store used to download every object into staging for a
+	// deep check, see archiver.newStore
+	newStore func(fsys afero.Fs) (stores.Store, error)
+}
+
+// fsck checks every object with metadata below roots and writes one line per object to w
+func (fc *fsckChecker) fsck(ctx context.Context, w io.Writer, roots ...string) (err error) {
+	objects, err := fc.src.List(roots...)
+	if err != nil {
+		return err
+	}
+	var d *deepChecker
+	if fc.newStore != nil {
+		d, err = newDeepChecker(fc.fsys, fc.newStore)
+		if err != nil {
+			return err
+		}
+		defer func() { err = multierr.Append(err, d.Close()).ErrorOrNil() }()
+	}
+	r := reportFrom(ctx)
+	failed := 0
+	for _, obj := range objects {
+		start := time.Now()
+		cfile := fc.src.Location(obj)
+		m, err := fc.src.Get(obj)
+		if err != nil {
+			fmt.Fprintf(w, "FAILED   %s: %v\n", cfile, err)
+			r.add(newObjectResult(obj, outcomeFailed, nil, start, err))
+			failed++
+			continue
+		}
+		problems := fc.check(ctx, d, obj, m)
+		r.add(newObjectResult(obj, outcomeOK, m, start, problems.ErrorOrNil()))
+		if problems == nil {
+			fmt.Fprintf(w, "OK       %s\n", cfile)
+			continue
+		}
+		failed++
+		for _, problem := range problems.Errors {
+			fmt.Fprintf(w, "FAILED   %s: %v\n", cfile, problem)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrFsckFailed, failed, len(objects))
+	}
+	return nil
+}
+
+// check returns the problems with obj, or nil if there are none
+func (fc *fsckChecker) check(ctx context.Context, d *deepChecker, obj string, m *metadata.ObjectMetaData) *multierr.Error {
+	var problems *multierr.Error
+	if err := fc.checkLocal(obj, m); err != nil {
+		problems = multierr.Append(problems, fmt.Errorf("local: %w", err))
+	}
+	remoteErr := fc.checkRemote(ctx, m)
+	if remoteErr != nil {
+		problems = multierr.Append(problems, fmt.Errorf("remote: %w", remoteErr))
+	}
+	// there is nothing to download if the object is gone
+	if d != nil && !errors.Is(remoteErr, stores.ErrObjectNotExist) {
+		if err := d.check(ctx, obj, m); err != nil {
+			problems = multierr.Append(problems, fmt.Errorf("deep: %w", err))
+		}
+	}
+	return problems
+}
+
+// checkLocal verifies obj against m if it is present
+func (fc *fsckChecker) checkLocal(obj string, m *metadata.ObjectMetaData) error {
+	if _, err := fc.fsys.Stat(obj); errors.Is(err, os.ErrNotExist) {
+		slog.Debug("object is not present locally", "object", obj)
+		return nil
+	}
+	var mismatch bool
+	var err error
+	if m.Archive != nil {
+		mismatch, err = shouldRetrieveArchive(fc.fsys, m, obj)
+	} else {
+		mismatch, err = shouldRetrieve(fc.fsys, m, obj)
+	}
+	if err != nil {
+		return err
+	}
+	if mismatch {
+		return ErrLocalMismatch
+	}
+	return nil
+}
+
+// checkRemote checks that the object described by m exists in the store. Its size is only known if
+// the object is stored as is.
+func (fc *fsckChecker) checkRemote(ctx context.Context, m *metadata.ObjectMetaData) error {
+	info, err := fc.stat.Stat(ctx, m.Name)
+	if err != nil {
+		return err
+	}
+	if m.Size == nil || m.Encryption != nil || m.Chunks != nil {
+		return nil
+	}
+	if info.Size != *m.Size {
+		return fmt.Errorf("%w: %s is %d bytes but %d were expected", ErrRemoteSizeMismatch, m.Name, info.Size, *m.Size)
+	}
+	return nil
+}
+
+// deepChecker downloads objects into staging and hashes them
+type deepChecker struct {
+	staging afero.Fs
+	s       stores.Store
+	cleanup func() error
+}
+
+func newDeepChecker(fsys afero.Fs, newStore func(fsys afero.Fs) (stores.Store, error)) (*deepChecker, error) {
+	overlay, staging, cleanup, err := newStagingOverlay(fsys)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newStore(overlay)
+	if err != nil {
+		_ = cleanup()
+		return nil, err
+	}
+	return &deepChecker{staging: staging, s: s, cleanup: cleanup}, nil
+}
+
+// check downloads obj into staging and verifies it against m
+func (d *deepChecker) check(ctx context.Context, obj string, m *metadata.ObjectMetaData) error {
+	opts, err := d.s.GetOptions()
+	if err != nil {
+		return err
+	}
+	ext := opts.MetadataFileExtension
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	if err := stagePlaceholder(d.staging, obj); err != nil {
+		return err
+	}
+	defer func() { _ = d.staging.Remove(obj) }()
+	// stores derive the path of the object from the cfile it would have in sidecar mode
+	cfile := fmt.Sprintf("%s.%s", obj, ext)
+	if err := d.s.Retrieve(ctx, metadata.CfileMetadataMap{cfile: *m}, cfile); err != nil {
+		return err
+	}
+	f, err := d.staging.Open(obj)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	actual, err := metadata.HashFromReader(m.HashAlgorithm(), f)
+	if err != nil {
+		return err
+	}
+	if actual != m.Checksum {
+		return fmt.Errorf("%w: got %s", metadata.ErrRetrieveFailureHashMismatch, actual)
+	}
+	return nil
+}
+
+func (d *deepChecker) Close() error {
+	return multierr.Append(d.s.Close(), d.cleanup()).ErrorOrNil()
+}
+
+func fsckCmd() *cobra.Command {
+	fsckCmd := &cobra.Command{
+		Use:   "fsck [path...]",
+		Short: "Verify local objects and check that the store still holds them",
+		Long: `Verify every object with a cfile below the given paths, or the whole repo if none are given.
+Local objects are hashed if they are present and the store is asked whether it still holds each object
+with the expected size. With --deep every object is downloaded into a temporary location and hashed.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: fsckFn,
+	}
+	fsckCmd.Flags().Bool("deep", false, "Download and hash every object")
+	return fsckCmd
+}
+
+func fsckFn(cmd *cobra.Command, paths []string) error {
+	deep, err := cmd.Flags().GetBool("deep")
+	if err != nil {
+		return err
+	}
+	fsys := afero.NewOsFs()
+	// the backend is enough to stat objects since decorators such as encryption keep their keys
+	s, err := newBackendStore(cmd.Context(), config.Cfg, fsys)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	stat, ok := s.(stores.StatStore)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrStatNotSupported, config.Cfg.StoreType)
+	}
+	fc := &fsckChecker{fsys: fsys, src: newMetadataSource(config.Cfg, fsys), stat: stat}
+	defer fc.src.Close()
+	if deep {
+		fc.newStore = stagedStoreFunc(cmd.Context(), config.Cfg)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	return fc.fsck(cmd.Context(), cmd.OutOrStdout(), paths...)
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/testutils"
+)
+
+func fsckTestFs(t *testing.T) afero.Fs {
+	t.Helper()
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"tools/ok":              {Content: []byte("stuff")},
+		"tools/ok.cfile":        {Content: []byte(fmt.Sprintf(stuffCfile, "tools/ok", "sha256", stuffSHA256))},
+		"tools/modified":        {Content: []byte("STUFF")},
+		"tools/modified.cfile":  {Content: []byte(fmt.Sprintf(stuffCfile, "tools/modified", "sha256", stuffSHA256))},
+		"tools/remote.cfile":    {Content: []byte(fmt.Sprintf(stuffCfile, "tools/remote", "sha256", stuffSHA256))},
+		"tools/gone.cfile":      {Content: []byte(fmt.Sprintf(stuffCfile, "tools/gone", "sha256", stuffSHA256))},
+		"tools/truncated.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/truncated", "sha256", stuffSHA256))},
+	})
+	require.NoError(t, err)
+	return *fsys
+}
+
+func TestFsck(t *testing.T) {
+	fsys := fsckTestFs(t)
+	fc := &fsckChecker{
+		fsys: fsys,
+		src:  metadata.NewSidecarSource(fsys, "cfile"),
+		stat: fakeStatStore{"tools/ok": 5, "tools/modified": 5, "tools/remote": 5, "tools/truncated": 3},
+	}
+	var out bytes.Buffer
+	err := fc.fsck(context.Background(), &out, ".")
+	assert.ErrorIs(t, err, ErrFsckFailed)
+	assert.ErrorContains(t, err, "3 of 5")
+	assert.Equal(t, `FAILED   tools/gone.cfile: remote: object does not exist in the store
+FAILED   tools/modified.cfile: local: local object does not match its metadata
+OK       tools/ok.cfile
+OK       tools/remote.cfile
+FAILED   tools/truncated.cfile: remote: remote object has an unexpected size: tools/truncated is 3 bytes but 5 were expected
+`, out.String())
+
+	out.Reset()
+	assert.NoError(t, fc.fsck(context.Background(), &out, "tools/ok", "tools/remote"))
+}
+
+func TestFsckDeep(t *testing.T) {
+	fsys := fsckTestFs(t)
+	bucket, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"tools/ok":       {Content: []byte("stuff")},
+		"tools/modified": {Content: []byte("stuff")},
+		// same size, different content
+		"tools/remote": {Content: []byte("STUFF")},
+	})
+	require.NoError(t, err)
+	fc := &fsckChecker{
+		fsys: fsys,
+		src:  metadata.NewSidecarSource(fsys, "cfile"),
+		stat: fakeStatStore{"tools/ok": 5, "tools/modified": 5, "tools/remote": 5},
+		newStore: func(overlay afero.Fs) (stores.Store, error) {
+			return simpleStoreForRetrieve{sourceFsys: overlay, bucketFsys: *bucket, options: stores.Options{MetadataFileExtension: "cfile"}}, nil
+		},
+	}
+	var out bytes.Buffer
+	err = fc.fsck(context.Background(), &out, "tools/ok", "tools/modified", "tools/remote")
+	assert.ErrorIs(t, err, ErrFsckFailed)
+	assert.Contains(t, out.String(), "OK       tools/ok.cfile\n")
+	assert.Contains(t, out.String(), "FAILED   tools/modified.cfile: local:")
+	assert.NotContains(t, out.String(), "tools/modified.cfile: deep:")
+	assert.Contains(t, out.String(), "FAILED   tools/remote.cfile: deep:")
+
+	// nothing is written to the repo
+	_, err = fsys.Stat("tools/remote")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+	b, err := afero.ReadFile(fsys, "tools/modified")
+	require.NoError(t, err)
+	assert.Equal(t, "STUFF", string(b))
+}
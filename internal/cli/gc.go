@@ -0,0 +1,350 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/program"
+	"github.com/discentem/cavorite/stores"
+)
+
+// defaultGracePeriod is how long objects are kept after they were uploaded, since their cfiles may
+// not be committed yet
+const defaultGracePeriod = 7 * 24 * time.Hour
+
+var (
+	ErrListNotSupported   = errors.New("store cannot list objects")
+	ErrDeleteNotSupported = errors.New("store cannot delete objects")
+	ErrNothingReferenced  = errors.New("no metadata was found, refusing to delete every object")
+	ErrNoKeyPrefix        = errors.New("object_key_prefix is not set, so gc would consider every object in the store, pass --all-keys if that is intended")
+)
+
+// historyCollector finds the objects referenced by the metadata in the history of a git repo
+type historyCollector struct {
+	// git runs git with args in the root of the repo with stdin as its input and returns its stdout
+	git func(stdin io.Reader, args ...string) ([]byte, error)
+	ext string
+	// manifest, if set, is the path of the manifest relative to the root of the repo
+	manifest string
+}
+
+// referenced returns the keys of the objects described by cfiles, manifests and content stored
+// through the git filter in any commit reachable from refs, or from any ref if none are given
+func (hc *historyCollector) referenced(refs ...string) (map[string]bool, error) {
+	args := []string{"rev-list", "--objects"}
+	if len(refs) == 0 {
+		args = append(args, "--all")
+	}
+	args = append(args, refs...)
+	out, err := hc.git(nil, append(args, "--")...)
+	if err != nil {
+		return nil, err
+	}
+	ext := hc.ext
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	suffix := "." + strings.TrimPrefix(ext, ".")
+	// the same blob may appear under several paths, one of them is enough
+	blobs := make(map[string]string)
+	others := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		oid, path, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || path == "" {
+			// commits and the root tree have no path
+			continue
+		}
+		switch {
+		case strings.HasSuffix(path, suffix) || (hc.manifest != "" && path == filepath.ToSlash(hc.manifest)):
+			blobs[oid] = path
+		default:
+			others[path] = append(others[path], oid)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	filtered, err := hc.filtered(others)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range filtered {
+		for _, oid := range others[path] {
+			blobs[oid] = path
+		}
+	}
+	referenced := make(map[string]bool)
+	if len(blobs) == 0 {
+		return referenced, nil
+	}
+	oids := make([]string, 0, len(blobs))
+	for oid := range blobs {
+		oids = append(oids, oid)
+	}
+	sort.Strings(oids)
+	out, err = hc.git(strings.NewReader(strings.Join(oids, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	err = readBatch(out, func(oid, typ string, content []byte) error {
+		path := blobs[oid]
+		if typ != "blob" {
+			// a directory named like a cfile
+			return nil
+		}
+		if hc.manifest != "" && path == filepath.ToSlash(hc.manifest) {
+			return addManifest(referenced, path, content)
+		}
+		if m := decodeCfile(content); m != nil {
+			referenced[m.Name] = true
+		}
+		return nil
+	})
+	return referenced, err
+}
+
+// filtered returns the paths git filters through cavorite according to the attributes of the
+// working tree
+func (hc *historyCollector) filtered(paths map[string][]string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	var stdin bytes.Buffer
+	for path := range paths {
+		stdin.WriteString(path)
+		stdin.WriteByte(0)
+	}
+	out, err := hc.git(&stdin, "check-attr", "-z", "--stdin", "filter")
+	if err != nil {
+		return nil, err
+	}
+	fields := splitNul(out)
+	var filtered []string
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+2] == program.Name {
+			filtered = append(filtered, fields[i])
+		}
+	}
+	return filtered, nil
+}
+
+// readBatch calls fn for every object in the output of git cat-file --batch
+func readBatch(out []byte, fn func(oid, typ string, content []byte) error) error {
+	for len(out) > 0 {
+		header, rest, ok := bytes.Cut(out, []byte("\n"))
+		if !ok {
+			return fmt.Errorf("truncated output of git cat-file: %q", out)
+		}
+		fields := strings.Fields(string(header))
+		if len(fields) != 3 {
+			return fmt.Errorf("unexpected output of git cat-file: %q", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size+1 > len(rest) {
+			return fmt.Errorf("unexpected output of git cat-file: %q", header)
+		}
+		if err := fn(fields[0], fields[1], rest[:size]); err != nil {
+			return err
+		}
+		out = rest[size+1:]
+	}
+	return nil
+}
+
+// addManifest adds the objects of the manifest at path with content to referenced
+func addManifest(referenced map[string]bool, path string, content []byte) error {
+	fsys := afero.NewMemMapFs()
+	if err := afero.WriteFile(fsys, path, content, 0644); err != nil {
+		return err
+	}
+	ms := metadata.NewManifestSource(fsys, path)
+	objects, err := ms.List(".")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		m, err := ms.Get(obj)
+		if err != nil {
+			return err
+		}
+		referenced[m.Name] = true
+	}
+	return nil
+}
+
+// isChunkKey reports whether key looks like chunker.Key under any key prefix. Chunks are not
+// referenced by metadata, so anything that may be one is kept.
+func isChunkKey(key string) bool {
+	parts := strings.Split(key, "/")
+	return len(parts) >= 3 && parts[len(parts)-3] == "chunks"
+}
+
+// unreferenced returns the objects of infos that are not referenced and were last modified before
+// cutoff. Chunks are shared between objects and never returned, whichever prefix they are under.
+func unreferenced(infos []stores.ObjectInfo, referenced map[string]bool, cutoff time.Time) []stores.ObjectInfo {
+	var garbage []stores.ObjectInfo
+	for _, info := range infos {
+		switch {
+		case referenced[info.Key]:
+		case isChunkKey(info.Key):
+			slog.Debug("keeping object, chunks are not collected", "key", info.Key)
+		case !info.LastModified.Before(cutoff):
+			slog.Debug("keeping object, it was uploaded less than the grace period ago", "key", info.Key)
+		default:
+			garbage = append(garbage, info)
+		}
+	}
+	return garbage
+}
+
+func gcCmd() *cobra.Command {
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete objects from the store that no metadata in git history refers to",
+		Long: fmt.Sprintf(`Collect the metadata in every commit reachable from any ref of the git repo in the working
+directory, or only from the refs given with --ref, along with the metadata in the working tree. Then
+list the objects in the store under the object key prefix and delete those no metadata refers to
+and that were uploaded longer than --grace ago. Chunks are never deleted. Without an object key
+prefix, --all-keys is required since every object in the store is considered. Use --dry-run first, %s
+cannot know about clones that were not pushed.`, program.Name),
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: gcFn,
+	}
+	gcCmd.Flags().StringArray("ref", nil, "Only keep objects referenced from this branch, tag or commit, may be repeated")
+	gcCmd.Flags().Duration("grace", defaultGracePeriod, "Keep objects uploaded more recently than this")
+	gcCmd.Flags().Bool("dry-run", false, "Print what would be deleted without deleting anything")
+	gcCmd.Flags().Bool("all-keys", false, "Consider every object in the store instead of those under the object key prefix, including objects of other repos")
+	return gcCmd
+}
+
+func gcFn(cmd *cobra.Command, _ []string) error {
+	refs, err := cmd.Flags().GetStringArray("ref")
+	if err != nil {
+		return err
+	}
+	grace, err := cmd.Flags().GetDuration("grace")
+	if err != nil {
+		return err
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	allKeys, err := cmd.Flags().GetBool("all-keys")
+	if err != nil {
+		return err
+	}
+	prefix := config.Cfg.Options.ObjectKeyPrefix
+	if prefix == "" && !allKeys {
+		// the store may be shared with other repos
+		return ErrNoKeyPrefix
+	}
+	listPrefix := ""
+	if !allKeys {
+		listPrefix = prefix + "/"
+	}
+	fsys := afero.NewOsFs()
+	// the backend is enough to list objects since decorators such as encryption keep their keys
+	s, err := newBackendStore(cmd.Context(), config.Cfg, fsys)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	list, ok := s.(stores.ListStore)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrListNotSupported, config.Cfg.StoreType)
+	}
+	var del stores.DeleteStore
+	if !dryRun {
+		if del, ok = s.(stores.DeleteStore); !ok {
+			return fmt.Errorf("%w: %s", ErrDeleteNotSupported, config.Cfg.StoreType)
+		}
+	}
+
+	hc := &historyCollector{
+		git:      gitOutputFrom,
+		ext:      config.Cfg.Options.MetadataFileExtension,
+		manifest: config.Cfg.Manifest,
+	}
+	referenced, err := hc.referenced(refs...)
+	if err != nil {
+		return err
+	}
+	src := newMetadataSource(config.Cfg, fsys)
+	defer src.Close()
+	objects, err := src.List(".")
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if m, err := src.Get(obj); err == nil {
+			referenced[m.Name] = true
+		}
+	}
+	if len(referenced) == 0 {
+		return ErrNothingReferenced
+	}
+
+	infos, err := list.List(cmd.Context(), listPrefix)
+	if err != nil {
+		return err
+	}
+	garbage := unreferenced(infos, referenced, time.Now().Add(-grace))
+	return deleteGarbage(cmd.Context(), del, garbage, len(infos), cmd.OutOrStdout())
+}
+
+// deleteGarbage deletes garbage, unreferenced objects out of total objects, with del and writes
+// one line per object to w. If del is nil, the objects are only listed.
+func deleteGarbage(ctx context.Context, del stores.DeleteStore, garbage []stores.ObjectInfo, total int, w io.Writer) error {
+	var size int64
+	keys := make([]string, len(garbage))
+	for i, info := range garbage {
+		keys[i] = info.Key
+		size += info.Size
+	}
+	r := reportFrom(ctx)
+	if del == nil {
+		for _, info := range garbage {
+			fmt.Fprintf(w, "would delete %s\n", info.Key)
+			r.add(storedObjectResult(info, outcomeUnreferenced, time.Now(), nil))
+		}
+		slog.Info("found unreferenced objects", "unreferenced", len(garbage), "objects", total, "bytes", size)
+		return nil
+	}
+	if len(keys) > 0 {
+		start := time.Now()
+		if err := del.Delete(ctx, keys...); err != nil {
+			for _, info := range garbage {
+				r.add(storedObjectResult(info, outcomeDeleted, start, err))
+			}
+			return err
+		}
+		for _, info := range garbage {
+			r.add(storedObjectResult(info, outcomeDeleted, start, nil))
+		}
+	}
+	for _, key := range keys {
+		fmt.Fprintf(w, "deleted %s\n", key)
+	}
+	slog.Info("deleted unreferenced objects", "deleted", len(garbage), "objects", total, "bytes", size)
+	return nil
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/stores"
+)
+
+func TestHistoryCollector(t *testing.T) {
+	repo, _ := newTestGitRepo(t, nil)
+	cfile := func(key string) string {
+		return fmt.Sprintf(stuffCfile, key, "sha256", stuffSHA256)
+	}
+	writeRepoFiles(t, repo, map[string]string{
+		".gitattributes":   "*.iso filter=cavorite -text\n",
+		"a.cfile":          cfile("repo/a"),
+		"tools/b.cfile":    cfile("repo/tools/b"),
+		"disk.iso":         cfile("repo/disk.iso"),
+		"README":           "plain",
+		"manifest.json":    `{"c": ` + cfile("repo/c") + `}`,
+		"notes/todo.cfile": "not metadata",
+	})
+	git(t, repo, nil, "add", ".")
+	git(t, repo, nil, "commit", "-q", "-m", "first")
+	require.NoError(t, os.Remove(filepath.Join(repo, "tools/b.cfile")))
+	git(t, repo, nil, "add", "-A")
+	git(t, repo, nil, "commit", "-q", "-m", "remove b")
+	git(t, repo, nil, "checkout", "-q", "-b", "feature")
+	writeRepoFiles(t, repo, map[string]string{"d.cfile": cfile("repo/d")})
+	git(t, repo, nil, "add", ".")
+	git(t, repo, nil, "commit", "-q", "-m", "add d")
+	git(t, repo, nil, "checkout", "-q", "-")
+
+	hc := &historyCollector{
+		git:      gitIn(repo),
+		ext:      "cfile",
+		manifest: "manifest.json",
+	}
+	referenced, err := hc.referenced()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"repo/a": true, "repo/tools/b": true, "repo/disk.iso": true, "repo/c": true, "repo/d": true,
+	}, referenced)
+
+	referenced, err = hc.referenced("HEAD")
+	require.NoError(t, err)
+	assert.False(t, referenced["repo/d"])
+	assert.True(t, referenced["repo/tools/b"])
+}
+
+func TestUnreferenced(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-30 * 24 * time.Hour)
+	infos := []stores.ObjectInfo{
+		{Key: "repo/a", LastModified: old},
+		{Key: "repo/gone", LastModified: old, Size: 3},
+		{Key: "repo/new", LastModified: now},
+		{Key: "repo/chunks/ab/abcd", LastModified: old},
+		// chunks of other repos and of repos without a prefix, listed with --all-keys
+		{Key: "chunks/cd/cdef", LastModified: old},
+		{Key: "other/chunks/ef/ef01", LastModified: old},
+	}
+	garbage := unreferenced(infos, map[string]bool{"repo/a": true}, now.Add(-defaultGracePeriod))
+	assert.Equal(t, []stores.ObjectInfo{{Key: "repo/gone", LastModified: old, Size: 3}}, garbage)
+}
+
+func TestGCRequiresKeyPrefix(t *testing.T) {
+	defer func(cfg config.Config) { config.Cfg = cfg }(config.Cfg)
+	config.Cfg = config.Config{}
+	assert.ErrorIs(t, gcFn(gcCmd(), nil), ErrNoKeyPrefix)
+}
@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/program"
+)
+
+// gitignoreFile holds the block of objects that git must not pick up
+const gitignoreFile = ".gitignore"
+
+var (
+	gitignoreBegin = fmt.Sprintf(`# BEGIN %s objects, run "%s gitignore sync" instead of editing this block`, program.Name, program.Name)
+	gitignoreEnd   = fmt.Sprintf("# END %s objects", program.Name)
+)
+
+var ErrUnterminatedBlock = errors.New("block is not terminated")
+
+// gitignoreResult is what gitignore sync records for --output json
+type gitignoreResult struct {
+	Objects int `json:"objects"`
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// gitignoreEntry returns the line ignoring obj and nothing else. Entries are anchored to the root of
+// the repo, so they never start with "!" or "#", and characters that gitignore files give a meaning
+// to are escaped.
+func gitignoreEntry(obj string) string {
+	obj = filepath.ToSlash(filepath.Clean(obj))
+	var b strings.Builder
+	b.WriteString("/")
+	for i, c := range obj {
+		switch {
+		case strings.ContainsRune(`\*?[`, c):
+			b.WriteRune('\\')
+		case c == ' ' && strings.TrimRight(obj[i:], " ") == "":
+			// trailing spaces are dropped unless escaped
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// ignoredObjects is the .gitignore in the root of a repo, split around the block of ignored objects
+type ignoredObjects struct {
+	before, after []string
+	entries       map[string]bool
+}
+
+// readIgnoredObjects reads the .gitignore in the root of the repo in fsys
+func readIgnoredObjects(fsys afero.Fs) (*ignoredObjects, error) {
+	b, err := afero.ReadFile(fsys, gitignoreFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ig := &ignoredObjects{entries: make(map[string]bool)}
+	inBlock, seenBlock := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case !seenBlock && line == gitignoreBegin:
+			inBlock, seenBlock = true, true
+		case inBlock && line == gitignoreEnd:
+			inBlock = false
+		case inBlock:
+			if line != "" {
+				ig.entries[line] = true
+			}
+		case seenBlock:
+			ig.after = append(ig.after, line)
+		default:
+			ig.before = append(ig.before, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if inBlock {
+		return nil, fmt.Errorf("%s: %w: %q is missing", gitignoreFile, ErrUnterminatedBlock, gitignoreEnd)
+	}
+	return ig, nil
+}
+
+// write writes the .gitignore back to fsys, without the block if it has no entries
+func (ig *ignoredObjects) write(fsys afero.Fs) error {
+	lines := append([]string{}, ig.before...)
+	if len(ig.entries) > 0 {
+		entries := make([]string, 0, len(ig.entries))
+		for entry := range ig.entries {
+			entries = append(entries, entry)
+		}
+		sort.Strings(entries)
+		lines = append(lines, gitignoreBegin)
+		lines = append(lines, entries...)
+		lines = append(lines, gitignoreEnd)
+	}
+	lines = append(lines, ig.after...)
+	if len(lines) == 0 {
+		if _, err := fsys.Stat(gitignoreFile); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	}
+	content := strings.Join(lines, "\n")
+	if len(lines) > 0 {
+		content += "\n"
+	}
+	return afero.WriteFile(fsys, gitignoreFile, []byte(content), 0644)
+}
+
+// updateIgnoredObjects lets update change the entries of the block of ignored objects and writes
+// the .gitignore if they changed. It returns the number of entries added and removed.
+func updateIgnoredObjects(fsys afero.Fs, update func(entries map[string]bool)) (added, removed int, err error) {
+	ig, err := readIgnoredObjects(fsys)
+	if err != nil {
+		return 0, 0, err
+	}
+	previous := make(map[string]bool, len(ig.entries))
+	for entry := range ig.entries {
+		previous[entry] = true
+	}
+	update(ig.entries)
+	for entry := range ig.entries {
+		if !previous[entry] {
+			added++
+		}
+	}
+	for entry := range previous {
+		if !ig.entries[entry] {
+			removed++
+		}
+	}
+	if added == 0 && removed == 0 {
+		return 0, 0, nil
+	}
+	return added, removed, ig.write(fsys)
+}
+
+// ignoreObjects adds objects to the block of ignored objects
+func ignoreObjects(fsys afero.Fs, objects ...string) error {
+	_, _, err := updateIgnoredObjects(fsys, func(entries map[string]bool) {
+		for _, obj := range objects {
+			entries[gitignoreEntry(obj)] = true
+		}
+	})
+	return err
+}
+
+// unignoreObjects removes objects from the block of ignored objects
+func unignoreObjects(fsys afero.Fs, objects ...string) error {
+	_, _, err := updateIgnoredObjects(fsys, func(entries map[string]bool) {
+		for _, obj := range objects {
+			delete(entries, gitignoreEntry(obj))
+		}
+	})
+	return err
+}
+
+// ignoreUploaded adds objects that were uploaded to the block of ignored objects if the config
+// asks for it
+func ignoreUploaded(fsys afero.Fs, objects ...string) error {
+	if !config.Cfg.Gitignore {
+		return nil
+	}
+	return ignoreObjects(fsys, objects...)
+}
+
+// syncIgnoredObjects makes objects the only entries of the block of ignored objects
+func syncIgnoredObjects(fsys afero.Fs, objects ...string) (added, removed int, err error) {
+	return updateIgnoredObjects(fsys, func(entries map[string]bool) {
+		for entry := range entries {
+			delete(entries, entry)
+		}
+		for _, obj := range objects {
+			entries[gitignoreEntry(obj)] = true
+		}
+	})
+}
+
+func gitignoreCmd() *cobra.Command {
+	gitignoreCmd := &cobra.Command{
+		Use:   "gitignore",
+		Short: fmt.Sprintf("Manage the objects %s keeps out of git", program.Name),
+		Long: fmt.Sprintf(`Manage a block of %s in the root of the repo that lists every object, so that objects cannot
+be committed next to their cfile by accident. With "gitignore": true in .cavorite/config, upload adds
+the objects it uploads to the block and rm removes them.`, gitignoreFile),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+	}
+	gitignoreCmd.AddCommand(&cobra.Command{
+		Use:   "sync",
+		Short: "Rebuild the block of ignored objects from the metadata of every object",
+		Args:  cobra.NoArgs,
+		RunE:  gitignoreSyncFn,
+	})
+	return gitignoreCmd
+}
+
+func gitignoreSyncFn(cmd *cobra.Command, _ []string) error {
+	fsys := afero.NewOsFs()
+	src := newMetadataSource(config.Cfg, fsys)
+	defer src.Close()
+	objects, err := src.List(".")
+	if err != nil {
+		return err
+	}
+	added, removed, err := syncIgnoredObjects(fsys, objects...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "%s ignores %d objects, %d added and %d removed\n", gitignoreFile, len(objects), added, removed)
+	reportFrom(cmd.Context()).setResult(gitignoreResult{Objects: len(objects), Added: added, Removed: removed})
+	return nil
+}
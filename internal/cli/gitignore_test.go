@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitignoreEntry(t *testing.T) {
+	assert.Equal(t, "/tools/tool.bin", gitignoreEntry("tools/tool.bin"))
+	assert.Equal(t, "/!important", gitignoreEntry("!important"))
+	assert.Equal(t, "/#1 \\[final]\\*.psd", gitignoreEntry("#1 [final]*.psd"))
+	assert.Equal(t, "/trailing\\ \\ ", gitignoreEntry("trailing  "))
+}
+
+func TestIgnoredObjects(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, gitignoreFile, []byte("node_modules/\n"), 0644))
+
+	require.NoError(t, ignoreObjects(fsys, "tools/tool.bin", "assets/logo.png"))
+	b, err := afero.ReadFile(fsys, gitignoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, `node_modules/
+`+gitignoreBegin+`
+/assets/logo.png
+/tools/tool.bin
+`+gitignoreEnd+`
+`, string(b))
+
+	// lines after the block are kept where they are
+	require.NoError(t, afero.WriteFile(fsys, gitignoreFile, append(b, "*.log\n"...), 0644))
+	added, removed, err := syncIgnoredObjects(fsys, "assets/logo.png", "sdk")
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, removed)
+	b, err = afero.ReadFile(fsys, gitignoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, `node_modules/
+`+gitignoreBegin+`
+/assets/logo.png
+/sdk
+`+gitignoreEnd+`
+*.log
+`, string(b))
+
+	// the block disappears with its last entry
+	require.NoError(t, unignoreObjects(fsys, "assets/logo.png", "sdk"))
+	b, err = afero.ReadFile(fsys, gitignoreFile)
+	require.NoError(t, err)
+	assert.Equal(t, "node_modules/\n*.log\n", string(b))
+
+	require.NoError(t, afero.WriteFile(fsys, gitignoreFile, []byte(gitignoreBegin+"\n/a\n"), 0644))
+	assert.ErrorIs(t, ignoreObjects(fsys, "b"), ErrUnterminatedBlock)
+
+	// no .gitignore is created for nothing
+	empty := afero.NewMemMapFs()
+	require.NoError(t, unignoreObjects(empty, "a"))
+	_, err = empty.Stat(gitignoreFile)
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+}
@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 
 	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/encryption"
+	"github.com/discentem/cavorite/logging"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/signing"
 	"github.com/discentem/cavorite/stores"
 )
 
@@ -47,7 +52,7 @@ func rootOfSourceRepo(fsyses ...fsWithAbs) (*string, error) {
 	if err != nil {
 		return nil, errors.New(".cavorite/config not detected, not in sourceRepo root")
 	}
-	logger.V(2).Infof("absPathOfconfig: %q", absPathOfConfig)
+	slog.Debug("found config", "path", absPathOfConfig)
 	root := filepath.Dir(filepath.Dir(absPathOfConfig))
 	return &root, nil
 }
@@ -61,13 +66,108 @@ func removePathPrefix(objects []string, prefix string) ([]string, error) {
 		if !strings.HasPrefix(absObject, prefix) {
 			return nil, fmt.Errorf("%q does not exist relative to source_repo: %q", object, prefix)
 		}
+		if absObject == prefix {
+			objects[i] = "."
+			continue
+		}
 		objects[i] = strings.TrimPrefix(absObject, fmt.Sprintf("%s/", prefix))
 	}
 
 	return objects, nil
 }
 
+var (
+	ErrEncryptionWithPlugin   = errors.New("encryption is not supported with plugin stores")
+	ErrChunkingWithPlugin     = errors.New("chunking is not supported with plugin stores")
+	ErrEncryptionWithChunking = errors.New("encryption and chunking cannot be enabled at the same time")
+)
+
+// newMetadataSource returns the metadata.Source holding the metadata of objects according to cfg
+func newMetadataSource(cfg config.Config, fsys afero.Fs) metadata.Source {
+	if cfg.Manifest != "" {
+		return metadata.NewManifestSource(fsys, cfg.Manifest)
+	}
+	return metadata.NewSidecarSource(fsys, cfg.Options.MetadataFileExtension)
+}
+
+// initStoreFromConfig returns the Store described by cfg. If cfg enables encryption or chunking, the
+// backend Store operates on a staging filesystem and is wrapped by a Store that reads from fsys.
+// If cfg enables signing, the result is wrapped once more by a SignedStore.
 func initStoreFromConfig(ctx context.Context, cfg config.Config, fsys afero.Fs) (stores.Store, error) {
+	s, err := initUnsignedStore(ctx, cfg, fsys)
+	if err != nil || !cfg.Signing.Enabled() {
+		return s, err
+	}
+	signed, err := newSignedStore(s, cfg.Signing, fsys)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return signed, nil
+}
+
+func newSignedStore(s stores.Store, opts *signing.Options, fsys afero.Fs) (*stores.SignedStore, error) {
+	var signer func() (*signing.Signer, error)
+	if opts.KeyFile != "" {
+		// the key is only loaded when something is uploaded, so retrieving works without it
+		signer = sync.OnceValues(func() (*signing.Signer, error) {
+			return signing.LoadSigner(fsys, opts.KeyFile)
+		})
+	}
+	var verifier *signing.Verifier
+	if len(opts.TrustedKeys) > 0 {
+		var err error
+		verifier, err = signing.NewVerifier(opts.TrustedKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stores.NewSignedStore(s, signer, verifier, opts.Enforce)
+}
+
+func initUnsignedStore(ctx context.Context, cfg config.Config, fsys afero.Fs) (stores.Store, error) {
+	encrypted := cfg.Encryption.Enabled()
+	chunked := cfg.Chunking != nil
+	switch {
+	case !encrypted && !chunked:
+		return newBackendStore(ctx, cfg, fsys)
+	case encrypted && chunked:
+		return nil, ErrEncryptionWithChunking
+	case encrypted && cfg.StoreType == stores.StoreTypeGoPlugin:
+		return nil, ErrEncryptionWithPlugin
+	case chunked && cfg.StoreType == stores.StoreTypeGoPlugin:
+		return nil, ErrChunkingWithPlugin
+	}
+
+	var key *encryption.Key
+	if encrypted {
+		var err error
+		key, err = encryption.LoadKey(ctx, fsys, cfg.Encryption)
+		if err != nil {
+			return nil, fmt.Errorf("loading encryption key: %w", err)
+		}
+	}
+	if chunked {
+		if err := cfg.Chunking.WithDefaults().Validate(); err != nil {
+			return nil, err
+		}
+	}
+	staging, cleanup, err := stores.NewStagingFs()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := newBackendStore(ctx, cfg, staging)
+	if err != nil {
+		_ = cleanup()
+		return nil, err
+	}
+	if chunked {
+		return stores.NewChunkedStore(backend, fsys, staging, cleanup, *cfg.Chunking), nil
+	}
+	return stores.NewEncryptedStore(backend, fsys, staging, cleanup, key), nil
+}
+
+func newBackendStore(ctx context.Context, cfg config.Config, fsys afero.Fs) (stores.Store, error) {
 	switch cfg.StoreType {
 	case stores.StoreTypeS3:
 		s3, err := stores.NewS3Store(ctx, fsys, cfg.Options)
@@ -86,14 +186,31 @@ func initStoreFromConfig(ctx context.Context, cfg config.Config, fsys afero.Fs)
 			return nil, fmt.Errorf("improper plugin init: %v", err)
 		}
 		return ps, nil
+	case stores.StoreTypeHTTP:
+		hs, err := stores.NewHTTPStore(fsys, cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("improper stores.HTTPStore init: %v", err)
+		}
+		return hs, nil
 	default:
 		return nil, fmt.Errorf("type %s is not supported", cfg.StoreType)
 	}
 }
 
-func setLoggerOpts() {
+// setLoggerOpts configures the default logger from the global logging flags
+func setLoggerOpts() error {
+	opts := logging.Options{Format: logFormat}
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	opts.Level = level
 	if VV {
-		logger.SetLevel(2)
+		opts.Level = min(opts.Level, slog.LevelDebug)
+	}
+	if debug {
+		opts.Level = logging.LevelTrace
+		opts.AddSource = true
 	}
-	logger.SetFlags(log.LUTC)
+	return logging.Setup(os.Stderr, opts)
 }
@@ -11,7 +11,6 @@ import (
 	"github.com/discentem/cavorite/config"
 	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/stores"
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -31,6 +30,11 @@ func TestRemovePathPrefix(t *testing.T) {
 	)
 	assert.NoError(t, err)
 	assert.Equal(t, expectedRemovePathPrefixes, testRemovePathPrefixes)
+
+	// the root of the repo itself
+	testRemovePathPrefixes, err = removePathPrefix([]string{pathPrefix}, pathPrefix)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"."}, testRemovePathPrefixes)
 }
 
 func TestInitStoreFromConfig(t *testing.T) {
@@ -113,6 +117,23 @@ func TestInitStoreFromConfig_InvalidateStoreType(t *testing.T) {
 	assert.Errorf(t, err, "type %s is not supported", "s4")
 }
 
+func TestInitStoreFromConfig_HTTP(t *testing.T) {
+	cfg := config.Config{
+		StoreType: stores.StoreTypeHTTP,
+		Options: stores.Options{
+			BackendAddress:        "http://localhost:8080",
+			MetadataFileExtension: metadata.MetadataFileExtension,
+		},
+	}
+	s, err := initStoreFromConfig(context.Background(), cfg, afero.NewMemMapFs())
+	require.NoError(t, err)
+	assert.IsType(t, &stores.HTTPStore{}, s)
+
+	cfg.Options.BackendAddress = "s3://test-bucket"
+	_, err = initStoreFromConfig(context.Background(), cfg, afero.NewMemMapFs())
+	assert.Error(t, err)
+}
+
 type aferoxWithAbsErr struct {
 	aferox.Aferox
 }
@@ -191,7 +212,6 @@ func TestRootOfSourceRepo(t *testing.T) {
 			expectedErr: nil,
 		},
 	}
-	logger.Init("helpers_test", true, false, os.Stdout)
 	t.Parallel()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
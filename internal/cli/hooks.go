@@ -0,0 +1,492 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gonuts/go-shellquote"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/bindetector"
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/program"
+)
+
+const (
+	hookPreCommit    = "pre-commit"
+	hookPostCheckout = "post-checkout"
+	hookPostMerge    = "post-merge"
+
+	// defaultMaxSize is the size above which the pre-commit hook rejects staged files
+	defaultMaxSize = 10 << 20
+	// checkAttrBatch is the number of paths passed to a single git check-attr
+	checkAttrBatch = 1000
+)
+
+// hookMarker marks hooks written by hooks install, which may be overwritten
+var hookMarker = fmt.Sprintf("# installed by %s hooks install", program.Name)
+
+var (
+	ErrHookExists         = errors.New("hook exists and was not installed by " + program.Name)
+	ErrStagedRejected     = errors.New("some staged files should be stored with " + program.Name)
+	ErrUnexpectedHookArgs = errors.New("unexpected hook arguments")
+)
+
+// hookInstaller writes git hooks that run "cavorite hooks run"
+type hookInstaller struct {
+	fsys afero.Fs
+	// dir is the directory git runs hooks from
+	dir string
+}
+
+// hookScript returns a hook that runs hook with binary, followed by args and the arguments git passes
+func hookScript(binary, hook string, args ...string) string {
+	command := shellquote.Join(append([]string{binary, "hooks", "run", hook}, args...)...)
+	return fmt.Sprintf("#!/bin/sh\n%s, run it again instead of editing this file\nexec %s \"$@\"\n", hookMarker, command)
+}
+
+// install writes scripts, which map hook names to their contents, to hi.dir. Hooks that were not
+// written by install are only replaced if force is set. It returns the paths of the hooks it wrote.
+func (hi *hookInstaller) install(scripts map[string]string, force bool) ([]string, error) {
+	if err := hi.fsys.MkdirAll(hi.dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	var hooks []string
+	for _, hook := range []string{hookPreCommit, hookPostCheckout, hookPostMerge} {
+		script, ok := scripts[hook]
+		if !ok {
+			continue
+		}
+		path := filepath.Join(hi.dir, hook)
+		b, err := afero.ReadFile(hi.fsys, path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return hooks, err
+		case !bytes.Contains(b, []byte(hookMarker)) && !force:
+			return hooks, fmt.Errorf("%w: %s, use --force to replace it", ErrHookExists, path)
+		}
+		if err := afero.WriteFile(hi.fsys, path, []byte(script), 0755); err != nil {
+			return hooks, err
+		}
+		// WriteFile keeps the mode of existing files
+		if err := hi.fsys.Chmod(path, 0755); err != nil {
+			return hooks, err
+		}
+		hooks = append(hooks, path)
+	}
+	return hooks, nil
+}
+
+// rejection is a staged file that should not be committed as it is
+type rejection struct {
+	path   string
+	reason string
+}
+
+// preCommitChecker finds staged files that should be stored with cavorite instead. Files are
+// checked as they are in the index, which is what gets committed.
+type preCommitChecker struct {
+	src metadata.Source
+	ext string
+	// git runs git with args in the root of the repo with stdin as its input and returns its stdout
+	git func(stdin io.Reader, args ...string) ([]byte, error)
+	// track, if not nil, matches files that are rejected whatever their size or content
+	track    *ignore.Patterns
+	isBinary func(content []byte) bool
+	// maxSize is the size above which any file is rejected, zero for no limit
+	maxSize int64
+	// minBinarySize is the size below which binaries are accepted
+	minBinarySize int64
+}
+
+// stagedFile is a file added or modified in the index
+type stagedFile struct {
+	path string
+	mode string
+	oid  string
+}
+
+// splitNul splits the output of a git command run with -z
+func splitNul(out []byte) []string {
+	var fields []string
+	for _, field := range strings.Split(string(out), "\x00") {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// staged returns the files added or modified in the index
+func (pc *preCommitChecker) staged() ([]stagedFile, error) {
+	out, err := pc.git(nil, "diff", "--cached", "--raw", "--no-abbrev", "--no-renames", "--diff-filter=ACM", "-z")
+	if err != nil {
+		return nil, err
+	}
+	// ":<old mode> <new mode> <old oid> <new oid> <status>" followed by the path of each file
+	fields := splitNul(out)
+	var staged []stagedFile
+	for i := 0; i+1 < len(fields); i += 2 {
+		info := strings.Fields(fields[i])
+		if len(info) != 5 {
+			return nil, fmt.Errorf("unexpected output of git diff: %q", fields[i])
+		}
+		staged = append(staged, stagedFile{path: fields[i+1], mode: info[1], oid: info[3]})
+	}
+	return staged, nil
+}
+
+// sizes returns the sizes of the blobs oids
+func (pc *preCommitChecker) sizes(oids []string) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	if len(oids) == 0 {
+		return sizes, nil
+	}
+	out, err := pc.git(strings.NewReader(strings.Join(oids, "\n")+"\n"), "cat-file", "--batch-check")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected output of git cat-file: %q", line)
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output of git cat-file: %q", line)
+		}
+		sizes[fields[0]] = size
+	}
+	return sizes, nil
+}
+
+// binaries returns which of the blobs oids are binary
+func (pc *preCommitChecker) binaries(oids []string) (map[string]bool, error) {
+	binaries := make(map[string]bool)
+	if len(oids) == 0 {
+		return binaries, nil
+	}
+	out, err := pc.git(strings.NewReader(strings.Join(oids, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+	err = readBatch(out, func(oid, _ string, content []byte) error {
+		binaries[oid] = pc.isBinary(content)
+		return nil
+	})
+	return binaries, err
+}
+
+// check returns the staged files that should not be committed and the number of staged files
+func (pc *preCommitChecker) check() ([]rejection, int, error) {
+	staged, err := pc.staged()
+	if err != nil {
+		return nil, 0, err
+	}
+	paths := make([]string, len(staged))
+	for i, f := range staged {
+		paths[i] = f.path
+	}
+	filtered, err := pc.filtered(paths)
+	if err != nil {
+		return nil, 0, err
+	}
+	ext := pc.ext
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	var candidates []stagedFile
+	var oids []string
+	for _, f := range staged {
+		// submodules are commits, not blobs
+		if filtered[f.path] || strings.HasSuffix(f.path, "."+ext) || f.mode == "160000" {
+			continue
+		}
+		candidates = append(candidates, f)
+		oids = append(oids, f.oid)
+	}
+	sizes, err := pc.sizes(oids)
+	if err != nil {
+		return nil, 0, err
+	}
+	// only the content of files that are not rejected otherwise is read
+	reasons := make([]string, len(candidates))
+	var unknown []string
+	for i, f := range candidates {
+		size := sizes[f.oid]
+		switch {
+		case pc.hasMetadata(f.path):
+			reasons[i] = fmt.Sprintf("object has metadata in %s, commit only the metadata", pc.src.Location(f.path))
+		case pc.track != nil && pc.track.Match(f.path, false):
+			reasons[i] = "file matches a tracked pattern in .cavorite/config"
+		case pc.maxSize > 0 && size > pc.maxSize:
+			reasons[i] = fmt.Sprintf("file is %d bytes, more than the limit of %d", size, pc.maxSize)
+		case size >= pc.minBinarySize:
+			unknown = append(unknown, f.oid)
+		}
+	}
+	binaries, err := pc.binaries(unknown)
+	if err != nil {
+		return nil, 0, err
+	}
+	var rejections []rejection
+	for i, f := range candidates {
+		if reasons[i] == "" && binaries[f.oid] {
+			reasons[i] = "binary file is not stored with " + program.Name
+		}
+		if reasons[i] != "" {
+			rejections = append(rejections, rejection{f.path, reasons[i]})
+		}
+	}
+	return rejections, len(staged), nil
+}
+
+func (pc *preCommitChecker) hasMetadata(path string) bool {
+	_, err := pc.src.Get(path)
+	return err == nil
+}
+
+// filtered returns which of paths git filters through cavorite
+func (pc *preCommitChecker) filtered(paths []string) (map[string]bool, error) {
+	filtered := make(map[string]bool)
+	for start := 0; start < len(paths); start += checkAttrBatch {
+		batch := paths[start:min(start+checkAttrBatch, len(paths))]
+		out, err := pc.git(nil, append([]string{"check-attr", "-z", "filter", "--"}, batch...)...)
+		if err != nil {
+			return nil, err
+		}
+		// path, attribute and value for each path
+		fields := strings.Split(string(out), "\x00")
+		for i := 0; i+2 < len(fields); i += 3 {
+			if fields[i+2] == program.Name {
+				filtered[fields[i]] = true
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// writeRejections writes rejections and how to fix them to w
+func writeRejections(w io.Writer, rejections []rejection) {
+	for _, r := range rejections {
+		fmt.Fprintf(w, "REJECTED %s: %s\n", r.path, r.reason)
+	}
+	fmt.Fprintf(w, `
+Upload these files with "%s upload <path>...", unstage them with "git rm --cached <path>..."
+and commit their metadata instead, or skip this check with "git commit --no-verify".
+`, program.Name)
+}
+
+// changedObjects returns the objects whose metadata differs between the commits prev and next. prev
+// is all zeros when a clone is first checked out.
+func changedObjects(git func(stdin io.Reader, args ...string) ([]byte, error), src metadata.Source, ext, manifest, prev, next string) ([]string, error) {
+	var out []byte
+	var err error
+	if strings.Trim(prev, "0") == "" {
+		out, err = git(nil, "ls-tree", "-r", "--name-only", "-z", next)
+	} else {
+		out, err = git(nil, "diff", "--name-only", "--diff-filter=ACMRT", "-z", prev, next)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	var objects []string
+	for _, path := range splitNul(out) {
+		if manifest != "" {
+			if filepath.Clean(path) == filepath.Clean(manifest) {
+				// objects that are still current are skipped when they are retrieved
+				return src.List(".")
+			}
+			continue
+		}
+		if obj, ok := strings.CutSuffix(path, "."+ext); ok {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func hooksCmd() *cobra.Command {
+	hooksCmd := &cobra.Command{
+		Use:   "hooks",
+		Short: "Manage the git hooks of the repo",
+	}
+	hooksCmd.AddCommand(hooksInstallCmd(), hooksRunCmd())
+	return hooksCmd
+}
+
+// addPreCommitFlags adds the flags of the pre-commit hook to cmd
+func addPreCommitFlags(cmd *cobra.Command) {
+	cmd.Flags().Int64("max-size", defaultMaxSize, "Reject staged files larger than this many bytes, 0 for no limit")
+	cmd.Flags().Int64("min-binary-size", 0, "Accept staged binaries smaller than this many bytes")
+}
+
+func hooksInstallCmd() *cobra.Command {
+	installCmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install git hooks that reject binaries on commit and retrieve objects on checkout",
+		Long: fmt.Sprintf(`Install a pre-commit hook that rejects staged files that match a tracked pattern, are binary
+or are larger than --max-size unless they are stored with %s, and post-checkout and post-merge
+hooks that retrieve the objects whose metadata changed. Hooks that were not installed by %s are
+only replaced with --force.`,
+			program.Name, program.Name),
+		Args: cobra.NoArgs,
+		RunE: hooksInstallFn,
+	}
+	installCmd.Flags().String("binary", program.Name, fmt.Sprintf("The %s binary the hooks run, looked up in PATH unless it is a path", program.Name))
+	installCmd.Flags().Bool("force", false, "Replace existing hooks")
+	addPreCommitFlags(installCmd)
+	return installCmd
+}
+
+func hooksInstallFn(cmd *cobra.Command, _ []string) error {
+	binary, err := cmd.Flags().GetString("binary")
+	if err != nil {
+		return err
+	}
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	maxSize, err := cmd.Flags().GetInt64("max-size")
+	if err != nil {
+		return err
+	}
+	minBinarySize, err := cmd.Flags().GetInt64("min-binary-size")
+	if err != nil {
+		return err
+	}
+	// respects core.hooksPath
+	out, err := gitOutput("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return err
+	}
+	hi := &hookInstaller{fsys: afero.NewOsFs(), dir: strings.TrimSpace(string(out))}
+	hooks, err := hi.install(map[string]string{
+		hookPreCommit: hookScript(binary, hookPreCommit,
+			"--max-size", strconv.FormatInt(maxSize, 10),
+			"--min-binary-size", strconv.FormatInt(minBinarySize, 10)),
+		hookPostCheckout: hookScript(binary, hookPostCheckout),
+		hookPostMerge:    hookScript(binary, hookPostMerge),
+	}, force)
+	for _, hook := range hooks {
+		fmt.Fprintf(cmd.OutOrStdout(), "installed %s\n", hook)
+	}
+	return err
+}
+
+func hooksRunCmd() *cobra.Command {
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run a hook, run by git itself",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+	}
+	preCommitCmd := &cobra.Command{
+		Use:   hookPreCommit,
+		Short: fmt.Sprintf("Reject staged files that should be stored with %s", program.Name),
+		Args:  cobra.NoArgs,
+		RunE:  preCommitFn,
+	}
+	addPreCommitFlags(preCommitCmd)
+	postCheckoutCmd := &cobra.Command{
+		Use:   hookPostCheckout + " <previous> <new> <branch>",
+		Short: "Retrieve the objects whose metadata changed between two commits",
+		Args:  cobra.ExactArgs(3),
+		RunE:  postCheckoutFn,
+	}
+	postMergeCmd := &cobra.Command{
+		Use:   hookPostMerge + " <squash>",
+		Short: "Retrieve the objects whose metadata changed in a merge",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return retrieveChanged(cmd, "ORIG_HEAD", "HEAD")
+		},
+	}
+	runCmd.AddCommand(preCommitCmd, postCheckoutCmd, postMergeCmd)
+	return runCmd
+}
+
+func preCommitFn(cmd *cobra.Command, _ []string) error {
+	maxSize, err := cmd.Flags().GetInt64("max-size")
+	if err != nil {
+		return err
+	}
+	minBinarySize, err := cmd.Flags().GetInt64("min-binary-size")
+	if err != nil {
+		return err
+	}
+	track, err := trackPatterns(config.Cfg)
+	if err != nil {
+		return err
+	}
+	pc := &preCommitChecker{
+		src:   newMetadataSource(config.Cfg, afero.NewOsFs()),
+		ext:   config.Cfg.Options.MetadataFileExtension,
+		git:   gitOutputFrom,
+		track: track,
+		isBinary: func(content []byte) bool {
+			r, err := bindetector.Detector{}.Detect(bytes.NewReader(content))
+			return err == nil && r.Binary
+		},
+		maxSize:       maxSize,
+		minBinarySize: minBinarySize,
+	}
+	defer pc.src.Close()
+	rejections, staged, err := pc.check()
+	if err != nil {
+		return err
+	}
+	if len(rejections) == 0 {
+		return nil
+	}
+	writeRejections(cmd.ErrOrStderr(), rejections)
+	return fmt.Errorf("%w: %d of %d", ErrStagedRejected, len(rejections), staged)
+}
+
+func postCheckoutFn(cmd *cobra.Command, args []string) error {
+	switch args[2] {
+	case "0":
+		// only files were checked out
+		return nil
+	case "1":
+		return retrieveChanged(cmd, args[0], args[1])
+	}
+	return fmt.Errorf("%w: %q", ErrUnexpectedHookArgs, args)
+}
+
+// retrieveChanged retrieves the objects whose metadata differs between the commits prev and next
+func retrieveChanged(cmd *cobra.Command, prev, next string) error {
+	fsys := afero.NewOsFs()
+	src := newMetadataSource(config.Cfg, fsys)
+	defer src.Close()
+	objects, err := changedObjects(gitOutputFrom, src, config.Cfg.Options.MetadataFileExtension, config.Cfg.Manifest, prev, next)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		slog.Debug("no metadata changed", "from", prev, "to", next)
+		return nil
+	}
+	s, err := initStoreFromConfig(cmd.Context(), config.Cfg, fsys)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return retrieveObjects(cmd.Context(), fsys, src, s, objects...)
+}
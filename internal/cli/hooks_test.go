@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+)
+
+func writeRepoFiles(t *testing.T, repo string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(repo, path)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(repo, path), []byte(content), 0644))
+	}
+}
+
+func TestHooksInstall(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "hooks")
+	hi := &hookInstaller{fsys: afero.NewOsFs(), dir: dir}
+	scripts := map[string]string{
+		hookPreCommit:    hookScript("/opt/cavorite bin/cavorite", hookPreCommit, "--max-size", "100"),
+		hookPostCheckout: hookScript("cavorite", hookPostCheckout),
+	}
+	assert.Equal(t, `#!/bin/sh
+# installed by cavorite hooks install, run it again instead of editing this file
+exec '/opt/cavorite bin/cavorite' hooks run pre-commit --max-size 100 "$@"
+`, scripts[hookPreCommit])
+
+	hooks, err := hi.install(scripts, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, hookPreCommit), filepath.Join(dir, hookPostCheckout)}, hooks)
+	info, err := os.Stat(filepath.Join(dir, hookPreCommit))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// hooks installed before are replaced, others only with force
+	_, err = hi.install(scripts, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, hookPostMerge), []byte("#!/bin/sh\nmake\n"), 0755))
+	scripts[hookPostMerge] = hookScript("cavorite", hookPostMerge)
+	_, err = hi.install(scripts, false)
+	assert.ErrorIs(t, err, ErrHookExists)
+	_, err = hi.install(scripts, true)
+	require.NoError(t, err)
+	b, err := os.ReadFile(filepath.Join(dir, hookPostMerge))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "hooks run post-merge")
+}
+
+func TestPreCommit(t *testing.T) {
+	repo, _ := newTestGitRepo(t, nil)
+	writeRepoFiles(t, repo, map[string]string{
+		".gitattributes":       "*.iso filter=cavorite\n",
+		"README":               "plain",
+		"big.txt":              strings.Repeat("x", 101),
+		"tools/tool.bin":       "\x00tool",
+		"tools/tool.bin.cfile": fmt.Sprintf(stuffCfile, "tools/tool.bin", "sha256", stuffSHA256),
+		"icon.bin":             "\x00",
+		"image.bin":            "\x00image",
+		"disk.iso":             "\x00disk",
+		"app.dmg":              "dmg",
+	})
+	git(t, repo, nil, "add", ".")
+	// the index is checked, not the working tree
+	writeRepoFiles(t, repo, map[string]string{
+		"README":    "\x00changed after it was staged",
+		"image.bin": strings.Repeat("x", 101),
+	})
+	fsys := afero.NewBasePathFs(afero.NewOsFs(), repo)
+	track, err := ignore.ParsePatterns("*.dmg")
+	require.NoError(t, err)
+	pc := &preCommitChecker{
+		src:   metadata.NewSidecarSource(fsys, "cfile"),
+		ext:   "cfile",
+		git:   gitIn(repo),
+		track: track,
+		isBinary: func(content []byte) bool {
+			return bytes.HasPrefix(content, []byte("\x00"))
+		},
+		maxSize:       100,
+		minBinarySize: 2,
+	}
+	rejections, staged, err := pc.check()
+	require.NoError(t, err)
+	assert.Equal(t, 9, staged)
+	assert.Equal(t, []rejection{
+		{"app.dmg", "file matches a tracked pattern in .cavorite/config"},
+		{"big.txt", "file is 101 bytes, more than the limit of 100"},
+		{"image.bin", "binary file is not stored with cavorite"},
+		{"tools/tool.bin", "object has metadata in tools/tool.bin.cfile, commit only the metadata"},
+	}, rejections)
+
+	var out bytes.Buffer
+	writeRejections(&out, rejections[1:2])
+	assert.True(t, strings.HasPrefix(out.String(), "REJECTED big.txt: file is 101 bytes"))
+}
+
+func TestChangedObjects(t *testing.T) {
+	repo, _ := newTestGitRepo(t, nil)
+	cfile := func(obj string) string {
+		return fmt.Sprintf(stuffCfile, obj, "sha256", stuffSHA256)
+	}
+	writeRepoFiles(t, repo, map[string]string{
+		"a.cfile":       cfile("a"),
+		"b.cfile":       cfile("b"),
+		"tools/c.cfile": cfile("tools/c"),
+		"README":        "plain",
+	})
+	git(t, repo, nil, "add", ".")
+	git(t, repo, nil, "commit", "-q", "-m", "first")
+	first := strings.TrimSpace(git(t, repo, nil, "rev-parse", "HEAD"))
+	writeRepoFiles(t, repo, map[string]string{
+		"a.cfile": cfile("a") + "\n",
+		"d.cfile": cfile("d"),
+		"README":  "changed",
+	})
+	require.NoError(t, os.Remove(filepath.Join(repo, "b.cfile")))
+	git(t, repo, nil, "add", "-A")
+	git(t, repo, nil, "commit", "-q", "-m", "second")
+
+	fsys := afero.NewBasePathFs(afero.NewOsFs(), repo)
+	src := metadata.NewSidecarSource(fsys, "cfile")
+	objects, err := changedObjects(gitIn(repo), src, "cfile", "", first, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "d"}, objects)
+
+	// the first checkout of a clone
+	objects, err = changedObjects(gitIn(repo), src, "cfile", "", strings.Repeat("0", 40), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "d", "tools/c"}, objects)
+
+	// every object in manifest mode once the manifest changed
+	objects, err = changedObjects(gitIn(repo), src, "cfile", "README", first, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "d", "tools/c"}, objects)
+	objects, err = changedObjects(gitIn(repo), src, "cfile", "tools/c.cfile", first, "HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
+}
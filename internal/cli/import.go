@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+)
+
+func importCmd() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Convert metadata written by other tools into cfiles",
+		Long:  "Convert metadata written by other tools into cfiles",
+		Args:  cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return cmd.Help()
+		},
+	}
+	importCmd.AddCommand(
+		importLFSCmd(),
+		importPantriCmd(),
+	)
+	return importCmd
+}
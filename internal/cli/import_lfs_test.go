@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/lfs"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/testutils"
+)
+
+const stuffSHA256 = "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0"
+
+var stuffLFSPointer = lfs.Pointer{Oid: stuffSHA256, Size: 5}
+
+// uploadRecorder is a simpleStore that remembers what was uploaded
+type uploadRecorder struct {
+	simpleStore
+	uploaded *[]string
+}
+
+func (s uploadRecorder) Upload(ctx context.Context, objects ...string) error {
+	*s.uploaded = append(*s.uploaded, objects...)
+	return nil
+}
+
+func TestImportLFS(t *testing.T) {
+	missing := lfs.Pointer{Oid: "8a0a4d5f3b2c1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f", Size: 3}
+	storage := lfs.LocalStorage{Dir: lfs.DefaultObjectsDir}
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		storage.Path(stuffLFSPointer): {Content: []byte("stuff")},
+		"tools/pointer":               {Content: []byte(stuffLFSPointer.String()), Mode: 0755},
+		"tools/missing":               {Content: []byte(missing.String())},
+		"tools/regular":               {Content: []byte("not a pointer")},
+	})
+	require.NoError(t, err)
+	storage.Fsys = *fsys
+
+	var uploaded []string
+	s := uploadRecorder{
+		simpleStore: simpleStore{options: stores.Options{MetadataFileExtension: "cfile", ObjectKeyPrefix: "lfs"}},
+		uploaded:    &uploaded,
+	}
+	li := &lfsImporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile"), sources: []lfs.Fetcher{storage}}
+	var out bytes.Buffer
+	err = importLFS(context.Background(), li, s, &out, "tools")
+	assert.ErrorIs(t, err, ErrLFSObjectUnavailable)
+	assert.ErrorContains(t, err, "tools/missing")
+	assert.Equal(t, "imported tools/pointer\n", out.String())
+	assert.Equal(t, []string{"lfs/tools/pointer"}, uploaded)
+
+	b, err := afero.ReadFile(*fsys, "tools/pointer")
+	require.NoError(t, err)
+	assert.Equal(t, "stuff", string(b))
+	m, err := metadata.ParseCfile(*fsys, "tools/pointer.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, "lfs/tools/pointer", m.Name)
+	assert.Equal(t, metadata.AlgorithmSHA256, m.Algorithm)
+	assert.Equal(t, stuffSHA256, m.Checksum)
+	assert.Equal(t, int64(5), *m.Size)
+	assert.Equal(t, metadata.FileMode(0755), m.Mode)
+
+	// the missing object is left as a pointer and nothing else is touched
+	b, err = afero.ReadFile(*fsys, "tools/missing")
+	require.NoError(t, err)
+	assert.Equal(t, missing.String(), string(b))
+	for _, cfile := range []string{"tools/missing.cfile", "tools/regular.cfile"} {
+		_, err = (*fsys).Stat(cfile)
+		assert.ErrorIs(t, err, afero.ErrFileNotFound)
+	}
+}
+
+func TestImportLFSRejectsCorruptObject(t *testing.T) {
+	storage := lfs.LocalStorage{Dir: lfs.DefaultObjectsDir}
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		storage.Path(stuffLFSPointer): {Content: []byte("stiff")},
+		"pointer":                     {Content: []byte(stuffLFSPointer.String())},
+	})
+	require.NoError(t, err)
+	storage.Fsys = *fsys
+
+	li := &lfsImporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile"), sources: []lfs.Fetcher{storage}}
+	err = importLFS(context.Background(), li, simpleStore{}, &bytes.Buffer{}, ".")
+	assert.ErrorIs(t, err, lfs.ErrChecksumMismatch)
+	b, err := afero.ReadFile(*fsys, "pointer")
+	require.NoError(t, err)
+	assert.Equal(t, stuffLFSPointer.String(), string(b))
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	cavoriteObjLib "github.com/discentem/cavorite/objects"
+	"github.com/discentem/cavorite/pantri"
+	"github.com/discentem/cavorite/stores"
+)
+
+// pantriImporter converts Pantri items into cavorite metadata
+type pantriImporter struct {
+	fsys afero.Fs
+	src  metadata.Source
+	// shelf, if not nil, holds the objects of the Pantri shelf under their checksums
+	shelf afero.Fs
+}
+
+func (pi *pantriImporter) parseItem(path string) (*pantri.Item, error) {
+	f, err := pi.fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	item, err := pantri.ParseItem(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return item, nil
+}
+
+// fetch makes sure obj matches item, copying it from the shelf if it is missing or different
+func (pi *pantriImporter) fetch(obj string, item *pantri.Item) error {
+	if f, err := pi.fsys.Open(obj); err == nil {
+		err = item.Verify(io.Discard, f)
+		f.Close()
+		if err == nil {
+			return nil
+		}
+		if pi.shelf == nil {
+			return fmt.Errorf("%s: %w", obj, err)
+		}
+		slog.Info("object does not match its pantri item, copying it from the shelf", "object", obj)
+	} else if pi.shelf == nil {
+		return fmt.Errorf("%s is not present and no shelf was given: %w", obj, err)
+	}
+
+	src, err := pi.shelf.Open(item.ShelfKey())
+	if err != nil {
+		return fmt.Errorf("%s: %w", obj, err)
+	}
+	defer src.Close()
+	tmp := obj + ".cavorite-tmp"
+	out, err := pi.fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = item.Verify(out, src)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = pi.fsys.Remove(tmp)
+		return fmt.Errorf("%s: %w", obj, err)
+	}
+	return pi.fsys.Rename(tmp, obj)
+}
+
+// writeMetadata records the metadata of obj without uploading it and returns it
+func (pi *pantriImporter) writeMetadata(opts stores.Options, obj string) (*metadata.ObjectMetaData, error) {
+	f, err := pi.fsys.Open(obj)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	prefixOp := cavoriteObjLib.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}
+	m, err := metadata.GenerateFromFileWithAlgorithm(f, prefixOp.Modify(obj), opts.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	return m, pi.src.Put(obj, m)
+}
+
+// importPantri records metadata for every Pantri item below roots. If s is not nil, the objects
+// are also uploaded to s, otherwise they are expected to be uploaded separately.
+func importPantri(ctx context.Context, pi *pantriImporter, s stores.Store, opts stores.Options, w io.Writer, roots ...string) error {
+	items, err := walkCfiles(pi.fsys, pantri.Extension, roots...)
+	if err != nil {
+		return err
+	}
+	r := reportFrom(ctx)
+	var result *multierr.Error
+	var objects []string
+	for _, path := range items {
+		start := time.Now()
+		obj := strings.TrimSuffix(path, "."+pantri.Extension)
+		item, err := pi.parseItem(path)
+		if err != nil {
+			r.add(newObjectResult(obj, outcomeFailed, nil, start, err))
+			result = multierr.Append(result, err)
+			continue
+		}
+		if err := pi.fetch(obj, item); err != nil {
+			r.add(newObjectResult(obj, outcomeFailed, nil, start, err))
+			result = multierr.Append(result, err)
+			continue
+		}
+		objects = append(objects, obj)
+	}
+	if len(objects) == 0 {
+		return result.ErrorOrNil()
+	}
+	if s != nil {
+		// upload records the result of every object
+		if err := upload(ctx, pi.fsys, pi.src, s, objects...); err != nil {
+			return multierr.Append(result, err)
+		}
+	} else {
+		var written []string
+		for _, obj := range objects {
+			start := time.Now()
+			m, err := pi.writeMetadata(opts, obj)
+			r.add(newObjectResult(obj, outcomeImported, m, start, err))
+			if err != nil {
+				result = multierr.Append(result, fmt.Errorf("%s: %w", obj, err))
+				continue
+			}
+			written = append(written, obj)
+		}
+		objects = written
+	}
+	for _, obj := range objects {
+		fmt.Fprintf(w, "imported %s\n", obj)
+	}
+	return result.ErrorOrNil()
+}
+
+func importPantriCmd() *cobra.Command {
+	importPantriCmd := &cobra.Command{
+		Use:   "pantri [path...]",
+		Short: "Convert Pantri items into cfiles",
+		Long: `Convert every Pantri .pitem file below the given paths, or the whole repo if none are given, into a cfile.
+Objects that are missing or do not match their item are copied from --shelf, a directory holding the Pantri shelf.
+With --upload the objects are also uploaded to the configured store.`,
+		RunE: importPantriFn,
+	}
+	importPantriCmd.Flags().String("shelf", "", "Directory containing the objects of the Pantri shelf, named by their sha1 checksum")
+	importPantriCmd.Flags().Bool("upload", false, "Upload the objects to the configured store")
+	return importPantriCmd
+}
+
+func importPantriFn(cmd *cobra.Command, paths []string) error {
+	fsys := afero.NewOsFs()
+	pi := &pantriImporter{fsys: fsys, src: newMetadataSource(config.Cfg, fsys)}
+	shelf, err := cmd.Flags().GetString("shelf")
+	if err != nil {
+		return err
+	}
+	if shelf != "" {
+		dir, err := homedir.Expand(shelf)
+		if err != nil {
+			return err
+		}
+		pi.shelf = afero.NewReadOnlyFs(afero.NewBasePathFs(fsys, dir))
+	}
+	doUpload, err := cmd.Flags().GetBool("upload")
+	if err != nil {
+		return err
+	}
+	var s stores.Store
+	if doUpload {
+		s, err = initStoreFromConfig(cmd.Context(), config.Cfg, fsys)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	err = importPantri(cmd.Context(), pi, s, config.Cfg.Options, cmd.OutOrStdout(), paths...)
+	return multierr.Append(err, pi.src.Close()).ErrorOrNil()
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/pantri"
+	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/testutils"
+)
+
+const stuffPitem = `{"checksum": "5eee38381388b6f30efdd5c5c6f067dbf32c0bb3"}`
+
+func TestImportPantri(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		// present locally
+		"tools/local":       {Content: []byte("stuff")},
+		"tools/local.pitem": {Content: []byte(stuffPitem)},
+		// only in the shelf
+		"tools/shelved.pitem": {Content: []byte(stuffPitem)},
+		// modified locally, so it is replaced from the shelf
+		"tools/modified":       {Content: []byte("other stuff")},
+		"tools/modified.pitem": {Content: []byte(stuffPitem)},
+	})
+	require.NoError(t, err)
+	shelf, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"5eee38381388b6f30efdd5c5c6f067dbf32c0bb3": {Content: []byte("stuff")},
+	})
+	require.NoError(t, err)
+
+	pi := &pantriImporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile"), shelf: *shelf}
+	opts := stores.Options{MetadataFileExtension: "cfile", ObjectKeyPrefix: "pantri"}
+	var out bytes.Buffer
+	require.NoError(t, importPantri(context.Background(), pi, nil, opts, &out, "tools"))
+	assert.Equal(t, "imported tools/local\nimported tools/modified\nimported tools/shelved\n", out.String())
+
+	for _, obj := range []string{"tools/local", "tools/modified", "tools/shelved"} {
+		b, err := afero.ReadFile(*fsys, obj)
+		require.NoError(t, err)
+		assert.Equal(t, "stuff", string(b))
+		m, err := metadata.ParseCfile(*fsys, obj+".cfile")
+		require.NoError(t, err)
+		assert.Equal(t, "pantri/"+obj, m.Name)
+		assert.Equal(t, "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0", m.Checksum)
+	}
+	_, err = (*fsys).Stat("tools/shelved.cavorite-tmp")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+}
+
+func TestImportPantriWithoutShelf(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"tools/local":         {Content: []byte("stuff")},
+		"tools/local.pitem":   {Content: []byte(stuffPitem)},
+		"tools/shelved.pitem": {Content: []byte(stuffPitem)},
+		"tools/broken.pitem":  {Content: []byte(`{"checksum": "nope"}`)},
+	})
+	require.NoError(t, err)
+
+	pi := &pantriImporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile")}
+	var out bytes.Buffer
+	err = importPantri(context.Background(), pi, nil, stores.Options{MetadataFileExtension: "cfile"}, &out, ".")
+	assert.ErrorIs(t, err, pantri.ErrInvalidItem)
+	assert.ErrorContains(t, err, "tools/shelved is not present")
+	assert.Equal(t, "imported tools/local\n", out.String())
+	_, err = (*fsys).Stat("tools/shelved.cfile")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+}
+
+func TestImportPantriUpload(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"tools/local":       {Content: []byte("stuff")},
+		"tools/local.pitem": {Content: []byte(stuffPitem)},
+	})
+	require.NoError(t, err)
+	s := simpleStore{
+		sourceFsys: *fsys,
+		options:    stores.Options{MetadataFileExtension: "cfile"},
+	}
+
+	pi := &pantriImporter{fsys: *fsys, src: metadata.NewSidecarSource(*fsys, "cfile")}
+	var out bytes.Buffer
+	require.NoError(t, importPantri(context.Background(), pi, s, stores.Options{}, &out, "."))
+	assert.Equal(t, "imported tools/local\n", out.String())
+	m, err := metadata.ParseCfile(*fsys, "tools/local.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, "tools/local", m.Name)
+}
@@ -3,13 +3,16 @@ package cli
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/discentem/cavorite/chunker"
 	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/encryption"
+	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/program"
 	"github.com/discentem/cavorite/stores"
 )
@@ -38,6 +41,14 @@ func initCmd() *cobra.Command {
 	initCmd.PersistentFlags().String("object_key_prefix",
 		"",
 		"Prefixed added to backend upload and retrieve requests. This does not affect the location of written metadata for objects.")
+	initCmd.PersistentFlags().String("encryption_key_file", "", "Encrypt objects before upload with the key stored in this file")
+	initCmd.PersistentFlags().String("encryption_key_env", "", "Encrypt objects before upload with the key stored in this environment variable")
+	initCmd.PersistentFlags().String("encryption_key_command", "", "Encrypt objects before upload with the key printed by this command")
+	initCmd.PersistentFlags().Bool("chunking", false, "Store objects as deduplicated content-defined chunks")
+	initCmd.PersistentFlags().Bool("manifest", false, fmt.Sprintf("Keep the metadata of every object in %s instead of a cfile next to each object", metadata.DefaultManifest))
+	initCmd.PersistentFlags().String("hash_algorithm",
+		metadata.DefaultAlgorithm,
+		fmt.Sprintf("Hash algorithm used to checksum objects, one of %v", metadata.Algorithms()))
 
 	return initCmd
 }
@@ -70,6 +81,11 @@ func initPreExecFn(cmd *cobra.Command, _ []string) error {
 	if err := viper.BindPFlag("object_key_prefix", cmd.PersistentFlags().Lookup("object_key_prefix")); err != nil {
 		return errors.New("Failed to bind object_key_prefix to viper")
 	}
+	for _, flag := range []string{"encryption_key_file", "encryption_key_env", "encryption_key_command", "chunking", "hash_algorithm", "manifest"} {
+		if err := viper.BindPFlag(flag, cmd.PersistentFlags().Lookup(flag)); err != nil {
+			return fmt.Errorf("Failed to bind %s to viper", flag)
+		}
+	}
 
 	return nil
 }
@@ -92,20 +108,31 @@ func initFn(cmd *cobra.Command, args []string) error {
 	backendAddress := viper.GetString("backend_address")
 	region := viper.GetString("region")
 	keyPrefix := viper.GetString("object_key_prefix")
+	hashAlgorithm := viper.GetString("hash_algorithm")
+	if _, err := metadata.NewHasher(hashAlgorithm); err != nil {
+		return err
+	}
 
 	opts := stores.Options{
 		BackendAddress:        backendAddress,
 		MetadataFileExtension: fileExt,
 		Region:                region,
 		ObjectKeyPrefix:       keyPrefix,
+		HashAlgorithm:         hashAlgorithm,
 	}
 
 	pluginAddress := viper.GetString("plugin_address")
 	if pluginAddress != "" {
-		logger.Infof("pluginAddress: %s", pluginAddress)
+		slog.Info("using plugin", "address", pluginAddress)
 		opts.PluginAddress = pluginAddress
 	}
 
+	encryptionOpts := &encryption.Options{
+		KeyFile:    viper.GetString("encryption_key_file"),
+		KeyEnv:     viper.GetString("encryption_key_env"),
+		KeyCommand: viper.GetString("encryption_key_command"),
+	}
+
 	fsys := afero.NewOsFs()
 
 	sb := stores.StoreType(backend)
@@ -125,13 +152,38 @@ func initFn(cmd *cobra.Command, args []string) error {
 		config.Cfg = getConfig()
 	case stores.StoreTypeAzureBlob:
 		config.Cfg = getConfig()
+	case stores.StoreTypeHTTP:
+		config.Cfg = getConfig()
 	case stores.StoreTypeGoPlugin:
 		if pluginAddress == "" {
-			return fmt.Errorf("--store_type was %q but --plugin_address was not specified", string(sb))
+			return fmt.Errorf("%w: --store_type was %q but --plugin_address was not specified", ErrUsage, string(sb))
 		}
 		config.Cfg = getConfig()
 	default:
 		return config.ErrUnsupportedStore
 	}
-	return config.Cfg.Write(fsys, repoToInit)
+	if encryptionOpts.Enabled() {
+		if sb == stores.StoreTypeGoPlugin {
+			return ErrEncryptionWithPlugin
+		}
+		config.Cfg.Encryption = encryptionOpts
+	}
+	if viper.GetBool("chunking") {
+		if sb == stores.StoreTypeGoPlugin {
+			return ErrChunkingWithPlugin
+		}
+		if config.Cfg.Encryption != nil {
+			return ErrEncryptionWithChunking
+		}
+		config.Cfg.Chunking = &chunker.Options{}
+	}
+	if viper.GetBool("manifest") {
+		config.Cfg.Manifest = metadata.DefaultManifest
+	}
+	if err := config.Cfg.Write(fsys, repoToInit); err != nil {
+		return err
+	}
+	// the config that was written is the result of init
+	reportFrom(cmd.Context()).setResult(config.Cfg)
+	return nil
 }
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gonuts/go-shellquote"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/program"
+)
+
+// gitAttributesFile is where install records the paths git filters through cavorite
+const gitAttributesFile = ".gitattributes"
+
+// filterInstaller configures git to filter paths through filter-process
+type filterInstaller struct {
+	// fsys is rooted at the root of the repo
+	fsys afero.Fs
+	// git runs git with args in the root of the repo
+	git func(args ...string) error
+}
+
+// filterAttributes returns the attributes of paths filtered through cavorite
+func filterAttributes() string {
+	return fmt.Sprintf("filter=%s -text", program.Name)
+}
+
+// install makes git run process for the cavorite filter and records patterns in .gitattributes. It
+// returns the patterns that were not recorded yet.
+func (fi *filterInstaller) install(process string, patterns ...string) ([]string, error) {
+	filter := "filter." + program.Name
+	if err := fi.git("config", "--local", filter+".process", process); err != nil {
+		return nil, err
+	}
+	// git refuses to add or check out files when the filter fails instead of storing them as they are
+	if err := fi.git("config", "--local", filter+".required", "true"); err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	b, err := afero.ReadFile(fi.fsys, gitAttributesFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	recorded := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		for _, attr := range fields[min(1, len(fields)):] {
+			if attr == "filter="+program.Name {
+				recorded[fields[0]] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var added []string
+	var buf bytes.Buffer
+	buf.Write(b)
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	for _, pattern := range patterns {
+		// gitattributes patterns end at the first whitespace
+		pattern = strings.ReplaceAll(pattern, " ", "[[:space:]]")
+		if recorded[pattern] {
+			continue
+		}
+		recorded[pattern] = true
+		added = append(added, pattern)
+		fmt.Fprintf(&buf, "%s %s\n", pattern, filterAttributes())
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+	return added, afero.WriteFile(fi.fsys, gitAttributesFile, buf.Bytes(), 0644)
+}
+
+func installCmd() *cobra.Command {
+	installCmd := &cobra.Command{
+		Use:   "install [pattern...]",
+		Short: fmt.Sprintf("Configure git to store files matching patterns in %s", program.Name),
+		Long: fmt.Sprintf(`Configure the git repo in the working directory to run "%s filter-process" and add a line
+to %s for each pattern, which uses the syntax of %s. Git then uploads matching files and
+commits their cfile instead when they are added, and retrieves them when they are checked out.
+Every clone needs to run install once, without patterns if %s is already committed.`,
+			program.Name, gitAttributesFile, gitAttributesFile, gitAttributesFile),
+		RunE: installFn,
+	}
+	installCmd.Flags().String("binary", program.Name, fmt.Sprintf("The %s binary git runs, looked up in PATH unless it is a path", program.Name))
+	return installCmd
+}
+
+func installFn(cmd *cobra.Command, patterns []string) error {
+	binary, err := cmd.Flags().GetString("binary")
+	if err != nil {
+		return err
+	}
+	fi := &filterInstaller{
+		fsys: afero.NewOsFs(),
+		git: func(args ...string) error {
+			return runGit(cmd.ErrOrStderr(), args...)
+		},
+	}
+	process := shellquote.Join(binary, "filter-process")
+	added, err := fi.install(process, patterns...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "git runs %q for the %s filter\n", process, program.Name)
+	for _, pattern := range added {
+		fmt.Fprintf(cmd.OutOrStdout(), "added %s to %s\n", pattern, gitAttributesFile)
+	}
+	return nil
+}
+
+// runGit runs git with args in the working directory, copying its stderr to stderr
+func runGit(stderr io.Writer, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// gitOutput runs git with args in the working directory and returns its stdout
+func gitOutput(args ...string) ([]byte, error) {
+	return gitOutputFrom(nil, args...)
+}
+
+// gitOutputFrom runs git with args in the working directory with stdin as its input and returns its
+// stdout
+func gitOutputFrom(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Stdin = stdin
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(exitErr.Stderr))
+		}
+		return nil, fmt.Errorf("git %s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
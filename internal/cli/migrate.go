@@ -0,0 +1,236 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	cavoriteObjLib "github.com/discentem/cavorite/objects"
+	"github.com/discentem/cavorite/signing"
+	"github.com/discentem/cavorite/stores"
+)
+
+var (
+	ErrCfilesOutdated = errors.New("some cfiles use an outdated schema, run cavorite migrate")
+	ErrMigrateSigned  = errors.New("cfile is signed and signing.key_file is not configured, so it cannot be re-signed")
+)
+
+// migrator upgrades metadata to the schema version it requires
+type migrator struct {
+	fsys afero.Fs
+	src  metadata.Source
+	// stat, if not nil, is used to fill in the size of objects that are not available locally
+	stat stores.StatStore
+	// signer, if not nil, re-signs cfiles that were signed before being migrated
+	signer func() (*signing.Signer, error)
+	// verifier checks the signatures of signed cfiles before they are re-signed
+	verifier *signing.Verifier
+	// prefix derives the key a signed cfile must name from the path of its object
+	prefix cavoriteObjLib.AddPrefixToKey
+}
+
+// outdated reports whether m was written with an older schema than it requires
+func outdated(m *metadata.ObjectMetaData) bool {
+	return m.SchemaVersion < m.RequiredSchemaVersion()
+}
+
+// upgrade brings m to the current schema. Fields that cannot be determined are left empty.
+func (mg *migrator) upgrade(ctx context.Context, obj string, m *metadata.ObjectMetaData) error {
+	if m.Signature != nil {
+		// re-signing must not turn a forged cfile into one signed by a trusted key
+		if err := mg.verifySignature(obj, m); err != nil {
+			return err
+		}
+	}
+	m.Algorithm = m.HashAlgorithm()
+	if m.Size == nil {
+		if err := mg.fillFromLocal(obj, m); err != nil {
+			return err
+		}
+	}
+	if m.Size == nil {
+		mg.fillFromRemote(ctx, m)
+	}
+	if m.Size == nil {
+		slog.Warn("size unknown, the object is neither available locally nor in the store", "metadata", mg.src.Location(obj), "key", m.Name)
+	}
+	m.SchemaVersion = m.RequiredSchemaVersion()
+	if m.Signature == nil {
+		return nil
+	}
+	signer, err := mg.signer()
+	if err != nil {
+		return err
+	}
+	return signer.Sign(m)
+}
+
+// verifySignature checks that signed metadata m of obj can be re-signed, which requires a signer and
+// a signature by a trusted key for obj
+func (mg *migrator) verifySignature(obj string, m *metadata.ObjectMetaData) error {
+	if mg.signer == nil {
+		return ErrMigrateSigned
+	}
+	if mg.verifier == nil {
+		return ErrSigningNotConfigured
+	}
+	if err := mg.verifier.VerifyKey(*m, mg.prefix.Modify(obj)); err != nil {
+		return fmt.Errorf("%w: %w", ErrSignaturesInvalid, err)
+	}
+	return nil
+}
+
+// fillFromLocal records the size and mode of obj if it matches m
+func (mg *migrator) fillFromLocal(obj string, m *metadata.ObjectMetaData) error {
+	f, err := mg.fsys.Open(obj)
+	if err != nil {
+		// the object has not been retrieved
+		return nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hash, err := metadata.HashFromReader(m.HashAlgorithm(), f)
+	if err != nil {
+		return err
+	}
+	if hash != m.Checksum {
+		slog.Debug("local object does not match its metadata, not using it", "object", obj)
+		return nil
+	}
+	size := info.Size()
+	m.Size = &size
+	m.Mode = metadata.FileMode(info.Mode().Perm())
+	return nil
+}
+
+// fillFromRemote records the size of the stored object. Encrypted and chunked objects are stored
+// in a different form, so the size of the stored object says nothing about the size of the file.
+func (mg *migrator) fillFromRemote(ctx context.Context, m *metadata.ObjectMetaData) {
+	if mg.stat == nil || m.Encryption != nil || m.Chunks != nil {
+		return
+	}
+	info, err := mg.stat.Stat(ctx, m.Name)
+	if err != nil {
+		slog.Debug("could not stat object", "key", m.Name, "error", err)
+		return
+	}
+	m.Size = &info.Size
+}
+
+// migrate upgrades the outdated metadata of every object below roots. If check is true, outdated
+// metadata is only reported.
+func (mg *migrator) migrate(ctx context.Context, check bool, w io.Writer, roots ...string) error {
+	objects, err := mg.src.List(roots...)
+	if err != nil {
+		return err
+	}
+	r := reportFrom(ctx)
+	var result *multierr.Error
+	count := 0
+	for _, obj := range objects {
+		start := time.Now()
+		cfile := mg.src.Location(obj)
+		m, err := mg.src.Get(obj)
+		if err != nil {
+			r.add(newObjectResult(obj, outcomeFailed, nil, start, err))
+			result = multierr.Append(result, err)
+			continue
+		}
+		if !outdated(m) {
+			continue
+		}
+		count++
+		if check {
+			fmt.Fprintf(w, "outdated %s (schema version %d)\n", cfile, m.SchemaVersion)
+			r.add(newObjectResult(obj, outcomeOutdated, m, start, nil))
+			continue
+		}
+		from := m.SchemaVersion
+		if err := mg.upgrade(ctx, obj, m); err != nil {
+			r.add(newObjectResult(obj, outcomeFailed, m, start, err))
+			result = multierr.Append(result, fmt.Errorf("%s: %w", cfile, err))
+			continue
+		}
+		if err := mg.src.Put(obj, m); err != nil {
+			r.add(newObjectResult(obj, outcomeFailed, m, start, err))
+			result = multierr.Append(result, err)
+			continue
+		}
+		fmt.Fprintf(w, "migrated %s (schema version %d -> %d)\n", cfile, from, m.SchemaVersion)
+		r.add(newObjectResult(obj, outcomeMigrated, m, start, nil))
+	}
+	if check && count > 0 {
+		result = multierr.Append(result, fmt.Errorf("%w: %d of %d", ErrCfilesOutdated, count, len(objects)))
+	}
+	return result.ErrorOrNil()
+}
+
+func migrateCmd() *cobra.Command {
+	migrateCmd := &cobra.Command{
+		Use:   "migrate [path...]",
+		Short: fmt.Sprintf("Upgrade cfiles to schema version %d", metadata.BaseSchemaVersion),
+		Long: fmt.Sprintf(`Upgrade every cfile below the given paths, or the whole repo if none are given, to schema version %d,
+or %d for archived directories. Objects are not re-uploaded. Sizes are taken from local objects that
+match their cfile or from the store.`, metadata.BaseSchemaVersion, metadata.SchemaVersion),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: migrateFn,
+	}
+	migrateCmd.Flags().Bool("check", false, "Only report outdated cfiles and fail if there are any")
+	return migrateCmd
+}
+
+func migrateFn(cmd *cobra.Command, paths []string) error {
+	check, err := cmd.Flags().GetBool("check")
+	if err != nil {
+		return err
+	}
+	fsys := afero.NewOsFs()
+	mg := &migrator{
+		fsys:   fsys,
+		src:    newMetadataSource(config.Cfg, fsys),
+		prefix: cavoriteObjLib.AddPrefixToKey{Prefix: config.Cfg.Options.ObjectKeyPrefix},
+	}
+	if !check {
+		// the backend is only needed to stat objects, so decorators such as encryption are skipped
+		s, err := newBackendStore(cmd.Context(), config.Cfg, fsys)
+		if err != nil {
+			slog.Warn("sizes of objects that are not available locally will not be filled in", "error", err)
+		} else {
+			defer s.Close()
+			if stat, ok := s.(stores.StatStore); ok {
+				mg.stat = stat
+			}
+		}
+		if config.Cfg.Signing != nil && config.Cfg.Signing.KeyFile != "" {
+			keyFile := config.Cfg.Signing.KeyFile
+			mg.signer = sync.OnceValues(func() (*signing.Signer, error) {
+				return signing.LoadSigner(fsys, keyFile)
+			})
+		}
+		if config.Cfg.Signing != nil && len(config.Cfg.Signing.TrustedKeys) > 0 {
+			if mg.verifier, err = signing.NewVerifier(config.Cfg.Signing.TrustedKeys); err != nil {
+				return err
+			}
+		}
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	err = mg.migrate(cmd.Context(), check, cmd.OutOrStdout(), paths...)
+	return multierr.Append(err, mg.src.Close()).ErrorOrNil()
+}
@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/signing"
+	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/testutils"
+)
+
+type fakeStatStore map[string]int64
+
+func (s fakeStatStore) Stat(_ context.Context, key string) (stores.ObjectInfo, error) {
+	size, ok := s[key]
+	if !ok {
+		return stores.ObjectInfo{}, stores.ErrObjectNotExist
+	}
+	return stores.ObjectInfo{Key: key, Size: size}, nil
+}
+
+const legacyCfile = `{
+ "name": "%s",
+ "checksum": "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+ "date_modified": "2014-11-12T11:45:26.371Z"
+}`
+
+func migrateTestFs(t *testing.T) afero.Fs {
+	t.Helper()
+	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		// retrieved, so size and mode come from the local object
+		"tools/retrieved":       {Content: []byte("stuff"), ModTime: &mTime, Mode: 0755},
+		"tools/retrieved.cfile": {Content: []byte(fmt.Sprintf(legacyCfile, "tools/retrieved"))},
+		// not retrieved, so size comes from the store
+		"tools/remote.cfile": {Content: []byte(fmt.Sprintf(legacyCfile, "tools/remote"))},
+		// local object was modified, so it is not used
+		"tools/modified":       {Content: []byte("other stuff")},
+		"tools/modified.cfile": {Content: []byte(fmt.Sprintf(legacyCfile, "tools/modified"))},
+	})
+	require.NoError(t, err)
+	return *fsys
+}
+
+func TestMigrate(t *testing.T) {
+	fsys := migrateTestFs(t)
+	mg := &migrator{fsys: fsys, src: metadata.NewSidecarSource(fsys, "cfile"), stat: fakeStatStore{"tools/remote": 5}}
+
+	var out bytes.Buffer
+	err := mg.migrate(context.Background(), true, &out, "tools")
+	assert.ErrorIs(t, err, ErrCfilesOutdated)
+	assert.Contains(t, out.String(), "outdated tools/remote.cfile (schema version 0)")
+	m, err := metadata.ParseCfile(fsys, "tools/remote.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.SchemaVersion, "--check must not modify cfiles")
+
+	out.Reset()
+	require.NoError(t, mg.migrate(context.Background(), false, &out, "tools"))
+	assert.Contains(t, out.String(), "migrated tools/retrieved.cfile (schema version 0 -> 2)")
+
+	tests := []struct {
+		cfile string
+		size  *int64
+		mode  metadata.FileMode
+	}{
+		{cfile: "tools/retrieved.cfile", size: int64Ptr(5), mode: 0755},
+		{cfile: "tools/remote.cfile", size: int64Ptr(5)},
+		{cfile: "tools/modified.cfile"},
+	}
+	for _, test := range tests {
+		m, err := metadata.ParseCfile(fsys, test.cfile)
+		require.NoError(t, err)
+		assert.Equal(t, metadata.BaseSchemaVersion, m.SchemaVersion, test.cfile)
+		assert.Equal(t, metadata.AlgorithmSHA256, m.Algorithm, test.cfile)
+		assert.Equal(t, test.size, m.Size, test.cfile)
+		assert.Equal(t, test.mode, m.Mode, test.cfile)
+	}
+
+	out.Reset()
+	assert.NoError(t, mg.migrate(context.Background(), true, &out, "tools"))
+	assert.Empty(t, out.String())
+}
+
+func TestMigrateArchive(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	size := int64(5)
+	// written before archives required schema version 3
+	m := &metadata.ObjectMetaData{
+		SchemaVersion: 2,
+		Name:          "sdk",
+		Algorithm:     metadata.AlgorithmSHA256,
+		Checksum:      "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+		Size:          &size,
+		Archive:       &metadata.ArchiveMetadata{Format: "tar", Entries: 1},
+	}
+	require.NoError(t, metadata.WriteCfile(fsys, "sdk.cfile", m))
+	mg := &migrator{fsys: fsys, src: metadata.NewSidecarSource(fsys, "cfile")}
+
+	var out bytes.Buffer
+	require.NoError(t, mg.migrate(context.Background(), false, &out, "."))
+	assert.Contains(t, out.String(), "migrated sdk.cfile (schema version 2 -> 3)")
+	m, err := metadata.ParseCfile(fsys, "sdk.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, metadata.SchemaVersion, m.SchemaVersion)
+}
+
+func TestMigrateSignedWithoutSigner(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	m := metadata.ObjectMetaData{
+		Name:      "a",
+		Checksum:  "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+		Signature: &metadata.SignatureMetadata{Key: "SHA256:x", Format: "ssh-ed25519", Blob: "eA=="},
+	}
+	require.NoError(t, metadata.WriteCfile(fsys, "a.cfile", &m))
+	mg := &migrator{fsys: fsys, src: metadata.NewSidecarSource(fsys, "cfile")}
+	err := mg.migrate(context.Background(), false, &bytes.Buffer{}, ".")
+	assert.ErrorIs(t, err, ErrMigrateSigned)
+	b, err := afero.ReadFile(fsys, "a.cfile")
+	require.NoError(t, err)
+	assert.NotContains(t, string(b), "schema_version")
+}
+
+func TestMigrateSignedVerifiesSignature(t *testing.T) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	sshSigner, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	signer := signing.NewSigner(sshSigner)
+	verifier, err := signing.NewVerifier([]string{string(ssh.MarshalAuthorizedKey(sshSigner.PublicKey()))})
+	require.NoError(t, err)
+
+	fsys := afero.NewMemMapFs()
+	good := metadata.ObjectMetaData{Name: "a", Checksum: "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0"}
+	require.NoError(t, signer.Sign(&good))
+	require.NoError(t, metadata.WriteCfile(fsys, "a.cfile", &good))
+	// the checksum was replaced after signing
+	tampered := metadata.ObjectMetaData{Name: "b", Checksum: "1"}
+	require.NoError(t, signer.Sign(&tampered))
+	tampered.Checksum = "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0"
+	require.NoError(t, metadata.WriteCfile(fsys, "b.cfile", &tampered))
+	// the signed cfile of a copied next to another object
+	require.NoError(t, metadata.WriteCfile(fsys, "c.cfile", &good))
+
+	mg := &migrator{
+		fsys:     fsys,
+		src:      metadata.NewSidecarSource(fsys, "cfile"),
+		signer:   func() (*signing.Signer, error) { return signer, nil },
+		verifier: verifier,
+	}
+	var out bytes.Buffer
+	err = mg.migrate(context.Background(), false, &out, ".")
+	assert.ErrorIs(t, err, ErrSignaturesInvalid)
+	assert.Contains(t, out.String(), "migrated a.cfile")
+	assert.NotContains(t, out.String(), "b.cfile")
+	assert.ErrorIs(t, err, signing.ErrWrongObject)
+	assert.NotContains(t, out.String(), "c.cfile")
+
+	m, err := metadata.ParseCfile(fsys, "a.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, metadata.BaseSchemaVersion, m.SchemaVersion)
+	assert.NoError(t, verifier.Verify(*m))
+	m, err = metadata.ParseCfile(fsys, "b.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.SchemaVersion, "tampered cfiles must not be migrated")
+	assert.Error(t, verifier.Verify(*m))
+
+	mg.verifier = nil
+	assert.ErrorIs(t, mg.migrate(context.Background(), false, &out, "."), ErrSigningNotConfigured)
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
@@ -0,0 +1,276 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/encryption"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/program"
+	"github.com/discentem/cavorite/signing"
+	"github.com/discentem/cavorite/stores"
+)
+
+// Exit codes are part of the interface of cavorite, scripts may rely on them. Errors matching
+// several classes get the code of the first class below that they match.
+const (
+	ExitOK = 0
+	// ExitFailure is returned for errors that do not belong to any class below
+	ExitFailure = 1
+	// ExitUsage is returned for invalid flags and arguments
+	ExitUsage = 2
+	// ExitConfig is returned if .cavorite/config is missing or invalid, or if it asks for a
+	// combination of features that is not supported
+	ExitConfig = 3
+	// ExitIntegrity is returned if an object or a cfile does not match its checksum or signature
+	ExitIntegrity = 4
+	// ExitStore is returned if the store failed, refused a request or does not have an object
+	ExitStore = 5
+	// ExitCheck is returned if a check such as status --strict or the pre-commit hook found problems
+	ExitCheck = 6
+	// ExitInterrupted is returned if cavorite was interrupted
+	ExitInterrupted = 130
+)
+
+var ErrUsage = errors.New("invalid usage")
+
+// exitClasses maps exit codes to the errors they are returned for, in the order they are checked
+var exitClasses = []struct {
+	code int
+	errs []error
+}{
+	{ExitInterrupted, []error{context.Canceled}},
+	{ExitUsage, []error{ErrUsage, ErrUnknownOutput, ErrTLSFlags, ErrNoKeyPrefix, ErrUnknownMetadataMode, ErrUnexpectedHookArgs}},
+	{ExitConfig, []error{
+		config.ErrViperReadConfig, config.ErrConfigNotExist, config.ErrConfigDirNotExist, config.ErrUnsupportedStore,
+		config.ErrValidate, ErrEncryptionWithPlugin, ErrChunkingWithPlugin, ErrEncryptionWithChunking,
+		ErrSigningNotConfigured, ErrNothingTracked, ErrStagingWithPlugin, signing.ErrNoTrustedKeys, encryption.ErrNoKeySource,
+		encryption.ErrKeyEnvEmpty, encryption.ErrKeyMaterial, encryption.ErrKeyCommand, metadata.ErrUnknownAlgorithm,
+	}},
+	{ExitIntegrity, []error{
+		metadata.ErrRetrieveFailureHashMismatch, ErrFsckFailed, ErrSignaturesInvalid, signing.ErrInvalidSignature,
+		signing.ErrUntrustedKey, signing.ErrUnsigned, signing.ErrWrongObject, encryption.ErrDecrypt, encryption.ErrWrongKey,
+		encryption.ErrUnknownKeyID,
+	}},
+	{ExitStore, []error{
+		ErrUpload, stores.ErrObjectNotExist, stores.ErrUnauthorized, ErrListNotSupported, ErrDeleteNotSupported,
+		ErrStatNotSupported,
+	}},
+	{ExitCheck, []error{ErrStatusNotClean, ErrStagedRejected, ErrCfilesOutdated}},
+}
+
+// ExitCode returns the exit code cavorite exits with after err
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for _, class := range exitClasses {
+		for _, target := range class.errs {
+			if errors.Is(err, target) {
+				return class.code
+			}
+		}
+	}
+	return ExitFailure
+}
+
+// usageErrors makes the errors of argument validation of cmd and its subcommands wrap ErrUsage
+func usageErrors(cmd *cobra.Command) {
+	if args := cmd.Args; args != nil {
+		cmd.Args = func(cmd *cobra.Command, a []string) error {
+			if err := args(cmd, a); err != nil {
+				return fmt.Errorf("%w: %w", ErrUsage, err)
+			}
+			return nil
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		usageErrors(sub)
+	}
+}
+
+// objectOutcome is what happened to an object
+type objectOutcome string
+
+const (
+	outcomeUploaded  objectOutcome = "uploaded"
+	outcomeRetrieved objectOutcome = "retrieved"
+	// outcomeSkipped means nothing had to be done, for example because the object was present
+	outcomeSkipped objectOutcome = "skipped"
+	outcomeFailed  objectOutcome = "failed"
+	// outcomeOK means a check such as fsck or verify-signatures found no problem
+	outcomeOK       objectOutcome = "ok"
+	outcomeOutdated objectOutcome = "outdated"
+	outcomeMigrated objectOutcome = "migrated"
+	outcomeImported objectOutcome = "imported"
+	outcomeExported objectOutcome = "exported"
+	// outcomeMoved means the metadata of the object was moved by convert
+	outcomeMoved   objectOutcome = "moved"
+	outcomeRemoved objectOutcome = "removed"
+	// outcomeUnreferenced means gc --dry-run would delete the object
+	outcomeUnreferenced objectOutcome = "unreferenced"
+	outcomeDeleted      objectOutcome = "deleted"
+)
+
+// objectResult is what happened to a single object
+type objectResult struct {
+	// Path is empty for objects that only exist in the store, such as those collected by gc
+	Path       string        `json:"path,omitempty"`
+	Key        string        `json:"key,omitempty"`
+	Status     objectOutcome `json:"status"`
+	Algorithm  string        `json:"algorithm,omitempty"`
+	Checksum   string        `json:"checksum,omitempty"`
+	Bytes      int64         `json:"bytes"`
+	DurationMS int64         `json:"duration_ms"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// newObjectResult returns the result of path, which took since start, described by m if it is
+// known, and failed if err is not nil
+func newObjectResult(path string, outcome objectOutcome, m *metadata.ObjectMetaData, start time.Time, err error) objectResult {
+	r := objectResult{
+		Path:       path,
+		Status:     outcome,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if m != nil {
+		r.Key = m.Name
+		r.Algorithm = m.HashAlgorithm()
+		r.Checksum = m.Checksum
+		if m.Size != nil {
+			r.Bytes = *m.Size
+		}
+	}
+	if err != nil {
+		r.Status = outcomeFailed
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// storedObjectResult returns the result of the stored object described by info
+func storedObjectResult(info stores.ObjectInfo, outcome objectOutcome, start time.Time, err error) objectResult {
+	r := newObjectResult("", outcome, nil, start, err)
+	r.Key = info.Key
+	r.Bytes = info.Size
+	return r
+}
+
+// resultDocument is what a command prints on stdout with --output json
+type resultDocument struct {
+	Command    string         `json:"command"`
+	OK         bool           `json:"ok"`
+	ExitCode   int            `json:"exit_code"`
+	Error      string         `json:"error,omitempty"`
+	DurationMS int64          `json:"duration_ms"`
+	Objects    []objectResult `json:"objects"`
+	// Result is what commands such as status and scan print with --output text
+	Result any `json:"result,omitempty"`
+}
+
+// report collects what happens while a command runs, to be printed as a resultDocument
+type report struct {
+	start time.Time
+	// stdout is where the document is written, logs are moved off stdout while the command runs
+	stdout  io.WriteCloser
+	mu      sync.Mutex
+	objects []objectResult
+	result  any
+}
+
+type reportKey struct{}
+
+// withReport returns a copy of ctx carrying r
+func withReport(ctx context.Context, r *report) context.Context {
+	return context.WithValue(ctx, reportKey{}, r)
+}
+
+// reportFrom returns the report carried by ctx, or nil. A nil report discards everything.
+func reportFrom(ctx context.Context) *report {
+	r, _ := ctx.Value(reportKey{}).(*report)
+	return r
+}
+
+// add records the results of objects
+func (r *report) add(results ...objectResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.objects = append(r.objects, results...)
+}
+
+// setResult records the output of a command that has its own format, such as the states of status
+func (r *report) setResult(result any) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.result = result
+}
+
+// moveLogs points stdout at stderr while the command runs, so that it only holds the document
+func (r *report) moveLogs() error {
+	if r == nil || r.stdout != nil {
+		return nil
+	}
+	stdout, err := protocolStdout()
+	if err != nil {
+		return err
+	}
+	r.stdout = stdout
+	return nil
+}
+
+// write writes the document of cmd, which returned err, to w
+func (r *report) write(w io.Writer, cmd *cobra.Command, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	doc := resultDocument{
+		OK:         err == nil,
+		ExitCode:   ExitCode(err),
+		DurationMS: time.Since(r.start).Milliseconds(),
+		Objects:    r.objects,
+		Result:     r.result,
+	}
+	if cmd != nil {
+		doc.Command = strings.TrimPrefix(strings.TrimPrefix(cmd.CommandPath(), program.Name), " ")
+	}
+	if err != nil {
+		doc.Error = err.Error()
+	}
+	if doc.Objects == nil {
+		doc.Objects = []objectResult{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(doc)
+}
+
+// close writes the document to the stdout the report moved logs off
+func (r *report) close(cmd *cobra.Command, err error) error {
+	out := r.stdout
+	if out == nil {
+		return r.write(os.Stdout, cmd, err)
+	}
+	writeErr := r.write(out, cmd, err)
+	if out == os.Stdout {
+		return writeErr
+	}
+	if closeErr := out.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	return writeErr
+}
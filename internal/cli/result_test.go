@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitOK},
+		{errors.New("boom"), ExitFailure},
+		{fmt.Errorf("%w: requires at least 1 path or --all", ErrUsage), ExitUsage},
+		{config.ErrConfigNotExist, ExitConfig},
+		{fmt.Errorf("tools/tool: %w", metadata.ErrRetrieveFailureHashMismatch), ExitIntegrity},
+		{fmt.Errorf("%w for tools/tool: %w", ErrUpload, errors.New("denied")), ExitStore},
+		{stores.ErrObjectNotExist, ExitStore},
+		{ErrStatusNotClean, ExitCheck},
+		{context.Canceled, ExitInterrupted},
+		// the first class that matches wins
+		{multierr.Append(stores.ErrObjectNotExist, metadata.ErrRetrieveFailureHashMismatch), ExitIntegrity},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.code, ExitCode(tc.err), "%v", tc.err)
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	cmd := rootCmd()
+	cmd.SetArgs([]string{"status", "--strict", "--no-such-flag"})
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	assert.Equal(t, ExitUsage, ExitCode(cmd.Execute()))
+
+	cmd.SetArgs([]string{"serve", "extra"})
+	assert.Equal(t, ExitUsage, ExitCode(cmd.Execute()))
+}
+
+func TestReport(t *testing.T) {
+	var nilReport *report
+	// commands run without a report when they are called directly
+	nilReport.add(objectResult{Path: "tools/tool"})
+	nilReport.setResult("ignored")
+	assert.Nil(t, reportFrom(context.Background()))
+
+	r := &report{start: time.Now()}
+	ctx := withReport(context.Background(), r)
+	size := int64(5)
+	m := &metadata.ObjectMetaData{Name: "tools/tool", Checksum: "abc", Size: &size}
+	reportFrom(ctx).add(
+		newObjectResult("tools/tool", outcomeUploaded, m, time.Now(), nil),
+		newObjectResult("tools/other", outcomeUploaded, nil, time.Now(), errors.New("denied")),
+	)
+	cmd := rootCmd()
+	upload, _, err := cmd.Find([]string{"upload"})
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, r.write(&out, upload, fmt.Errorf("%w for tools/other: denied", ErrUpload)))
+	doc := decodeDocument(t, out.Bytes())
+	assert.Equal(t, resultDocument{
+		Command:  "upload",
+		ExitCode: ExitStore,
+		Error:    "failed to upload for tools/other: denied",
+		Objects: []objectResult{
+			{Path: "tools/tool", Key: "tools/tool", Status: outcomeUploaded, Algorithm: metadata.LegacyAlgorithm, Checksum: "abc", Bytes: 5},
+			{Path: "tools/other", Status: outcomeFailed, Error: "denied"},
+		},
+	}, doc)
+
+	// commands with their own output put it in result and report no objects
+	r = &report{start: time.Now()}
+	r.setResult([]objectStatus{{Path: "tools/tool", State: stateOK}})
+	out.Reset()
+	require.NoError(t, r.write(&out, cmd, nil))
+	assert.JSONEq(t, `[{"path": "tools/tool", "state": "ok"}]`, string(decodeDocument(t, out.Bytes()).Result.(json.RawMessage)))
+	assert.Contains(t, out.String(), `"objects": []`)
+}
+
+// decodeDocument decodes the resultDocument in b, leaving Result undecoded and durations zero
+func decodeDocument(t *testing.T, b []byte) resultDocument {
+	t.Helper()
+	var raw struct {
+		resultDocument
+		Result json.RawMessage `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(b, &raw))
+	doc := raw.resultDocument
+	doc.DurationMS = 0
+	for i := range doc.Objects {
+		doc.Objects[i].DurationMS = 0
+	}
+	if raw.Result != nil {
+		doc.Result = raw.Result
+	}
+	return doc
+}
+
+// fakeDeleteStore records the keys it is asked to delete and fails with err if it is set
+type fakeDeleteStore struct {
+	deleted *[]string
+	err     error
+}
+
+func (s fakeDeleteStore) Delete(_ context.Context, keys ...string) error {
+	if s.err != nil {
+		return s.err
+	}
+	*s.deleted = append(*s.deleted, keys...)
+	return nil
+}
+
+// statuses returns the status of every object of doc by path, or by key if it has no path
+func statuses(doc resultDocument) map[string]objectOutcome {
+	got := make(map[string]objectOutcome)
+	for _, obj := range doc.Objects {
+		name := obj.Path
+		if name == "" {
+			name = obj.Key
+		}
+		got[name] = obj.Status
+	}
+	return got
+}
+
+func TestCommandResults(t *testing.T) {
+	t.Run("init", func(t *testing.T) {
+		defer func(cfg config.Config) { config.Cfg = cfg }(config.Cfg)
+		defer slog.SetDefault(slog.Default())
+		r := &report{start: time.Now()}
+		cmd := rootCmd()
+		cmd.SetArgs([]string{"init", t.TempDir(), "--store_type=s3", "--backend_address=http://127.0.0.1:9000/test"})
+		cmd.SetOut(&bytes.Buffer{})
+		initCmd, err := cmd.ExecuteContextC(withReport(context.Background(), r))
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		require.NoError(t, r.write(&out, initCmd, nil))
+		doc := decodeDocument(t, out.Bytes())
+		assert.Equal(t, "init", doc.Command)
+		var cfg config.Config
+		require.NoError(t, json.Unmarshal(doc.Result.(json.RawMessage), &cfg))
+		assert.Equal(t, stores.StoreTypeS3, cfg.StoreType)
+		assert.Equal(t, "http://127.0.0.1:9000/test", cfg.Options.BackendAddress)
+	})
+
+	t.Run("fsck", func(t *testing.T) {
+		fsys := fsckTestFs(t)
+		fc := &fsckChecker{
+			fsys: fsys,
+			src:  metadata.NewSidecarSource(fsys, "cfile"),
+			stat: fakeStatStore{"tools/ok": 5, "tools/modified": 5, "tools/remote": 5, "tools/truncated": 3},
+		}
+		r := &report{start: time.Now()}
+		err := fc.fsck(withReport(context.Background(), r), &bytes.Buffer{}, ".")
+		assert.ErrorIs(t, err, ErrFsckFailed)
+
+		var out bytes.Buffer
+		require.NoError(t, r.write(&out, nil, err))
+		doc := decodeDocument(t, out.Bytes())
+		assert.Equal(t, map[string]objectOutcome{
+			"tools/gone":      outcomeFailed,
+			"tools/modified":  outcomeFailed,
+			"tools/ok":        outcomeOK,
+			"tools/remote":    outcomeOK,
+			"tools/truncated": outcomeFailed,
+		}, statuses(doc))
+		for _, obj := range doc.Objects {
+			if obj.Path == "tools/modified" {
+				assert.Contains(t, obj.Error, ErrLocalMismatch.Error())
+				assert.Equal(t, stuffSHA256, obj.Checksum)
+			}
+		}
+	})
+
+	t.Run("gc", func(t *testing.T) {
+		garbage := []stores.ObjectInfo{{Key: "repo/a", Size: 3}, {Key: "repo/b", Size: 4}}
+
+		r := &report{start: time.Now()}
+		require.NoError(t, deleteGarbage(withReport(context.Background(), r), nil, garbage, 5, &bytes.Buffer{}))
+		var out bytes.Buffer
+		require.NoError(t, r.write(&out, nil, nil))
+		doc := decodeDocument(t, out.Bytes())
+		assert.Equal(t, []objectResult{
+			{Key: "repo/a", Status: outcomeUnreferenced, Bytes: 3},
+			{Key: "repo/b", Status: outcomeUnreferenced, Bytes: 4},
+		}, doc.Objects)
+		assert.NotContains(t, out.String(), `"path"`)
+
+		var deleted []string
+		r = &report{start: time.Now()}
+		require.NoError(t, deleteGarbage(withReport(context.Background(), r), fakeDeleteStore{deleted: &deleted}, garbage, 5, &bytes.Buffer{}))
+		assert.Equal(t, []string{"repo/a", "repo/b"}, deleted)
+		out.Reset()
+		require.NoError(t, r.write(&out, nil, nil))
+		assert.Equal(t, map[string]objectOutcome{"repo/a": outcomeDeleted, "repo/b": outcomeDeleted}, statuses(decodeDocument(t, out.Bytes())))
+
+		r = &report{start: time.Now()}
+		err := deleteGarbage(withReport(context.Background(), r), fakeDeleteStore{err: stores.ErrUnauthorized}, garbage, 5, &bytes.Buffer{})
+		assert.ErrorIs(t, err, stores.ErrUnauthorized)
+		out.Reset()
+		require.NoError(t, r.write(&out, nil, err))
+		assert.Equal(t, map[string]objectOutcome{"repo/a": outcomeFailed, "repo/b": outcomeFailed}, statuses(decodeDocument(t, out.Bytes())))
+	})
+}
@@ -4,73 +4,112 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/ignore"
 	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/program"
 	"github.com/discentem/cavorite/stores"
-	"github.com/google/logger"
 	multierr "github.com/hashicorp/go-multierror"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
-func shouldRetrieve(fsys afero.Fs, m *metadata.ObjectMetaData, cfile string) (bool, error) {
+// shouldRetrieve reports whether obj is missing or does not match m
+func shouldRetrieve(fsys afero.Fs, m *metadata.ObjectMetaData, obj string) (bool, error) {
 	if m == nil {
 		return true, errors.New("m cannot be nil")
 	}
 	expectedHash := m.Checksum
-	f, err := fsys.Open(strings.TrimSuffix(cfile, filepath.Ext(cfile)))
+	f, err := fsys.Open(obj)
 	if err != nil {
 		return true, err
 	}
-	actualHash, err := metadata.SHA256FromReader(f)
+	defer f.Close()
+	// comparing sizes is much cheaper than hashing and catches most changes
+	if m.Size != nil {
+		info, err := f.Stat()
+		if err != nil {
+			return true, err
+		}
+		if !m.SizeMatches(info.Size()) {
+			slog.Debug("size mismatch", "object", obj, "expected", *m.Size, "got", info.Size())
+			return true, nil
+		}
+	}
+	actualHash, err := metadata.HashFromReader(m.HashAlgorithm(), f)
 	if err != nil {
 		return false, err
 	}
 	if actualHash != expectedHash {
-		logger.Errorf("expected hash %q but got %q", expectedHash, actualHash)
+		slog.Debug("hash mismatch", "object", obj, "expected", expectedHash, "got", actualHash)
 		return true, nil
 	}
 	return false, nil
 }
 
-func Retrieve(ctx context.Context, fsys afero.Fs, s stores.Store, cfiles ...string) error {
+// Retrieve retrieves each object in paths whose metadata in src it does not match. Paths may name
+// the objects or their cfiles.
+func Retrieve(ctx context.Context, fsys afero.Fs, src metadata.Source, s stores.Store, paths ...string) error {
+	opts, err := s.GetOptions()
+	if err != nil {
+		return err
+	}
+	ext := opts.MetadataFileExtension
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
 	var result *multierr.Error
-	cmap := make(metadata.CfileMetadataMap)
-	for _, cfile := range cfiles {
-		m, err := metadata.ParseCfile(fsys, cfile)
+	r := reportFrom(ctx)
+	retrieved := 0
+	for _, path := range paths {
+		start := time.Now()
+		obj := strings.TrimSuffix(path, "."+ext)
+		m, err := src.Get(obj)
 		if err != nil {
+			r.add(newObjectResult(obj, outcomeFailed, nil, start, err))
 			result = multierr.Append(result, err)
 			continue
 		}
-		doRetrieve, err := shouldRetrieve(fsys, m, cfile)
+		if m.Archive != nil {
+			err := fmt.Errorf("%w: %s", ErrArchived, obj)
+			r.add(newObjectResult(obj, outcomeFailed, m, start, err))
+			result = multierr.Append(result, err)
+			continue
+		}
+		doRetrieve, err := shouldRetrieve(fsys, m, obj)
 		if !doRetrieve {
 			if err != nil {
 				result = multierr.Append(result, err)
 			}
 			// we don't need to retrieve because we already have it
+			r.add(newObjectResult(obj, outcomeSkipped, m, start, err))
 			continue
 		}
-		cmap[cfile] = *m
+		// stores derive the path of the object from the cfile it would have in sidecar mode
+		cfile := fmt.Sprintf("%s.%s", obj, ext)
+		err = s.Retrieve(ctx, metadata.CfileMetadataMap{cfile: *m}, cfile)
+		r.add(newObjectResult(obj, outcomeRetrieved, m, start, err))
+		result = multierr.Append(result, err)
+		retrieved++
 	}
-	if len(cmap) == 0 {
-		logger.Infof("retrieval not needed, all requested files are present from %v", cfiles)
-		return nil
+	if retrieved == 0 {
+		slog.Info("retrieval not needed, all requested files are present", "paths", paths)
 	}
-	retrieveErr := s.Retrieve(ctx, cmap, cfiles...)
-	return multierr.Append(result, retrieveErr).ErrorOrNil()
-
+	return result.ErrorOrNil()
 }
 
 func retrieveCmd() *cobra.Command {
 	retrieveCmd := &cobra.Command{
-		Use:   "retrieve",
+		Use:   "retrieve [path...]",
 		Short: fmt.Sprintf("retrieve a file from %s", program.Name),
-		Long:  fmt.Sprintf("retrieve a file from %s", program.Name),
-		Args:  cobra.MinimumNArgs(1),
+		Long: fmt.Sprintf(`retrieve a file from %s. Paths may name objects, their cfiles or directories, in which case
+every object with a cfile below them is retrieved. --include and --exclude take patterns in the syntax
+of %s files and can be given more than once.`, program.Name, ignore.FileName),
 		// PersistentPreRunE
 		// Loads the config with OsFs
 		/*
@@ -87,10 +126,56 @@ func retrieveCmd() *cobra.Command {
 		},
 		RunE: retrieveFn,
 	}
+	retrieveCmd.Flags().Bool("all", false, "Retrieve every object in the repo")
+	retrieveCmd.Flags().StringArray("include", nil, "Only retrieve objects matching this pattern")
+	retrieveCmd.Flags().StringArray("exclude", nil, "Do not retrieve objects matching this pattern")
 
 	return retrieveCmd
 }
 
+// selectObjects returns the objects named by paths. Paths may name objects, their cfiles or
+// directories, which select every object with metadata below them. If include has patterns,
+// only objects it matches are returned, and objects matched by exclude are never returned.
+func selectObjects(fsys afero.Fs, src metadata.Source, ext string, include, exclude *ignore.Patterns, paths ...string) ([]string, error) {
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	var candidates []string
+	for _, path := range paths {
+		obj := strings.TrimSuffix(filepath.Clean(path), "."+ext)
+		// archived directories are objects too
+		if _, err := src.Get(obj); err == nil {
+			candidates = append(candidates, obj)
+			continue
+		}
+		if info, err := fsys.Stat(obj); err == nil && info.IsDir() {
+			objects, err := src.List(obj)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, objects...)
+			continue
+		}
+		// Retrieve reports that there is no metadata
+		candidates = append(candidates, obj)
+	}
+	seen := make(map[string]bool)
+	var selected []string
+	for _, obj := range candidates {
+		switch {
+		case seen[obj]:
+		case include != nil && include.Len() > 0 && !include.Match(obj, false):
+			slog.Debug("skipping object, it is not included", "object", obj)
+		case exclude != nil && exclude.Match(obj, false):
+			slog.Debug("skipping object, it is excluded", "object", obj)
+		default:
+			selected = append(selected, obj)
+		}
+		seen[obj] = true
+	}
+	return selected, nil
+}
+
 // retrieveFn is the execution runtime for the retrieveCmd functionality
 // in Cobra, this is the RunE phase
 /*
@@ -103,6 +188,26 @@ func retrieveCmd() *cobra.Command {
 	// All functions get the same args, the arguments after the command name.
 */
 func retrieveFn(cmd *cobra.Command, objects []string) error {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+	switch {
+	case all && len(objects) > 0:
+		return fmt.Errorf("%w: --all cannot be combined with paths", ErrUsage)
+	case all:
+		objects = []string{"."}
+	case len(objects) == 0:
+		return fmt.Errorf("%w: requires at least 1 path or --all", ErrUsage)
+	}
+	include, err := patternsFlag(cmd, "include")
+	if err != nil {
+		return err
+	}
+	exclude, err := patternsFlag(cmd, "exclude")
+	if err != nil {
+		return err
+	}
 	fsys := afero.NewOsFs()
 
 	s, err := initStoreFromConfig(
@@ -134,7 +239,43 @@ func retrieveFn(cmd *cobra.Command, objects []string) error {
 		return err
 	}
 
-	logger.Infof("Downloading files from: %s", opts.BackendAddress)
-	logger.Infof("Downloading file: %s", objects)
-	return Retrieve(cmd.Context(), fsys, s, objects...)
+	src := newMetadataSource(config.Cfg, fsys)
+	defer src.Close()
+	objects, err = selectObjects(fsys, src, opts.MetadataFileExtension, include, exclude, objects...)
+	if err != nil {
+		return fmt.Errorf("retrieve error: %w", err)
+	}
+	return retrieveObjects(cmd.Context(), fsys, src, s, objects...)
+}
+
+// retrieveObjects retrieves objects, which may name archived directories, with s
+func retrieveObjects(ctx context.Context, fsys afero.Fs, src metadata.Source, s stores.Store, objects ...string) error {
+	opts, err := s.GetOptions()
+	if err != nil {
+		return err
+	}
+	slog.Info("downloading objects", "address", opts.BackendAddress, "objects", objects)
+	objects, dirs := splitArchives(src, opts.MetadataFileExtension, objects...)
+	var result *multierr.Error
+	if len(objects) > 0 {
+		result = multierr.Append(result, Retrieve(ctx, fsys, src, s, objects...))
+	}
+	if len(dirs) > 0 {
+		a := &archiver{fsys: fsys, src: src, newStore: stagedStoreFunc(ctx, config.Cfg)}
+		result = multierr.Append(result, a.retrieve(ctx, opts.MetadataFileExtension, dirs...))
+	}
+	return result.ErrorOrNil()
+}
+
+// patternsFlag parses the patterns given with the string array flag name
+func patternsFlag(cmd *cobra.Command, name string) (*ignore.Patterns, error) {
+	lines, err := cmd.Flags().GetStringArray(name)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := ignore.ParsePatterns(lines...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: --%s: %w", ErrUsage, name, err)
+	}
+	return ps, nil
 }
@@ -5,30 +5,23 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/discentem/cavorite/ignore"
 	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/stores"
 	"github.com/discentem/cavorite/testutils"
 	"github.com/gonuts/go-shellquote"
-	"github.com/google/logger"
 	multierr "github.com/hashicorp/go-multierror"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-// bazel test //internal/cli_test fails due to TestRetrieveAlreadyHaveAllFilesLocally unless we initialize the logger here
-var (
-	w = bytes.NewBufferString("")
-)
-
-func init() {
-	logger.Init("test", true, false, w)
-}
 func TestRetrieveCmd(t *testing.T) {
 	expectedRetrieveCmdArgs := "retrieve ./test_file_one ./test_file_two"
 
@@ -42,7 +35,7 @@ func TestRetrieveCmd(t *testing.T) {
 	subCmd, subArgs, err := retrieveCmd.Traverse(args)
 	require.NoError(t, err)
 	assert.NotNil(t, subCmd)
-	assert.Equal(t, subCmd.UseLine(), "retrieve")
+	assert.Equal(t, subCmd.UseLine(), "retrieve [path...] [flags]")
 
 	// Test the the subArgs equal the expected expectedRetrieveCmdArgs and flags
 	assert.NoError(t, subCmd.ParseFlags(subArgs))
@@ -95,7 +88,7 @@ func (s simpleStoreForRetrieve) Retrieve(ctx context.Context, mmap metadata.Cfil
 			result = multierr.Append(result, err)
 			continue
 		}
-		matches, err := metadata.HashFromCfileMatches(s.sourceFsys, c, m.Checksum)
+		matches, err := metadata.HashFromCfileMatches(s.sourceFsys, c, m.HashAlgorithm(), m.Checksum)
 		if err != nil {
 			result = multierr.Append(result, err)
 			continue
@@ -155,6 +148,7 @@ func TestRetrieve(t *testing.T) {
 	err = Retrieve(
 		context.Background(),
 		*sourceFsys,
+		metadata.NewSidecarSource(*sourceFsys, "cfile"),
 		simpleStoreForRetrieve{
 			sourceFsys: *sourceFsys,
 			bucketFsys: *bucket,
@@ -172,6 +166,9 @@ func TestRetrieve(t *testing.T) {
 }
 
 func TestRetrieveAlreadyHaveAllFilesLocally(t *testing.T) {
+	var w bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&w, nil)))
 	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
 	sourceFsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
 		"someFile.cfile": {
@@ -199,6 +196,7 @@ func TestRetrieveAlreadyHaveAllFilesLocally(t *testing.T) {
 	err = Retrieve(
 		context.Background(),
 		*sourceFsys,
+		metadata.NewSidecarSource(*sourceFsys, "cfile"),
 		simpleStoreForRetrieve{
 			sourceFsys: *sourceFsys,
 			bucketFsys: *bucket,
@@ -209,14 +207,57 @@ func TestRetrieveAlreadyHaveAllFilesLocally(t *testing.T) {
 		"someFile.cfile",
 	)
 	require.NoError(t, err)
-	require.True(t, strings.Contains(w.String(), "retrieval not needed, all requested files are present from"))
+	require.True(t, strings.Contains(w.String(), "retrieval not needed, all requested files are present"))
+}
+func TestRetrieveFromManifest(t *testing.T) {
+	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
+	sourceFsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		metadata.DefaultManifest: {
+			Content: []byte(`{
+ "tools/someFile": {
+  "name": "repo/tools/someFile",
+  "checksum": "4df3c3f68fcc83b27e9d42c90431a72499f17875c81a599b566c9889b9696703",
+  "date_modified": "2014-11-12T11:45:26.371Z"
+ }
+}`),
+		},
+	})
+	require.NoError(t, err)
+	bucket, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"repo/tools/someFile": {
+			Content: []byte(`bla`),
+			ModTime: &mTime,
+		},
+	})
+	require.NoError(t, err)
+	store := simpleStoreForRetrieve{
+		sourceFsys: *sourceFsys,
+		bucketFsys: *bucket,
+		options: stores.Options{
+			MetadataFileExtension: "cfile",
+		},
+	}
+	src := metadata.NewManifestSource(*sourceFsys, metadata.DefaultManifest)
+
+	// objects can be named by their path or the cfile they would have
+	for _, path := range []string{"tools/someFile", "tools/someFile.cfile"} {
+		require.NoError(t, (*sourceFsys).RemoveAll("tools/someFile"))
+		require.NoError(t, Retrieve(context.Background(), *sourceFsys, src, store, path))
+		b, err := afero.ReadFile(*sourceFsys, "tools/someFile")
+		require.NoError(t, err)
+		assert.Equal(t, "bla", string(b))
+	}
+
+	err = Retrieve(context.Background(), *sourceFsys, src, store, "tools/otherFile")
+	assert.ErrorIs(t, err, metadata.ErrNoMetadata)
 }
+
 func TestShouldRetrieve(t *testing.T) {
 	tests := []struct {
 		name           string
 		fsys           afero.Fs
 		m              *metadata.ObjectMetaData
-		cfile          string
+		obj            string
 		shouldRetrieve bool
 		expectedError  func(e error) bool
 	}{
@@ -224,7 +265,7 @@ func TestShouldRetrieve(t *testing.T) {
 			name:           "metadata.ObjectMetadata is nil",
 			fsys:           nil,
 			m:              nil,
-			cfile:          "",
+			obj:            "",
 			shouldRetrieve: true,
 			expectedError: func(err error) bool {
 				return assert.Error(t, err)
@@ -236,7 +277,7 @@ func TestShouldRetrieve(t *testing.T) {
 			m: &metadata.ObjectMetaData{
 				Checksum: "blah",
 			},
-			cfile:          "blah",
+			obj:            "blah",
 			shouldRetrieve: true,
 			expectedError: func(err error) bool {
 				return strings.Contains(err.Error(), "file does not exist")
@@ -254,7 +295,7 @@ func TestShouldRetrieve(t *testing.T) {
 			m: &metadata.ObjectMetaData{
 				Checksum: "blah",
 			},
-			cfile:          "blah.cfile",
+			obj:            "blah",
 			shouldRetrieve: true,
 			expectedError: func(err error) bool {
 				return err == nil
@@ -271,16 +312,35 @@ func TestShouldRetrieve(t *testing.T) {
 			m: &metadata.ObjectMetaData{
 				Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
 			},
-			cfile:          "blah.cfile",
+			obj:            "blah",
 			shouldRetrieve: false,
 			expectedError: func(err error) bool {
 				return err == nil
 			},
 		},
+		{
+			// the checksum matches the empty local file, so only the size check can trigger a retrieve
+			name: "object found locally, wrong size, should retrieve",
+			fsys: func(t *testing.T) afero.Fs {
+				memfs := afero.NewMemMapFs()
+				_, err := memfs.Create("blah")
+				assert.NoError(t, err)
+				return memfs
+			}(t),
+			m: &metadata.ObjectMetaData{
+				Checksum: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				Size:     func() *int64 { size := int64(3); return &size }(),
+			},
+			obj:            "blah",
+			shouldRetrieve: true,
+			expectedError: func(err error) bool {
+				return err == nil
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			actual, err := shouldRetrieve(test.fsys, test.m, test.cfile)
+			actual, err := shouldRetrieve(test.fsys, test.m, test.obj)
 			expectedErr := test.expectedError(err)
 			assert.Equal(t, true, expectedErr)
 			assert.Equal(t, test.shouldRetrieve, actual)
@@ -288,3 +348,32 @@ func TestShouldRetrieve(t *testing.T) {
 
 	}
 }
+
+func TestSelectObjects(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"assets/logo.png":              {Content: []byte("stuff")},
+		"assets/logo.png.cfile":        {Content: []byte(fmt.Sprintf(stuffCfile, "assets/logo.png", "sha256", stuffSHA256))},
+		"assets/video/intro.mp4.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "assets/video/intro.mp4", "sha256", stuffSHA256))},
+		"tools/tool.cfile":             {Content: []byte(fmt.Sprintf(stuffCfile, "tools/tool", "sha256", stuffSHA256))},
+		"tools/source.go":              {Content: []byte("package tools\n")},
+	})
+	require.NoError(t, err)
+	src := metadata.NewSidecarSource(*fsys, "cfile")
+
+	objects, err := selectObjects(*fsys, src, "cfile", nil, nil, ".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"assets/logo.png", "assets/video/intro.mp4", "tools/tool"}, objects)
+
+	// cfiles, objects and directories may overlap
+	objects, err = selectObjects(*fsys, src, "cfile", nil, nil, "tools/tool.cfile", "tools", "./tools/tool", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tools/tool", "missing"}, objects)
+
+	include, err := ignore.ParsePatterns("assets/")
+	require.NoError(t, err)
+	exclude, err := ignore.ParsePatterns("*.mp4")
+	require.NoError(t, err)
+	objects, err = selectObjects(*fsys, src, "cfile", include, exclude, ".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"assets/logo.png"}, objects)
+}
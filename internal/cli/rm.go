@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/program"
+)
+
+// removeObjects deletes the metadata of objects from src and removes them from the block of ignored
+// objects. Unless cached is set, the objects themselves are deleted from fsys as well. Nothing is
+// removed unless every object has metadata.
+func removeObjects(fsys afero.Fs, src metadata.Source, cached bool, objects ...string) error {
+	for i, obj := range objects {
+		objects[i] = filepath.Clean(obj)
+		if _, err := src.Get(objects[i]); err != nil {
+			return err
+		}
+	}
+	for _, obj := range objects {
+		if err := src.Delete(obj); err != nil {
+			return err
+		}
+		if cached {
+			continue
+		}
+		if err := fsys.RemoveAll(obj); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return unignoreObjects(fsys, objects...)
+}
+
+func rmCmd() *cobra.Command {
+	rmCmd := &cobra.Command{
+		Use:   "rm <path...>",
+		Short: fmt.Sprintf("Stop storing objects with %s", program.Name),
+		Long: fmt.Sprintf(`Delete the metadata of objects and the objects themselves, and remove them from the block of
+objects in %s. With --cached the objects are kept, so that they can be committed to git instead.
+Objects are not deleted from the store.`, gitignoreFile),
+		Args: cobra.MinimumNArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: rmFn,
+	}
+	rmCmd.Flags().Bool("cached", false, "Keep the objects, only delete their metadata")
+	return rmCmd
+}
+
+func rmFn(cmd *cobra.Command, objects []string) (err error) {
+	cached, err := cmd.Flags().GetBool("cached")
+	if err != nil {
+		return err
+	}
+	fsys := afero.NewOsFs()
+	src := newMetadataSource(config.Cfg, fsys)
+	defer func() { err = multierr.Append(err, src.Close()).ErrorOrNil() }()
+	start := time.Now()
+	if err := removeObjects(fsys, src, cached, objects...); err != nil {
+		return err
+	}
+	r := reportFrom(cmd.Context())
+	for _, obj := range objects {
+		fmt.Fprintf(cmd.OutOrStdout(), "removed %s\n", obj)
+		r.add(newObjectResult(obj, outcomeRemoved, nil, start, nil))
+	}
+	return nil
+}
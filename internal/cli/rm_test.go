@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/testutils"
+)
+
+func TestRemoveObjects(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"tools/a":       {Content: []byte("stuff")},
+		"tools/a.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/a", "sha256", stuffSHA256))},
+		"tools/b":       {Content: []byte("stuff")},
+		"tools/b.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/b", "sha256", stuffSHA256))},
+		"tools/c":       {Content: []byte("plain")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ignoreObjects(*fsys, "tools/a", "tools/b"))
+	src := metadata.NewSidecarSource(*fsys, "cfile")
+
+	// nothing is removed if any object has no metadata
+	assert.ErrorIs(t, removeObjects(*fsys, src, false, "tools/a", "tools/c"), metadata.ErrNoMetadata)
+	_, err = (*fsys).Stat("tools/a.cfile")
+	require.NoError(t, err)
+
+	require.NoError(t, removeObjects(*fsys, src, false, "tools/a"))
+	require.NoError(t, removeObjects(*fsys, src, true, "./tools/b"))
+	for path, exists := range map[string]bool{"tools/a": false, "tools/a.cfile": false, "tools/b": true, "tools/b.cfile": false} {
+		_, err := (*fsys).Stat(path)
+		assert.Equal(t, exists, err == nil, path)
+	}
+	_, err = (*fsys).Stat(gitignoreFile)
+	require.NoError(t, err)
+	b, err := afero.ReadFile(*fsys, gitignoreFile)
+	require.NoError(t, err)
+	assert.Empty(t, string(b))
+}
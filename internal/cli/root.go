@@ -3,11 +3,13 @@ package cli
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/google/logger"
+	multierr "github.com/hashicorp/go-multierror"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/discentem/cavorite/logging"
 	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/program"
 	"github.com/discentem/cavorite/stores"
@@ -17,16 +19,30 @@ var (
 	// These vars are available to every sub command
 	debug bool
 	VV    bool
+	// logLevel and logFormat configure the logger, see setLoggerOpts
+	logLevel  string
+	logFormat string
+	// outputFormat is outputText or outputJSON, with outputJSON commands print a resultDocument
+	outputFormat string
 
 	// TODO (@radsec) Update this to be dynamic with GH action on new release and tagging....
 	version string = "development"
 )
 
+// ExecuteWithContext runs the command named by the arguments of the process. With --output json it
+// prints a resultDocument on stdout once the command returned, whether it failed or not.
 func ExecuteWithContext(ctx context.Context) error {
-	return rootCmd().ExecuteContext(ctx)
+	r := &report{start: time.Now()}
+	cmd, err := rootCmd().ExecuteContextC(withReport(ctx, r))
+	if outputFormat != outputJSON {
+		return err
+	}
+	return multierr.Append(err, r.close(cmd, err)).ErrorOrNil()
 }
 
 func rootCmd() *cobra.Command {
+	// the hooks of the root command run before those of subcommands instead of being replaced
+	cobra.EnableTraverseRunHooks = true
 	rootCmd := &cobra.Command{
 		Use:   fmt.Sprintf(program.Name),
 		Short: "A source control friendly binary storage system",
@@ -35,15 +51,24 @@ func rootCmd() *cobra.Command {
 		// Set the global logger opts
 		/*
 			// The *Run functions are executed in the following order:
-			//   * PersistentPreRun() [X]
-			//   * PreRun()
-			//   * Run()
-			//   * PostRun()
-			//   * PersistentPostRun()
+			//   * PersistentPreRunE() [X]
+			//   * PreRunE()
+			//   * RunE()
+			//   * PostRunE()
+			//   * PersistentPostRunE()
 			// All functions get the same args, the arguments after the command name.
 		*/
-		PersistentPreRun: func(_ *cobra.Command, _ []string) {
-			setLoggerOpts()
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			if err := setLoggerOpts(); err != nil {
+				return fmt.Errorf("%w: %w", ErrUsage, err)
+			}
+			if err := validOutput(outputFormat); err != nil {
+				return fmt.Errorf("%w: %w", ErrUsage, err)
+			}
+			if outputFormat == outputJSON {
+				return reportFrom(cmd.Context()).moveLogs()
+			}
+			return nil
 		},
 		// RunE
 		// Return the help page if an error occurs
@@ -67,23 +92,44 @@ func rootCmd() *cobra.Command {
 	}
 
 	// At the rootCmd level, set these global flags that will be available to downstream cmds
-	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Run in debug mode")
-	rootCmd.PersistentFlags().BoolVar(&VV, "vv", false, "Run in verbose logging mode")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Log everything, including the output of plugins, with the source location of each message")
+	rootCmd.PersistentFlags().BoolVar(&VV, "vv", false, "Log debug messages, the same as --log-level debug")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Minimum level of messages to log, trace, debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, fmt.Sprintf("Format of log messages on stderr, %q or %q", logging.FormatText, logging.FormatJSON))
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output", outputText, fmt.Sprintf("Output format, %q or %q to print a result document on stdout", outputText, outputJSON))
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return fmt.Errorf("%w: %w", ErrUsage, err)
+	})
 
 	// Defaults set here will be used if they do not exist in the config file
 	viper.SetDefault("store_type", stores.StoreTypeUndefined)
 	viper.SetDefault("metadata_file_extension", metadata.MetadataFileExtension)
 
-	if VV {
-		logger.SetLevel(2)
-	}
-
 	// Import subCmds into the rootCmd
 	rootCmd.AddCommand(
+		convertCmd(),
+		exportCmd(),
+		filterProcessCmd(),
+		fsckCmd(),
+		gcCmd(),
+		gitignoreCmd(),
+		hooksCmd(),
+		importCmd(),
 		initCmd(),
+		installCmd(),
+		migrateCmd(),
 		retrieveCmd(),
+		rmCmd(),
+		scanCmd(),
+		serveCmd(),
+		statusCmd(),
+		trackCmd(),
+		untrackCmd(),
 		uploadCmd(),
+		verifySignaturesCmd(),
+		watchCmd(),
 	)
+	usageErrors(rootCmd)
 
 	return rootCmd
 }
@@ -11,9 +11,31 @@ import (
 func TestRootCmd(t *testing.T) {
 	tests := []string{
 		"cavorite",
+		"cavorite convert",
+		"cavorite export lfs",
+		"cavorite filter-process",
+		"cavorite fsck",
+		"cavorite gc",
+		"cavorite gitignore sync",
+		"cavorite hooks install",
+		"cavorite hooks run pre-commit",
+		"cavorite hooks run post-checkout",
+		"cavorite hooks run post-merge",
+		"cavorite import lfs",
+		"cavorite import pantri",
 		"cavorite init",
+		"cavorite install",
+		"cavorite migrate",
 		"cavorite upload",
 		"cavorite retrieve",
+		"cavorite rm",
+		"cavorite scan",
+		"cavorite serve",
+		"cavorite status",
+		"cavorite track",
+		"cavorite untrack",
+		"cavorite verify-signatures",
+		"cavorite watch",
 	}
 
 	rootCmd := rootCmd()
@@ -0,0 +1,176 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/bindetector"
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/program"
+)
+
+// candidate is a file that should be stored with cavorite
+type candidate struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// treeScanner finds files in a repo that should be stored with cavorite
+type treeScanner struct {
+	fsys    afero.Fs
+	src     metadata.Source
+	ignores *ignore.Matcher
+	ext     string
+	// track, if not nil, matches files that are candidates whatever their size or content
+	track *ignore.Patterns
+	// maxSize is the size above which any file is a candidate, zero for no limit
+	maxSize int64
+	// minBinarySize is the size below which binaries are not candidates
+	minBinarySize int64
+}
+
+// scan returns the files below roots that upload would pick up and that are tracked, binary or
+// larger than maxSize, in lexical order. Files that already have metadata are skipped.
+func (ts *treeScanner) scan(roots ...string) ([]candidate, error) {
+	paths, err := uploadPaths(ts.fsys, ts.src, ts.ignores, ts.ext, roots...)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []candidate
+	for _, path := range paths {
+		if _, err := ts.src.Get(path); err == nil {
+			continue
+		}
+		info, err := ts.fsys.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if ts.track != nil && ts.track.Match(path, false) {
+			candidates = append(candidates, candidate{path, info.Size(), "matches a tracked pattern"})
+			continue
+		}
+		if ts.maxSize > 0 && info.Size() > ts.maxSize {
+			candidates = append(candidates, candidate{path, info.Size(), fmt.Sprintf("larger than %d bytes", ts.maxSize)})
+			continue
+		}
+		if info.Size() < ts.minBinarySize {
+			continue
+		}
+		r, err := ts.detect(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if r.Binary {
+			candidates = append(candidates, candidate{path, info.Size(), fmt.Sprintf("binary (%s), %s", r.Type, r.Reason)})
+		}
+	}
+	return candidates, nil
+}
+
+func (ts *treeScanner) detect(path string) (bindetector.Result, error) {
+	f, err := ts.fsys.Open(path)
+	if err != nil {
+		return bindetector.Result{}, err
+	}
+	defer f.Close()
+	return bindetector.Detector{}.Detect(f)
+}
+
+// writeCandidates writes a line for each of candidates to w
+func writeCandidates(w io.Writer, candidates []candidate) {
+	for _, c := range candidates {
+		fmt.Fprintf(w, "%12d %s: %s\n", c.Size, c.Path, c.Reason)
+	}
+}
+
+func scanCmd() *cobra.Command {
+	scanCmd := &cobra.Command{
+		Use:   "scan [path...]",
+		Short: fmt.Sprintf("Find binary or large files that should be stored with %s", program.Name),
+		Long: fmt.Sprintf(`List the files below the given paths, or the whole repo if none are given, that match a tracked
+pattern, are binary or are larger than --max-size and have no cfile yet, along with their size in bytes. Files that upload
+skips, such as those matched by %s files, are not listed. With --upload the files are uploaded
+right away, so that their cfiles can be committed in their place.`, ignore.FileName),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: scanFn,
+	}
+	scanCmd.Flags().Int64("max-size", defaultMaxSize, "List files larger than this many bytes, 0 for no limit")
+	scanCmd.Flags().Int64("min-binary-size", 0, "Skip binaries smaller than this many bytes")
+	scanCmd.Flags().Bool("upload", false, "Upload the files that are found")
+	return scanCmd
+}
+
+func scanFn(cmd *cobra.Command, paths []string) (err error) {
+	maxSize, err := cmd.Flags().GetInt64("max-size")
+	if err != nil {
+		return err
+	}
+	minBinarySize, err := cmd.Flags().GetInt64("min-binary-size")
+	if err != nil {
+		return err
+	}
+	doUpload, err := cmd.Flags().GetBool("upload")
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	track, err := trackPatterns(config.Cfg)
+	if err != nil {
+		return err
+	}
+	fsys := afero.NewOsFs()
+	ts := &treeScanner{
+		fsys:          fsys,
+		src:           newMetadataSource(config.Cfg, fsys),
+		ignores:       ignore.NewMatcher(fsys, "."),
+		ext:           config.Cfg.Options.MetadataFileExtension,
+		track:         track,
+		maxSize:       maxSize,
+		minBinarySize: minBinarySize,
+	}
+	defer func() { err = multierr.Append(err, ts.src.Close()).ErrorOrNil() }()
+	candidates, err := ts.scan(paths...)
+	if err != nil {
+		return err
+	}
+	if outputFormat == outputJSON {
+		if candidates == nil {
+			candidates = []candidate{}
+		}
+		reportFrom(cmd.Context()).setResult(candidates)
+	} else {
+		writeCandidates(cmd.OutOrStdout(), candidates)
+	}
+	if !doUpload {
+		return nil
+	}
+	if len(candidates) == 0 {
+		slog.Info("nothing to upload")
+		return nil
+	}
+	objects := make([]string, len(candidates))
+	for i, c := range candidates {
+		objects[i] = c.Path
+	}
+	s, err := initStoreFromConfig(cmd.Context(), config.Cfg, fsys)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := upload(cmd.Context(), fsys, ts.src, s, objects...); err != nil {
+		return err
+	}
+	return ignoreUploaded(fsys, objects...)
+}
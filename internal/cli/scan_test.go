@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/testutils"
+)
+
+func TestScan(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		".cavoriteignore":     {Content: []byte("*.tmp\n")},
+		".git/index":          {Content: []byte("DIRC\x00\x00\x00\x02")},
+		"README.md":           {Content: []byte("# tools\n")},
+		"big.txt":             {Content: []byte(strings.Repeat("x", 101))},
+		"icon.png":            {Content: []byte("\x89PNG\r\n\x1a\n")},
+		"installers/app.dmg":  {Content: []byte("dmg")},
+		"tools/tool":          {Content: []byte("\x7fELF\x02\x01\x01\x00")},
+		"tools/tool.tmp":      {Content: []byte("\x7fELF\x02\x01\x01\x00")},
+		"tools/tracked":       {Content: []byte("\x00stuff")},
+		"tools/tracked.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/tracked", "sha256", stuffSHA256))},
+	})
+	require.NoError(t, err)
+	track, err := ignore.ParsePatterns("*.dmg")
+	require.NoError(t, err)
+	ts := &treeScanner{
+		fsys:          *fsys,
+		src:           metadata.NewSidecarSource(*fsys, "cfile"),
+		ignores:       ignore.NewMatcher(*fsys, "."),
+		ext:           "cfile",
+		track:         track,
+		maxSize:       100,
+		minBinarySize: 0,
+	}
+	candidates, err := ts.scan(".")
+	require.NoError(t, err)
+	assert.Equal(t, []candidate{
+		{"big.txt", 101, "larger than 100 bytes"},
+		{"icon.png", 8, "binary (png), starts with the magic number of png"},
+		{"installers/app.dmg", 3, "matches a tracked pattern"},
+		{"tools/tool", 8, "binary (elf), starts with the magic number of elf"},
+	}, candidates)
+
+	ts.maxSize, ts.minBinarySize = 0, 10
+	candidates, err = ts.scan("tools")
+	require.NoError(t, err)
+	assert.Empty(t, candidates)
+
+	var out bytes.Buffer
+	writeCandidates(&out, []candidate{{"big.txt", 101, "larger than 100 bytes"}})
+	assert.Equal(t, "         101 big.txt: larger than 100 bytes\n", out.String())
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/program"
+	"github.com/discentem/cavorite/stores"
+)
+
+const (
+	defaultListenAddress = "localhost:8080"
+	// shutdownTimeout is how long requests in progress may take to finish once serve is stopped
+	shutdownTimeout = 30 * time.Second
+)
+
+var ErrTLSFlags = errors.New("--tls-cert and --tls-key must be given together")
+
+func serveCmd() *cobra.Command {
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the store of the repo over HTTP, caching objects on disk",
+		Long: fmt.Sprintf(`Serve the store described by .cavorite/config in the working directory over HTTP so that other
+%[1]s repos can use it with store_type %[2]q and the URL of the server as backend_address.
+
+GET and HEAD /<key> download and describe an object and PUT /<key> uploads one. Objects are kept in
+--cache-dir once they were uploaded or downloaded, so the store is only asked for objects that are
+not cached yet. GET and PUT require the digest of the object in the %[4]s header, and
+objects are only cached and served if they match it. Objects are stored as clients send them,
+encryption and chunking are done by the clients.
+
+If %[3]s is set, clients must send it as a bearer token, which they read from the same variable.
+Every request is logged. %[1]s serve stops on interrupt or SIGTERM once the requests in progress
+are finished.`, program.Name, stores.StoreTypeHTTP, stores.HTTPTokenEnv, stores.DigestHeader),
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: serveFn,
+	}
+	serveCmd.Flags().String("listen", defaultListenAddress, "Address to listen on")
+	serveCmd.Flags().String("cache-dir", "", "Directory objects are cached in, a directory in the user cache directory by default")
+	serveCmd.Flags().String("tls-cert", "", "Certificate file to serve HTTPS with")
+	serveCmd.Flags().String("tls-key", "", "Key file of --tls-cert")
+	return serveCmd
+}
+
+// defaultCacheDir returns the directory serve caches objects in if --cache-dir is not given
+func defaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, program.Name, "serve"), nil
+}
+
+func serveFn(cmd *cobra.Command, _ []string) (err error) {
+	listen, err := cmd.Flags().GetString("listen")
+	if err != nil {
+		return err
+	}
+	cacheDir, err := cmd.Flags().GetString("cache-dir")
+	if err != nil {
+		return err
+	}
+	tlsCert, err := cmd.Flags().GetString("tls-cert")
+	if err != nil {
+		return err
+	}
+	tlsKey, err := cmd.Flags().GetString("tls-key")
+	if err != nil {
+		return err
+	}
+	if (tlsCert == "") != (tlsKey == "") {
+		return ErrTLSFlags
+	}
+	if config.Cfg.StoreType == stores.StoreTypeGoPlugin {
+		// plugins read and write the source repo instead of the cache
+		return ErrStagingWithPlugin
+	}
+	if cacheDir == "" {
+		if cacheDir, err = defaultCacheDir(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return err
+	}
+	token := os.Getenv(stores.HTTPTokenEnv)
+	if token == "" {
+		slog.Warn(fmt.Sprintf("%s is not set, anyone who can reach the server can read and write objects", stores.HTTPTokenEnv), "listen", listen)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cache := afero.NewBasePathFs(afero.NewOsFs(), cacheDir)
+	// clients encrypt and chunk objects themselves, so the backend stores what they send
+	backend, err := newBackendStore(ctx, config.Cfg, cache)
+	if err != nil {
+		return err
+	}
+	defer func() { err = multierr.Append(err, backend.Close()).ErrorOrNil() }()
+
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           stores.NewCacheServer(backend, cache, token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if tlsCert != "" {
+			serveErr <- srv.ServeTLS(ln, tlsCert, tlsKey)
+			return
+		}
+		serveErr <- srv.Serve(ln)
+	}()
+	slog.Info("serving", "store_type", config.Cfg.StoreType, "address", config.Cfg.Options.BackendAddress, "listen", ln.Addr().String(), "cache", cacheDir)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("stopping, waiting for requests in progress")
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/stores"
+)
+
+func TestServeTLSFlags(t *testing.T) {
+	cmd := serveCmd()
+	require.NoError(t, cmd.Flags().Set("tls-cert", "cert.pem"))
+	assert.ErrorIs(t, serveFn(cmd, nil), ErrTLSFlags)
+}
+
+func TestServeRejectsPlugins(t *testing.T) {
+	defer func(cfg config.Config) { config.Cfg = cfg }(config.Cfg)
+	config.Cfg = config.Config{StoreType: stores.StoreTypeGoPlugin}
+	assert.ErrorIs(t, serveFn(serveCmd(), nil), ErrStagingWithPlugin)
+}
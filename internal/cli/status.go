@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/bindetector"
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+)
+
+type objectState string
+
+const (
+	// stateOK means the object is present and matches its metadata
+	stateOK objectState = "ok"
+	// stateMissing means the object has metadata but is not present
+	stateMissing objectState = "missing"
+	// stateModified means the object is present but does not match its metadata
+	stateModified objectState = "modified"
+	// stateUntracked means the object is a binary file or a file matching a tracked pattern without
+	// metadata
+	stateUntracked objectState = "untracked"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var (
+	ErrStatusNotClean = errors.New("some objects are missing, modified or untracked")
+	ErrUnknownOutput  = errors.New("unknown output format")
+)
+
+// objectStatus is the state of a single object
+type objectStatus struct {
+	Path  string      `json:"path"`
+	State objectState `json:"state"`
+	// Metadata is where the metadata of the object is kept, empty for untracked objects
+	Metadata string `json:"metadata,omitempty"`
+	Size     *int64 `json:"size,omitempty"`
+}
+
+// statusChecker classifies the objects in a repo
+type statusChecker struct {
+	fsys    afero.Fs
+	src     metadata.Source
+	ignores *ignore.Matcher
+	ext     string
+	// track, if not nil, matches files that are untracked objects unless they have metadata
+	track *ignore.Patterns
+	// isBinary reports whether a file without metadata is binary. Untracked objects are not
+	// reported if it is nil.
+	isBinary func(path string) bool
+}
+
+// status returns the state of every object with metadata below roots and every untracked binary or
+// tracked file that upload would pick up, sorted by path. Objects whose metadata cannot be read are returned as
+// errors.
+func (sc *statusChecker) status(roots ...string) ([]objectStatus, error) {
+	objects, err := sc.src.List(roots...)
+	if err != nil {
+		return nil, err
+	}
+	var result *multierr.Error
+	var statuses []objectStatus
+	tracked := make(map[string]bool)
+	for _, obj := range objects {
+		tracked[filepath.Clean(obj)] = true
+		m, err := sc.src.Get(obj)
+		if err != nil {
+			result = multierr.Append(result, err)
+			continue
+		}
+		state, err := sc.state(obj, m)
+		if err != nil {
+			result = multierr.Append(result, fmt.Errorf("%s: %w", obj, err))
+			continue
+		}
+		statuses = append(statuses, objectStatus{
+			Path:     obj,
+			State:    state,
+			Metadata: sc.src.Location(obj),
+			Size:     m.Size,
+		})
+	}
+	if sc.isBinary != nil {
+		// roots may name objects that are missing
+		var present []string
+		for _, root := range roots {
+			if _, err := sc.fsys.Stat(root); err == nil {
+				present = append(present, root)
+			}
+		}
+		candidates, err := uploadPaths(sc.fsys, sc.src, sc.ignores, sc.ext, present...)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range candidates {
+			if tracked[path] || !(sc.track != nil && sc.track.Match(path, false) || sc.isBinary(path)) {
+				continue
+			}
+			status := objectStatus{Path: path, State: stateUntracked}
+			if info, err := sc.fsys.Stat(path); err == nil {
+				size := info.Size()
+				status.Size = &size
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Path < statuses[j].Path
+	})
+	return statuses, result.ErrorOrNil()
+}
+
+func (sc *statusChecker) state(obj string, m *metadata.ObjectMetaData) (objectState, error) {
+	if _, err := sc.fsys.Stat(obj); errors.Is(err, os.ErrNotExist) {
+		return stateMissing, nil
+	}
+	var modified bool
+	var err error
+	if m.Archive != nil {
+		modified, err = shouldRetrieveArchive(sc.fsys, m, obj)
+	} else {
+		modified, err = shouldRetrieve(sc.fsys, m, obj)
+	}
+	if err != nil {
+		return "", err
+	}
+	if modified {
+		return stateModified, nil
+	}
+	return stateOK, nil
+}
+
+func validOutput(output string) error {
+	switch output {
+	case outputText, outputJSON:
+		return nil
+	}
+	return fmt.Errorf("%w %q, expected %q or %q", ErrUnknownOutput, output, outputText, outputJSON)
+}
+
+// writeStatus writes a line for each of statuses to w
+func writeStatus(w io.Writer, statuses []objectStatus) {
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%-9s %s\n", strings.ToUpper(string(s.State)), s.Path)
+	}
+}
+
+// notClean returns an error wrapping ErrStatusNotClean if any of statuses is not stateOK
+func notClean(statuses []objectStatus) error {
+	dirty := 0
+	for _, s := range statuses {
+		if s.State != stateOK {
+			dirty++
+		}
+	}
+	if dirty > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrStatusNotClean, dirty, len(statuses))
+	}
+	return nil
+}
+
+func statusCmd() *cobra.Command {
+	statusCmd := &cobra.Command{
+		Use:   "status [path...]",
+		Short: "Show which objects are missing, modified or untracked",
+		Long: `Show the state of every object with a cfile below the given paths, or the whole repo if none are given.
+Objects are ok, missing, modified (present but different from their cfile) or untracked (binary files
+or files matching a tracked pattern without a cfile that upload would pick up).`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: statusFn,
+	}
+	statusCmd.Flags().Bool("strict", false, "Fail unless every object is ok")
+	statusCmd.Flags().Bool("untracked", true, "Look for untracked binaries and tracked files")
+	return statusCmd
+}
+
+func statusFn(cmd *cobra.Command, paths []string) error {
+	strict, err := cmd.Flags().GetBool("strict")
+	if err != nil {
+		return err
+	}
+	untracked, err := cmd.Flags().GetBool("untracked")
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	fsys := afero.NewOsFs()
+	sc := &statusChecker{
+		fsys:    fsys,
+		src:     newMetadataSource(config.Cfg, fsys),
+		ignores: ignore.NewMatcher(fsys, "."),
+		ext:     config.Cfg.Options.MetadataFileExtension,
+	}
+	defer sc.src.Close()
+	if untracked {
+		if sc.track, err = trackPatterns(config.Cfg); err != nil {
+			return err
+		}
+		sc.isBinary = bindetector.IsBinary
+	}
+	statuses, err := sc.status(paths...)
+	if outputFormat == outputJSON {
+		if statuses == nil {
+			statuses = []objectStatus{}
+		}
+		reportFrom(cmd.Context()).setResult(statuses)
+	} else {
+		writeStatus(cmd.OutOrStdout(), statuses)
+	}
+	if strict {
+		err = multierr.Append(err, notClean(statuses)).ErrorOrNil()
+	}
+	return err
+}
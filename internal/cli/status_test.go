@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/testutils"
+)
+
+func TestStatus(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		".cavoriteignore":         {Content: []byte("*.tmp\n")},
+		"tools/ok":                {Content: []byte("stuff")},
+		"tools/ok.cfile":          {Content: []byte(fmt.Sprintf(stuffCfile, "tools/ok", "sha256", stuffSHA256))},
+		"tools/missing.cfile":     {Content: []byte(fmt.Sprintf(stuffCfile, "tools/missing", "sha256", stuffSHA256))},
+		"tools/modified":          {Content: []byte("stuff, but different")},
+		"tools/modified.cfile":    {Content: []byte(fmt.Sprintf(stuffCfile, "tools/modified", "sha256", stuffSHA256))},
+		"tools/untracked.bin":     {Content: []byte("\x00\x01\x02")},
+		"tools/untracked.bin.tmp": {Content: []byte("\x00\x01\x02")},
+		"tools/source.go":         {Content: []byte("package tools\n")},
+		"tools/tracked.dmg":       {Content: []byte("dmg")},
+	})
+	require.NoError(t, err)
+	track, err := ignore.ParsePatterns("*.dmg")
+	require.NoError(t, err)
+	sc := &statusChecker{
+		fsys:    *fsys,
+		src:     metadata.NewSidecarSource(*fsys, "cfile"),
+		ignores: ignore.NewMatcher(*fsys, "."),
+		ext:     "cfile",
+		track:   track,
+		isBinary: func(path string) bool {
+			return strings.HasSuffix(path, ".bin")
+		},
+	}
+	statuses, err := sc.status(".")
+	require.NoError(t, err)
+	size := int64(5)
+	untrackedSize := int64(3)
+	assert.Equal(t, []objectStatus{
+		{Path: "tools/missing", State: stateMissing, Metadata: "tools/missing.cfile", Size: &size},
+		{Path: "tools/modified", State: stateModified, Metadata: "tools/modified.cfile", Size: &size},
+		{Path: "tools/ok", State: stateOK, Metadata: "tools/ok.cfile", Size: &size},
+		{Path: "tools/tracked.dmg", State: stateUntracked, Size: &untrackedSize},
+		{Path: "tools/untracked.bin", State: stateUntracked, Size: &untrackedSize},
+	}, statuses)
+	assert.ErrorIs(t, notClean(statuses), ErrStatusNotClean)
+	assert.NoError(t, notClean(statuses[2:3]))
+
+	var out bytes.Buffer
+	writeStatus(&out, statuses)
+	assert.Equal(t, `MISSING   tools/missing
+MODIFIED  tools/modified
+OK        tools/ok
+UNTRACKED tools/tracked.dmg
+UNTRACKED tools/untracked.bin
+`, out.String())
+
+	// paths may name missing objects
+	statuses, err = sc.status("tools/missing")
+	require.NoError(t, err)
+	assert.Len(t, statuses, 1)
+}
+
+func TestStatusArchive(t *testing.T) {
+	a, fsys, _, _ := newTestArchiver(t)
+	require.NoError(t, a.upload(context.Background(), "", "sdk"))
+	sc := &statusChecker{fsys: fsys, src: a.src, ignores: ignore.NewMatcher(fsys, "."), ext: "cfile"}
+
+	statuses, err := sc.status(".")
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	assert.Equal(t, stateOK, statuses[0].State)
+
+	require.NoError(t, fsys.Remove("sdk/include/sdk.h"))
+	statuses, err = sc.status(".")
+	require.NoError(t, err)
+	assert.Equal(t, stateModified, statuses[0].State)
+}
@@ -0,0 +1,24 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package cli
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// protocolStdout returns a new file writing to stdout and points stdout at stderr, so that nothing
+// written to stdout afterwards, such as log messages, ends up between the messages of a protocol
+func protocolStdout() (*os.File, error) {
+	fd, err := unix.Dup(int(os.Stdout.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Dup2(int(os.Stderr.Fd()), int(os.Stdout.Fd())); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "stdout"), nil
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package cli
+
+import (
+	"os"
+)
+
+// protocolStdout returns stdout as it is, log messages must be kept off stdout on windows while a
+// protocol is spoken on it
+func protocolStdout() (*os.File, error) {
+	return os.Stdout, nil
+}
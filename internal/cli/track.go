@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/program"
+)
+
+var (
+	ErrNotTracked     = errors.New("pattern is not tracked")
+	ErrNothingTracked = errors.New("no patterns are tracked, add some with track")
+)
+
+// trackResult is what track and untrack record for --output json
+type trackResult struct {
+	Tracked []string `json:"tracked"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// trackPatterns returns the patterns of the track section of cfg
+func trackPatterns(cfg config.Config) (*ignore.Patterns, error) {
+	track, err := ignore.ParsePatterns(cfg.Track...)
+	if err != nil {
+		return nil, fmt.Errorf("track section of the config: %w", err)
+	}
+	return track, nil
+}
+
+// addTracked returns tracked with patterns added and the patterns that were not tracked yet
+func addTracked(tracked []string, patterns ...string) ([]string, []string, error) {
+	if _, err := ignore.ParsePatterns(patterns...); err != nil {
+		return nil, nil, err
+	}
+	var added []string
+	for _, pattern := range patterns {
+		if pattern == "" || slices.Contains(tracked, pattern) {
+			continue
+		}
+		tracked = append(tracked, pattern)
+		added = append(added, pattern)
+	}
+	return tracked, added, nil
+}
+
+// removeTracked returns tracked without patterns. Nothing is removed unless every pattern is tracked.
+func removeTracked(tracked []string, patterns ...string) ([]string, error) {
+	for _, pattern := range patterns {
+		if !slices.Contains(tracked, pattern) {
+			return nil, fmt.Errorf("%w: %q", ErrNotTracked, pattern)
+		}
+	}
+	var kept []string
+	for _, pattern := range tracked {
+		if !slices.Contains(patterns, pattern) {
+			kept = append(kept, pattern)
+		}
+	}
+	return kept, nil
+}
+
+func trackCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "track [pattern...]",
+		Short: fmt.Sprintf("Require files matching patterns to be stored with %s", program.Name),
+		Long: fmt.Sprintf(`Add patterns, in the syntax of %s files and relative to the root of the repo, to the
+track section of .cavorite/config, or list the tracked patterns if none are given. Matching files
+without a cfile are found by scan and status, uploaded by "upload --all" and rejected by the
+pre-commit hook.`, ignore.FileName),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: trackFn,
+	}
+}
+
+func trackFn(cmd *cobra.Command, patterns []string) error {
+	cfg := config.Cfg.WithDefaultFuncs()
+	r := reportFrom(cmd.Context())
+	if len(patterns) == 0 {
+		for _, pattern := range cfg.Track {
+			fmt.Fprintln(cmd.OutOrStdout(), pattern)
+		}
+		r.setResult(trackResult{Tracked: append([]string{}, cfg.Track...)})
+		return nil
+	}
+	tracked, added, err := addTracked(cfg.Track, patterns...)
+	if err != nil {
+		return err
+	}
+	if len(added) == 0 {
+		r.setResult(trackResult{Tracked: append([]string{}, tracked...)})
+		return nil
+	}
+	cfg.Track = tracked
+	if err := cfg.Write(afero.NewOsFs(), "."); err != nil {
+		return err
+	}
+	for _, pattern := range added {
+		fmt.Fprintf(cmd.OutOrStdout(), "tracking %s\n", pattern)
+	}
+	r.setResult(trackResult{Tracked: tracked, Added: added})
+	return nil
+}
+
+func untrackCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "untrack <pattern...>",
+		Short: fmt.Sprintf("Stop requiring files matching patterns to be stored with %s", program.Name),
+		Long: `Remove patterns from the track section of .cavorite/config. Files that were already uploaded
+keep their cfiles.`,
+		Args: cobra.MinimumNArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: untrackFn,
+	}
+}
+
+func untrackFn(cmd *cobra.Command, patterns []string) error {
+	cfg := config.Cfg.WithDefaultFuncs()
+	tracked, err := removeTracked(cfg.Track, patterns...)
+	if err != nil {
+		return err
+	}
+	cfg.Track = tracked
+	if err := cfg.Write(afero.NewOsFs(), "."); err != nil {
+		return err
+	}
+	for _, pattern := range patterns {
+		fmt.Fprintf(cmd.OutOrStdout(), "no longer tracking %s\n", pattern)
+	}
+	reportFrom(cmd.Context()).setResult(trackResult{Tracked: append([]string{}, tracked...), Removed: patterns})
+	return nil
+}
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/config"
+)
+
+func TestTrack(t *testing.T) {
+	tracked, added, err := addTracked(nil, "*.dmg", "*.pkg", "*.dmg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.dmg", "*.pkg"}, tracked)
+	assert.Equal(t, []string{"*.dmg", "*.pkg"}, added)
+	tracked, added, err = addTracked(tracked, "*.pkg", "assets/**")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.dmg", "*.pkg", "assets/**"}, tracked)
+	assert.Equal(t, []string{"assets/**"}, added)
+
+	track, err := trackPatterns(config.Config{Track: tracked})
+	require.NoError(t, err)
+	assert.True(t, track.Match("installers/app.dmg", false))
+	assert.True(t, track.Match("assets/images/logo.png", false))
+	assert.False(t, track.Match("README.md", false))
+
+	_, err = removeTracked(tracked, "*.pkg", "*.iso")
+	assert.ErrorIs(t, err, ErrNotTracked)
+	tracked, err = removeTracked(tracked, "*.pkg", "*.dmg")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"assets/**"}, tracked)
+}
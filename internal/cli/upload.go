@@ -4,14 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/google/logger"
 	multierr "github.com/hashicorp/go-multierror"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 
+	"github.com/discentem/cavorite/archive"
 	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/fileutils"
+	"github.com/discentem/cavorite/ignore"
 	"github.com/discentem/cavorite/metadata"
 	cavoriteObjLib "github.com/discentem/cavorite/objects"
 	"github.com/discentem/cavorite/program"
@@ -20,10 +26,16 @@ import (
 
 func uploadCmd() *cobra.Command {
 	uploadCmd := &cobra.Command{
-		Use:   "upload",
-		Short: fmt.Sprintf("Upload a file to %s", program.Name),
-		Long:  fmt.Sprintf("Upload a file to %s", program.Name),
-		Args:  cobra.MinimumNArgs(1),
+		Use:   "upload [path...]",
+		Short: fmt.Sprintf("Upload files to %s", program.Name),
+		Long: fmt.Sprintf(`Upload files to %s. Directories are uploaded recursively, skipping cfiles and
+anything matched by a %s file in the root of the repo or any directory below it.
+
+With --all every file matching a pattern of the track section of .cavorite/config is uploaded
+unless it did not change since it was last uploaded.
+
+With --as-archive each directory is uploaded as a single archive instead and retrieve extracts it
+in place.`, program.Name, ignore.FileName),
 		// PersistentPreRunE
 		// Loads the config with OsFs
 		/*
@@ -40,6 +52,10 @@ func uploadCmd() *cobra.Command {
 		},
 		RunE: uploadFn,
 	}
+	uploadCmd.Flags().Bool("all", false, "Upload every new or changed file matching a tracked pattern")
+	uploadCmd.Flags().Bool("dry-run", false, "Print what would be uploaded without uploading anything")
+	uploadCmd.Flags().Bool("as-archive", false, "Upload each directory as a single archive")
+	uploadCmd.Flags().String("compression", archive.CompressionNone, fmt.Sprintf("Compression of archives, %q or none", archive.CompressionGzip))
 
 	return uploadCmd
 }
@@ -62,15 +78,23 @@ var (
 	ErrUpload              = errors.New("failed to upload")
 )
 
-func upload(ctx context.Context, fsys afero.Fs, s stores.Store, objects ...string) error {
+// upload uploads objects to s and records their metadata in src
+func upload(ctx context.Context, fsys afero.Fs, src metadata.Source, s stores.Store, objects ...string) error {
 	opts, err := s.GetOptions()
 	if err != nil {
 		return err
 	}
-	logger.Info("Options:", opts)
+	slog.Debug("store options", "options", opts)
+
+	// fail before uploading anything if metadata cannot be generated
+	if _, err := metadata.NewHasher(opts.HashAlgorithm); err != nil {
+		return err
+	}
+	if err := prepareUpload(s); err != nil {
+		return fmt.Errorf("%w: %w", ErrWriteMetadataToFsys, err)
+	}
 
-	logger.Infof("Uploading to: %s", opts.BackendAddress)
-	logger.Infof("Uploading file: %s", objects)
+	slog.Info("uploading objects", "address", opts.BackendAddress, "objects", objects)
 
 	var derivedKeys []string
 	prefixOp := cavoriteObjLib.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}
@@ -85,48 +109,183 @@ func upload(ctx context.Context, fsys afero.Fs, s stores.Store, objects ...strin
 		}
 	}
 
-	if err := s.Upload(ctx, derivedKeys...); err != nil {
-		logger.Error(err)
-		return fmt.Errorf("%w for %v", ErrUpload, objects)
+	var errResult *multierr.Error
+	r := reportFrom(ctx)
+	for i, obj := range objects {
+		start := time.Now()
+		m, err := uploadObject(ctx, fsys, src, s, obj, derivedKeys[i], opts.HashAlgorithm)
+		r.add(newObjectResult(obj, outcomeUploaded, m, start, err))
+		errResult = multierr.Append(errResult, err)
+	}
+	return errResult.ErrorOrNil()
+}
+
+// uploadObject uploads obj to s as key and records its metadata in src
+func uploadObject(ctx context.Context, fsys afero.Fs, src metadata.Source, s stores.Store, obj, key, algorithm string) (*metadata.ObjectMetaData, error) {
+	if err := s.Upload(ctx, key); err != nil {
+		slog.Error("uploading object", "object", obj, "error", err)
+		return nil, fmt.Errorf("%w for %s: %w", ErrUpload, obj, err)
+	}
+	f, err := fsys.Open(obj)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s", ErrOpen, obj)
+	}
+	m, err := metadata.GenerateFromFileWithAlgorithm(f, key, algorithm)
+	f.Close()
+	if err == nil {
+		err = writeMetadata(src, s, obj, m)
+	}
+	if err != nil {
+		slog.Error("writing metadata", "object", obj, "error", err)
+		return nil, fmt.Errorf("%w for %s: %w", ErrWriteMetadataToFsys, obj, err)
+	}
+	return m, nil
+}
+
+// writeMetadata lets s annotate m, the metadata of obj after it was uploaded to s, and records it in src
+func writeMetadata(src metadata.Source, s stores.Store, obj string, m *metadata.ObjectMetaData) error {
+	if err := annotate(s, m); err != nil {
+		return err
 	}
+	return src.Put(obj, m)
+}
+
+// prepareUpload lets s check that it can annotate metadata, for example that its signing key can
+// be loaded, before anything is uploaded to it
+func prepareUpload(s stores.Store) error {
+	if preparer, ok := s.(stores.UploadPreparer); ok {
+		return preparer.PrepareUpload()
+	}
+	return nil
+}
 
-	var errResult error
-	for _, obj := range objects {
-		f, err := fsys.Open(obj)
+// annotate lets s annotate m, the metadata of an object after it was uploaded to s
+func annotate(s stores.Store, m *metadata.ObjectMetaData) error {
+	slog.Debug("annotating metadata", "key", m.Name, "checksum", m.Checksum)
+	if annotator, ok := s.(stores.MetadataAnnotator); ok {
+		return annotator.Annotate(m.Name, m)
+	}
+	return nil
+}
+
+// uploadPaths returns the files to upload for paths. Directories are walked recursively. Files with
+// extension ext, ignore files, anything matched by ignore files and directories that src holds as
+// archives are skipped.
+func uploadPaths(fsys afero.Fs, src metadata.Source, ignores *ignore.Matcher, ext string, paths ...string) ([]string, error) {
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	suffix := "." + strings.TrimPrefix(ext, ".")
+	seen := make(map[string]bool)
+	var objects []string
+	for _, root := range paths {
+		root = filepath.Clean(root)
+		err := afero.Walk(fsys, root, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != root && fileutils.SkippedDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			ignored, err := ignores.Ignored(path, info.IsDir())
+			if err != nil {
+				return err
+			}
+			archived := info.IsDir() && isArchived(src, path)
+			switch {
+			case archived && path == root:
+				slog.Warn("skipping directory, it is uploaded as an archive, use --as-archive to upload it again", "path", path)
+			case archived:
+				slog.Debug("skipping directory, it is uploaded as an archive", "path", path)
+			case ignored && path == root:
+				slog.Warn("skipping path, it is ignored by "+ignore.FileName, "path", path)
+			case ignored:
+				slog.Debug("skipping path, it is ignored by "+ignore.FileName, "path", path)
+			case info.IsDir():
+				return nil
+			case strings.HasSuffix(path, suffix) || info.Name() == ignore.FileName:
+				slog.Debug("skipping file, it is cavorite metadata", "path", path)
+			case !seen[path]:
+				seen[path] = true
+				objects = append(objects, path)
+			}
+			if (ignored || archived) && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
 		if err != nil {
-			errResult = multierr.Append(fmt.Errorf("%w for %s", ErrOpen, obj))
-			continue
+			return nil, err
 		}
-		_, err = f.Seek(0, io.SeekStart)
-		if err != nil {
-			errResult = multierr.Append(err)
+	}
+	return objects, nil
+}
+
+// trackedObjects returns the files in the repo that upload would pick up and that match track, unless
+// they have metadata and did not change since they were uploaded
+func trackedObjects(fsys afero.Fs, src metadata.Source, ignores *ignore.Matcher, ext string, track *ignore.Patterns) ([]string, error) {
+	paths, err := uploadPaths(fsys, src, ignores, ext, ".")
+	if err != nil {
+		return nil, err
+	}
+	var objects []string
+	for _, path := range paths {
+		if !track.Match(path, false) {
 			continue
 		}
-		mon := prefixOp.Modify(obj)
-		err = metadata.WriteToFsys(metadata.FsysWriteRequest{
-			Object:       mon,
-			Fsys:         fsys,
-			Fi:           f,
-			MetadataPath: obj,
-			Extension:    opts.MetadataFileExtension,
-		})
-		if err != nil {
-			errResult = multierr.Append(fmt.Errorf("%w for %s", ErrWriteMetadataToFsys, obj))
+		if m, err := src.Get(path); err == nil {
+			changed, err := shouldRetrieve(fsys, m, path)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			if !changed {
+				slog.Debug("skipping file, it did not change since it was uploaded", "path", path)
+				continue
+			}
 		}
+		objects = append(objects, path)
+	}
+	return objects, nil
+}
+
+// isArchived reports whether src holds dir as an archive
+func isArchived(src metadata.Source, dir string) bool {
+	if dir == "." {
+		return false
 	}
-	return errResult
+	m, err := src.Get(dir)
+	return err == nil && m.Archive != nil
 }
-func uploadFn(cmd *cobra.Command, objects []string) error {
+
+func uploadFn(cmd *cobra.Command, objects []string) (err error) {
 	fsys := afero.NewOsFs()
-	s, err := initStoreFromConfig(
-		cmd.Context(),
-		config.Cfg,
-		fsys,
-	)
+	all, err := cmd.Flags().GetBool("all")
 	if err != nil {
 		return err
 	}
-	defer s.Close()
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+	asArchive, err := cmd.Flags().GetBool("as-archive")
+	if err != nil {
+		return err
+	}
+	switch {
+	case all && len(objects) > 0:
+		return fmt.Errorf("%w: --all cannot be combined with paths", ErrUsage)
+	case all && asArchive:
+		return fmt.Errorf("%w: --all cannot be combined with --as-archive", ErrUsage)
+	case !all && len(objects) == 0:
+		return fmt.Errorf("%w: requires at least 1 path or --all", ErrUsage)
+	}
+	compression, err := cmd.Flags().GetString("compression")
+	if err != nil {
+		return err
+	}
+	if compression != archive.CompressionNone && !asArchive {
+		return fmt.Errorf("%w: --compression can only be used with --as-archive", ErrUsage)
+	}
 	sourceRepoRoot, err := rootOfSourceRepo()
 	if err != nil {
 		return err
@@ -140,5 +299,64 @@ func uploadFn(cmd *cobra.Command, objects []string) error {
 	if err != nil {
 		return fmt.Errorf("upload error: %w", err)
 	}
-	return upload(cmd.Context(), fsys, s, objects...)
+	src := newMetadataSource(config.Cfg, fsys)
+	defer func() { err = multierr.Append(err, src.Close()).ErrorOrNil() }()
+	if asArchive {
+		for i, dir := range objects {
+			objects[i] = filepath.Clean(dir)
+			if dryRun {
+				fmt.Fprintf(cmd.OutOrStdout(), "would upload %s as an archive\n", objects[i])
+			}
+		}
+		if dryRun {
+			return nil
+		}
+		a := &archiver{fsys: fsys, src: src, newStore: stagedStoreFunc(cmd.Context(), config.Cfg)}
+		if err := a.upload(cmd.Context(), compression, objects...); err != nil {
+			return err
+		}
+		return ignoreUploaded(fsys, objects...)
+	}
+	if all {
+		track, err := trackPatterns(config.Cfg)
+		if err != nil {
+			return err
+		}
+		if track.Len() == 0 {
+			return ErrNothingTracked
+		}
+		objects, err = trackedObjects(fsys, src, ignore.NewMatcher(fsys, "."), config.Cfg.Options.MetadataFileExtension, track)
+		if err != nil {
+			return fmt.Errorf("upload error: %w", err)
+		}
+	} else {
+		objects, err = uploadPaths(fsys, src, ignore.NewMatcher(fsys, "."), config.Cfg.Options.MetadataFileExtension, objects...)
+		if err != nil {
+			return fmt.Errorf("upload error: %w", err)
+		}
+	}
+	if len(objects) == 0 {
+		slog.Info("nothing to upload")
+		return nil
+	}
+	if dryRun {
+		for _, obj := range objects {
+			fmt.Fprintf(cmd.OutOrStdout(), "would upload %s\n", obj)
+		}
+		return nil
+	}
+
+	s, err := initStoreFromConfig(
+		cmd.Context(),
+		config.Cfg,
+		fsys,
+	)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := upload(cmd.Context(), fsys, src, s, objects...); err != nil {
+		return err
+	}
+	return ignoreUploaded(fsys, objects...)
 }
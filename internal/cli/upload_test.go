@@ -3,15 +3,16 @@ package cli
 import (
 	"context"
 	"fmt"
-	"io"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/discentem/cavorite/ignore"
 	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/signing"
 	"github.com/discentem/cavorite/stores"
 	"github.com/discentem/cavorite/testutils"
 	"github.com/gonuts/go-shellquote"
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,7 +31,7 @@ func TestUploadCmd(t *testing.T) {
 	subCmd, subArgs, err := uploadCmd.Traverse(args)
 	require.NoError(t, err)
 	assert.NotNil(t, subCmd)
-	assert.Equal(t, subCmd.UseLine(), "upload")
+	assert.Equal(t, subCmd.UseLine(), "upload [path...] [flags]")
 
 	// Test the the subArgs equal the expected expectedUploadCmdArgs and flags
 	assert.NoError(t, subCmd.ParseFlags(subArgs))
@@ -67,7 +68,6 @@ func (s simpleStore) Close() error {
 
 // TestUpload tests whether metadata gets generated correctly
 func TestUpload(t *testing.T) {
-	logger.Init("TestUpload", false, false, io.Discard)
 	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
 	objs := []string{"someFile", "someOtherFile"}
 	sourceFsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
@@ -91,7 +91,7 @@ func TestUpload(t *testing.T) {
 			BackendAddress:        "simpleStore/Test",
 		},
 	}
-	err = upload(context.Background(), *sourceFsys, sStore, objs...)
+	err = upload(context.Background(), *sourceFsys, metadata.NewSidecarSource(*sourceFsys, "cfile"), sStore, objs...)
 	assert.NoError(t, err)
 
 	require.NoError(t, err)
@@ -102,8 +102,11 @@ func TestUpload(t *testing.T) {
 		b, err := afero.ReadFile(sStore.sourceFsys, fmt.Sprintf("%s.%s", f, sopts.MetadataFileExtension))
 		require.NoError(t, err)
 		assert.Equal(t, fmt.Sprintf(`{
+ "schema_version": 2,
  "name": "%s",
+ "algorithm": "sha256",
  "checksum": "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+ "size": 5,
  "date_modified": "2014-11-12T11:45:26.371Z"
 }`, f), string(b))
 	}
@@ -136,7 +139,7 @@ func TestUploadWithPrefix(t *testing.T) {
 			ObjectKeyPrefix:       "aCoolPrefix",
 		},
 	}
-	err = upload(context.Background(), *sourceFsys, sStore, "someFile", "someOtherFile")
+	err = upload(context.Background(), *sourceFsys, metadata.NewSidecarSource(*sourceFsys, "cfile"), sStore, "someFile", "someOtherFile")
 	require.NoError(t, err)
 
 	require.NoError(t, err)
@@ -153,8 +156,11 @@ func TestUploadWithPrefix(t *testing.T) {
 			objkey = f
 		}
 		expected := fmt.Sprintf(`{
+ "schema_version": 2,
  "name": "%s",
+ "algorithm": "sha256",
  "checksum": "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+ "size": 5,
  "date_modified": "2014-11-12T11:45:26.371Z"
 }`, objkey)
 		assert.Equal(t, expected, string(b))
@@ -163,7 +169,6 @@ func TestUploadWithPrefix(t *testing.T) {
 
 // TestUploadPartialFail tests whether metadata generation will succeed for n+1 even if n fails
 func TestUploadPartialFail(t *testing.T) {
-	logger.Init("TestUpload", false, false, io.Discard)
 	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
 	sourceFsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
 		"someFile": {
@@ -178,21 +183,124 @@ func TestUploadPartialFail(t *testing.T) {
 		sourceFsys: *sourceFsys,
 		bucketFsys: *bucket,
 	}
-	err = upload(context.Background(), *sourceFsys, sStore, "someOtherFileThatDoesntExist", "someFile")
+	err = upload(context.Background(), *sourceFsys, metadata.NewSidecarSource(*sourceFsys, "cfile"), sStore, "someOtherFileThatDoesntExist", "someFile")
 
 	// upload is expected for fail for someOtherFileThatDoesntExist as it does not exist in sourceFsys
 	require.ErrorIs(t, err, ErrOpen)
 
-	sopts, err := sStore.GetOptions()
+	_, err = sStore.GetOptions()
 	assert.NoError(t, err)
 	for _, f := range []string{"someFile"} {
-		b, _ := afero.ReadFile(sStore.sourceFsys, fmt.Sprintf("%s.%s", f, sopts.MetadataFileExtension))
+		b, _ := afero.ReadFile(sStore.sourceFsys, fmt.Sprintf("%s.%s", f, metadata.MetadataFileExtension))
 		assert.Equal(t, fmt.Sprintf(`{
+ "schema_version": 2,
  "name": "%s",
+ "algorithm": "sha256",
  "checksum": "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+ "size": 5,
  "date_modified": "2014-11-12T11:45:26.371Z"
 }`, f), string(b))
 	}
 
 	assert.NoError(t, err)
 }
+
+func TestUploadWithoutSigningKey(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, "someFile", []byte("stuff"), 0644))
+	var uploaded []string
+	s, err := newSignedStore(uploadRecorder{uploaded: &uploaded}, &signing.Options{KeyFile: "missing_key"}, fsys)
+	require.NoError(t, err)
+
+	err = upload(context.Background(), fsys, metadata.NewSidecarSource(fsys, "cfile"), s, "someFile")
+	assert.ErrorIs(t, err, ErrWriteMetadataToFsys)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	// nothing is uploaded without metadata that could be signed
+	assert.Empty(t, uploaded)
+	_, err = fsys.Stat("someFile.cfile")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// TestUploadToManifest tests whether metadata is written to the manifest instead of cfiles
+func TestUploadToManifest(t *testing.T) {
+	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
+	sourceFsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"tools/someFile": {
+			ModTime: &mTime,
+			Content: []byte(`stuff`),
+		},
+	})
+	require.NoError(t, err)
+	sStore := simpleStore{
+		sourceFsys: *sourceFsys,
+		options: stores.Options{
+			MetadataFileExtension: "cfile",
+			ObjectKeyPrefix:       "repo",
+		},
+	}
+	src := metadata.NewManifestSource(*sourceFsys, metadata.DefaultManifest)
+	require.NoError(t, upload(context.Background(), *sourceFsys, src, sStore, "tools/someFile"))
+	require.NoError(t, src.Close())
+
+	exists, err := afero.Exists(*sourceFsys, "tools/someFile.cfile")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	m, err := metadata.NewManifestSource(*sourceFsys, metadata.DefaultManifest).Get("tools/someFile")
+	require.NoError(t, err)
+	assert.Equal(t, "repo/tools/someFile", m.Name)
+	assert.Equal(t, "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0", m.Checksum)
+}
+
+func TestUploadPaths(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		".cavoriteignore":            {Content: []byte("*.tmp\n/build/\n")},
+		"assets/.cavoriteignore":     {Content: []byte(".DS_Store\n")},
+		"assets/.DS_Store":           {Content: []byte("litter")},
+		"assets/logo.png":            {Content: []byte("png")},
+		"assets/logo.png.cfile":      {Content: []byte("{}")},
+		"assets/sounds/boom.wav":     {Content: []byte("wav")},
+		"assets/sounds/boom.wav.tmp": {Content: []byte("wav")},
+		"build/game.pak":             {Content: []byte("pak")},
+		"game.bin":                   {Content: []byte("bin")},
+		"notes.tmp":                  {Content: []byte("tmp")},
+		".git/objects/pack":          {Content: []byte("pack")},
+		"sdk/include/sdk.h":          {Content: []byte("h")},
+		"sdk.cfile":                  {Content: []byte(`{"name": "sdk", "checksum": "abc", "archive": {"format": "tar", "entries": 2}}`)},
+	})
+	require.NoError(t, err)
+	ignores := ignore.NewMatcher(*fsys, ".")
+	src := metadata.NewSidecarSource(*fsys, "cfile")
+
+	objects, err := uploadPaths(*fsys, src, ignores, "cfile", ".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"assets/logo.png", "assets/sounds/boom.wav", "game.bin"}, objects)
+
+	// explicit files are subject to the same rules and are only returned once
+	objects, err = uploadPaths(*fsys, src, ignores, "cfile", "game.bin", "notes.tmp", "build/game.pak", "assets/logo.png.cfile", "assets", "assets/logo.png", "sdk")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"game.bin", "assets/logo.png", "assets/sounds/boom.wav"}, objects)
+
+	_, err = uploadPaths(*fsys, src, ignores, "cfile", "missing")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+}
+
+func TestTrackedObjects(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		".cavoriteignore":        {Content: []byte("*.tmp\n")},
+		"assets/logo.png":        {Content: []byte("stuff")},
+		"assets/logo.png.cfile":  {Content: []byte(fmt.Sprintf(stuffCfile, "assets/logo.png", "sha256", stuffSHA256))},
+		"assets/boom.wav":        {Content: []byte("changed")},
+		"assets/boom.wav.cfile":  {Content: []byte(fmt.Sprintf(stuffCfile, "assets/boom.wav", "sha256", stuffSHA256))},
+		"assets/new.png":         {Content: []byte("new")},
+		"assets/new.png.tmp":     {Content: []byte("new")},
+		"installers/app.dmg":     {Content: []byte("dmg")},
+		"installers/README.md":   {Content: []byte("# installers")},
+		"installers/old.dmg.tmp": {Content: []byte("dmg")},
+	})
+	require.NoError(t, err)
+	track, err := ignore.ParsePatterns("*.dmg", "assets/**")
+	require.NoError(t, err)
+	objects, err := trackedObjects(*fsys, metadata.NewSidecarSource(*fsys, "cfile"), ignore.NewMatcher(*fsys, "."), "cfile", track)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"assets/boom.wav", "assets/new.png", "installers/app.dmg"}, objects)
+}
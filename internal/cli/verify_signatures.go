@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/metadata"
+	cavoriteObjLib "github.com/discentem/cavorite/objects"
+	"github.com/discentem/cavorite/signing"
+)
+
+var (
+	ErrSigningNotConfigured = errors.New("signing.trusted_keys must be set in .cavorite/config to verify signatures")
+	ErrSignaturesInvalid    = errors.New("some cfiles are not signed by a trusted key")
+)
+
+// verifySignatures checks the metadata of every object below roots against verifier and writes
+// one line per object to w. Metadata must name the object it is kept for, under prefix.
+func verifySignatures(ctx context.Context, src metadata.Source, verifier *signing.Verifier, prefix cavoriteObjLib.AddPrefixToKey, w io.Writer, roots ...string) error {
+	objects, err := src.List(roots...)
+	if err != nil {
+		return err
+	}
+	r := reportFrom(ctx)
+	failed := 0
+	for _, obj := range objects {
+		start := time.Now()
+		cfile := src.Location(obj)
+		m, err := src.Get(obj)
+		if err == nil {
+			err = verifier.VerifyKey(*m, prefix.Modify(obj))
+		}
+		r.add(newObjectResult(obj, outcomeOK, m, start, err))
+		switch {
+		case err == nil:
+			fmt.Fprintf(w, "OK       %s (%s)\n", cfile, m.Signature.Key)
+			continue
+		case errors.Is(err, signing.ErrUnsigned):
+			fmt.Fprintf(w, "UNSIGNED %s\n", cfile)
+		default:
+			fmt.Fprintf(w, "INVALID  %s: %v\n", cfile, err)
+		}
+		failed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrSignaturesInvalid, failed, len(objects))
+	}
+	return nil
+}
+
+func verifySignaturesCmd() *cobra.Command {
+	verifySignaturesCmd := &cobra.Command{
+		Use:   "verify-signatures [path...]",
+		Short: "Check that every cfile is signed by a trusted key",
+		Long:  "Check that every cfile below the given paths, or the whole repo if none are given, is signed by a key in signing.trusted_keys",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: verifySignaturesFn,
+	}
+	return verifySignaturesCmd
+}
+
+func verifySignaturesFn(cmd *cobra.Command, paths []string) error {
+	if config.Cfg.Signing == nil || len(config.Cfg.Signing.TrustedKeys) == 0 {
+		return ErrSigningNotConfigured
+	}
+	verifier, err := signing.NewVerifier(config.Cfg.Signing.TrustedKeys)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	src := newMetadataSource(config.Cfg, afero.NewOsFs())
+	defer src.Close()
+	prefix := cavoriteObjLib.AddPrefixToKey{Prefix: config.Cfg.Options.ObjectKeyPrefix}
+	return verifySignatures(cmd.Context(), src, verifier, prefix, cmd.OutOrStdout(), paths...)
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/discentem/cavorite/metadata"
+	cavoriteObjLib "github.com/discentem/cavorite/objects"
+	"github.com/discentem/cavorite/signing"
+)
+
+func TestVerifySignatures(t *testing.T) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	sshSigner, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	signer := signing.NewSigner(sshSigner)
+	verifier, err := signing.NewVerifier([]string{string(ssh.MarshalAuthorizedKey(sshSigner.PublicKey()))})
+	require.NoError(t, err)
+
+	fsys := afero.NewMemMapFs()
+	writeCfile := func(path string, m metadata.ObjectMetaData) {
+		b, err := json.Marshal(m)
+		require.NoError(t, err)
+		require.NoError(t, afero.WriteFile(fsys, path, b, 0644))
+	}
+	good := metadata.ObjectMetaData{Name: "repo/a/good", Checksum: "1"}
+	require.NoError(t, signer.Sign(&good))
+	writeCfile("repo/a/good.cfile", good)
+	require.NoError(t, afero.WriteFile(fsys, "repo/a/good", []byte("good"), 0644))
+	writeCfile("repo/b/unsigned.cfile", metadata.ObjectMetaData{Name: "repo/b/unsigned", Checksum: "2"})
+	// a signed cfile copied next to another object
+	writeCfile("repo/c/copied.cfile", good)
+	// cfiles inside .git and .cavorite are ignored
+	writeCfile("repo/.git/objects.cfile", metadata.ObjectMetaData{})
+	writeCfile("repo/.cavorite/state.cfile", metadata.ObjectMetaData{})
+
+	cfiles, err := walkCfiles(fsys, "", "repo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"repo/a/good.cfile", "repo/b/unsigned.cfile", "repo/c/copied.cfile"}, cfiles)
+
+	var out bytes.Buffer
+	assert.NoError(t, verifySignatures(context.Background(), metadata.NewSidecarSource(fsys, "cfile"), verifier, cavoriteObjLib.AddPrefixToKey{}, &out, "repo/a"))
+	assert.Contains(t, out.String(), "OK       repo/a/good.cfile")
+
+	out.Reset()
+	err = verifySignatures(context.Background(), metadata.NewSidecarSource(fsys, "cfile"), verifier, cavoriteObjLib.AddPrefixToKey{}, &out, "repo")
+	assert.ErrorIs(t, err, ErrSignaturesInvalid)
+	assert.Contains(t, out.String(), "UNSIGNED repo/b/unsigned.cfile")
+	assert.Contains(t, out.String(), "INVALID  repo/c/copied.cfile: cfile is signed for another object")
+
+	// keys carry the object key prefix
+	out.Reset()
+	err = verifySignatures(context.Background(), metadata.NewSidecarSource(fsys, "cfile"), verifier, cavoriteObjLib.AddPrefixToKey{Prefix: "team"}, &out, "repo/a")
+	assert.ErrorIs(t, err, ErrSignaturesInvalid)
+	assert.Contains(t, out.String(), "INVALID  repo/a/good.cfile")
+}
@@ -0,0 +1,22 @@
+package cli
+
+import (
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/fileutils"
+	"github.com/discentem/cavorite/metadata"
+)
+
+// walkCfiles returns every file with extension ext below each root, in lexical order.
+// If ext is empty, metadata.MetadataFileExtension is used.
+func walkCfiles(fsys afero.Fs, ext string, roots ...string) ([]string, error) {
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	suffix := "." + strings.TrimPrefix(ext, ".")
+	return fileutils.Walk(fsys, func(path string) bool {
+		return strings.HasSuffix(path, suffix)
+	}, roots...)
+}
@@ -0,0 +1,307 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/discentem/cavorite/config"
+	"github.com/discentem/cavorite/fileutils"
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/program"
+	"github.com/discentem/cavorite/stores"
+)
+
+// defaultDebounce is how long files must stop changing before they are uploaded
+const defaultDebounce = 2 * time.Second
+
+// watcher uploads objects as they change
+type watcher struct {
+	fsys    afero.Fs
+	ignores *ignore.Matcher
+	ext     string
+	// track, if not nil, matches files that are uploaded even if they have no metadata yet
+	track *ignore.Patterns
+	// newSource returns the metadata of the repo as it is now, it is closed after every upload so
+	// that manifests are written
+	newSource func() metadata.Source
+	s         stores.Store
+	// debounce is how long files must stop changing before they are uploaded
+	debounce time.Duration
+	// afterUpload, if not nil, is called with the objects that were uploaded
+	afterUpload func(objects ...string) error
+}
+
+// shouldUpload reports whether path, which changed, is an object that src has no up to date
+// metadata for and that is either tracked or already has metadata
+func (w *watcher) shouldUpload(src metadata.Source, path string) (bool, error) {
+	ext := w.ext
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	if strings.HasSuffix(path, "."+strings.TrimPrefix(ext, ".")) || filepath.Base(path) == ignore.FileName {
+		return false, nil
+	}
+	info, err := w.fsys.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// deleted or renamed again before the upload
+		return false, nil
+	}
+	if err != nil || info.IsDir() {
+		return false, err
+	}
+	if ignored, err := w.ignores.Ignored(path, false); err != nil || ignored {
+		return false, err
+	}
+	m, err := src.Get(path)
+	if err != nil {
+		return w.track != nil && w.track.Match(path, false), nil
+	}
+	// retrieving objects changes them too
+	return shouldRetrieve(w.fsys, m, path)
+}
+
+// flush uploads the objects among paths that changed
+func (w *watcher) flush(ctx context.Context, paths []string) (err error) {
+	src := w.newSource()
+	defer func() { err = multierr.Append(err, src.Close()).ErrorOrNil() }()
+	var objects []string
+	for _, path := range paths {
+		ok, err := w.shouldUpload(src, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if ok {
+			objects = append(objects, path)
+		}
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+	if err := upload(ctx, w.fsys, src, w.s, objects...); err != nil {
+		return err
+	}
+	slog.Info("uploaded objects", "objects", objects)
+	if w.afterUpload != nil {
+		return w.afterUpload(objects...)
+	}
+	return nil
+}
+
+// run uploads the paths received from changes once none of them changed for the debounce period,
+// until changes is closed or ctx is done. Changes keep being received while an upload is in
+// progress and are uploaded after it. An upload in progress is finished before run returns.
+func (w *watcher) run(ctx context.Context, changes <-chan string) error {
+	pending := make(map[string]bool)
+	var quiet <-chan time.Time
+	// flushed is not nil while an upload is in progress and is closed once it finished
+	var flushed chan struct{}
+	flush := func() {
+		paths := make([]string, 0, len(pending))
+		for path := range pending {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		pending = make(map[string]bool)
+		flushed = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			// the upload is not interrupted by a signal so that cfiles are always written
+			if err := w.flush(context.WithoutCancel(ctx), paths); err != nil {
+				slog.Error("upload failed", "error", err)
+			}
+		}(flushed)
+	}
+	wait := func() {
+		if flushed != nil {
+			<-flushed
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			wait()
+			if len(pending) > 0 {
+				slog.Warn("stopping without uploading changed files", "pending", len(pending))
+			}
+			return nil
+		case path, ok := <-changes:
+			if !ok {
+				wait()
+				return nil
+			}
+			pending[filepath.Clean(path)] = true
+			quiet = time.After(w.debounce)
+		case <-quiet:
+			quiet = nil
+			// otherwise the files are uploaded once the upload in progress finished
+			if flushed == nil {
+				flush()
+			}
+		case <-flushed:
+			flushed = nil
+			// files that stopped changing during the upload
+			if quiet == nil && len(pending) > 0 {
+				flush()
+			}
+		}
+	}
+}
+
+// watchTree sends the paths, relative to root, of files that are created, written or renamed below
+// root to changes until ctx is done. Directories for which skip returns true are not watched. If
+// events were dropped, every file below root is sent.
+func watchTree(ctx context.Context, root string, skip func(path string) bool, changes chan<- string) error {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fw.Close()
+	// send gives up once ctx is done since nothing receives changes anymore
+	send := func(path string) {
+		select {
+		case changes <- path:
+		case <-ctx.Done():
+		}
+	}
+	// add watches dir and every directory below it, sending the files in them if existing is set since
+	// they may have been written before the watch was added
+	add := func(dir string, existing bool) error {
+		return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				if existing {
+					send(rel)
+				}
+				return nil
+			}
+			if rel != "." && (fileutils.SkippedDirs[d.Name()] || skip(rel)) {
+				return filepath.SkipDir
+			}
+			return fw.Add(path)
+		})
+	}
+	if err := add(root, false); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-fw.Errors:
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				slog.Warn("watching", "path", root, "error", err)
+				continue
+			}
+			// events were dropped, so any file may have changed
+			slog.Warn("too many changes to watch, rescanning", "path", root)
+			if err := add(root, true); err != nil {
+				slog.Warn("watching", "path", root, "error", err)
+			}
+		case event := <-fw.Events:
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+			info, err := os.Stat(event.Name)
+			if err != nil {
+				continue
+			}
+			if info.IsDir() {
+				if err := add(event.Name, true); err != nil {
+					slog.Warn("watching", "path", event.Name, "error", err)
+				}
+				continue
+			}
+			rel, err := filepath.Rel(root, event.Name)
+			if err != nil {
+				return err
+			}
+			send(rel)
+		}
+	}
+}
+
+func watchCmd() *cobra.Command {
+	watchCmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Upload objects whenever they change",
+		Long: fmt.Sprintf(`Watch the repo in the working directory and upload files that have a cfile or match a
+tracked pattern whenever they change, once they stopped changing for --debounce. Their cfiles are
+rewritten after every upload. %s watch stops on interrupt or SIGTERM after finishing the upload in
+progress.`, program.Name),
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return config.Load(afero.NewOsFs())
+		},
+		RunE: watchFn,
+	}
+	watchCmd.Flags().Duration("debounce", defaultDebounce, "How long files must stop changing before they are uploaded")
+	return watchCmd
+}
+
+func watchFn(cmd *cobra.Command, _ []string) (err error) {
+	debounce, err := cmd.Flags().GetDuration("debounce")
+	if err != nil {
+		return err
+	}
+	track, err := trackPatterns(config.Cfg)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fsys := afero.NewOsFs()
+	s, err := initStoreFromConfig(ctx, config.Cfg, fsys)
+	if err != nil {
+		return err
+	}
+	defer func() { err = multierr.Append(err, s.Close()).ErrorOrNil() }()
+	ignores := ignore.NewMatcher(fsys, ".")
+	w := &watcher{
+		fsys:    fsys,
+		ignores: ignores,
+		ext:     config.Cfg.Options.MetadataFileExtension,
+		track:   track,
+		newSource: func() metadata.Source {
+			return newMetadataSource(config.Cfg, fsys)
+		},
+		s:        s,
+		debounce: debounce,
+		afterUpload: func(objects ...string) error {
+			return ignoreUploaded(fsys, objects...)
+		},
+	}
+	changes := make(chan string)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watchTree(ctx, ".", func(path string) bool {
+			ignored, err := ignores.Ignored(path, true)
+			return err == nil && ignored
+		}, changes)
+		stop()
+	}()
+	slog.Info("watching for changes, interrupt to stop")
+	runErr := w.run(ctx, changes)
+	return multierr.Append(runErr, <-watchErr).ErrorOrNil()
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/ignore"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/testutils"
+)
+
+func TestWatcherRun(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		".cavoriteignore":       {Content: []byte("*.tmp\n")},
+		"app.dmg":               {Content: []byte("dmg")},
+		"notes.txt":             {Content: []byte("notes")},
+		"scratch.dmg.tmp":       {Content: []byte("dmg")},
+		"tools/changed":         {Content: []byte("changed")},
+		"tools/changed.cfile":   {Content: []byte(fmt.Sprintf(stuffCfile, "tools/changed", "sha256", stuffSHA256))},
+		"tools/retrieved":       {Content: []byte("stuff")},
+		"tools/retrieved.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/retrieved", "sha256", stuffSHA256))},
+	})
+	require.NoError(t, err)
+	track, err := ignore.ParsePatterns("*.dmg*")
+	require.NoError(t, err)
+	var uploaded []string
+	done := make(chan []string)
+	w := &watcher{
+		fsys:    *fsys,
+		ignores: ignore.NewMatcher(*fsys, "."),
+		ext:     "cfile",
+		track:   track,
+		newSource: func() metadata.Source {
+			return metadata.NewSidecarSource(*fsys, "cfile")
+		},
+		s: uploadRecorder{
+			simpleStore: simpleStore{options: stores.Options{MetadataFileExtension: "cfile"}},
+			uploaded:    &uploaded,
+		},
+		debounce: 10 * time.Millisecond,
+		afterUpload: func(objects ...string) error {
+			done <- objects
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string)
+	runErr := make(chan error, 1)
+	go func() { runErr <- w.run(ctx, changes) }()
+	for _, path := range []string{
+		"app.dmg",
+		"./app.dmg",
+		"app.dmg.cfile",
+		"missing.dmg",
+		"notes.txt",
+		"scratch.dmg.tmp",
+		"tools",
+		"tools/changed",
+		"tools/retrieved",
+	} {
+		changes <- path
+	}
+	select {
+	case objects := <-done:
+		assert.Equal(t, []string{"app.dmg", "tools/changed"}, objects)
+	case <-time.After(10 * time.Second):
+		t.Fatal("nothing was uploaded")
+	}
+	assert.Equal(t, []string{"app.dmg", "tools/changed"}, uploaded)
+	_, err = (*fsys).Stat("app.dmg.cfile")
+	assert.NoError(t, err)
+
+	// the cfiles now match, so changes that do not alter content upload nothing
+	changes <- "app.dmg"
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	require.NoError(t, <-runErr)
+	assert.Equal(t, []string{"app.dmg", "tools/changed"}, uploaded)
+}
+
+// blockingStore is an uploadRecorder whose first upload closes started and waits until release is
+// closed
+type blockingStore struct {
+	uploadRecorder
+	once    *sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStore) Upload(ctx context.Context, objects ...string) error {
+	s.once.Do(func() {
+		close(s.started)
+		<-s.release
+	})
+	return s.uploadRecorder.Upload(ctx, objects...)
+}
+
+func TestWatcherRunDuringUpload(t *testing.T) {
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"app.dmg":             {Content: []byte("dmg")},
+		"tools/changed":       {Content: []byte("changed")},
+		"tools/changed.cfile": {Content: []byte(fmt.Sprintf(stuffCfile, "tools/changed", "sha256", stuffSHA256))},
+	})
+	require.NoError(t, err)
+	track, err := ignore.ParsePatterns("*.dmg")
+	require.NoError(t, err)
+	var uploaded []string
+	s := blockingStore{
+		uploadRecorder: uploadRecorder{
+			simpleStore: simpleStore{options: stores.Options{MetadataFileExtension: "cfile"}},
+			uploaded:    &uploaded,
+		},
+		once:    &sync.Once{},
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	done := make(chan []string)
+	w := &watcher{
+		fsys:    *fsys,
+		ignores: ignore.NewMatcher(*fsys, "."),
+		ext:     "cfile",
+		track:   track,
+		newSource: func() metadata.Source {
+			return metadata.NewSidecarSource(*fsys, "cfile")
+		},
+		s:        s,
+		debounce: 10 * time.Millisecond,
+		afterUpload: func(objects ...string) error {
+			done <- objects
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string)
+	runErr := make(chan error, 1)
+	go func() { runErr <- w.run(ctx, changes) }()
+
+	changes <- "tools/changed"
+	select {
+	case <-s.started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("nothing was uploaded")
+	}
+	// changes are received while the upload is in progress
+	require.NoError(t, afero.WriteFile(*fsys, "app.dmg", []byte("new dmg"), 0644))
+	select {
+	case changes <- "app.dmg":
+	case <-time.After(10 * time.Second):
+		t.Fatal("changes are not received during an upload")
+	}
+	close(s.release)
+	for _, want := range [][]string{{"tools/changed"}, {"app.dmg"}} {
+		select {
+		case objects := <-done:
+			assert.Equal(t, want, objects)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%v was not uploaded", want)
+		}
+	}
+	cancel()
+	require.NoError(t, <-runErr)
+	assert.Equal(t, []string{"tools/changed", "app.dmg"}, uploaded)
+}
+
+func TestWatchTree(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, ".git"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "build"), 0755))
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan string)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watchTree(ctx, root, func(path string) bool { return path == "build" }, changes)
+	}()
+	// wait for the watches to be added, there is no way to know when they are
+	time.Sleep(100 * time.Millisecond)
+	fsys := afero.NewBasePathFs(afero.NewOsFs(), root)
+	require.NoError(t, afero.WriteFile(fsys, ".git/index", []byte("index"), 0644))
+	require.NoError(t, afero.WriteFile(fsys, "build/out", []byte("out"), 0644))
+	// the file may be written before the new directory is watched
+	require.NoError(t, fsys.MkdirAll("tools", 0755))
+	require.NoError(t, afero.WriteFile(fsys, "tools/tool", []byte("tool"), 0644))
+	select {
+	case path := <-changes:
+		assert.Equal(t, filepath.Join("tools", "tool"), path)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no change was reported")
+	}
+	cancel()
+	require.NoError(t, <-watchErr)
+}
@@ -0,0 +1,190 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const mediaType = "application/vnd.git-lfs+json"
+
+var ErrServer = errors.New("git lfs server error")
+
+var _ = Fetcher(&Client{})
+
+// Client talks to a Git LFS server using the batch API and the basic transfer adapter.
+// Credentials can be given as the userinfo of Endpoint.
+type Client struct {
+	// Endpoint is the LFS url of the repo, e.g. https://example.com/org/repo.git/info/lfs
+	Endpoint   string
+	HTTPClient *http.Client
+}
+
+func NewClient(endpoint string) *Client {
+	return &Client{
+		Endpoint:   strings.TrimSuffix(endpoint, "/"),
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+type batchObject struct {
+	Oid     string                 `json:"oid"`
+	Size    int64                  `json:"size"`
+	Actions map[string]batchAction `json:"actions,omitempty"`
+	Error   *batchError            `json:"error,omitempty"`
+}
+
+type batchAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header,omitempty"`
+}
+
+type batchError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type batchRequest struct {
+	Operation string        `json:"operation"`
+	Transfers []string      `json:"transfers"`
+	Objects   []batchObject `json:"objects"`
+	HashAlgo  string        `json:"hash_algo"`
+}
+
+type batchResponse struct {
+	Objects []batchObject `json:"objects"`
+}
+
+// batch asks the server how to transfer the object p points to
+func (c *Client) batch(ctx context.Context, operation string, p Pointer) (*batchObject, error) {
+	body, err := json.Marshal(batchRequest{
+		Operation: operation,
+		Transfers: []string{"basic"},
+		Objects:   []batchObject{{Oid: p.Oid, Size: p.Size}},
+		HashAlgo:  "sha256",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", mediaType)
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var br batchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&br); err != nil {
+		return nil, fmt.Errorf("%w: invalid batch response: %v", ErrServer, err)
+	}
+	for _, obj := range br.Objects {
+		if obj.Oid != p.Oid {
+			continue
+		}
+		if obj.Error != nil {
+			return nil, fmt.Errorf("%w: %s: %d %s", ErrServer, p.Oid, obj.Error.Code, obj.Error.Message)
+		}
+		return &obj, nil
+	}
+	return nil, fmt.Errorf("%w: %s missing from batch response", ErrServer, p.Oid)
+}
+
+// do sends req and returns an error for any status other than 2xx
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%w: %s %s: %s: %s", ErrServer, req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+func (c *Client) newActionRequest(ctx context.Context, method string, action batchAction, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, action.Href, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range action.Header {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+func (c *Client) Fetch(ctx context.Context, p Pointer) (io.ReadCloser, error) {
+	obj, err := c.batch(ctx, "download", p)
+	if err != nil {
+		return nil, err
+	}
+	action, ok := obj.Actions["download"]
+	if !ok {
+		return nil, fmt.Errorf("%w: no download action for %s", ErrServer, p.Oid)
+	}
+	req, err := c.newActionRequest(ctx, http.MethodGet, action, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Push uploads r, the object p points to, unless the server already has it
+func (c *Client) Push(ctx context.Context, p Pointer, r io.Reader) error {
+	obj, err := c.batch(ctx, "upload", p)
+	if err != nil {
+		return err
+	}
+	upload, ok := obj.Actions["upload"]
+	if !ok {
+		// the server already has the object
+		return nil
+	}
+	req, err := c.newActionRequest(ctx, http.MethodPut, upload, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = p.Size
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	verify, ok := obj.Actions["verify"]
+	if !ok {
+		return nil
+	}
+	body, err := json.Marshal(batchObject{Oid: p.Oid, Size: p.Size})
+	if err != nil {
+		return err
+	}
+	req, err = c.newActionRequest(ctx, http.MethodPost, verify, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", mediaType)
+	req.Header.Set("Content-Type", mediaType)
+	resp, err = c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,127 @@
+package lfs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer is a minimal Git LFS server keeping objects in memory
+type fakeServer struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	verified []string
+	url      string
+}
+
+func newFakeServer(t *testing.T, objects map[string][]byte) *fakeServer {
+	fs := &fakeServer{objects: objects}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repo.git/info/lfs/objects/batch", fs.batch)
+	mux.HandleFunc("/objects/", fs.object)
+	mux.HandleFunc("/verify", fs.verify)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	fs.url = srv.URL
+	return fs
+}
+
+func (fs *fakeServer) batch(w http.ResponseWriter, r *http.Request) {
+	user, pass, _ := r.BasicAuth()
+	if user != "user" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var resp batchResponse
+	for _, obj := range req.Objects {
+		_, ok := fs.objects[obj.Oid]
+		action := batchAction{Href: fs.url + "/objects/" + obj.Oid, Header: map[string]string{"X-Token": "t"}}
+		switch {
+		case req.Operation == "download" && ok:
+			obj.Actions = map[string]batchAction{"download": action}
+		case req.Operation == "download":
+			obj.Error = &batchError{Code: 404, Message: "Object does not exist"}
+		case req.Operation == "upload" && !ok:
+			obj.Actions = map[string]batchAction{"upload": action, "verify": {Href: fs.url + "/verify"}}
+		}
+		resp.Objects = append(resp.Objects, obj)
+	}
+	w.Header().Set("Content-Type", mediaType)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (fs *fakeServer) object(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Token") != "t" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	oid := strings.TrimPrefix(r.URL.Path, "/objects/")
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		_, _ = w.Write(fs.objects[oid])
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		fs.objects[oid] = b
+	}
+}
+
+func (fs *fakeServer) verify(w http.ResponseWriter, r *http.Request) {
+	var obj batchObject
+	_ = json.NewDecoder(r.Body).Decode(&obj)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.verified = append(fs.verified, obj.Oid)
+}
+
+func (fs *fakeServer) endpoint(userinfo string) string {
+	return strings.Replace(fs.url, "://", "://"+userinfo+"@", 1) + "/repo.git/info/lfs/"
+}
+
+func TestClientFetch(t *testing.T) {
+	srv := newFakeServer(t, map[string][]byte{stuffOid: []byte("stuff")})
+	c := NewClient(srv.endpoint("user:secret"))
+
+	rc, err := c.Fetch(context.Background(), stuffPointer)
+	require.NoError(t, err)
+	defer rc.Close()
+	require.NoError(t, stuffPointer.Verify(io.Discard, rc))
+
+	_, err = c.Fetch(context.Background(), Pointer{Oid: strings.Repeat("0", 64), Size: 1})
+	assert.ErrorIs(t, err, ErrServer)
+	assert.ErrorContains(t, err, "Object does not exist")
+
+	_, err = NewClient(srv.endpoint("user:wrong")).Fetch(context.Background(), stuffPointer)
+	assert.ErrorIs(t, err, ErrServer)
+	assert.ErrorContains(t, err, "401")
+	assert.NotContains(t, err.Error(), "wrong")
+}
+
+func TestClientPush(t *testing.T) {
+	srv := newFakeServer(t, map[string][]byte{})
+	c := NewClient(srv.endpoint("user:secret"))
+
+	require.NoError(t, c.Push(context.Background(), stuffPointer, strings.NewReader("stuff")))
+	assert.Equal(t, []byte("stuff"), srv.objects[stuffOid])
+	assert.Equal(t, []string{stuffOid}, srv.verified)
+
+	// the server already has it, so nothing is sent
+	require.NoError(t, c.Push(context.Background(), stuffPointer, strings.NewReader("")))
+	assert.Equal(t, []string{stuffOid}, srv.verified)
+}
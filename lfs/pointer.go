@@ -0,0 +1,102 @@
+// Package lfs reads and writes Git LFS pointer files and the object storage behind them
+// (https://github.com/git-lfs/git-lfs/blob/main/docs/spec.md) so that repos can be moved
+// between Git LFS and cavorite.
+package lfs
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Version is the pointer format version written by every current Git LFS client
+	Version = "https://git-lfs.github.com/spec/v1"
+	// MaxPointerSize is the size above which a file is never a pointer file
+	MaxPointerSize = 1024
+)
+
+var (
+	ErrNotPointer       = errors.New("not a git lfs pointer file")
+	ErrChecksumMismatch = errors.New("object does not match its git lfs pointer")
+)
+
+// Pointer is the content of a Git LFS pointer file
+type Pointer struct {
+	// Oid is the hex encoded sha256 of the object
+	Oid  string
+	Size int64
+}
+
+// ParsePointer parses a pointer file. It returns ErrNotPointer for anything that is not one.
+func ParsePointer(r io.Reader) (*Pointer, error) {
+	b, err := io.ReadAll(io.LimitReader(r, MaxPointerSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxPointerSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrNotPointer, MaxPointerSize)
+	}
+	s := bufio.NewScanner(strings.NewReader(string(b)))
+	var p Pointer
+	seenSize := false
+	for line := 0; s.Scan(); line++ {
+		key, value, ok := strings.Cut(s.Text(), " ")
+		if !ok {
+			return nil, fmt.Errorf("%w: malformed line %q", ErrNotPointer, s.Text())
+		}
+		if line == 0 {
+			if key != "version" || value != Version {
+				return nil, fmt.Errorf("%w: unsupported version line %q", ErrNotPointer, s.Text())
+			}
+			continue
+		}
+		switch key {
+		case "oid":
+			oid, ok := strings.CutPrefix(value, "sha256:")
+			if b, err := hex.DecodeString(oid); !ok || err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("%w: oid %q is not a sha256", ErrNotPointer, value)
+			}
+			p.Oid = oid
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("%w: invalid size %q", ErrNotPointer, value)
+			}
+			p.Size = size
+			seenSize = true
+		}
+		// other keys are extensions, which do not change the object
+	}
+	if p.Oid == "" || !seenSize {
+		return nil, fmt.Errorf("%w: oid or size missing", ErrNotPointer)
+	}
+	return &p, nil
+}
+
+// String returns the pointer file for p
+func (p Pointer) String() string {
+	return fmt.Sprintf("version %s\noid sha256:%s\nsize %d\n", Version, p.Oid, p.Size)
+}
+
+// Verify copies r to w and returns ErrChecksumMismatch if r is not the object p points to.
+// w may be io.Discard.
+func (p Pointer) Verify(w io.Writer, r io.Reader) error {
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, h), r)
+	if err != nil {
+		return err
+	}
+	if size != p.Size {
+		return fmt.Errorf("%w: got %d bytes, want %d", ErrChecksumMismatch, size, p.Size)
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != p.Oid {
+		return fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, actual, p.Oid)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package lfs
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stuffOid = "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0"
+
+var stuffPointer = Pointer{Oid: stuffOid, Size: 5}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		name    string
+		pointer string
+		wantErr error
+	}{
+		{
+			name:    "valid",
+			pointer: "version https://git-lfs.github.com/spec/v1\noid sha256:" + stuffOid + "\nsize 5\n",
+		},
+		{
+			name:    "with extension",
+			pointer: "version https://git-lfs.github.com/spec/v1\next-0-foo sha256:" + stuffOid + "\noid sha256:" + stuffOid + "\nsize 5\n",
+		},
+		{
+			name:    "binary file",
+			pointer: "\x00\x01\x02",
+			wantErr: ErrNotPointer,
+		},
+		{
+			name:    "unknown version",
+			pointer: "version https://hawser.github.com/spec/v1\noid sha256:" + stuffOid + "\nsize 5\n",
+			wantErr: ErrNotPointer,
+		},
+		{
+			name:    "not sha256",
+			pointer: "version https://git-lfs.github.com/spec/v1\noid sha1:5eee38381388b6f30efdd5c5c6f067dbf32c0bb3\nsize 5\n",
+			wantErr: ErrNotPointer,
+		},
+		{
+			name:    "missing size",
+			pointer: "version https://git-lfs.github.com/spec/v1\noid sha256:" + stuffOid + "\n",
+			wantErr: ErrNotPointer,
+		},
+		{
+			name:    "too large",
+			pointer: "version https://git-lfs.github.com/spec/v1\noid sha256:" + stuffOid + "\nsize 5\n" + strings.Repeat("x", MaxPointerSize),
+			wantErr: ErrNotPointer,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParsePointer(strings.NewReader(test.pointer))
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, stuffPointer, *p)
+		})
+	}
+}
+
+func TestPointerString(t *testing.T) {
+	s := stuffPointer.String()
+	assert.Equal(t, "version https://git-lfs.github.com/spec/v1\noid sha256:"+stuffOid+"\nsize 5\n", s)
+	p, err := ParsePointer(strings.NewReader(s))
+	require.NoError(t, err)
+	assert.Equal(t, stuffPointer, *p)
+}
+
+func TestVerify(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, stuffPointer.Verify(&out, strings.NewReader("stuff")))
+	assert.Equal(t, "stuff", out.String())
+
+	assert.ErrorIs(t, stuffPointer.Verify(io.Discard, strings.NewReader("other stuff")), ErrChecksumMismatch)
+	assert.ErrorIs(t, stuffPointer.Verify(io.Discard, strings.NewReader("stuf")), ErrChecksumMismatch)
+}
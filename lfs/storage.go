@@ -0,0 +1,65 @@
+package lfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// DefaultObjectsDir is where Git LFS keeps objects, relative to the root of the repo
+var DefaultObjectsDir = filepath.Join(".git", "lfs", "objects")
+
+// Fetcher returns the content of LFS objects
+type Fetcher interface {
+	// Fetch returns the object p points to. The content is not verified.
+	Fetch(ctx context.Context, p Pointer) (io.ReadCloser, error)
+}
+
+var _ = Fetcher(LocalStorage{})
+
+// LocalStorage is the object directory of a local Git LFS repo
+type LocalStorage struct {
+	Fsys afero.Fs
+	Dir  string
+}
+
+// Path returns where the object p points to is stored
+func (l LocalStorage) Path(p Pointer) string {
+	return filepath.Join(l.Dir, p.Oid[0:2], p.Oid[2:4], p.Oid)
+}
+
+func (l LocalStorage) Fetch(_ context.Context, p Pointer) (io.ReadCloser, error) {
+	return l.Fsys.Open(l.Path(p))
+}
+
+// Store copies r into the storage if it is the object p points to. Objects that are already
+// stored are left alone.
+func (l LocalStorage) Store(p Pointer, r io.Reader) error {
+	path := l.Path(p)
+	if info, err := l.Fsys.Stat(path); err == nil && info.Size() == p.Size {
+		return nil
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := l.Fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := l.Fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = p.Verify(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = l.Fsys.Remove(tmp)
+		return err
+	}
+	return l.Fsys.Rename(tmp, path)
+}
@@ -0,0 +1,33 @@
+package lfs
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalStorage(t *testing.T) {
+	storage := LocalStorage{Fsys: afero.NewMemMapFs(), Dir: DefaultObjectsDir}
+	assert.Equal(t, filepath.Join(".git", "lfs", "objects", "35", "ba", stuffOid), storage.Path(stuffPointer))
+
+	_, err := storage.Fetch(context.Background(), stuffPointer)
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+
+	assert.ErrorIs(t, storage.Store(stuffPointer, strings.NewReader("other stuff")), ErrChecksumMismatch)
+	_, err = storage.Fsys.Stat(storage.Path(stuffPointer) + ".tmp")
+	assert.ErrorIs(t, err, afero.ErrFileNotFound)
+
+	require.NoError(t, storage.Store(stuffPointer, strings.NewReader("stuff")))
+	rc, err := storage.Fetch(context.Background(), stuffPointer)
+	require.NoError(t, err)
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "stuff", string(b))
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// NameKey is the attribute records logged through HCLog are tagged with, for example
+// "plugin.localstore" for the output of the localstore plugin
+const NameKey = "logger"
+
+// hcLogger is an hclog.Logger writing to a slog.Logger, the level is decided by the slog.Logger
+type hcLogger struct {
+	l       *slog.Logger
+	name    string
+	implied []any
+}
+
+var _ hclog.Logger = (*hcLogger)(nil)
+
+// HCLog returns an hclog.Logger named name that writes to l, so that go-plugin and the plugins it
+// runs log to the same place as cavorite
+func HCLog(l *slog.Logger, name string) hclog.Logger {
+	return &hcLogger{l: l, name: name}
+}
+
+// slogLevel returns the slog.Level of level
+func slogLevel(level hclog.Level) slog.Level {
+	switch level {
+	case hclog.Trace:
+		return LevelTrace
+	case hclog.Debug:
+		return slog.LevelDebug
+	case hclog.Warn:
+		return slog.LevelWarn
+	case hclog.Error:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func (h *hcLogger) Log(level hclog.Level, msg string, args ...any) {
+	if level == hclog.Off {
+		return
+	}
+	attrs := make([]any, 0, 2+len(h.implied)+len(args))
+	if h.name != "" {
+		attrs = append(attrs, NameKey, h.name)
+	}
+	attrs = append(attrs, h.implied...)
+	h.l.Log(context.Background(), slogLevel(level), msg, append(attrs, args...)...)
+}
+
+func (h *hcLogger) Trace(msg string, args ...any) { h.Log(hclog.Trace, msg, args...) }
+func (h *hcLogger) Debug(msg string, args ...any) { h.Log(hclog.Debug, msg, args...) }
+func (h *hcLogger) Info(msg string, args ...any)  { h.Log(hclog.Info, msg, args...) }
+func (h *hcLogger) Warn(msg string, args ...any)  { h.Log(hclog.Warn, msg, args...) }
+func (h *hcLogger) Error(msg string, args ...any) { h.Log(hclog.Error, msg, args...) }
+
+func (h *hcLogger) enabled(level hclog.Level) bool {
+	return h.l.Enabled(context.Background(), slogLevel(level))
+}
+
+func (h *hcLogger) IsTrace() bool { return h.enabled(hclog.Trace) }
+func (h *hcLogger) IsDebug() bool { return h.enabled(hclog.Debug) }
+func (h *hcLogger) IsInfo() bool  { return h.enabled(hclog.Info) }
+func (h *hcLogger) IsWarn() bool  { return h.enabled(hclog.Warn) }
+func (h *hcLogger) IsError() bool { return h.enabled(hclog.Error) }
+
+func (h *hcLogger) ImpliedArgs() []any {
+	return h.implied
+}
+
+func (h *hcLogger) With(args ...any) hclog.Logger {
+	implied := append(append([]any{}, h.implied...), args...)
+	return &hcLogger{l: h.l, name: h.name, implied: implied}
+}
+
+func (h *hcLogger) Name() string {
+	return h.name
+}
+
+func (h *hcLogger) Named(name string) hclog.Logger {
+	if h.name != "" {
+		name = h.name + "." + name
+	}
+	return h.ResetNamed(name)
+}
+
+func (h *hcLogger) ResetNamed(name string) hclog.Logger {
+	return &hcLogger{l: h.l, name: name, implied: h.implied}
+}
+
+// SetLevel does nothing, the level is set by the options of the slog.Logger
+func (h *hcLogger) SetLevel(hclog.Level) {}
+
+func (h *hcLogger) GetLevel() hclog.Level {
+	for level := hclog.Trace; level < hclog.Off; level++ {
+		if h.enabled(level) {
+			return level
+		}
+	}
+	return hclog.Off
+}
+
+func (h *hcLogger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	level := slog.LevelInfo
+	if opts != nil && opts.ForceLevel != hclog.NoLevel {
+		level = slogLevel(opts.ForceLevel)
+	}
+	l := h.l
+	if h.name != "" {
+		l = l.With(NameKey, h.name)
+	}
+	return slog.NewLogLogger(l.With(h.implied...).Handler(), level)
+}
+
+func (h *hcLogger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return h.StandardLogger(opts).Writer()
+}
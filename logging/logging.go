@@ -0,0 +1,83 @@
+// Package logging sets up the log/slog logger cavorite logs through
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// LevelTrace is below slog.LevelDebug and enables the most detailed logs, such as those of
+	// plugins and the plugin protocol
+	LevelTrace = slog.LevelDebug - 4
+)
+
+var (
+	ErrUnknownFormat = fmt.Errorf("log format must be %q or %q", FormatText, FormatJSON)
+	ErrUnknownLevel  = errors.New(`log level must be "trace", "debug", "info", "warn" or "error"`)
+)
+
+// Options configure the logger returned by New
+type Options struct {
+	Level slog.Level
+	// Format is FormatText or FormatJSON
+	Format string
+	// AddSource adds the file and line each record was logged at
+	AddSource bool
+}
+
+// ParseLevel parses the name of a level, such as "debug" or "warn"
+func ParseLevel(name string) (slog.Level, error) {
+	if strings.EqualFold(name, "trace") {
+		return LevelTrace, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("%w, got %q", ErrUnknownLevel, name)
+	}
+	return level, nil
+}
+
+// New returns a logger writing records of at least opts.Level to w in opts.Format
+func New(w io.Writer, opts Options) (*slog.Logger, error) {
+	hopts := &slog.HandlerOptions{
+		Level:       opts.Level,
+		AddSource:   opts.AddSource,
+		ReplaceAttr: replaceLevel,
+	}
+	switch opts.Format {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, hopts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, hopts)), nil
+	default:
+		return nil, fmt.Errorf("%w, got %q", ErrUnknownFormat, opts.Format)
+	}
+}
+
+// Setup makes the logger returned by New the default logger of slog and of the log package
+func Setup(w io.Writer, opts Options) error {
+	l, err := New(w, opts)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l)
+	return nil
+}
+
+// replaceLevel names LevelTrace, which slog would call DEBUG-4
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.LevelKey || len(groups) > 0 {
+		return a
+	}
+	if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+		a.Value = slog.StringValue("TRACE")
+	}
+	return a
+}
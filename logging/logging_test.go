@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"trace": LevelTrace,
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for name, want := range tests {
+		level, err := ParseLevel(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, level, name)
+	}
+	_, err := ParseLevel("loud")
+	assert.ErrorIs(t, err, ErrUnknownLevel)
+}
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	l, err := New(&out, Options{Level: LevelTrace, Format: FormatJSON})
+	require.NoError(t, err)
+	l.Log(context.Background(), LevelTrace, "uploading objects", "objects", []string{"tools/tool"})
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &record))
+	assert.Equal(t, "TRACE", record["level"])
+	assert.Equal(t, "uploading objects", record["msg"])
+	assert.Equal(t, []any{"tools/tool"}, record["objects"])
+
+	out.Reset()
+	l, err = New(&out, Options{Level: slog.LevelInfo})
+	require.NoError(t, err)
+	l.Debug("hidden")
+	l.Info("uploading objects", "objects", 1)
+	assert.Contains(t, out.String(), `level=INFO msg="uploading objects" objects=1`)
+	assert.NotContains(t, out.String(), "hidden")
+
+	_, err = New(&out, Options{Format: "yaml"})
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestHCLog(t *testing.T) {
+	var out bytes.Buffer
+	l, err := New(&out, Options{Level: slog.LevelDebug})
+	require.NoError(t, err)
+	h := HCLog(l, "plugin")
+	assert.False(t, h.IsTrace())
+	assert.True(t, h.IsDebug())
+
+	// go-plugin logs the output of a plugin through a logger named after it
+	named := h.Named("localstore").With("pid", 42)
+	assert.Equal(t, "plugin.localstore", named.Name())
+	named.Info("copying object", "from", "/backend/tools/tool")
+	named.Trace("hidden")
+	h.StandardLogger(nil).Print("from the log package")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `level=INFO msg="copying object" logger=plugin.localstore pid=42 from=/backend/tools/tool`)
+	assert.Contains(t, lines[1], `msg="from the log package" logger=plugin`)
+}
@@ -2,20 +2,22 @@ package main
 
 import (
 	"context"
-	"io"
-	"log"
-
-	"github.com/google/logger"
+	"log/slog"
+	"os"
 
 	"github.com/discentem/cavorite/internal/cli"
-	"github.com/discentem/cavorite/program"
+	"github.com/discentem/cavorite/logging"
 )
 
 func main() {
-	defer logger.Init(program.Name, true, false, io.Discard).Close()
+	// the flags of the command configure the logger once they are parsed
+	if err := logging.Setup(os.Stderr, logging.Options{Level: slog.LevelInfo, Format: logging.FormatText}); err != nil {
+		panic(err)
+	}
 	ctx := context.Background()
 	err := cli.ExecuteWithContext(ctx)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error(err.Error())
 	}
+	os.Exit(cli.ExitCode(err))
 }
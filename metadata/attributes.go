@@ -0,0 +1,60 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// FileMode holds Unix permission bits. It is written to cfiles as an octal string such as "0755".
+type FileMode uint32
+
+func (m FileMode) Perm() fs.FileMode {
+	return fs.FileMode(m) & fs.ModePerm
+}
+
+func (m FileMode) String() string {
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *FileMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("mode must be an octal string: %w", err)
+	}
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("mode must be an octal string: %w", err)
+	}
+	*m = FileMode(v)
+	return nil
+}
+
+// SizeMatches reports whether size is the size recorded in m. It is always true if m predates sizes.
+func (m ObjectMetaData) SizeMatches(size int64) bool {
+	return m.Size == nil || *m.Size == size
+}
+
+// RestoreAttributes applies the mode and modification time recorded in m to path.
+// It should only be called once the content of path has been verified against m.
+func RestoreAttributes(fsys afero.Fs, path string, m ObjectMetaData) error {
+	if m.Mode != 0 {
+		if err := fsys.Chmod(path, m.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+	if !m.DateModified.IsZero() {
+		if err := fsys.Chtimes(path, time.Now(), m.DateModified); err != nil {
+			return err
+		}
+	}
+	return nil
+}
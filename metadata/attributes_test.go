@@ -0,0 +1,45 @@
+package metadata
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileModeJSON(t *testing.T) {
+	b, err := json.Marshal(struct {
+		Mode FileMode `json:"mode,omitempty"`
+	}{Mode: 0755})
+	require.NoError(t, err)
+	assert.Equal(t, `{"mode":"0755"}`, string(b))
+
+	var m ObjectMetaData
+	require.NoError(t, json.Unmarshal([]byte(`{"mode":"0644"}`), &m))
+	assert.Equal(t, os.FileMode(0644), m.Mode.Perm())
+
+	assert.Error(t, json.Unmarshal([]byte(`{"mode":"rwxr-xr-x"}`), &m))
+	assert.Error(t, json.Unmarshal([]byte(`{"mode":493}`), &m))
+}
+
+func TestRestoreAttributes(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, "tool", []byte("#!/bin/sh"), 0644))
+	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
+
+	require.NoError(t, RestoreAttributes(fsys, "tool", ObjectMetaData{Mode: 0755, DateModified: mTime}))
+	info, err := fsys.Stat("tool")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.True(t, mTime.Equal(info.ModTime()))
+
+	// legacy cfiles have no mode, which leaves the mode alone
+	require.NoError(t, RestoreAttributes(fsys, "tool", ObjectMetaData{DateModified: mTime}))
+	info, err = fsys.Stat("tool")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
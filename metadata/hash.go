@@ -0,0 +1,76 @@
+package metadata
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"lukechampine.com/blake3"
+)
+
+const (
+	AlgorithmSHA256 = "sha256"
+	AlgorithmSHA512 = "sha512"
+	AlgorithmBLAKE3 = "blake3"
+
+	// DefaultAlgorithm is used for new cfiles unless another algorithm is configured
+	DefaultAlgorithm = AlgorithmSHA256
+	// LegacyAlgorithm is implied by cfiles that do not declare an algorithm
+	LegacyAlgorithm = AlgorithmSHA256
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown hash algorithm")
+)
+
+// hashers maps algorithm names, as they appear in cfiles, to hash constructors
+var hashers = map[string]func() hash.Hash{
+	AlgorithmSHA256: sha256.New,
+	AlgorithmSHA512: sha512.New,
+	AlgorithmBLAKE3: func() hash.Hash { return blake3.New(32, nil) },
+}
+
+// RegisterHasher makes a hash algorithm available under name, replacing any algorithm already
+// registered under it. It is not safe to call concurrently with hashing and is meant to be called from init.
+func RegisterHasher(name string, fn func() hash.Hash) {
+	hashers[name] = fn
+}
+
+// Algorithms returns the names of all registered hash algorithms in sorted order
+func Algorithms() []string {
+	names := make([]string, 0, len(hashers))
+	for name := range hashers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewHasher returns a new hash.Hash for algorithm. An empty algorithm means LegacyAlgorithm.
+func NewHasher(algorithm string) (hash.Hash, error) {
+	if algorithm == "" {
+		algorithm = LegacyAlgorithm
+	}
+	fn, ok := hashers[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, must be one of %v", ErrUnknownAlgorithm, algorithm, Algorithms())
+	}
+	return fn(), nil
+}
+
+// HashFromReader returns the hex encoded digest of r using algorithm
+func HashFromReader(algorithm string, r io.Reader) (string, error) {
+	h, err := NewHasher(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("%v: could not generate %s due to io.Copy error", err, algorithm)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
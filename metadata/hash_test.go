@@ -0,0 +1,61 @@
+package metadata
+
+import (
+	"crypto/md5"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashFromReader(t *testing.T) {
+	// digests of the empty string
+	tests := map[string]string{
+		AlgorithmSHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		AlgorithmSHA512: "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+		AlgorithmBLAKE3: "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+		"":              "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	for algorithm, want := range tests {
+		t.Run(algorithm, func(t *testing.T) {
+			got, err := HashFromReader(algorithm, strings.NewReader(""))
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+	_, err := HashFromReader("md4", strings.NewReader(""))
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestRegisterHasher(t *testing.T) {
+	RegisterHasher("md5", md5.New)
+	defer delete(hashers, "md5")
+	assert.Contains(t, Algorithms(), "md5")
+	got, err := HashFromReader("md5", strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Equal(t, "d41d8cd98f00b204e9800998ecf8427e", got)
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("legacy", func(t *testing.T) {
+		m, err := Decode(strings.NewReader(`{"name":"a","checksum":"b","date_modified":"2014-11-12T11:45:26.371Z"}`))
+		require.NoError(t, err)
+		assert.Equal(t, 0, m.SchemaVersion)
+		assert.Equal(t, "", m.Algorithm)
+		assert.Equal(t, AlgorithmSHA256, m.HashAlgorithm())
+	})
+	t.Run("current", func(t *testing.T) {
+		m, err := Decode(strings.NewReader(`{"schema_version":1,"name":"a","algorithm":"blake3","checksum":"b"}`))
+		require.NoError(t, err)
+		assert.Equal(t, AlgorithmBLAKE3, m.HashAlgorithm())
+	})
+	t.Run("newer schema", func(t *testing.T) {
+		_, err := Decode(strings.NewReader(`{"schema_version":99,"name":"a","checksum":"b"}`))
+		assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+	})
+	t.Run("unknown algorithm", func(t *testing.T) {
+		_, err := Decode(strings.NewReader(`{"schema_version":1,"name":"a","algorithm":"crc32","checksum":"b"}`))
+		assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+	})
+}
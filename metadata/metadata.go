@@ -1,36 +1,111 @@
 package metadata
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 )
 
 const MetadataFileExtension string = "cfile"
 
+// SchemaVersion is the newest cfile schema version this version of cavorite reads and writes.
+//
+//	0: cfiles without a schema_version, which imply LegacyAlgorithm
+//	1: adds schema_version and algorithm
+//	2: adds size and mode
+//	3: adds archive
+const SchemaVersion = 3
+
+// BaseSchemaVersion is written to cfiles that use no field added after it, so that older versions
+// of cavorite keep reading them
+const BaseSchemaVersion = 2
+
 var (
 	ErrFileExtensionEmpty          = fmt.Errorf("options.MetadatafileExtension cannot be %q", "")
 	ErrRetrieveFailureHashMismatch = errors.New("hashes don't match, Retrieve aborted")
+	ErrUnsupportedSchemaVersion    = errors.New("cfile schema_version is newer than this version of cavorite supports")
 )
 
 type ObjectMetaData struct {
-	Name         string    `json:"name"`
-	Checksum     string    `json:"checksum"`
-	DateModified time.Time `json:"date_modified"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	Name          string `json:"name"`
+	// Algorithm is the hash algorithm Checksum was computed with. Use HashAlgorithm to read it.
+	Algorithm string `json:"algorithm,omitempty"`
+	Checksum  string `json:"checksum"`
+	// Size is the size of the object in bytes, nil in cfiles older than schema version 2
+	Size *int64 `json:"size,omitempty"`
+	// Mode holds the permission bits of the object, zero if unknown
+	Mode         FileMode            `json:"mode,omitempty"`
+	DateModified time.Time           `json:"date_modified"`
+	Encryption   *EncryptionMetadata `json:"encryption,omitempty"`
+	Chunks       *ChunksMetadata     `json:"chunks,omitempty"`
+	Archive      *ArchiveMetadata    `json:"archive,omitempty"`
+	// Signature covers every other field and must remain the last field
+	Signature *SignatureMetadata `json:"signature,omitempty"`
+}
+
+// RequiredSchemaVersion returns the schema version m has to be written with: the first one with
+// every field m uses, but at least BaseSchemaVersion
+func (m *ObjectMetaData) RequiredSchemaVersion() int {
+	if m.Archive != nil {
+		// older versions would write the archive where the directory should be
+		return 3
+	}
+	return BaseSchemaVersion
+}
+
+// SignatureMetadata is an SSH signature over the rest of the metadata, see package signing
+type SignatureMetadata struct {
+	// Key is the SHA256 fingerprint of the public key that made the signature
+	Key    string `json:"key"`
+	Format string `json:"format"`
+	Blob   string `json:"blob"`
+}
+
+// ChunksMetadata is recorded for objects stored as content-defined chunks. The object stored
+// under Name is then a chunk manifest rather than the object itself.
+type ChunksMetadata struct {
+	ManifestChecksum string `json:"manifest_checksum"`
+	Count            int    `json:"count"`
+}
+
+// ArchiveMetadata is recorded for directories stored as a single archive, see package archive.
+// Checksum and Size then refer to the archive as it is stored.
+type ArchiveMetadata struct {
+	Format      string `json:"format"`
+	Compression string `json:"compression,omitempty"`
+	Entries     int    `json:"entries"`
+}
+
+// EncryptionMetadata is recorded for objects that were encrypted before being uploaded.
+// ObjectMetaData.Checksum always refers to the plaintext.
+type EncryptionMetadata struct {
+	Scheme string `json:"scheme"`
+	KeyID  string `json:"key_id"`
+	// CiphertextChecksum is the sha256 of the object as it is stored in the backend
+	CiphertextChecksum string `json:"ciphertext_checksum"`
+}
+
+// HashAlgorithm returns the algorithm Checksum was computed with, accounting for legacy cfiles
+func (m ObjectMetaData) HashAlgorithm() string {
+	if m.Algorithm == "" {
+		return LegacyAlgorithm
+	}
+	return m.Algorithm
 }
 
 type CfileMetadataMap map[string]ObjectMetaData
 
-func HashFromCfileMatches(fsys afero.Fs, cfile string, expected string) (bool, error) {
+// HashFromCfileMatches reports whether the object next to cfile hashes to expected with algorithm
+func HashFromCfileMatches(fsys afero.Fs, cfile string, algorithm string, expected string) (bool, error) {
 	if fsys == nil {
 		return false, errors.New("fsys cannot be nil")
 	}
@@ -39,13 +114,13 @@ func HashFromCfileMatches(fsys afero.Fs, cfile string, expected string) (bool, e
 	if err != nil {
 		return false, err
 	}
-	actual, err := SHA256FromReader(f)
+	actual, err := HashFromReader(algorithm, f)
 	if err != nil {
 		return false, err
 	}
 	// If the hash of the downloaded file does not match the retrieved file, return an error
 	if actual != expected {
-		logger.Infof("Hash mismatch, got %s but expected %s", actual, expected)
+		slog.Info("hash mismatch", "object", obj, "got", actual, "expected", expected)
 		return false, ErrRetrieveFailureHashMismatch
 	}
 	if err := f.Close(); err != nil {
@@ -56,39 +131,57 @@ func HashFromCfileMatches(fsys afero.Fs, cfile string, expected string) (bool, e
 }
 
 func SHA256FromReader(r io.Reader) (string, error) {
-	h := sha256.New()
-	if _, err := io.Copy(h, r); err != nil {
-		return "", fmt.Errorf("%v: %s", err, "could not generate sha256 due to io.Copy error")
-	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return HashFromReader(AlgorithmSHA256, r)
 }
 
 func GenerateFromReader(name string, modTime time.Time, r io.Reader) (*ObjectMetaData, error) {
-	hash, err := SHA256FromReader(r)
+	return GenerateFromReaderWithAlgorithm(name, modTime, DefaultAlgorithm, r)
+}
+
+// GenerateFromReaderWithAlgorithm generates metadata for r, hashing it with algorithm
+func GenerateFromReaderWithAlgorithm(name string, modTime time.Time, algorithm string, r io.Reader) (*ObjectMetaData, error) {
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+	}
+	h, err := NewHasher(algorithm)
 	if err != nil {
 		return nil, err
 	}
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return nil, fmt.Errorf("%v: could not generate %s due to io.Copy error", err, algorithm)
+	}
 	return &ObjectMetaData{
-		Name:         name,
-		Checksum:     hash,
-		DateModified: modTime,
+		SchemaVersion: BaseSchemaVersion,
+		Name:          name,
+		Algorithm:     algorithm,
+		Checksum:      hex.EncodeToString(h.Sum(nil)),
+		Size:          &size,
+		DateModified:  modTime,
 	}, nil
 }
 
 func GenerateFromFile(f afero.File, key string) (*ObjectMetaData, error) {
+	return GenerateFromFileWithAlgorithm(f, key, DefaultAlgorithm)
+}
+
+func GenerateFromFileWithAlgorithm(f afero.File, key string, algorithm string) (*ObjectMetaData, error) {
 	fstat, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
-	return GenerateFromReader(key, fstat.ModTime(), f)
-}
-
-func ParseCfile(fsys afero.Fs, obj string) (*ObjectMetaData, error) {
-	cfile, err := fsys.Open(obj)
+	m, err := GenerateFromReaderWithAlgorithm(key, fstat.ModTime(), algorithm, f)
 	if err != nil {
 		return nil, err
 	}
-	b, err := io.ReadAll(cfile)
+	m.Mode = FileMode(fstat.Mode().Perm())
+	return m, nil
+}
+
+// Decode reads a cfile of any supported schema version. Legacy cfiles are returned as they are,
+// HashAlgorithm reports the algorithm they imply.
+func Decode(r io.Reader) (*ObjectMetaData, error) {
+	b, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
@@ -96,9 +189,28 @@ func ParseCfile(fsys afero.Fs, obj string) (*ObjectMetaData, error) {
 	if err := json.Unmarshal(b, &metadata); err != nil {
 		return nil, fmt.Errorf("json marshal failed: %w", err)
 	}
+	if metadata.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("%w: got %d, want at most %d", ErrUnsupportedSchemaVersion, metadata.SchemaVersion, SchemaVersion)
+	}
+	if _, err := NewHasher(metadata.HashAlgorithm()); err != nil {
+		return nil, err
+	}
 	return &metadata, nil
 }
 
+func ParseCfile(fsys afero.Fs, obj string) (*ObjectMetaData, error) {
+	cfile, err := fsys.Open(obj)
+	if err != nil {
+		return nil, err
+	}
+	defer cfile.Close()
+	m, err := Decode(cfile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", obj, err)
+	}
+	return m, nil
+}
+
 func ParseCfileWithExtension(fsys afero.Fs, obj, ext string) (*ObjectMetaData, error) {
 	cfile := fmt.Sprintf("%s.%s", obj, ext)
 	return ParseCfile(fsys, cfile)
@@ -110,6 +222,10 @@ type FsysWriteRequest struct {
 	Fi           afero.File
 	MetadataPath string
 	Extension    string
+	// Algorithm is the hash algorithm to use, DefaultAlgorithm if empty
+	Algorithm string
+	// Annotate, if set, is called with the generated metadata before it is written
+	Annotate func(m *ObjectMetaData) error
 }
 
 // WriteToFsys generates Cavorite metadata for req.Object and writes it to req.Fsys
@@ -117,24 +233,34 @@ func WriteToFsys(req FsysWriteRequest) (err error) {
 	if req.MetadataPath == "" {
 		return fmt.Errorf("req.MetadataPath cannot be %q", "")
 	}
-	logger.V(2).Infof("object: %s", req.Object)
+	slog.Debug("generating metadata", "object", req.Object)
 	// generate metadata
-	m, err := GenerateFromFile(req.Fi, req.Object)
+	m, err := GenerateFromFileWithAlgorithm(req.Fi, req.Object, req.Algorithm)
 	if err != nil {
 		return err
 	}
-	logger.V(2).Infof("%s has a checksum of %q", req.Object, m.Checksum)
-	// convert metadata to json
-	blob, err := json.MarshalIndent(m, "", " ")
-	if err != nil {
-		return err
+	slog.Debug("hashed object", "object", req.Object, "checksum", m.Checksum)
+	if req.Annotate != nil {
+		if err := req.Annotate(m); err != nil {
+			return err
+		}
 	}
 	// Write metadata to disk
 	metadataPath := fmt.Sprintf("%s.%s", req.MetadataPath, req.Extension)
-	logger.V(2).Infof("writing metadata to %s", metadataPath)
-	if err := afero.WriteFile(req.Fsys, metadataPath, blob, 0644); err != nil {
+	return WriteCfile(req.Fsys, metadataPath, m)
+}
+
+// Marshal returns m in the format used for all cfiles
+func Marshal(m *ObjectMetaData) ([]byte, error) {
+	return json.MarshalIndent(m, "", " ")
+}
+
+// WriteCfile writes m to cfile in the format used for all cfiles
+func WriteCfile(fsys afero.Fs, cfile string, m *ObjectMetaData) error {
+	blob, err := Marshal(m)
+	if err != nil {
 		return err
 	}
-
-	return nil
+	slog.Debug("writing metadata", "cfile", cfile)
+	return afero.WriteFile(fsys, cfile, blob, 0644)
 }
@@ -2,13 +2,11 @@ package metadata
 
 import (
 	"errors"
-	"io"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/discentem/cavorite/testutils"
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -48,6 +46,7 @@ func TestWriteToFsys(t *testing.T) {
 		"thing/a/whatever": {
 			Content: []byte(`blah`),
 			ModTime: &mTime,
+			Mode:    0755,
 		},
 	})
 	fs := *memfs
@@ -65,8 +64,12 @@ func TestWriteToFsys(t *testing.T) {
 
 	b, _ := afero.ReadFile(*memfs, "thing/a/whatever.cfile")
 	assert.Equal(t, string(b), `{
+ "schema_version": 2,
  "name": "thing/a/whatever",
+ "algorithm": "sha256",
  "checksum": "8b7df143d91c716ecfa5fc1730022f6b421b05cedee8fd52b1fc65a96030ad52",
+ "size": 4,
+ "mode": "0755",
  "date_modified": "2014-11-12T11:45:26.371Z"
 }`)
 }
@@ -149,8 +152,7 @@ func TestHashFromCfileMatches(t *testing.T) {
 	t.Parallel()
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			logger.Init("metadata_test", true, false, io.Discard)
-			actual, err := HashFromCfileMatches(test.fsys, test.cfile, test.expectedHash)
+			actual, err := HashFromCfileMatches(test.fsys, test.cfile, AlgorithmSHA256, test.expectedHash)
 			if test.errExpected != nil {
 				assert.Equal(t, true, test.errExpected(err))
 			}
@@ -0,0 +1,241 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/fileutils"
+)
+
+// DefaultManifest is where ManifestSource keeps metadata by default, relative to the root of the repo
+const DefaultManifest = ".cavorite/manifest.json"
+
+var ErrNoMetadata = errors.New("object has no metadata")
+
+// Source reads and writes the metadata of objects. Objects are identified by their path relative
+// to the root of the repo, so callers behave the same whichever Source holds the metadata.
+type Source interface {
+	// Get returns the metadata of obj, or an error wrapping ErrNoMetadata if there is none
+	Get(obj string) (*ObjectMetaData, error)
+	Put(obj string, m *ObjectMetaData) error
+	Delete(obj string) error
+	// List returns every object below roots that has metadata, in lexical order
+	List(roots ...string) ([]string, error)
+	// Location describes where the metadata of obj is kept, for messages
+	Location(obj string) string
+	// Close writes changes that have not been written yet
+	Close() error
+}
+
+var (
+	_ = Source(&SidecarSource{})
+	_ = Source(&ManifestSource{})
+)
+
+// SidecarSource keeps the metadata of each object in a cfile next to it
+type SidecarSource struct {
+	fsys afero.Fs
+	ext  string
+}
+
+// NewSidecarSource returns a SidecarSource for cfiles with extension ext, MetadataFileExtension if empty
+func NewSidecarSource(fsys afero.Fs, ext string) *SidecarSource {
+	if ext == "" {
+		ext = MetadataFileExtension
+	}
+	return &SidecarSource{fsys: fsys, ext: strings.TrimPrefix(ext, ".")}
+}
+
+func (s *SidecarSource) Location(obj string) string {
+	return fmt.Sprintf("%s.%s", obj, s.ext)
+}
+
+func (s *SidecarSource) Get(obj string) (*ObjectMetaData, error) {
+	m, err := ParseCfile(s.fsys, s.Location(obj))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", obj, ErrNoMetadata)
+	}
+	return m, err
+}
+
+func (s *SidecarSource) Put(obj string, m *ObjectMetaData) error {
+	return WriteCfile(s.fsys, s.Location(obj), m)
+}
+
+func (s *SidecarSource) Delete(obj string) error {
+	return s.fsys.Remove(s.Location(obj))
+}
+
+func (s *SidecarSource) List(roots ...string) ([]string, error) {
+	suffix := "." + s.ext
+	walkRoots := make([]string, 0, len(roots))
+	for _, root := range roots {
+		// a root may name an object rather than a directory
+		if info, err := s.fsys.Stat(root); err != nil || !info.IsDir() {
+			if _, err := s.fsys.Stat(s.Location(root)); err == nil {
+				root = s.Location(root)
+			}
+		}
+		walkRoots = append(walkRoots, root)
+	}
+	cfiles, err := fileutils.Walk(s.fsys, func(path string) bool {
+		return strings.HasSuffix(path, suffix)
+	}, walkRoots...)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]string, 0, len(cfiles))
+	for _, cfile := range cfiles {
+		objects = append(objects, strings.TrimSuffix(cfile, suffix))
+	}
+	return objects, nil
+}
+
+func (s *SidecarSource) Close() error {
+	return nil
+}
+
+// ManifestSource keeps the metadata of every object in a single file, keyed by object path and
+// sorted so that changes produce small diffs. The manifest is read on first use and written by Close.
+type ManifestSource struct {
+	fsys    afero.Fs
+	path    string
+	entries map[string]ObjectMetaData
+	dirty   bool
+}
+
+func NewManifestSource(fsys afero.Fs, path string) *ManifestSource {
+	if path == "" {
+		path = DefaultManifest
+	}
+	return &ManifestSource{fsys: fsys, path: path}
+}
+
+func manifestKey(obj string) string {
+	return filepath.ToSlash(filepath.Clean(obj))
+}
+
+func (ms *ManifestSource) load() error {
+	if ms.entries != nil {
+		return nil
+	}
+	b, err := afero.ReadFile(ms.fsys, ms.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		ms.entries = make(map[string]ObjectMetaData)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("%s: %w", ms.path, err)
+	}
+	entries := make(map[string]ObjectMetaData, len(raw))
+	for obj, entry := range raw {
+		m, err := Decode(bytes.NewReader(entry))
+		if err != nil {
+			return fmt.Errorf("%s: %w", ms.Location(obj), err)
+		}
+		entries[manifestKey(obj)] = *m
+	}
+	ms.entries = entries
+	return nil
+}
+
+func (ms *ManifestSource) Location(obj string) string {
+	return fmt.Sprintf("%s:%s", ms.path, manifestKey(obj))
+}
+
+func (ms *ManifestSource) Get(obj string) (*ObjectMetaData, error) {
+	if err := ms.load(); err != nil {
+		return nil, err
+	}
+	m, ok := ms.entries[manifestKey(obj)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", obj, ErrNoMetadata)
+	}
+	return &m, nil
+}
+
+func (ms *ManifestSource) Put(obj string, m *ObjectMetaData) error {
+	if err := ms.load(); err != nil {
+		return err
+	}
+	ms.entries[manifestKey(obj)] = *m
+	ms.dirty = true
+	return nil
+}
+
+func (ms *ManifestSource) Delete(obj string) error {
+	if err := ms.load(); err != nil {
+		return err
+	}
+	key := manifestKey(obj)
+	if _, ok := ms.entries[key]; !ok {
+		return fmt.Errorf("%s: %w", obj, ErrNoMetadata)
+	}
+	delete(ms.entries, key)
+	ms.dirty = true
+	return nil
+}
+
+func (ms *ManifestSource) List(roots ...string) ([]string, error) {
+	if err := ms.load(); err != nil {
+		return nil, err
+	}
+	var objects []string
+	for obj := range ms.entries {
+		for _, root := range roots {
+			root = manifestKey(root)
+			if root == "." || obj == root || strings.HasPrefix(obj, root+"/") {
+				objects = append(objects, obj)
+				break
+			}
+		}
+	}
+	sort.Strings(objects)
+	return objects, nil
+}
+
+// Close writes the manifest if it was changed. A manifest without entries is removed.
+func (ms *ManifestSource) Close() error {
+	if !ms.dirty {
+		return nil
+	}
+	if len(ms.entries) == 0 {
+		slog.Debug("removing empty manifest", "path", ms.path)
+		if err := ms.fsys.Remove(ms.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		ms.dirty = false
+		return nil
+	}
+	blob, err := json.MarshalIndent(ms.entries, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := ms.fsys.MkdirAll(filepath.Dir(ms.path), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first so an interrupted write cannot lose the metadata of every object
+	tmp := ms.path + ".tmp"
+	if err := afero.WriteFile(ms.fsys, tmp, append(blob, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := ms.fsys.Rename(tmp, ms.path); err != nil {
+		return err
+	}
+	slog.Debug("wrote metadata", "objects", len(ms.entries), "path", ms.path)
+	ms.dirty = false
+	return nil
+}
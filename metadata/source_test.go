@@ -0,0 +1,125 @@
+package metadata
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMetadata(name string) *ObjectMetaData {
+	size := int64(5)
+	return &ObjectMetaData{
+		SchemaVersion: BaseSchemaVersion,
+		Name:          name,
+		Algorithm:     AlgorithmSHA256,
+		Checksum:      "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+		Size:          &size,
+		DateModified:  time.Date(2014, 11, 12, 11, 45, 26, 371000000, time.UTC),
+	}
+}
+
+// testSource exercises the behaviour every Source must share
+func testSource(t *testing.T, src Source) {
+	t.Helper()
+	_, err := src.Get("a/one")
+	assert.ErrorIs(t, err, ErrNoMetadata)
+
+	for _, obj := range []string{"b/two", "a/one", "a/sub/three", "ab"} {
+		require.NoError(t, src.Put(obj, testMetadata(obj)))
+	}
+	require.NoError(t, src.Close())
+
+	m, err := src.Get("a/one")
+	require.NoError(t, err)
+	assert.Equal(t, testMetadata("a/one"), m)
+
+	objects, err := src.List(".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/one", "a/sub/three", "ab", "b/two"}, objects)
+	objects, err = src.List("a", "b/two")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/one", "a/sub/three", "b/two"}, objects)
+
+	require.NoError(t, src.Delete("a/one"))
+	require.NoError(t, src.Close())
+	_, err = src.Get("a/one")
+	assert.ErrorIs(t, err, ErrNoMetadata)
+}
+
+func TestSidecarSource(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	src := NewSidecarSource(fsys, "cfile")
+	testSource(t, src)
+
+	assert.Equal(t, "b/two.cfile", src.Location("b/two"))
+	m, err := ParseCfile(fsys, "b/two.cfile")
+	require.NoError(t, err)
+	assert.Equal(t, "b/two", m.Name)
+}
+
+func TestManifestSource(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	src := NewManifestSource(fsys, "")
+	testSource(t, src)
+	assert.Equal(t, ".cavorite/manifest.json:b/two", src.Location("./b/two"))
+
+	// a fresh source reads what was written
+	objects, err := NewManifestSource(fsys, DefaultManifest).List(".")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/sub/three", "ab", "b/two"}, objects)
+	exists, err := afero.Exists(fsys, DefaultManifest+".tmp")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// the manifest is removed once it is empty
+	for _, obj := range objects {
+		require.NoError(t, src.Delete(obj))
+	}
+	require.NoError(t, src.Close())
+	exists, err = afero.Exists(fsys, DefaultManifest)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestManifestSourceFormat(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	src := NewManifestSource(fsys, DefaultManifest)
+	require.NoError(t, src.Put("z", testMetadata("z")))
+	require.NoError(t, src.Put("a", testMetadata("prefix/a")))
+	require.NoError(t, src.Close())
+
+	b, err := afero.ReadFile(fsys, DefaultManifest)
+	require.NoError(t, err)
+	assert.Equal(t, `{
+ "a": {
+  "schema_version": 2,
+  "name": "prefix/a",
+  "algorithm": "sha256",
+  "checksum": "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+  "size": 5,
+  "date_modified": "2014-11-12T11:45:26.371Z"
+ },
+ "z": {
+  "schema_version": 2,
+  "name": "z",
+  "algorithm": "sha256",
+  "checksum": "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0",
+  "size": 5,
+  "date_modified": "2014-11-12T11:45:26.371Z"
+ }
+}
+`, string(b))
+}
+
+func TestManifestSourceRejectsNewerSchema(t *testing.T) {
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, DefaultManifest, []byte(`{"a": {"schema_version": 99, "name": "a", "checksum": ""}}`), 0644))
+	_, err := NewManifestSource(fsys, DefaultManifest).Get("a")
+	assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+	assert.ErrorContains(t, err, ".cavorite/manifest.json:a")
+	assert.False(t, errors.Is(err, ErrNoMetadata))
+}
@@ -0,0 +1,55 @@
+// Package pantri reads the item files written by Pantri (https://github.com/facebook/IT-CPE/tree/main/pantri)
+// so that repos can be converted to cavorite.
+package pantri
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Extension is the extension Pantri gives item files. An item file sits next to the object it describes.
+const Extension = "pitem"
+
+var (
+	ErrInvalidItem      = errors.New("invalid pantri item")
+	ErrChecksumMismatch = errors.New("object does not match the sha1 checksum of its pantri item")
+)
+
+// Item is the content of a Pantri item file. Pantri stores objects in its shelf under their checksum.
+type Item struct {
+	// Checksum is the hex encoded sha1 of the object
+	Checksum string `json:"checksum"`
+}
+
+func ParseItem(r io.Reader) (*Item, error) {
+	var item Item
+	if err := json.NewDecoder(r).Decode(&item); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidItem, err)
+	}
+	if b, err := hex.DecodeString(item.Checksum); err != nil || len(b) != sha1.Size {
+		return nil, fmt.Errorf("%w: checksum %q is not a sha1", ErrInvalidItem, item.Checksum)
+	}
+	return &item, nil
+}
+
+// ShelfKey returns the name the object is stored under in the Pantri shelf
+func (i Item) ShelfKey() string {
+	return i.Checksum
+}
+
+// Verify copies r to w and returns ErrChecksumMismatch if r does not match the item's checksum.
+// w may be io.Discard.
+func (i Item) Verify(w io.Writer, r io.Reader) error {
+	h := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), r); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != i.Checksum {
+		return fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, actual, i.Checksum)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package pantri
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stuffSHA1 = "5eee38381388b6f30efdd5c5c6f067dbf32c0bb3"
+
+func TestParseItem(t *testing.T) {
+	tests := []struct {
+		name    string
+		item    string
+		wantErr error
+	}{
+		{
+			name: "valid",
+			item: `{"checksum": "` + stuffSHA1 + `"}`,
+		},
+		{
+			name:    "not json",
+			item:    `checksum`,
+			wantErr: ErrInvalidItem,
+		},
+		{
+			name:    "not a sha1",
+			item:    `{"checksum": "35bafb1ce99aef3ab068afbaabae8f21fd9b9f02d3a9442e364fa92c0b3eeef0"}`,
+			wantErr: ErrInvalidItem,
+		},
+		{
+			name:    "missing checksum",
+			item:    `{}`,
+			wantErr: ErrInvalidItem,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item, err := ParseItem(strings.NewReader(test.item))
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, stuffSHA1, item.ShelfKey())
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	item := Item{Checksum: stuffSHA1}
+	var out bytes.Buffer
+	require.NoError(t, item.Verify(&out, strings.NewReader("stuff")))
+	assert.Equal(t, "stuff", out.String())
+
+	err := item.Verify(io.Discard, strings.NewReader("other stuff"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
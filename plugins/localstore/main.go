@@ -8,8 +8,8 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
-	"github.com/google/logger"
 	"github.com/hashicorp/go-hclog"
 	multierr "github.com/hashicorp/go-multierror"
 	"github.com/spf13/afero"
@@ -32,7 +32,7 @@ var (
 )
 
 func (s *LocalStore) Upload(ctx context.Context, objects ...string) error {
-	s.logger.Info(fmt.Sprintf("Uploading %v via localstore plugin", objects))
+	s.logger.Info("uploading objects", "objects", objects)
 
 	if !filepath.IsAbs(s.opts.BackendAddress) {
 		return fmt.Errorf("s.Opts.BackendAddress %q is not absolute", s.opts.BackendAddress)
@@ -59,7 +59,6 @@ func (s *LocalStore) Upload(ctx context.Context, objects ...string) error {
 		defer dst.Close()
 		_, err = io.Copy(dst, srcf)
 		result = multierr.Append(err)
-		// fmt.Printf("final error: %v\n", err)
 	}
 
 	return result.ErrorOrNil()
@@ -70,10 +69,10 @@ func (s *LocalStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMa
 	if len(cfiles) == 0 {
 		return ErrCfilesLengthZero
 	}
-	s.logger.Info("", mmap)
+	s.logger.Debug("retrieving objects", "cfiles", cfiles)
 	for _, cfile := range cfiles {
 		srcFilePath := filepath.Join(s.opts.BackendAddress, mmap[cfile].Name)
-		s.logger.Info(fmt.Sprintf("srcFilePath: %s", srcFilePath))
+		s.logger.Debug("opening object", "path", srcFilePath)
 		sf, err := s.fsys.Open(srcFilePath)
 		if err != nil {
 			result = multierr.Append(result, err)
@@ -92,27 +91,26 @@ func (s *LocalStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMa
 			continue
 		}
 		defer df.Close()
-		fmt.Printf("mmap: %s\n", mmap)
 		m, ok := mmap[cfile]
 		if !ok {
 			result = multierr.Append(result, fmt.Errorf("%q not found in mmap", cfile))
 			continue
 		}
 		// copy from backend
-		logger.Infof("copying %q to %q", srcFilePath, mmap[cfile].Name)
+		s.logger.Info("copying object", "from", srcFilePath, "to", mmap[cfile].Name)
 		_, err = io.Copy(df, sf)
 		if err != nil {
 			result = multierr.Append(result, err)
 			continue
 		}
 
-		matches, err := metadata.HashFromCfileMatches(s.fsys, cfile, m.Checksum)
+		matches, err := metadata.HashFromCfileMatches(s.fsys, cfile, m.HashAlgorithm(), m.Checksum)
 		if err != nil {
 			result = multierr.Append(result, err)
 			continue
 		}
 		if !matches {
-			fmt.Printf("hash for %s did not match expected hash (%q) in %q\n", mmap[cfile].Name, mmap[cfile].Checksum, cfile)
+			s.logger.Warn("hash mismatch", "object", mmap[cfile].Name, "expected", mmap[cfile].Checksum, "cfile", cfile)
 			if err := s.fsys.Remove(m.Name); err != nil {
 				result = multierr.Append(result, err)
 				continue
@@ -120,6 +118,9 @@ func (s *LocalStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMa
 			result = multierr.Append(result, metadata.ErrRetrieveFailureHashMismatch)
 			continue
 		}
+		if err := metadata.RestoreAttributes(s.fsys, strings.TrimSuffix(cfile, filepath.Ext(cfile)), m); err != nil {
+			result = multierr.Append(result, err)
+		}
 	}
 	return result.ErrorOrNil()
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/carolynvs/aferox"
 	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/stores"
+	"github.com/discentem/cavorite/stores/pluginproto"
 	"github.com/discentem/cavorite/testutils"
 	"github.com/hashicorp/go-hclog"
 	"github.com/spf13/afero"
@@ -97,6 +98,28 @@ func TestRetrieve(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestRetrieveRestoresMode(t *testing.T) {
+	s := localStoreRetrieve()
+	size := int64(3)
+	mmap := metadata.CfileMetadataMap{
+		"someObject.cfile": metadata.ObjectMetaData{
+			Name:     "someObject",
+			Checksum: "59e5ad2a03d2499749f7943c9dded0f303ad7542befef6d0aead8a7888587f66",
+			Size:     &size,
+			Mode:     0755,
+		},
+	}
+	// as cavorite passes the metadata to the plugin
+	received := stores.PluginProtoMapToMetadataMap(&pluginproto.ObjectsAndMetadataMap{
+		Map: stores.MetadataMapToPluginProtoMap(mmap),
+	})
+	require.Equal(t, &size, received["someObject.cfile"].Size)
+	require.NoError(t, s.Retrieve(context.Background(), received, "someObject.cfile"))
+	info, err := s.fsys.Stat("/git_repo/someObject")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
+
 func TestRetrieveZeroCfiles(t *testing.T) {
 	s := LocalStore{}
 	err := s.Retrieve(context.Background(), metadata.CfileMetadataMap{})
@@ -0,0 +1,169 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/discentem/cavorite/metadata"
+)
+
+// payloadPrefix separates cfile signatures from signatures the same key makes for anything else
+const payloadPrefix = "cavorite cfile signature v1\x00"
+
+var (
+	ErrUnsigned         = errors.New("cfile is not signed")
+	ErrUntrustedKey     = errors.New("cfile was signed by a key that is not trusted")
+	ErrInvalidSignature = errors.New("cfile signature is invalid")
+	ErrWrongObject      = errors.New("cfile is signed for another object")
+	ErrNoTrustedKeys    = errors.New("signing.enforce is set but signing.trusted_keys is empty")
+)
+
+// Options configure signing of cfiles on upload and verification on retrieve
+type Options struct {
+	// KeyFile is an OpenSSH/PEM private key, or a hex or base64 encoded ed25519 seed, used to sign cfiles
+	KeyFile string `json:"key_file,omitempty" mapstructure:"key_file"`
+	// TrustedKeys are public keys in authorized_keys format, e.g. "ssh-ed25519 AAAA... release@example.com"
+	TrustedKeys []string `json:"trusted_keys,omitempty" mapstructure:"trusted_keys"`
+	// Enforce refuses to retrieve objects whose cfile is not signed by one of TrustedKeys
+	Enforce bool `json:"enforce,omitempty" mapstructure:"enforce"`
+}
+
+func (o *Options) Enabled() bool {
+	if o == nil {
+		return false
+	}
+	return o.KeyFile != "" || len(o.TrustedKeys) > 0 || o.Enforce
+}
+
+// payload returns the bytes that are signed for m: its JSON encoding without the signature
+func payload(m metadata.ObjectMetaData) ([]byte, error) {
+	m.Signature = nil
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(payloadPrefix), b...), nil
+}
+
+type Signer struct {
+	signer ssh.Signer
+}
+
+func NewSigner(s ssh.Signer) *Signer {
+	return &Signer{signer: s}
+}
+
+// LoadSigner reads a private key from keyFile. Passphrase protected keys are not supported.
+func LoadSigner(fsys afero.Fs, keyFile string) (*Signer, error) {
+	p, err := homedir.Expand(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := afero.ReadFile(fsys, p)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	if seed, ok := decodeSeed(b); ok {
+		s, err := ssh.NewSignerFromKey(ed25519.NewKeyFromSeed(seed))
+		if err != nil {
+			return nil, err
+		}
+		return NewSigner(s), nil
+	}
+	s, err := ssh.ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing signing key %s: %w", keyFile, err)
+	}
+	return NewSigner(s), nil
+}
+
+func decodeSeed(b []byte) ([]byte, bool) {
+	s := strings.TrimSpace(string(b))
+	if seed, err := hex.DecodeString(s); err == nil && len(seed) == ed25519.SeedSize {
+		return seed, true
+	}
+	if seed, err := base64.StdEncoding.DecodeString(s); err == nil && len(seed) == ed25519.SeedSize {
+		return seed, true
+	}
+	return nil, false
+}
+
+// Sign sets m.Signature to a signature over the rest of m
+func (s *Signer) Sign(m *metadata.ObjectMetaData) error {
+	data, err := payload(*m)
+	if err != nil {
+		return err
+	}
+	sig, err := s.signer.Sign(rand.Reader, data)
+	if err != nil {
+		return err
+	}
+	m.Signature = &metadata.SignatureMetadata{
+		Key:    ssh.FingerprintSHA256(s.signer.PublicKey()),
+		Format: sig.Format,
+		Blob:   base64.StdEncoding.EncodeToString(sig.Blob),
+	}
+	return nil
+}
+
+// Verifier checks cfile signatures against a set of trusted keys
+type Verifier struct {
+	keys map[string]ssh.PublicKey
+}
+
+func NewVerifier(trustedKeys []string) (*Verifier, error) {
+	v := &Verifier{keys: make(map[string]ssh.PublicKey)}
+	for _, line := range trustedKeys {
+		pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("parsing trusted key %q: %w", line, err)
+		}
+		v.keys[ssh.FingerprintSHA256(pk)] = pk
+	}
+	return v, nil
+}
+
+// Verify returns nil if m is signed by a trusted key
+func (v *Verifier) Verify(m metadata.ObjectMetaData) error {
+	if m.Signature == nil {
+		return ErrUnsigned
+	}
+	pk, ok := v.keys[m.Signature.Key]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUntrustedKey, m.Signature.Key)
+	}
+	blob, err := base64.StdEncoding.DecodeString(m.Signature.Blob)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	data, err := payload(m)
+	if err != nil {
+		return err
+	}
+	if err := pk.Verify(data, &ssh.Signature{Format: m.Signature.Format, Blob: blob}); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// VerifyKey returns nil if m is signed by a trusted key and names the object stored as key, so a
+// signed cfile copied next to another object is not accepted for it
+func (v *Verifier) VerifyKey(m metadata.ObjectMetaData, key string) error {
+	if err := v.Verify(m); err != nil {
+		return err
+	}
+	if m.Name != key {
+		return fmt.Errorf("%w: signed for %s, not %s", ErrWrongObject, m.Name, key)
+	}
+	return nil
+}
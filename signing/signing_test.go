@@ -0,0 +1,98 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/pem"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/discentem/cavorite/metadata"
+)
+
+func testKey(t *testing.T, b byte) (ed25519.PrivateKey, string) {
+	t.Helper()
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{b}, ed25519.SeedSize))
+	pub, err := ssh.NewPublicKey(priv.Public())
+	require.NoError(t, err)
+	return priv, string(ssh.MarshalAuthorizedKey(pub))
+}
+
+func testSigner(t *testing.T, priv ed25519.PrivateKey) *Signer {
+	t.Helper()
+	s, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return NewSigner(s)
+}
+
+func testMetadata() *metadata.ObjectMetaData {
+	return &metadata.ObjectMetaData{
+		Name:     "firmware.bin",
+		Checksum: "f29bc64a9d3732b4b9035125fdb3285f5b6455778edca72414671e0ca3b2e0de",
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	priv, authorized := testKey(t, 1)
+	_, other := testKey(t, 2)
+	m := testMetadata()
+	require.NoError(t, testSigner(t, priv).Sign(m))
+	require.NotNil(t, m.Signature)
+
+	v, err := NewVerifier([]string{other, authorized})
+	require.NoError(t, err)
+	assert.NoError(t, v.Verify(*m))
+
+	untrusted, err := NewVerifier([]string{other})
+	require.NoError(t, err)
+	assert.ErrorIs(t, untrusted.Verify(*m), ErrUntrustedKey)
+
+	tampered := *m
+	tampered.Checksum = "0000000000000000000000000000000000000000000000000000000000000000"
+	assert.ErrorIs(t, v.Verify(tampered), ErrInvalidSignature)
+
+	tampered = *m
+	tampered.Encryption = &metadata.EncryptionMetadata{Scheme: "none"}
+	assert.ErrorIs(t, v.Verify(tampered), ErrInvalidSignature)
+
+	assert.ErrorIs(t, v.Verify(*testMetadata()), ErrUnsigned)
+
+	assert.NoError(t, v.VerifyKey(*m, m.Name))
+	assert.ErrorIs(t, v.VerifyKey(*m, "other/"+m.Name), ErrWrongObject)
+	assert.ErrorIs(t, untrusted.VerifyKey(*m, m.Name), ErrUntrustedKey)
+}
+
+func TestLoadSigner(t *testing.T) {
+	priv, authorized := testKey(t, 3)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, "id_ed25519", pem.EncodeToMemory(block), 0600))
+	require.NoError(t, afero.WriteFile(fsys, "seed", []byte(hex.EncodeToString(priv.Seed())+"\n"), 0600))
+	require.NoError(t, afero.WriteFile(fsys, "garbage", []byte("not a key"), 0600))
+
+	v, err := NewVerifier([]string{authorized})
+	require.NoError(t, err)
+	for _, keyFile := range []string{"id_ed25519", "seed"} {
+		s, err := LoadSigner(fsys, keyFile)
+		require.NoError(t, err, keyFile)
+		m := testMetadata()
+		require.NoError(t, s.Sign(m))
+		assert.NoError(t, v.Verify(*m), keyFile)
+	}
+
+	_, err = LoadSigner(fsys, "garbage")
+	assert.Error(t, err)
+	_, err = LoadSigner(fsys, "missing")
+	assert.Error(t, err)
+}
+
+func TestNewVerifierRejectsMalformedKeys(t *testing.T) {
+	_, err := NewVerifier([]string{"ssh-ed25519 not-base64"})
+	assert.Error(t, err)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/url"
 	"path"
 	"path/filepath"
@@ -15,7 +16,6 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/discentem/cavorite/fileutils"
 	"github.com/discentem/cavorite/metadata"
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 )
 
@@ -79,10 +79,10 @@ func (s *AzureBlobStore) Retrieve(ctx context.Context, objects ...string) error
 			return err
 		}
 		if fileInfo.Size() > 0 {
-			logger.Infof("%s already exists", objectPath)
+			slog.Info("object already exists", "object", objectPath)
 		} else {
 			containerName := path.Base(s.Options.BackendAddress)
-			logger.Infof("containerName: %s", containerName)
+			slog.Info("downloading object", "object", objectPath, "container", containerName)
 			// Download the file
 			resp, err := s.containerClient.DownloadStream(
 				ctx,
@@ -105,19 +105,19 @@ func (s *AzureBlobStore) Retrieve(ctx context.Context, objects ...string) error
 			}
 
 		}
-		// Get the hash for the downloaded file
-		hash, err := metadata.SHA256FromReader(f)
+		// Get the metadata from the metadata file
+		m, err := metadata.ParseCfile(s.fsys, o)
 		if err != nil {
 			return err
 		}
-		// Get the metadata from the metadata file
-		m, err := metadata.ParseCfile(s.fsys, o)
+		// Get the hash for the downloaded file
+		hash, err := metadata.HashFromReader(m.HashAlgorithm(), f)
 		if err != nil {
 			return err
 		}
 		// If the hash of the downloaded file does not match the retrieved file, return an error
 		if hash != m.Checksum {
-			logger.Infof("Hash mismatch, got %s but expected %s", hash, m.Checksum)
+			slog.Info("hash mismatch", "object", objectPath, "got", hash, "expected", m.Checksum)
 			if err := s.fsys.Remove(objectPath); err != nil {
 				return err
 			}
@@ -126,6 +126,9 @@ func (s *AzureBlobStore) Retrieve(ctx context.Context, objects ...string) error
 		if err := f.Close(); err != nil {
 			return err
 		}
+		if err := metadata.RestoreAttributes(s.fsys, objectPath, *m); err != nil {
+			return err
+		}
 	}
 	return nil
 
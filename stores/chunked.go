@@ -0,0 +1,316 @@
+package stores
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/chunker"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/objects"
+)
+
+var (
+	_ = Store(&ChunkedStore{})
+	_ = MetadataAnnotator(&ChunkedStore{})
+)
+
+// ChunkedStore splits objects into content-defined chunks and uploads only the chunks that are not
+// already present in the wrapped Store, followed by a chunk manifest stored under the object's key.
+// Chunks are stored under chunker.Key(checksum), so identical chunks are shared between all objects.
+// The wrapped Store must operate on staging.
+type ChunkedStore struct {
+	inner    Store
+	fsys     afero.Fs
+	staging  afero.Fs
+	cleanup  func() error
+	chunking chunker.Options
+	uploaded map[string]metadata.ChunksMetadata
+	// noStat warns once that the wrapped Store cannot tell which chunks it has
+	noStat sync.Once
+}
+
+func NewChunkedStore(inner Store, fsys afero.Fs, staging afero.Fs, cleanup func() error, chunking chunker.Options) *ChunkedStore {
+	return &ChunkedStore{
+		inner:    inner,
+		fsys:     fsys,
+		staging:  staging,
+		cleanup:  cleanup,
+		chunking: chunking,
+		uploaded: make(map[string]metadata.ChunksMetadata),
+	}
+}
+
+func (s *ChunkedStore) GetOptions() (Options, error) {
+	return s.inner.GetOptions()
+}
+
+// exists reports whether key is known to be present in the wrapped Store
+func (s *ChunkedStore) exists(ctx context.Context, key string) bool {
+	stat, ok := s.inner.(StatStore)
+	if !ok {
+		s.noStat.Do(func() {
+			slog.Warn("store cannot describe objects, every chunk is uploaded even if it is already stored", "store", fmt.Sprintf("%T", s.inner))
+		})
+		return false
+	}
+	_, err := stat.Stat(ctx, key)
+	if err != nil && !errors.Is(err, ErrObjectNotExist) {
+		slog.Debug("could not stat chunk, uploading it again", "key", key, "error", err)
+	}
+	return err == nil
+}
+
+func (s *ChunkedStore) writeStaged(key string, b []byte) error {
+	if err := s.staging.MkdirAll(filepath.Dir(key), os.ModePerm); err != nil {
+		return err
+	}
+	return afero.WriteFile(s.staging, key, b, 0644)
+}
+
+// Upload chunks each object and uploads new chunks and the object's manifest. Objects are uploaded one at
+// a time so that staging never holds more than one object's worth of chunks.
+func (s *ChunkedStore) Upload(ctx context.Context, keys ...string) error {
+	opts, err := s.inner.GetOptions()
+	if err != nil {
+		return err
+	}
+	prefix := objects.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}
+	for _, key := range keys {
+		if err := s.upload(ctx, prefix, key); err != nil {
+			return fmt.Errorf("chunked upload of %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (s *ChunkedStore) upload(ctx context.Context, prefix objects.AddPrefixToKey, key string) error {
+	var staged []string
+	defer func() {
+		for _, k := range staged {
+			_ = s.staging.Remove(k)
+		}
+	}()
+	f, err := s.fsys.Open(prefix.Original(key))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	seen := make(map[string]bool)
+	manifest, err := chunker.Build(f, s.chunking, func(c chunker.Chunk, _ int64, data []byte) error {
+		ck := prefix.Modify(chunker.Key(c.Checksum))
+		if seen[ck] {
+			return nil
+		}
+		seen[ck] = true
+		if s.exists(ctx, ck) {
+			return nil
+		}
+		staged = append(staged, ck)
+		return s.writeStaged(ck, data)
+	})
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return err
+	}
+	if err := s.writeStaged(key, b); err != nil {
+		return err
+	}
+	slog.Info("uploading new chunks", "key", key, "new", len(staged), "chunks", len(manifest.Chunks))
+	staged = append(staged, key)
+	if err := s.inner.Upload(ctx, staged...); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	s.uploaded[key] = metadata.ChunksMetadata{
+		ManifestChecksum: hex.EncodeToString(sum[:]),
+		Count:            len(manifest.Chunks),
+	}
+	return nil
+}
+
+// Annotate records the chunk manifest of an uploaded object in its metadata
+func (s *ChunkedStore) Annotate(key string, m *metadata.ObjectMetaData) error {
+	cm, ok := s.uploaded[key]
+	if !ok {
+		return fmt.Errorf("%q was not uploaded by this store", key)
+	}
+	m.Chunks = &cm
+	return nil
+}
+
+// Retrieve reassembles chunked objects from their manifests. Chunks that are already present in the
+// previous local version of an object are copied from it instead of being downloaded.
+// Objects without chunk metadata are passed through unchanged.
+func (s *ChunkedStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap, cfiles ...string) error {
+	if len(cfiles) == 0 {
+		return ErrCfilesLengthZero
+	}
+	var result *multierr.Error
+	digests := make(map[string]digest)
+	var wanted []string
+	for _, cfile := range cfiles {
+		m, ok := mmap[cfile]
+		if !ok {
+			// cfiles absent from mmap do not need to be retrieved
+			continue
+		}
+		if m.Chunks != nil {
+			digests[m.Name] = sha256Digest(m.Chunks.ManifestChecksum)
+		} else {
+			digests[m.Name] = objectDigest(m)
+		}
+		wanted = append(wanted, cfile)
+	}
+	if err := retrieveStaged(ctx, s.inner, digests); err != nil {
+		result = multierr.Append(result, err)
+	}
+	for _, cfile := range wanted {
+		m := mmap[cfile]
+		if _, err := s.staging.Stat(m.Name); err != nil {
+			// the wrapped store already reported why this object is missing
+			continue
+		}
+		var err error
+		if m.Chunks != nil {
+			err = s.assemble(ctx, m, inferObjPath(cfile))
+		} else {
+			err = s.restore(m, inferObjPath(cfile))
+		}
+		if err != nil {
+			result = multierr.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (s *ChunkedStore) restore(m metadata.ObjectMetaData, dst string) error {
+	defer func() { _ = s.staging.Remove(m.Name) }()
+	src, err := s.staging.Open(m.Name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	err = replaceVerified(s.fsys, dst, objectDigest(m), func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return metadata.RestoreAttributes(s.fsys, dst, m)
+}
+
+func (s *ChunkedStore) readManifest(name string) (*chunker.Manifest, error) {
+	defer func() { _ = s.staging.Remove(name) }()
+	f, err := s.staging.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return chunker.ParseManifest(f)
+}
+
+// localChunks splits a previous local version of an object the same way manifest was split and returns
+// the offset of every chunk found in it
+func (s *ChunkedStore) localChunks(local afero.File, manifest *chunker.Manifest) map[string]int64 {
+	offsets := make(map[string]int64)
+	_, err := chunker.Build(local, manifest.Options, func(c chunker.Chunk, offset int64, _ []byte) error {
+		offsets[c.Checksum] = offset
+		return nil
+	})
+	if err != nil {
+		slog.Debug("could not reuse chunks", "path", local.Name(), "error", err)
+		return map[string]int64{}
+	}
+	return offsets
+}
+
+func (s *ChunkedStore) assemble(ctx context.Context, m metadata.ObjectMetaData, dst string) error {
+	manifest, err := s.readManifest(m.Name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dst, err)
+	}
+	// manifests always record the sha256 of the object, other algorithms are verified once it is assembled
+	if m.HashAlgorithm() == metadata.AlgorithmSHA256 && manifest.Checksum != m.Checksum {
+		return fmt.Errorf("%s: manifest describes %s, not %s: %w", dst, manifest.Checksum, m.Checksum, metadata.ErrRetrieveFailureHashMismatch)
+	}
+	opts, err := s.inner.GetOptions()
+	if err != nil {
+		return err
+	}
+	prefix := objects.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}
+
+	reuse := map[string]int64{}
+	var local afero.File
+	if f, err := s.fsys.Open(dst); err == nil {
+		local = f
+		defer f.Close()
+		reuse = s.localChunks(local, manifest)
+	}
+	need := make(map[string]digest)
+	for _, c := range manifest.Chunks {
+		if _, ok := reuse[c.Checksum]; !ok {
+			need[prefix.Modify(chunker.Key(c.Checksum))] = sha256Digest(c.Checksum)
+		}
+	}
+	defer func() {
+		for k := range need {
+			_ = s.staging.Remove(k)
+		}
+	}()
+	slog.Info("downloading chunks", "path", dst, "download", len(need), "reused", len(manifest.Chunks)-len(need))
+	if err := retrieveStaged(ctx, s.inner, need); err != nil {
+		return err
+	}
+	err = replaceVerified(s.fsys, dst, objectDigest(m), func(w io.Writer) error {
+		for _, c := range manifest.Chunks {
+			if offset, ok := reuse[c.Checksum]; ok {
+				if _, err := io.Copy(w, io.NewSectionReader(local, offset, c.Size)); err != nil {
+					return err
+				}
+				continue
+			}
+			f, err := s.staging.Open(prefix.Modify(chunker.Key(c.Checksum)))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+		if local != nil {
+			return local.Close()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return metadata.RestoreAttributes(s.fsys, dst, m)
+}
+
+func (s *ChunkedStore) Close() error {
+	var result *multierr.Error
+	result = multierr.Append(result, s.inner.Close())
+	if s.cleanup != nil {
+		result = multierr.Append(result, s.cleanup())
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,133 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"testing"
+
+	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/discentem/cavorite/chunker"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingDownloader struct {
+	S3Downloader
+	downloads *int
+}
+
+func (d countingDownloader) Download(ctx context.Context, w io.WriterAt, input *s3.GetObjectInput, options ...func(*s3manager.Downloader)) (int64, error) {
+	*d.downloads++
+	return d.S3Downloader.Download(ctx, w, input, options...)
+}
+
+func TestChunkedStoreDeduplicates(t *testing.T) {
+	ctx := context.Background()
+	fsys := afero.NewMemMapFs()
+	server := aferoS3Server{buckets: map[string]afero.Fs{"test": afero.NewMemMapFs()}}
+	downloads := 0
+	staging := afero.NewMemMapFs()
+	store := NewChunkedStore(&S3Store{
+		Options: Options{
+			BackendAddress:        "s3://test",
+			MetadataFileExtension: "cfile",
+			ObjectKeyPrefix:       "team",
+		},
+		fsys:         staging,
+		s3Uploader:   server,
+		s3Downloader: countingDownloader{S3Downloader: server, downloads: &downloads},
+		s3Client:     server,
+	}, fsys, staging, nil, chunker.Options{MinSize: 1024, AvgSize: 4096, MaxSize: 16384})
+
+	upload := func(content []byte) metadata.ObjectMetaData {
+		require.NoError(t, afero.WriteFile(fsys, "build.pak", content, 0644))
+		require.NoError(t, store.Upload(ctx, "team/build.pak"))
+		f, err := fsys.Open("build.pak")
+		require.NoError(t, err)
+		m, err := metadata.GenerateFromFile(f, "team/build.pak")
+		require.NoError(t, err)
+		require.NoError(t, store.Annotate("team/build.pak", m))
+		return *m
+	}
+	countChunks := func() int {
+		n := 0
+		require.NoError(t, afero.Walk(server.buckets["test"], "team/chunks", func(_ string, info fs.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				n++
+			}
+			return err
+		}))
+		return n
+	}
+
+	v1 := make([]byte, 256*1024)
+	_, err := rand.New(rand.NewSource(1)).Read(v1)
+	require.NoError(t, err)
+	m1 := upload(v1)
+	require.NotNil(t, m1.Chunks)
+	assert.Equal(t, m1.Chunks.Count, countChunks())
+
+	v2 := bytes.Clone(v1)
+	copy(v2[100000:], "a small change to the second revision")
+	m2 := upload(v2)
+	newChunks := countChunks() - m1.Chunks.Count
+	assert.Greater(t, newChunks, 0)
+	assert.Less(t, newChunks, 4, "only chunks around the change should be uploaded")
+
+	// retrieving v2 over a local copy of v1 only downloads the manifest and the changed chunks
+	require.NoError(t, afero.WriteFile(fsys, "build.pak", v1, 0644))
+	err = store.Retrieve(ctx, metadata.CfileMetadataMap{"build.pak.cfile": m2}, "build.pak.cfile")
+	require.NoError(t, err)
+	b, err := afero.ReadFile(fsys, "build.pak")
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(v2, b))
+	assert.Equal(t, 1+newChunks, downloads)
+
+	// without a local copy every chunk is downloaded
+	require.NoError(t, fsys.Remove("build.pak"))
+	downloads = 0
+	err = store.Retrieve(ctx, metadata.CfileMetadataMap{"build.pak.cfile": m2}, "build.pak.cfile")
+	require.NoError(t, err)
+	b, err = afero.ReadFile(fsys, "build.pak")
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(v2, b))
+	assert.Equal(t, 1+m2.Chunks.Count, downloads)
+}
+
+// statlessStore only exposes the methods of Store, so it is not a StatStore
+type statlessStore struct {
+	Store
+}
+
+func TestChunkedStoreWarnsWithoutStat(t *testing.T) {
+	var w bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&w, nil)))
+	ctx := context.Background()
+	fsys := afero.NewMemMapFs()
+	server := aferoS3Server{buckets: map[string]afero.Fs{"test": afero.NewMemMapFs()}}
+	staging := afero.NewMemMapFs()
+	store := NewChunkedStore(statlessStore{&S3Store{
+		Options:      Options{BackendAddress: "s3://test", MetadataFileExtension: "cfile"},
+		fsys:         staging,
+		s3Uploader:   server,
+		s3Downloader: server,
+		s3Client:     server,
+	}}, fsys, staging, nil, chunker.Options{MinSize: 1024, AvgSize: 4096, MaxSize: 16384})
+
+	content := make([]byte, 64*1024)
+	_, err := rand.New(rand.NewSource(2)).Read(content)
+	require.NoError(t, err)
+	require.NoError(t, afero.WriteFile(fsys, "a.pak", content, 0644))
+	require.NoError(t, afero.WriteFile(fsys, "b.pak", content, 0644))
+	require.NoError(t, store.Upload(ctx, "a.pak", "b.pak"))
+	assert.Equal(t, 1, strings.Count(w.String(), "every chunk is uploaded"), w.String())
+}
@@ -0,0 +1,182 @@
+package stores
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/encryption"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/objects"
+)
+
+var (
+	_ = Store(&EncryptedStore{})
+	_ = MetadataAnnotator(&EncryptedStore{})
+)
+
+// EncryptedStore encrypts objects before handing them to another Store and decrypts them after retrieval.
+// The wrapped Store must operate on staging so that ciphertext is never written into the source repo.
+type EncryptedStore struct {
+	inner    Store
+	fsys     afero.Fs
+	staging  afero.Fs
+	cleanup  func() error
+	key      *encryption.Key
+	uploaded map[string]metadata.EncryptionMetadata
+}
+
+func NewEncryptedStore(inner Store, fsys afero.Fs, staging afero.Fs, cleanup func() error, key *encryption.Key) *EncryptedStore {
+	return &EncryptedStore{
+		inner:    inner,
+		fsys:     fsys,
+		staging:  staging,
+		cleanup:  cleanup,
+		key:      key,
+		uploaded: make(map[string]metadata.EncryptionMetadata),
+	}
+}
+
+func (s *EncryptedStore) GetOptions() (Options, error) {
+	return s.inner.GetOptions()
+}
+
+// Upload encrypts each object into staging and uploads the ciphertext with the wrapped Store
+func (s *EncryptedStore) Upload(ctx context.Context, keys ...string) error {
+	opts, err := s.inner.GetOptions()
+	if err != nil {
+		return err
+	}
+	prefix := objects.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}
+	defer func() {
+		for _, key := range keys {
+			_ = s.staging.Remove(key)
+		}
+	}()
+	for _, key := range keys {
+		em, err := s.stage(prefix.Original(key), key)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %w", key, err)
+		}
+		s.uploaded[key] = *em
+	}
+	return s.inner.Upload(ctx, keys...)
+}
+
+func (s *EncryptedStore) stage(src, key string) (*metadata.EncryptionMetadata, error) {
+	in, err := s.fsys.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	if err := s.staging.MkdirAll(filepath.Dir(key), os.ModePerm); err != nil {
+		return nil, err
+	}
+	out, err := s.staging.Create(key)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	h := sha256.New()
+	if err := encryption.Encrypt(io.MultiWriter(out, h), in, s.key); err != nil {
+		return nil, err
+	}
+	return &metadata.EncryptionMetadata{
+		Scheme:             encryption.Scheme,
+		KeyID:              s.key.ID,
+		CiphertextChecksum: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Annotate records the encryption details of an uploaded object in its metadata
+func (s *EncryptedStore) Annotate(key string, m *metadata.ObjectMetaData) error {
+	em, ok := s.uploaded[key]
+	if !ok {
+		return fmt.Errorf("%q was not uploaded by this store", key)
+	}
+	m.Encryption = &em
+	return nil
+}
+
+// Retrieve downloads the ciphertext for each cfile into staging, decrypts it next to the cfile and
+// verifies the plaintext digest. Objects without encryption metadata are passed through unchanged.
+func (s *EncryptedStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap, cfiles ...string) error {
+	if len(cfiles) == 0 {
+		return ErrCfilesLengthZero
+	}
+	var result *multierr.Error
+	digests := make(map[string]digest)
+	var wanted []string
+	for _, cfile := range cfiles {
+		m, ok := mmap[cfile]
+		if !ok {
+			// cfiles absent from mmap do not need to be retrieved
+			continue
+		}
+		want := objectDigest(m)
+		if e := m.Encryption; e != nil {
+			if e.Scheme != encryption.Scheme {
+				result = multierr.Append(result, fmt.Errorf("%s: unsupported encryption scheme %q", cfile, e.Scheme))
+				continue
+			}
+			if e.KeyID != s.key.ID {
+				result = multierr.Append(result, fmt.Errorf("%s: %w: want key %s, have %s", cfile, encryption.ErrUnknownKeyID, e.KeyID, s.key.ID))
+				continue
+			}
+			want = sha256Digest(e.CiphertextChecksum)
+		}
+		digests[m.Name] = want
+		wanted = append(wanted, cfile)
+	}
+	if err := retrieveStaged(ctx, s.inner, digests); err != nil {
+		result = multierr.Append(result, err)
+	}
+	for _, cfile := range wanted {
+		m := mmap[cfile]
+		if _, err := s.staging.Stat(m.Name); err != nil {
+			// the wrapped store already reported why this object is missing
+			continue
+		}
+		if err := s.restore(m, inferObjPath(cfile)); err != nil {
+			result = multierr.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// restore writes the plaintext of the staged object for m to dst
+func (s *EncryptedStore) restore(m metadata.ObjectMetaData, dst string) error {
+	defer func() { _ = s.staging.Remove(m.Name) }()
+	src, err := s.staging.Open(m.Name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	err = replaceVerified(s.fsys, dst, objectDigest(m), func(w io.Writer) error {
+		if m.Encryption != nil {
+			return encryption.Decrypt(w, src, s.key)
+		}
+		_, err := io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return metadata.RestoreAttributes(s.fsys, dst, m)
+}
+
+func (s *EncryptedStore) Close() error {
+	var result *multierr.Error
+	result = multierr.Append(result, s.inner.Close())
+	if s.cleanup != nil {
+		result = multierr.Append(result, s.cleanup())
+	}
+	return result.ErrorOrNil()
+}
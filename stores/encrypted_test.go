@@ -0,0 +1,71 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/discentem/cavorite/encryption"
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/testutils"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEncryptedStore(t *testing.T, fsys afero.Fs, server aferoS3Server, keyByte byte) *EncryptedStore {
+	t.Helper()
+	key, err := encryption.NewKey(bytes.Repeat([]byte{keyByte}, encryption.KeySize))
+	require.NoError(t, err)
+	staging := afero.NewMemMapFs()
+	inner := &S3Store{
+		Options: Options{
+			BackendAddress:        "s3://test",
+			MetadataFileExtension: "cfile",
+		},
+		fsys:         staging,
+		s3Uploader:   server,
+		s3Downloader: server,
+	}
+	return NewEncryptedStore(inner, fsys, staging, nil, key)
+}
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
+	fsys, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"firmware.bin": {Content: []byte("proprietary"), ModTime: &mTime},
+	})
+	require.NoError(t, err)
+	server := aferoS3Server{buckets: map[string]afero.Fs{"test": afero.NewMemMapFs()}}
+	store := newTestEncryptedStore(t, *fsys, server, 1)
+
+	require.NoError(t, store.Upload(context.Background(), "firmware.bin"))
+	stored, err := afero.ReadFile(server.buckets["test"], "firmware.bin")
+	require.NoError(t, err)
+	assert.NotContains(t, string(stored), "proprietary")
+
+	f, err := (*fsys).Open("firmware.bin")
+	require.NoError(t, err)
+	m, err := metadata.GenerateFromFile(f, "firmware.bin")
+	require.NoError(t, err)
+	require.NoError(t, store.Annotate("firmware.bin", m))
+	require.NotNil(t, m.Encryption)
+	assert.Equal(t, encryption.Scheme, m.Encryption.Scheme)
+	assert.NotEqual(t, m.Checksum, m.Encryption.CiphertextChecksum)
+
+	require.NoError(t, (*fsys).Remove("firmware.bin"))
+	mmap := metadata.CfileMetadataMap{"firmware.bin.cfile": *m}
+	require.NoError(t, store.Retrieve(context.Background(), mmap, "firmware.bin.cfile"))
+	b, err := afero.ReadFile(*fsys, "firmware.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "proprietary", string(b))
+
+	// a store configured with a different key must refuse to write anything
+	require.NoError(t, (*fsys).Remove("firmware.bin"))
+	other := newTestEncryptedStore(t, *fsys, server, 2)
+	err = other.Retrieve(context.Background(), mmap, "firmware.bin.cfile")
+	assert.ErrorIs(t, err, encryption.ErrUnknownKeyID)
+	_, err = (*fsys).Stat("firmware.bin")
+	assert.Error(t, err)
+}
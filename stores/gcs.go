@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
@@ -11,7 +12,6 @@ import (
 	gcsStorage "cloud.google.com/go/storage"
 	"github.com/discentem/cavorite/fileutils"
 	"github.com/discentem/cavorite/metadata"
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 	"google.golang.org/api/option"
 )
@@ -68,7 +68,7 @@ func (s *GCSStore) GetFsys() (afero.Fs, error) {
 // Upload generates the metadata, writes it s.fsys and uploads the file to the GCS bucket
 func (s *GCSStore) Upload(ctx context.Context, objects ...string) error {
 	for _, o := range objects {
-		logger.V(2).Infof("Object: %s\n", o)
+		slog.Debug("uploading object", "object", o)
 		f, err := s.fsys.Open(o)
 		if err != nil {
 			return err
@@ -101,7 +101,7 @@ func (s *GCSStore) Upload(ctx context.Context, objects ...string) error {
 		if err := wc.Close(); err != nil {
 			// Error will contain this string if the DoesNotExist condition isn't met
 			if strings.Contains(err.Error(), "conditionNotMet") {
-				logger.Infof("%s already exists, skipping...", o)
+				slog.Info("object already exists, skipping it", "object", o)
 			} else {
 				return err
 			}
@@ -126,7 +126,7 @@ func (s *GCSStore) Retrieve(ctx context.Context, metaObjects ...string) error {
 			return err
 		}
 		if fileInfo.Size() > 0 {
-			logger.Infof("%s already exists", objectPath)
+			slog.Info("object already exists", "object", objectPath)
 		} else { // Download the file as it doesn't exist on disk
 			rc, err := s.gcsClient.Bucket(s.Options.BackendAddress).Object(objectPath).NewReader(ctx)
 			if err != nil {
@@ -144,19 +144,19 @@ func (s *GCSStore) Retrieve(ctx context.Context, metaObjects ...string) error {
 				return err
 			}
 		}
-		// Get the hash for the downloaded file
-		hash, err := metadata.SHA256FromReader(f)
+		// Get the metadata from the metadata file
+		m, err := metadata.ParseCfile(s.fsys, mo)
 		if err != nil {
 			return err
 		}
-		// Get the metadata from the metadata file
-		m, err := metadata.ParseCfile(s.fsys, mo)
+		// Get the hash for the downloaded file
+		hash, err := metadata.HashFromReader(m.HashAlgorithm(), f)
 		if err != nil {
 			return err
 		}
 		// If the hash of the downloaded file does not match the retrieved file, return an error
 		if hash != m.Checksum {
-			logger.Infof("Hash mismatch, got %s but expected %s", hash, m.Checksum)
+			slog.Info("hash mismatch", "object", objectPath, "got", hash, "expected", m.Checksum)
 			if err := s.fsys.Remove(objectPath); err != nil {
 				return err
 			}
@@ -165,6 +165,9 @@ func (s *GCSStore) Retrieve(ctx context.Context, metaObjects ...string) error {
 		if err := f.Close(); err != nil {
 			return err
 		}
+		if err := metadata.RestoreAttributes(s.fsys, objectPath, *m); err != nil {
+			return err
+		}
 	}
 	return nil
 }
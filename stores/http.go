@@ -0,0 +1,200 @@
+package stores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	multierr "github.com/hashicorp/go-multierror"
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/metadata"
+)
+
+const (
+	// HTTPTokenEnv is the environment variable holding the token HTTPStore and CacheServer
+	// authenticate with
+	HTTPTokenEnv = "CAVORITE_HTTP_TOKEN"
+	// DigestHeader carries the digest an object is expected to have as <algorithm>:<checksum>, so
+	// that a CacheServer can verify objects it fetches from its backend
+	DigestHeader = "Cavorite-Digest"
+)
+
+var (
+	_ = Store(&HTTPStore{})
+	_ = StatStore(&HTTPStore{})
+
+	ErrUnauthorized = errors.New("the server rejected the token, check " + HTTPTokenEnv)
+)
+
+// HTTPStore stores objects on a CacheServer, Options.BackendAddress is the URL of the server
+type HTTPStore struct {
+	Options Options `json:"options" mapstructure:"options"`
+	fsys    afero.Fs
+	client  *http.Client
+	token   string
+}
+
+// NewHTTPStore returns an HTTPStore reading and writing objects in fsys. The token is read from
+// HTTPTokenEnv.
+func NewHTTPStore(fsys afero.Fs, opts Options) (*HTTPStore, error) {
+	u, err := url.Parse(opts.BackendAddress)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("address did not contain http:// or https:// prefix")
+	}
+	return &HTTPStore{
+		Options: opts,
+		fsys:    fsys,
+		client:  &http.Client{},
+		token:   os.Getenv(HTTPTokenEnv),
+	}, nil
+}
+
+func (s *HTTPStore) GetOptions() (Options, error) {
+	return s.Options, nil
+}
+
+func (s *HTTPStore) GetFsys() (afero.Fs, error) {
+	return s.fsys, nil
+}
+
+// request sends a request for key to the server and returns the response if its status is not an
+// error, closing it otherwise
+func (s *HTTPStore) request(ctx context.Context, method, key string, body io.Reader, header http.Header) (*http.Response, error) {
+	u, err := url.JoinPath(s.Options.BackendAddress, strings.Split(key, "/")...)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, ErrUnauthorized
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotExist, key)
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%s: %w", key, metadata.ErrRetrieveFailureHashMismatch)
+	}
+	return nil, fmt.Errorf("%s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// Upload sends each object to the server, which uploads it to its own store
+func (s *HTTPStore) Upload(ctx context.Context, objects ...string) error {
+	for _, o := range objects {
+		if err := s.upload(ctx, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *HTTPStore) upload(ctx context.Context, key string) error {
+	f, err := s.fsys.Open(key)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	algorithm := s.Options.HashAlgorithm
+	if algorithm == "" {
+		algorithm = metadata.DefaultAlgorithm
+	}
+	// the server only caches what matches the digest
+	checksum, err := metadata.HashFromReader(algorithm, io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return err
+	}
+	header := http.Header{DigestHeader: {algorithm + ":" + checksum}}
+	// a sized body is sent with a Content-Length instead of being chunked
+	resp, err := s.request(ctx, http.MethodPut, key, io.NewSectionReader(f, 0, info.Size()), header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Retrieve downloads the object of each cfile from the server and verifies its digest
+func (s *HTTPStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap, cfiles ...string) error {
+	if len(cfiles) == 0 {
+		return ErrCfilesLengthZero
+	}
+	var result *multierr.Error
+	for _, cfile := range cfiles {
+		m, ok := mmap[cfile]
+		if !ok {
+			result = multierr.Append(result, fmt.Errorf("%q not found in mmap", cfile))
+			continue
+		}
+		if err := s.retrieve(ctx, m); err != nil {
+			result = multierr.Append(result, err)
+			continue
+		}
+		if err := metadata.RestoreAttributes(s.fsys, inferObjPath(cfile), m); err != nil {
+			result = multierr.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (s *HTTPStore) retrieve(ctx context.Context, m metadata.ObjectMetaData) error {
+	want := objectDigest(m)
+	header := http.Header{DigestHeader: {want.algorithm + ":" + want.checksum}}
+	resp, err := s.request(ctx, http.MethodGet, m.Name, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	slog.Debug("retrieving object", "key", m.Name, "address", s.Options.BackendAddress)
+	return replaceVerified(s.fsys, m.Name, want, func(w io.Writer) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
+}
+
+// Stat describes key without downloading it
+func (s *HTTPStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	resp, err := s.request(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer resp.Body.Close()
+	info := ObjectInfo{Key: key, Size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = modified
+	}
+	return info, nil
+}
+
+func (s *HTTPStore) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
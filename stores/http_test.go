@@ -0,0 +1,84 @@
+package stores
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/discentem/cavorite/metadata"
+)
+
+// newTestCacheServer returns a CacheServer backed by an S3Store on a fake bucket and that bucket
+func newTestCacheServer(t *testing.T, token string) (*httptest.Server, afero.Fs, afero.Fs) {
+	t.Helper()
+	bucket := afero.NewMemMapFs()
+	cache := afero.NewMemMapFs()
+	server := aferoS3Server{buckets: map[string]afero.Fs{"test": bucket}}
+	backend := &S3Store{
+		Options:      Options{BackendAddress: "s3://test", MetadataFileExtension: "cfile"},
+		fsys:         cache,
+		s3Uploader:   server,
+		s3Downloader: server,
+		s3Client:     server,
+	}
+	ts := httptest.NewServer(NewCacheServer(backend, cache, token))
+	t.Cleanup(ts.Close)
+	return ts, bucket, cache
+}
+
+func TestHTTPStore(t *testing.T) {
+	ctx := context.Background()
+	ts, bucket, cache := newTestCacheServer(t, "secret")
+	t.Setenv(HTTPTokenEnv, "secret")
+	fsys := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fsys, "team/tools/tool", []byte("stuff"), 0644))
+	s, err := NewHTTPStore(fsys, Options{BackendAddress: ts.URL, MetadataFileExtension: "cfile"})
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.Upload(ctx, "team/tools/tool"))
+	b, err := afero.ReadFile(bucket, "team/tools/tool")
+	require.NoError(t, err)
+	assert.Equal(t, "stuff", string(b))
+	info, err := s.Stat(ctx, "team/tools/tool")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), info.Size)
+	_, err = s.Stat(ctx, "team/tools/missing")
+	assert.ErrorIs(t, err, ErrObjectNotExist)
+
+	f, err := fsys.Open("team/tools/tool")
+	require.NoError(t, err)
+	m, err := metadata.GenerateFromFile(f, "team/tools/tool")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	mmap := metadata.CfileMetadataMap{"team/tools/tool.cfile": *m}
+	// retrieved from the cache, then from the bucket once the cache is gone
+	for _, clear := range []afero.Fs{nil, cache} {
+		if clear != nil {
+			require.NoError(t, clear.RemoveAll("team"))
+		}
+		require.NoError(t, fsys.Remove("team/tools/tool"))
+		require.NoError(t, s.Retrieve(ctx, mmap, "team/tools/tool.cfile"))
+		b, err = afero.ReadFile(fsys, "team/tools/tool")
+		require.NoError(t, err)
+		assert.Equal(t, "stuff", string(b))
+	}
+
+	wrong := *m
+	wrong.Checksum = strings.Repeat("0", 64)
+	err = s.Retrieve(ctx, metadata.CfileMetadataMap{"team/tools/tool.cfile": wrong}, "team/tools/tool.cfile")
+	assert.ErrorIs(t, err, metadata.ErrRetrieveFailureHashMismatch)
+
+	s.token = "wrong"
+	assert.ErrorIs(t, s.Upload(ctx, "team/tools/tool"), ErrUnauthorized)
+}
+
+func TestNewHTTPStoreRequiresHTTPAddress(t *testing.T) {
+	_, err := NewHTTPStore(afero.NewMemMapFs(), Options{BackendAddress: "s3://test"})
+	assert.Error(t, err)
+}
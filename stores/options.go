@@ -7,6 +7,8 @@ type Options struct {
 	PluginAddress         string `json:"plugin_address,omitempty" mapstructure:"plugin_address"`
 	MetadataFileExtension string `json:"metadata_file_extension" mapstructure:"metadata_file_extension"`
 	Region                string `json:"region" mapstructure:"region"`
+	// HashAlgorithm is used to checksum objects in new cfiles, metadata.DefaultAlgorithm if empty
+	HashAlgorithm string `json:"hash_algorithm,omitempty" mapstructure:"hash_algorithm"`
 	/*
 		If ObjectKeyPrefix is set to "team-bucket", and the initialized backend supports it,
 			- `cavorite upload whatever/thing` will be written to `team-bucket/whatever/thing`
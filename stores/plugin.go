@@ -3,7 +3,7 @@ package stores
 import (
 	"context"
 	"fmt"
-	"os"
+	"log/slog"
 	"os/exec"
 
 	"github.com/hashicorp/go-hclog"
@@ -14,6 +14,7 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/discentem/cavorite/logging"
 	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/stores/pluginproto"
 )
@@ -28,13 +29,6 @@ var (
 		MagicCookieKey:   "BASIC_PLUGIN",
 		MagicCookieValue: "cavorite",
 	}
-
-	// FIXME: make configurable?
-	HLog = hclog.New(&hclog.LoggerOptions{
-		Name:   "plugin",
-		Output: os.Stdout,
-		Level:  hclog.Debug,
-	})
 )
 
 // clientStore is the client cavorite uses to communicate with the plugin
@@ -54,6 +48,9 @@ func MetadataMapToPluginProtoMap(mmap metadata.CfileMetadataMap) map[string]*plu
 			Name:         v.Name,
 			Checksum:     v.Checksum,
 			DateModified: timestamppb.New(v.DateModified),
+			Algorithm:    v.Algorithm,
+			Mode:         uint32(v.Mode),
+			Size:         v.Size,
 		}
 	}
 	return ppmm
@@ -66,6 +63,9 @@ func PluginProtoMapToMetadataMap(ppm *pluginproto.ObjectsAndMetadataMap) metadat
 			Name:         v.Name,
 			Checksum:     v.Checksum,
 			DateModified: v.DateModified.AsTime(),
+			Algorithm:    v.Algorithm,
+			Mode:         metadata.FileMode(v.Mode),
+			Size:         v.Size,
 		}
 	}
 	return mm
@@ -94,6 +94,7 @@ func (p *clientStore) GetOptions() (Options, error) {
 		BackendAddress:        opts.BackendAddress,
 		MetadataFileExtension: opts.MetadataFileExtension,
 		Region:                opts.Region,
+		HashAlgorithm:         opts.HashAlgorithm,
 	}, nil
 }
 
@@ -142,6 +143,7 @@ func (p *serverStore) GetOptions(_ context.Context, _ *emptypb.Empty) (*pluginpr
 		BackendAddress:        opts.BackendAddress,
 		MetadataFileExtension: opts.MetadataFileExtension,
 		Region:                opts.Region,
+		HashAlgorithm:         opts.HashAlgorithm,
 	}, nil
 }
 
@@ -168,11 +170,12 @@ type PluggableStore struct {
 
 func NewPluggableStore(_ context.Context, opts Options) (*PluggableStore, error) {
 	cmd := exec.Command(opts.PluginAddress)
+	// the output of the plugin is logged by the logger of cavorite, named after the plugin
 	client := plugin.NewClient(&plugin.ClientConfig{
 		HandshakeConfig:  HandshakeConfig,
 		Plugins:          PluginSet,
 		Cmd:              cmd,
-		Logger:           HLog,
+		Logger:           logging.HCLog(slog.Default(), "plugin"),
 		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
 	})
 
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/url"
 	"path"
 	"strings"
@@ -12,7 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/google/logger"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/spf13/afero"
 
 	"github.com/discentem/cavorite/fileutils"
@@ -38,12 +39,29 @@ type S3Uploader interface {
 	)
 }
 
+// S3Client is the subset of the s3 API that S3Store calls directly
+type S3Client interface {
+	HeadObject(ctx context.Context,
+		params *s3.HeadObjectInput,
+		optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(ctx context.Context,
+		params *s3.ListObjectsV2Input,
+		optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	DeleteObjects(ctx context.Context,
+		params *s3.DeleteObjectsInput,
+		optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+}
+
+// maxDeleteObjects is the number of keys s3 deletes at most per request
+const maxDeleteObjects = 1000
+
 type S3Store struct {
 	Options      Options `json:"options" mapstructure:"options"`
 	fsys         afero.Fs
 	awsRegion    string
 	s3Uploader   S3Uploader
 	s3Downloader S3Downloader
+	s3Client     S3Client
 }
 
 func getConfig(ctx context.Context, region string, address string) (*aws.Config, error) {
@@ -122,6 +140,7 @@ func NewS3Store(ctx context.Context, fsys afero.Fs, opts Options) (*S3Store, err
 		s3Uploader: s3Uploader,
 		// s3Downloader meets our interface for S3Downloader
 		s3Downloader: s3Downloader,
+		s3Client:     s3Client,
 	}, nil
 }
 
@@ -144,7 +163,7 @@ func (s *S3Store) Upload(ctx context.Context, objects ...string) error {
 		// Generate S3 struct for object and upload to S3 bucket
 		s3BucketName, err := s.getBucketName()
 		if err != nil {
-			logger.Errorf("error encountered parsing backend address: %v", err)
+			slog.Error("parsing backend address", "error", err)
 			return err
 		}
 		obj := s3.PutObjectInput{
@@ -152,9 +171,8 @@ func (s *S3Store) Upload(ctx context.Context, objects ...string) error {
 			Key:    aws.String(o),
 			Body:   f,
 		}
-		out, err := s.s3Uploader.Upload(ctx, &obj)
-		if err != nil {
-			logger.Error(out)
+		if _, err := s.s3Uploader.Upload(ctx, &obj); err != nil {
+			slog.Error("uploading object", "object", o, "error", err)
 			return err
 		}
 		if err := f.Close(); err != nil {
@@ -194,7 +212,7 @@ func (s *S3Store) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap,
 			result = multierr.Append(result, e)
 			continue
 		}
-		logger.V(2).Infof("mmap: %s", mmap)
+		slog.Debug("retrieving object", "cfile", cfile, "metadata", mmap)
 		m, ok := mmap[cfile]
 		if !ok {
 			result = multierr.Append(result, fmt.Errorf("%q not found in mmap", cfile))
@@ -212,13 +230,13 @@ func (s *S3Store) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap,
 			continue
 		}
 
-		matches, err := metadata.HashFromCfileMatches(s.fsys, cfile, m.Checksum)
+		matches, err := metadata.HashFromCfileMatches(s.fsys, cfile, m.HashAlgorithm(), m.Checksum)
 		if err != nil {
 			result = multierr.Append(result, err)
 			continue
 		}
 		if !matches {
-			logger.V(2).Infof("hash for %s did not match expected hash (%q) in %q", m.Name, m.Checksum, cfile)
+			slog.Debug("hash mismatch", "object", m.Name, "expected", m.Checksum, "cfile", cfile)
 			if err := s.fsys.Remove(m.Name); err != nil {
 				result = multierr.Append(result, err)
 				continue
@@ -226,13 +244,109 @@ func (s *S3Store) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap,
 			result = multierr.Append(result, metadata.ErrRetrieveFailureHashMismatch)
 			continue
 		}
+		if err := metadata.RestoreAttributes(s.fsys, inferObjPath(cfile), m); err != nil {
+			result = multierr.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// Stat describes key without downloading it
+func (s *S3Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if s.s3Client == nil {
+		return ObjectInfo{}, errors.New("s3Client is not initialized")
+	}
+	s3BucketName, err := s.getBucketName()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	out, err := s.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s3BucketName),
+		Key:    aws.String(key),
+	})
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotExist, key)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info := ObjectInfo{
+		Key:  key,
+		Size: aws.ToInt64(out.ContentLength),
+	}
+	if out.LastModified != nil {
+		info.LastModified = *out.LastModified
+	}
+	return info, nil
+}
+
+// List describes every object whose key starts with prefix
+func (s *S3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if s.s3Client == nil {
+		return nil, errors.New("s3Client is not initialized")
+	}
+	s3BucketName, err := s.getBucketName()
+	if err != nil {
+		return nil, err
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s3BucketName),
+		Prefix: aws.String(prefix),
+	})
+	var infos []ObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			info := ObjectInfo{
+				Key:  aws.ToString(obj.Key),
+				Size: aws.ToInt64(obj.Size),
+			}
+			if obj.LastModified != nil {
+				info.LastModified = *obj.LastModified
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+// Delete deletes keys, at most maxDeleteObjects per request
+func (s *S3Store) Delete(ctx context.Context, keys ...string) error {
+	if s.s3Client == nil {
+		return errors.New("s3Client is not initialized")
+	}
+	s3BucketName, err := s.getBucketName()
+	if err != nil {
+		return err
+	}
+	var result *multierr.Error
+	for start := 0; start < len(keys); start += maxDeleteObjects {
+		batch := keys[start:min(start+maxDeleteObjects, len(keys))]
+		ids := make([]types.ObjectIdentifier, len(batch))
+		for i, key := range batch {
+			ids[i] = types.ObjectIdentifier{Key: aws.String(key)}
+		}
+		out, err := s.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s3BucketName),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range out.Errors {
+			result = multierr.Append(result, fmt.Errorf("deleting %s: %s", aws.ToString(e.Key), aws.ToString(e.Message)))
+		}
 	}
 	return result.ErrorOrNil()
 }
 
 func (s *S3Store) getBucketName() (string, error) {
 	var bucketName string
-	logger.Infof("s3store getBucketName: backend address: %s", s.Options.BackendAddress)
+	slog.Debug("getting bucket name", "address", s.Options.BackendAddress)
 	switch {
 	case strings.HasPrefix(s.Options.BackendAddress, "s3://"):
 		s3BucketUrl, err := url.Parse(s.Options.BackendAddress)
@@ -255,7 +369,7 @@ func (s *S3Store) getBucketName() (string, error) {
 		return "", fmt.Errorf("unsupported s3 backend address")
 	}
 
-	logger.V(2).Infof("s3store getBucketName: bucket: %s", bucketName)
+	slog.Debug("got bucket name", "bucket", bucketName)
 	return bucketName, nil
 }
 
@@ -6,14 +6,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/discentem/cavorite/metadata"
 	"github.com/discentem/cavorite/testutils"
-	"github.com/google/logger"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -22,6 +25,8 @@ import (
 
 type aferoS3Server struct {
 	buckets map[string]afero.Fs
+	// pageSize, if not zero, is the number of objects ListObjectsV2 returns at most
+	pageSize int
 }
 
 func (s aferoS3Server) Upload(ctx context.Context,
@@ -39,22 +44,83 @@ func (s aferoS3Server) Upload(ctx context.Context,
 	if err != nil {
 		return nil, err
 	}
-	// create a filesystem for bucket referenced in input
-	bucketfs, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
-		*input.Key: {
-			// write input body to bucketfs
-			Content: inputBytes,
-		},
-	})
-	if err != nil {
+	// write input body to the bucket referenced in input
+	bucketfs := s.buckets[bucket]
+	if err := bucketfs.MkdirAll(filepath.Dir(*input.Key), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := afero.WriteFile(bucketfs, *input.Key, inputBytes, 0644); err != nil {
 		return nil, err
 	}
-	// write bucketfs to associated bucket
-	s.buckets[bucket] = *bucketfs
 	// S3Store doesn't use UploadOutput, so in the test we don't either
 	return nil, nil
 }
 
+func (s aferoS3Server) HeadObject(ctx context.Context,
+	input *s3.HeadObjectInput,
+	optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	bucketfs, ok := s.buckets[*input.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist in this aferoS3Server", *input.Bucket)
+	}
+	info, err := bucketfs.Stat(*input.Key)
+	if err != nil {
+		return nil, &types.NotFound{}
+	}
+	modTime := info.ModTime()
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(info.Size()),
+		LastModified:  &modTime,
+	}, nil
+}
+
+func (s aferoS3Server) ListObjectsV2(ctx context.Context,
+	input *s3.ListObjectsV2Input,
+	optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	bucketfs, ok := s.buckets[*input.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist in this aferoS3Server", *input.Bucket)
+	}
+	var contents []types.Object
+	err := afero.Walk(bucketfs, "", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		key := filepath.ToSlash(path)
+		if !strings.HasPrefix(key, aws.ToString(input.Prefix)) || key <= aws.ToString(input.ContinuationToken) {
+			return nil
+		}
+		modTime := info.ModTime()
+		contents = append(contents, types.Object{Key: aws.String(key), Size: aws.Int64(info.Size()), LastModified: &modTime})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &s3.ListObjectsV2Output{Contents: contents, IsTruncated: aws.Bool(false)}
+	if s.pageSize > 0 && len(contents) > s.pageSize {
+		out.Contents = contents[:s.pageSize]
+		out.IsTruncated = aws.Bool(true)
+		out.NextContinuationToken = out.Contents[s.pageSize-1].Key
+	}
+	return out, nil
+}
+
+func (s aferoS3Server) DeleteObjects(ctx context.Context,
+	input *s3.DeleteObjectsInput,
+	optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	bucketfs, ok := s.buckets[*input.Bucket]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist in this aferoS3Server", *input.Bucket)
+	}
+	for _, obj := range input.Delete.Objects {
+		if err := bucketfs.Remove(*obj.Key); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
 func (s aferoS3Server) Download(
 	ctx context.Context,
 	w io.WriterAt,
@@ -132,7 +198,6 @@ func TestS3StoreUpload(t *testing.T) {
 }
 
 func TestS3StoreRetrieve(t *testing.T) {
-	logger.Init("TestS3StoreRetrieve", true, true, os.Stdout)
 	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
 	// create bucket content
 	bucketfs, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
@@ -191,6 +256,94 @@ func TestS3StoreRetrieve(t *testing.T) {
 
 }
 
+func TestS3StoreRetrieveVerifiesDeclaredAlgorithm(t *testing.T) {
+	bucketfs, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"someObject": {Content: []byte("tla")},
+	})
+	require.NoError(t, err)
+	fakeS3Server := aferoS3Server{buckets: map[string]afero.Fs{"aFakeBucket": *bucketfs}}
+	blake3sum, err := metadata.HashFromReader(metadata.AlgorithmBLAKE3, strings.NewReader("tla"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		algorithm string
+		checksum  string
+		wantErr   error
+	}{
+		{name: "blake3", algorithm: metadata.AlgorithmBLAKE3, checksum: blake3sum},
+		{
+			// the sha256 of the object does not satisfy a cfile that declares blake3
+			name:      "blake3 with sha256 checksum",
+			algorithm: metadata.AlgorithmBLAKE3,
+			checksum:  "59e5ad2a03d2499749f7943c9dded0f303ad7542befef6d0aead8a7888587f66",
+			wantErr:   metadata.ErrRetrieveFailureHashMismatch,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := S3Store{
+				Options: Options{
+					BackendAddress:        "s3://aFakeBucket",
+					MetadataFileExtension: "cfile",
+				},
+				fsys:         afero.NewMemMapFs(),
+				s3Uploader:   fakeS3Server,
+				s3Downloader: fakeS3Server,
+			}
+			err := store.Retrieve(context.Background(), metadata.CfileMetadataMap{
+				"someObject.cfile": {
+					SchemaVersion: metadata.SchemaVersion,
+					Name:          "someObject",
+					Algorithm:     test.algorithm,
+					Checksum:      test.checksum,
+				},
+			}, "someObject.cfile")
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestS3StoreRetrieveRestoresAttributes(t *testing.T) {
+	bucketfs, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"bin/tool": {Content: []byte("tla")},
+	})
+	require.NoError(t, err)
+	fakeS3Server := aferoS3Server{buckets: map[string]afero.Fs{"aFakeBucket": *bucketfs}}
+	mTime, _ := time.Parse("2006-01-02T15:04:05.000Z", "2014-11-12T11:45:26.371Z")
+	size := int64(3)
+	localFs := afero.NewMemMapFs()
+	store := S3Store{
+		Options: Options{
+			BackendAddress:        "s3://aFakeBucket",
+			MetadataFileExtension: "cfile",
+		},
+		fsys:         localFs,
+		s3Uploader:   fakeS3Server,
+		s3Downloader: fakeS3Server,
+	}
+	err = store.Retrieve(context.Background(), metadata.CfileMetadataMap{
+		"bin/tool.cfile": {
+			SchemaVersion: metadata.SchemaVersion,
+			Name:          "bin/tool",
+			Algorithm:     metadata.AlgorithmSHA256,
+			Checksum:      "59e5ad2a03d2499749f7943c9dded0f303ad7542befef6d0aead8a7888587f66",
+			Size:          &size,
+			Mode:          0755,
+			DateModified:  mTime,
+		},
+	}, "bin/tool.cfile")
+	require.NoError(t, err)
+	info, err := localFs.Stat("bin/tool")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.True(t, mTime.Equal(info.ModTime()))
+}
+
 func TestS3GetBucketNameWithS3Prefix(t *testing.T) {
 	expectedBackendAddress := "s3://aFakeBucket"
 
@@ -302,3 +455,34 @@ func TestRetrieveZeroCfiles(t *testing.T) {
 	err := s.Retrieve(context.Background(), metadata.CfileMetadataMap{})
 	require.ErrorIs(t, err, ErrCfilesLengthZero)
 }
+
+func TestS3StoreListAndDelete(t *testing.T) {
+	bucket, err := testutils.MemMapFsWith(map[string]testutils.MapFile{
+		"repo/a":               {Content: []byte("a")},
+		"repo/tools/b":         {Content: []byte("bb")},
+		"repo/tools/c":         {Content: []byte("ccc")},
+		"other-repo/d":         {Content: []byte("d")},
+		"repository/elsewhere": {Content: []byte("e")},
+	})
+	require.NoError(t, err)
+	server := aferoS3Server{buckets: map[string]afero.Fs{"test": *bucket}, pageSize: 2}
+	s := &S3Store{
+		Options:  Options{BackendAddress: "s3://test"},
+		s3Client: server,
+	}
+	infos, err := s.List(context.Background(), "repo/")
+	require.NoError(t, err)
+	var keys []string
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+	assert.Equal(t, []string{"repo/a", "repo/tools/b", "repo/tools/c"}, keys)
+	assert.Equal(t, int64(2), infos[1].Size)
+	assert.False(t, infos[1].LastModified.IsZero())
+
+	require.NoError(t, s.Delete(context.Background(), "repo/a", "repo/tools/c", "repo/missing"))
+	infos, err = s.List(context.Background(), "repo/")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "repo/tools/b", infos[0].Key)
+}
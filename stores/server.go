@@ -0,0 +1,352 @@
+package stores
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/metadata"
+)
+
+var _ = http.Handler(&CacheServer{})
+
+// CacheServer serves the objects of a Store over HTTP to HTTPStores. Objects are kept in a cache
+// after they are uploaded or retrieved, so the Store is only asked for objects that are not cached
+// yet. The Store must operate on the cache.
+//
+// GET and HEAD /<key> describe and download an object, PUT /<key> uploads one. Objects that are not
+// cached can only be downloaded with a DigestHeader, which is used to verify them.
+type CacheServer struct {
+	backend Store
+	cache   afero.Fs
+	// token, if not empty, must be sent by clients as a bearer token
+	token string
+	locks keyLocks
+}
+
+func NewCacheServer(backend Store, cache afero.Fs, token string) *CacheServer {
+	return &CacheServer{
+		backend: backend,
+		cache:   cache,
+		token:   token,
+	}
+}
+
+// ServeHTTP serves the object named by the path of r and logs the request
+func (s *CacheServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		slog.Info("request", "remote", r.RemoteAddr, "method", r.Method, "path", r.URL.Path, "status", rec.status, "bytes", rec.written, "duration", time.Since(start).Round(time.Millisecond))
+	}()
+	if !s.authorized(r) {
+		http.Error(rec, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	key, ok := objectKey(r.URL.Path)
+	if !ok {
+		http.Error(rec, fmt.Sprintf("invalid object key %q", r.URL.Path), http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.get(rec, r, key)
+	case http.MethodPut:
+		s.put(rec, r, key)
+	default:
+		rec.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(rec, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *CacheServer) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) == 1
+}
+
+// objectKey returns the key named by the path of a request, unless it is empty or not clean
+func objectKey(urlPath string) (string, bool) {
+	key := strings.TrimPrefix(urlPath, "/")
+	if key == "" || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", false
+	}
+	return key, true
+}
+
+func (s *CacheServer) get(w http.ResponseWriter, r *http.Request, key string) {
+	var want *digest
+	if value := r.Header.Get(DigestHeader); value != "" {
+		d, err := parseDigest(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		want = &d
+	}
+	if want == nil && r.Method == http.MethodGet {
+		// cached copies are verified against the digest before they are served
+		http.Error(w, fmt.Sprintf("%s is required to download %s", DigestHeader, key), http.StatusBadRequest)
+		return
+	}
+	unlock := s.locks.lock(key)
+	f, status, err := s.open(r, key, want)
+	unlock()
+	if err != nil {
+		if status == http.StatusInternalServerError || status == http.StatusBadGateway {
+			slog.Error("serving object", "key", key, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if f == nil {
+		// HEAD of an object that is not cached
+		s.stat(w, r, key)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// open opens the cached copy of key, retrieving it from the backend first if it is not cached or
+// does not match want. It returns a nil file if key is not cached and r does not download it.
+func (s *CacheServer) open(r *http.Request, key string, want *digest) (afero.File, int, error) {
+	info, err := s.cache.Stat(key)
+	switch {
+	case err == nil && info.IsDir():
+		return nil, http.StatusNotFound, fmt.Errorf("%w: %s", ErrObjectNotExist, key)
+	case err == nil && want != nil:
+		matches, err := s.matches(key, *want)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if !matches {
+			slog.Info("cached object does not match, retrieving it again", "key", key, "checksum", want.checksum)
+			if err := s.cache.Remove(key); err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+			return s.fetch(r, key, *want)
+		}
+	case errors.Is(err, os.ErrNotExist) && r.Method == http.MethodHead:
+		return nil, http.StatusOK, nil
+	case errors.Is(err, os.ErrNotExist):
+		return s.fetch(r, key, *want)
+	case err != nil:
+		return nil, http.StatusInternalServerError, err
+	}
+	f, err := s.cache.Open(key)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return f, http.StatusOK, nil
+}
+
+// fetch retrieves key from the backend into the cache and opens it
+func (s *CacheServer) fetch(r *http.Request, key string, want digest) (afero.File, int, error) {
+	err := retrieveStaged(r.Context(), s.backend, map[string]digest{key: want})
+	if err == nil {
+		// not every Store verifies what it retrieves
+		var matches bool
+		matches, err = s.matches(key, want)
+		if err == nil && !matches {
+			err = metadata.ErrRetrieveFailureHashMismatch
+		}
+	}
+	if err != nil {
+		// a partial download must not be served later
+		_ = s.cache.Remove(key)
+		switch {
+		case errors.Is(err, ErrObjectNotExist):
+			return nil, http.StatusNotFound, err
+		case errors.Is(err, metadata.ErrRetrieveFailureHashMismatch):
+			return nil, http.StatusConflict, fmt.Errorf("%s: %w", key, err)
+		}
+		return nil, http.StatusBadGateway, fmt.Errorf("retrieving %s: %w", key, err)
+	}
+	slog.Debug("cached object", "key", key)
+	f, err := s.cache.Open(key)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return f, http.StatusOK, nil
+}
+
+// matches reports whether the cached copy of key has the digest want
+func (s *CacheServer) matches(key string, want digest) (bool, error) {
+	f, err := s.cache.Open(key)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	actual, err := metadata.HashFromReader(want.algorithm, f)
+	if err != nil {
+		return false, err
+	}
+	return actual == want.checksum, nil
+}
+
+// stat describes key, which is not cached, as the backend does
+func (s *CacheServer) stat(w http.ResponseWriter, r *http.Request, key string) {
+	ss, ok := s.backend.(StatStore)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	info, err := ss.Stat(r.Context(), key)
+	switch {
+	case errors.Is(err, ErrObjectNotExist):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		slog.Error("describing object", "key", key, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(info.Size))
+	if !info.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// put verifies the body of r against its DigestHeader, caches it as key and uploads it to the backend
+func (s *CacheServer) put(w http.ResponseWriter, r *http.Request, key string) {
+	value := r.Header.Get(DigestHeader)
+	if value == "" {
+		http.Error(w, fmt.Sprintf("%s is required to upload %s", DigestHeader, key), http.StatusBadRequest)
+		return
+	}
+	want, err := parseDigest(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h, err := metadata.NewHasher(want.algorithm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unlock := s.locks.lock(key)
+	defer unlock()
+	if err := s.cache.MkdirAll(filepath.Dir(key), os.ModePerm); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmp := key + ".cavorite-tmp"
+	out, err := s.cache.Create(tmp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusBadRequest
+	_, err = io.Copy(io.MultiWriter(out, h), r.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != want.checksum {
+		// the cache is shared, so it only takes what the client vouched for
+		status = http.StatusConflict
+		err = fmt.Errorf("%s: %w", key, metadata.ErrRetrieveFailureHashMismatch)
+	}
+	if err == nil {
+		err = s.cache.Rename(tmp, key)
+	}
+	if err != nil {
+		_ = s.cache.Remove(tmp)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := s.backend.Upload(r.Context(), key); err != nil {
+		// the cache only holds objects the backend has
+		_ = s.cache.Remove(key)
+		slog.Error("uploading object", "key", key, "error", err)
+		http.Error(w, fmt.Sprintf("uploading %s: %v", key, err), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// parseDigest parses the value of DigestHeader
+func parseDigest(value string) (digest, error) {
+	algorithm, checksum, ok := strings.Cut(value, ":")
+	if !ok || checksum == "" {
+		return digest{}, fmt.Errorf("%s must be <algorithm>:<checksum>, got %q", DigestHeader, value)
+	}
+	if _, err := metadata.NewHasher(algorithm); err != nil {
+		return digest{}, err
+	}
+	return digest{algorithm: algorithm, checksum: checksum}, nil
+}
+
+// responseRecorder remembers the status and size of a response for logging
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.written += int64(n)
+	return n, err
+}
+
+// keyLocks serializes requests for the same key
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	// waiters is the number of requests holding or waiting for the lock
+	waiters int
+}
+
+// lock locks key and returns the function unlocking it
+func (l *keyLocks) lock(key string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.waiters++
+	l.mu.Unlock()
+	kl.Lock()
+	return func() {
+		kl.Unlock()
+		l.mu.Lock()
+		kl.waiters--
+		if kl.waiters == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,119 @@
+package stores
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		path string
+		key  string
+		ok   bool
+	}{
+		{"/tools/tool", "tools/tool", true},
+		{"/", "", false},
+		{"/tools/../../etc/passwd", "", false},
+		{"/../etc/passwd", "", false},
+		{"/tools//tool", "", false},
+		{"/tools/", "", false},
+	}
+	for _, tc := range tests {
+		key, ok := objectKey(tc.path)
+		assert.Equal(t, tc.key, key, tc.path)
+		assert.Equal(t, tc.ok, ok, tc.path)
+	}
+}
+
+func TestCacheServer(t *testing.T) {
+	ts, bucket, cache := newTestCacheServer(t, "")
+	do := func(method, key, body string, header http.Header) (int, string) {
+		req, err := http.NewRequest(method, ts.URL+"/"+key, strings.NewReader(body))
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	stuff := http.Header{DigestHeader: {"sha256:" + sha256Hex("stuff")}}
+
+	// uploads must come with the digest of their body, so that clients cannot poison the cache
+	status, _ := do(http.MethodPut, "tools/tool", "stuff", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = do(http.MethodPut, "tools/tool", "forged", stuff)
+	assert.Equal(t, http.StatusConflict, status)
+	for _, fsys := range []afero.Fs{bucket, cache} {
+		_, err := fsys.Stat("tools/tool")
+		assert.Error(t, err, "objects that do not match their digest must not be stored")
+	}
+
+	status, _ = do(http.MethodPut, "tools/tool", "stuff", stuff)
+	assert.Equal(t, http.StatusCreated, status)
+	for _, fsys := range []afero.Fs{bucket, cache} {
+		b, err := afero.ReadFile(fsys, "tools/tool")
+		require.NoError(t, err)
+		assert.Equal(t, "stuff", string(b))
+	}
+	status, body := do(http.MethodGet, "tools/tool", "", stuff)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "stuff", body)
+	status, _ = do(http.MethodGet, "tools/tool", "", nil)
+	assert.Equal(t, http.StatusBadRequest, status, "cache hits are only served once they were verified")
+
+	require.NoError(t, afero.WriteFile(bucket, "tools/other", []byte("other"), 0644))
+	status, _ = do(http.MethodHead, "tools/other", "", nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, body = do(http.MethodGet, "tools/other", "", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, DigestHeader)
+	status, _ = do(http.MethodGet, "tools/other", "", http.Header{DigestHeader: {"md5:whatever"}})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, _ = do(http.MethodHead, "tools/missing", "", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = do(http.MethodDelete, "tools/tool", "", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestCacheServerUploadFailure(t *testing.T) {
+	cache := afero.NewMemMapFs()
+	backend := &S3Store{
+		Options:    Options{BackendAddress: "s3://missing", MetadataFileExtension: "cfile"},
+		fsys:       cache,
+		s3Uploader: aferoS3Server{buckets: map[string]afero.Fs{}},
+	}
+	s := NewCacheServer(backend, cache, "secret")
+	req, err := http.NewRequest(http.MethodPut, "/tools/tool", strings.NewReader("stuff"))
+	require.NoError(t, err)
+	req.Header.Set(DigestHeader, "sha256:"+sha256Hex("stuff"))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	_, err = cache.Stat("tools/tool")
+	assert.Error(t, err, "objects that were not uploaded must not be cached")
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+package stores
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	multierr "github.com/hashicorp/go-multierror"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/objects"
+	"github.com/discentem/cavorite/signing"
+)
+
+var (
+	_ = Store(&SignedStore{})
+	_ = MetadataAnnotator(&SignedStore{})
+	_ = UploadPreparer(&SignedStore{})
+)
+
+// SignedStore signs the metadata of uploaded objects and verifies signatures before objects are retrieved.
+// It must be the outermost Store so that the signature covers metadata added by the stores it wraps.
+type SignedStore struct {
+	inner Store
+	// signer is called before the first upload so that retrieving does not require access to the
+	// signing key
+	signer   func() (*signing.Signer, error)
+	verifier *signing.Verifier
+	enforce  bool
+}
+
+// NewSignedStore returns a SignedStore. signer and verifier may be nil to disable signing or verification.
+// If enforce is true, objects whose metadata is not signed by a trusted key are not retrieved.
+func NewSignedStore(inner Store, signer func() (*signing.Signer, error), verifier *signing.Verifier, enforce bool) (*SignedStore, error) {
+	if enforce && verifier == nil {
+		return nil, signing.ErrNoTrustedKeys
+	}
+	return &SignedStore{
+		inner:    inner,
+		signer:   signer,
+		verifier: verifier,
+		enforce:  enforce,
+	}, nil
+}
+
+func (s *SignedStore) Upload(ctx context.Context, keys ...string) error {
+	return s.inner.Upload(ctx, keys...)
+}
+
+func (s *SignedStore) GetOptions() (Options, error) {
+	return s.inner.GetOptions()
+}
+
+// Annotate lets the wrapped store annotate m and then signs the result
+func (s *SignedStore) Annotate(key string, m *metadata.ObjectMetaData) error {
+	if annotator, ok := s.inner.(MetadataAnnotator); ok {
+		if err := annotator.Annotate(key, m); err != nil {
+			return err
+		}
+	}
+	if s.signer == nil {
+		return nil
+	}
+	signer, err := s.signer()
+	if err != nil {
+		return err
+	}
+	return signer.Sign(m)
+}
+
+// PrepareUpload lets the wrapped store prepare and then loads the signer
+func (s *SignedStore) PrepareUpload() error {
+	if preparer, ok := s.inner.(UploadPreparer); ok {
+		if err := preparer.PrepareUpload(); err != nil {
+			return err
+		}
+	}
+	if s.signer == nil {
+		return nil
+	}
+	_, err := s.signer()
+	return err
+}
+
+// objectPathsKey is the context key of the paths recorded by WithObjectPath
+type objectPathsKey struct{}
+
+// WithObjectPath returns a copy of ctx recording that the metadata passed to Retrieve as cfile
+// describes the object at obj. It is needed where cfile is not next to the object, such as when
+// objects are retrieved into staging under their key.
+func WithObjectPath(ctx context.Context, cfile, obj string) context.Context {
+	paths := make(map[string]string)
+	if parent, ok := ctx.Value(objectPathsKey{}).(map[string]string); ok {
+		for k, v := range parent {
+			paths[k] = v
+		}
+	}
+	paths[cfile] = obj
+	return context.WithValue(ctx, objectPathsKey{}, paths)
+}
+
+// objectPath returns the path of the object described by cfile
+func objectPath(ctx context.Context, cfile string) string {
+	if paths, ok := ctx.Value(objectPathsKey{}).(map[string]string); ok {
+		if obj, ok := paths[cfile]; ok {
+			return obj
+		}
+	}
+	return inferObjPath(cfile)
+}
+
+// Retrieve verifies the signature of every requested object before retrieving it. Signatures only
+// count for the object whose key is derived from the path of the cfile.
+func (s *SignedStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap, cfiles ...string) error {
+	if s.verifier == nil {
+		return s.inner.Retrieve(ctx, mmap, cfiles...)
+	}
+	opts, err := s.inner.GetOptions()
+	if err != nil {
+		return err
+	}
+	prefix := objects.AddPrefixToKey{Prefix: opts.ObjectKeyPrefix}
+	var result *multierr.Error
+	verified := make(metadata.CfileMetadataMap)
+	var verifiedCfiles []string
+	for _, cfile := range cfiles {
+		m, ok := mmap[cfile]
+		if !ok {
+			continue
+		}
+		if err := s.verifier.VerifyKey(m, prefix.Modify(objectPath(ctx, cfile))); err != nil {
+			if s.enforce {
+				result = multierr.Append(result, fmt.Errorf("%s: %w", cfile, err))
+				continue
+			}
+			slog.Warn("signature not verified", "cfile", cfile, "error", err)
+		}
+		verified[cfile] = m
+		verifiedCfiles = append(verifiedCfiles, cfile)
+	}
+	if len(verifiedCfiles) == 0 {
+		return result.ErrorOrNil()
+	}
+	return multierr.Append(result, s.inner.Retrieve(ctx, verified, verifiedCfiles...)).ErrorOrNil()
+}
+
+func (s *SignedStore) Close() error {
+	return s.inner.Close()
+}
@@ -0,0 +1,108 @@
+package stores
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/discentem/cavorite/metadata"
+	"github.com/discentem/cavorite/signing"
+)
+
+// recordingStore records the cfiles it is asked to retrieve
+type recordingStore struct {
+	retrieved []string
+}
+
+func (s *recordingStore) Upload(ctx context.Context, keys ...string) error { return nil }
+
+func (s *recordingStore) Retrieve(ctx context.Context, mmap metadata.CfileMetadataMap, cfiles ...string) error {
+	for _, cfile := range cfiles {
+		if _, ok := mmap[cfile]; ok {
+			s.retrieved = append(s.retrieved, cfile)
+		}
+	}
+	return nil
+}
+
+func (s *recordingStore) GetOptions() (Options, error) { return Options{}, nil }
+
+func (s *recordingStore) Close() error { return nil }
+
+func TestSignedStore(t *testing.T) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	sshSigner, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	signer := func() (*signing.Signer, error) { return signing.NewSigner(sshSigner), nil }
+	verifier, err := signing.NewVerifier([]string{string(ssh.MarshalAuthorizedKey(sshSigner.PublicKey()))})
+	require.NoError(t, err)
+
+	signed := metadata.ObjectMetaData{Name: "signed", Checksum: "a"}
+	upload, err := NewSignedStore(&recordingStore{}, signer, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, upload.Annotate("signed", &signed))
+	require.NotNil(t, signed.Signature)
+
+	tampered := signed
+	tampered.Name = "evil"
+	mmap := metadata.CfileMetadataMap{
+		"signed.cfile":   signed,
+		"unsigned.cfile": {Name: "unsigned", Checksum: "b"},
+		"tampered.cfile": tampered,
+		// the signed cfile copied next to another object
+		"copied.cfile": signed,
+	}
+	cfiles := []string{"signed.cfile", "unsigned.cfile", "tampered.cfile", "copied.cfile"}
+
+	t.Run("enforced", func(t *testing.T) {
+		inner := &recordingStore{}
+		s, err := NewSignedStore(inner, nil, verifier, true)
+		require.NoError(t, err)
+		err = s.Retrieve(context.Background(), mmap, cfiles...)
+		assert.ErrorIs(t, err, signing.ErrUnsigned)
+		assert.ErrorIs(t, err, signing.ErrInvalidSignature)
+		assert.ErrorIs(t, err, signing.ErrWrongObject)
+		assert.Equal(t, []string{"signed.cfile"}, inner.retrieved)
+	})
+
+	t.Run("object path", func(t *testing.T) {
+		inner := &recordingStore{}
+		s, err := NewSignedStore(inner, nil, verifier, true)
+		require.NoError(t, err)
+		// staged under a key that differs from the path of the object
+		ctx := WithObjectPath(context.Background(), "staging/key.cfile", "signed")
+		mmap := metadata.CfileMetadataMap{"staging/key.cfile": signed}
+		require.NoError(t, s.Retrieve(ctx, mmap, "staging/key.cfile"))
+		assert.Equal(t, []string{"staging/key.cfile"}, inner.retrieved)
+		assert.ErrorIs(t, s.Retrieve(context.Background(), mmap, "staging/key.cfile"), signing.ErrWrongObject)
+	})
+
+	t.Run("not enforced", func(t *testing.T) {
+		inner := &recordingStore{}
+		s, err := NewSignedStore(inner, nil, verifier, false)
+		require.NoError(t, err)
+		assert.NoError(t, s.Retrieve(context.Background(), mmap, cfiles...))
+		assert.Equal(t, cfiles, inner.retrieved)
+	})
+
+	_, err = NewSignedStore(&recordingStore{}, signer, nil, true)
+	assert.ErrorIs(t, err, signing.ErrNoTrustedKeys)
+}
+
+func TestSignedStorePrepareUpload(t *testing.T) {
+	errKey := errors.New("no key")
+	s, err := NewSignedStore(&recordingStore{}, func() (*signing.Signer, error) { return nil, errKey }, nil, false)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.PrepareUpload(), errKey)
+
+	// only verifying does not need a key
+	s, err = NewSignedStore(&recordingStore{}, nil, nil, false)
+	require.NoError(t, err)
+	assert.NoError(t, s.PrepareUpload())
+}
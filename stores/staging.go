@@ -0,0 +1,109 @@
+package stores
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/afero"
+
+	"github.com/discentem/cavorite/metadata"
+)
+
+// NewStagingFs returns a filesystem rooted in a new temporary directory. Stores that transform objects
+// before they are uploaded (or after they are retrieved) construct their backing Store on a staging
+// filesystem so that the transformed bytes never touch the source repo. cleanup removes the directory.
+func NewStagingFs() (staging afero.Fs, cleanup func() error, err error) {
+	dir, err := os.MkdirTemp("", "cavorite-staging-")
+	if err != nil {
+		return nil, nil, err
+	}
+	return afero.NewBasePathFs(afero.NewOsFs(), dir), func() error {
+		return os.RemoveAll(dir)
+	}, nil
+}
+
+// stagedCfile returns a cfile name for key such that inferObjPath(stagedCfile(key, ext)) == key.
+// It is used to ask a Store backed by a staging filesystem to retrieve arbitrary keys.
+func stagedCfile(key, ext string) string {
+	if ext == "" {
+		ext = metadata.MetadataFileExtension
+	}
+	return key + "." + ext
+}
+
+// digest is a checksum together with the algorithm it was computed with
+type digest struct {
+	algorithm string
+	checksum  string
+}
+
+// sha256Digest is used for everything cavorite stores in its own formats, such as ciphertext and chunks
+func sha256Digest(checksum string) digest {
+	return digest{algorithm: metadata.AlgorithmSHA256, checksum: checksum}
+}
+
+func objectDigest(m metadata.ObjectMetaData) digest {
+	return digest{algorithm: m.HashAlgorithm(), checksum: m.Checksum}
+}
+
+// retrieveStaged asks s, which must operate on a staging filesystem, to download each key in digests
+// into that filesystem. digests maps keys to the digest they are expected to have.
+func retrieveStaged(ctx context.Context, s Store, digests map[string]digest) error {
+	if len(digests) == 0 {
+		return nil
+	}
+	opts, err := s.GetOptions()
+	if err != nil {
+		return err
+	}
+	mmap := make(metadata.CfileMetadataMap)
+	cfiles := make([]string, 0, len(digests))
+	for key, d := range digests {
+		cfile := stagedCfile(key, opts.MetadataFileExtension)
+		mmap[cfile] = metadata.ObjectMetaData{
+			SchemaVersion: metadata.SchemaVersion,
+			Name:          key,
+			Algorithm:     d.algorithm,
+			Checksum:      d.checksum,
+		}
+		cfiles = append(cfiles, cfile)
+	}
+	sort.Strings(cfiles)
+	return s.Retrieve(ctx, mmap, cfiles...)
+}
+
+// replaceVerified writes the output of write to dst in fsys. dst is only replaced if the digest of
+// everything written matches want, otherwise dst is left untouched.
+func replaceVerified(fsys afero.Fs, dst string, want digest, write func(w io.Writer) error) error {
+	h, err := metadata.NewHasher(want.algorithm)
+	if err != nil {
+		return fmt.Errorf("%s: %w", dst, err)
+	}
+	if err := fsys.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := dst + ".cavorite-tmp"
+	out, err := fsys.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = write(io.MultiWriter(out, h))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != want.checksum {
+		slog.Debug("hash mismatch", "path", dst, "algorithm", want.algorithm, "expected", want.checksum)
+		err = metadata.ErrRetrieveFailureHashMismatch
+	}
+	if err != nil {
+		_ = fsys.Remove(tmp)
+		return fmt.Errorf("%s: %w", dst, err)
+	}
+	return fsys.Rename(tmp, dst)
+}
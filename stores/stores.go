@@ -2,8 +2,10 @@ package stores
 
 import (
 	"context"
+	"errors"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/discentem/cavorite/metadata"
 	"github.com/spf13/afero"
@@ -17,10 +19,14 @@ const (
 	StoreTypeGCS       StoreType = "gcs"
 	StoreTypeAzureBlob StoreType = "azure"
 	StoreTypeGoPlugin  StoreType = "plugin"
+	StoreTypeHTTP      StoreType = "http"
 )
 
 var (
 	_ = Store(&S3Store{})
+	_ = StatStore(&S3Store{})
+	_ = ListStore(&S3Store{})
+	_ = DeleteStore(&S3Store{})
 	// _ = Store(&GCSStore{})
 	// _ = Store(&AzureBlobStore{})
 	_ = Store(&PluggableStore{})
@@ -38,6 +44,48 @@ type Store interface {
 	Close() error
 }
 
+var (
+	ErrObjectNotExist = errors.New("object does not exist in the store")
+)
+
+// ObjectInfo describes an object in a storage backend
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// StatStore is implemented by stores that can describe remote objects without downloading them.
+// Stat returns an error wrapping ErrObjectNotExist if key is not present.
+type StatStore interface {
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+}
+
+// ListStore is implemented by stores that can enumerate remote objects. List describes every object
+// whose key starts with prefix.
+type ListStore interface {
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// DeleteStore is implemented by stores that can delete remote objects. Keys that are not present
+// are not an error.
+type DeleteStore interface {
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// MetadataAnnotator is implemented by stores that need to record additional
+// information in an object's metadata after it has been uploaded
+type MetadataAnnotator interface {
+	Annotate(key string, m *metadata.ObjectMetaData) error
+}
+
+// UploadPreparer is implemented by stores that need something, such as a signing key, to annotate
+// metadata. PrepareUpload is called before anything is uploaded, so that a store which could not
+// annotate the metadata of an object fails before the object is uploaded.
+type UploadPreparer interface {
+	PrepareUpload() error
+}
+
 func inferObjPath(cfilePath string) string {
 	return strings.TrimSuffix(cfilePath, filepath.Ext(cfilePath))
 }
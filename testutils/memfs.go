@@ -22,6 +22,8 @@ type Writer interface {
 type MapFile struct {
 	Content []byte
 	ModTime *time.Time
+	// Mode, if not zero, is applied with Chmod
+	Mode os.FileMode
 }
 
 type MemMapFsWithBrokenCreate struct {
@@ -48,6 +50,11 @@ func MemMapFsWith(files map[string]MapFile) (*afero.Fs, error) {
 		if err != nil {
 			return nil, err
 		}
+		if mfile.Mode != 0 {
+			if err := memfsys.Chmod(fname, mfile.Mode); err != nil {
+				return nil, err
+			}
+		}
 		if mfile.ModTime != nil {
 			err := memfsys.Chtimes(fname, time.Time{}, *mfile.ModTime)
 			if err != nil {